@@ -0,0 +1,418 @@
+package BLC
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+/**
+地址簿  记录听说过的节点地址，按NODE_ID保存在Peers_<NODE_ID>.dat中
+
+1.addr消息、连入的全节点和种子节点带来新地址，记录最后一次听说这个地址的时间
+2.主动连接前记录尝试次数，握手成功后记为可用(good)，失败次数清零
+3.太久没有听说、从未连接成功且已经失败多次、或者连续失败太多次的地址为不可用(bad)，不再选择并优先淘汰
+4.主动连接时从地址簿中随机选择，一半机会选择连接成功过的地址
+5.修改只记在内存中并标记为脏，每隔addrSaveInterval写一次文件，addr消息再多也不会反复重写文件
+*/
+
+//存储地址簿的文件名
+const PeersFile = "Peers_%s.dat"
+
+// 一条addr消息最多携带的地址数
+const maxAddrPerMessage = 1000
+
+// 地址簿最多记录的地址数
+const maxKnownAddresses = 2000
+
+// 不超过这么多地址的addr消息中的新地址会继续转发
+const addrRelayLimit = 10
+
+// 每个新地址转发给几个节点
+const addrRelayPeers = 2
+
+// 多久没有听说就不再使用  秒
+const addrHorizon = 30 * 24 * 60 * 60
+
+// 从未连接成功的地址最多尝试几次
+const addrMaxRetries = 3
+
+// 连接成功过的地址，超过addrMinFailSeconds没有成功且连续失败这么多次后不再使用
+const addrMaxFailures = 10
+const addrMinFailSeconds = 7 * 24 * 60 * 60
+
+// 同一个地址两次尝试连接的最小间隔  秒
+const addrRetryInterval = 60
+
+// 地址簿有修改时多久写一次文件
+const addrSaveInterval = 10 * time.Second
+
+type KnownAddress struct {
+	// 监听地址
+	Addr string
+	// 提供的服务  还不知道时为0
+	Services uint64
+	// 最后一次听说这个地址的时间
+	LastSeen int64
+	// 最后一次尝试连接的时间
+	LastAttempt int64
+	// 最后一次握手成功的时间
+	LastSuccess int64
+	// 上次成功之后尝试连接的次数
+	Attempts int64
+}
+
+// 是否连接成功过
+func (ka *KnownAddress) IsGood() bool {
+
+	return ka.LastSuccess > 0
+}
+
+// 是否不可用
+func (ka *KnownAddress) IsBad(now int64) bool {
+
+	if ka.LastSeen < now-addrHorizon {
+
+		return true
+	}
+
+	if ka.LastSuccess == 0 && ka.Attempts >= addrMaxRetries {
+
+		return true
+	}
+
+	if ka.LastSuccess < now-addrMinFailSeconds && ka.Attempts >= addrMaxFailures {
+
+		return true
+	}
+
+	return false
+}
+
+// 地址簿文件的内容
+type addrBook struct {
+	Addresses []*KnownAddress
+}
+
+func (book *addrBook) encode(w *binaryWriter) {
+
+	w.writeVarInt(uint64(len(book.Addresses)))
+	for _, ka := range book.Addresses {
+
+		w.writeString(ka.Addr)
+		w.writeUint64(ka.Services)
+		w.writeInt64(ka.LastSeen)
+		w.writeInt64(ka.LastAttempt)
+		w.writeInt64(ka.LastSuccess)
+		w.writeInt64(ka.Attempts)
+	}
+}
+
+func (book *addrBook) decode(r *binaryReader) {
+
+	count := r.readCount()
+	for i := 0; i < count && r.err == nil; i++ {
+
+		ka := &KnownAddress{}
+		ka.Addr = r.readString()
+		ka.Services = r.readUint64()
+		ka.LastSeen = r.readInt64()
+		ka.LastAttempt = r.readInt64()
+		ka.LastSuccess = r.readInt64()
+		ka.Attempts = r.readInt64()
+		book.Addresses = append(book.Addresses, ka)
+	}
+}
+
+type AddrManager struct {
+	nodeID string
+	addrs  map[string]*KnownAddress
+	lock   sync.Mutex
+	// 上次写文件后是否有修改
+	dirty bool
+}
+
+// 节点使用的地址簿  启动节点时加载
+var addrManager *AddrManager
+
+// 读取地址簿，文件不存在时为空
+func LoadAddrManager(nodeID string) *AddrManager {
+
+	am := &AddrManager{nodeID: nodeID, addrs: make(map[string]*KnownAddress)}
+
+	peersFile := fmt.Sprintf(PeersFile, nodeID)
+	if _, err := os.Stat(peersFile); os.IsNotExist(err) {
+
+		return am
+	}
+
+	fileContent, err := ioutil.ReadFile(peersFile)
+	if err != nil {
+
+		log.Panic(err)
+	}
+
+	var book addrBook
+	err = decodeMessage(fileContent, &book)
+	if err != nil {
+
+		// 地址簿损坏时重新开始收集
+		fmt.Printf("Ignore %s: %s\n", peersFile, err)
+		return am
+	}
+
+	for _, ka := range book.Addresses {
+
+		am.addrs[ka.Addr] = ka
+	}
+
+	return am
+}
+
+// 有修改时保存地址簿
+func (am *AddrManager) Flush() {
+
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	if am.dirty {
+
+		am.save()
+	}
+}
+
+// 定期保存地址簿  节点启动后在单独的协程中运行
+func (am *AddrManager) saveLoop() {
+
+	for {
+
+		time.Sleep(addrSaveInterval)
+		am.Flush()
+	}
+}
+
+// 保存地址簿  调用时已持有锁
+func (am *AddrManager) save() {
+
+	book := &addrBook{}
+	for _, ka := range am.addrs {
+
+		book.Addresses = append(book.Addresses, ka)
+	}
+
+	err := ioutil.WriteFile(fmt.Sprintf(PeersFile, am.nodeID), encodeMessage(book), 0644)
+	if err != nil {
+
+		log.Panic(err)
+	}
+	am.dirty = false
+}
+
+// 地址格式是否正确  自己的地址不记录
+func isValidPeerAddress(addr string) bool {
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" || port == "" || port == "0" {
+
+		return false
+	}
+
+	return addr != nodeAddress
+}
+
+// 记录听说的地址  返回是否为新地址
+// timestamp为对方最后一次确认这个地址可用的时间，不合理时按5天前处理
+func (am *AddrManager) AddAddress(addr string, services uint64, timestamp int64) bool {
+
+	if isValidPeerAddress(addr) == false {
+
+		return false
+	}
+
+	now := time.Now().Unix()
+	if timestamp <= 0 || timestamp > now+10*60 {
+
+		timestamp = now - 5*24*60*60
+	}
+
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	ka := am.addrs[addr]
+	if ka != nil {
+
+		if timestamp > ka.LastSeen {
+
+			ka.LastSeen = timestamp
+		}
+		ka.Services |= services
+		am.dirty = true
+
+		return false
+	}
+
+	if timestamp < now-addrHorizon {
+
+		return false
+	}
+
+	if len(am.addrs) >= maxKnownAddresses {
+
+		am.evict(now)
+	}
+
+	am.addrs[addr] = &KnownAddress{addr, services, timestamp, 0, 0, 0}
+	am.dirty = true
+
+	return true
+}
+
+// 地址簿已满时淘汰一个地址  优先淘汰不可用的，其次是最久没有听说且没有连接成功过的
+func (am *AddrManager) evict(now int64) {
+
+	var oldest *KnownAddress
+	for _, ka := range am.addrs {
+
+		if ka.IsBad(now) {
+
+			delete(am.addrs, ka.Addr)
+			return
+		}
+
+		if ka.IsGood() == false && (oldest == nil || ka.LastSeen < oldest.LastSeen) {
+
+			oldest = ka
+		}
+	}
+
+	if oldest != nil {
+
+		delete(am.addrs, oldest.Addr)
+	}
+}
+
+// 尝试连接前调用
+func (am *AddrManager) Attempt(addr string) {
+
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	ka := am.addrs[addr]
+	if ka == nil {
+
+		return
+	}
+
+	ka.LastAttempt = time.Now().Unix()
+	ka.Attempts++
+	am.dirty = true
+}
+
+// 握手成功后调用
+func (am *AddrManager) Good(addr string, services uint64) {
+
+	if isValidPeerAddress(addr) == false {
+
+		return
+	}
+
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	now := time.Now().Unix()
+	ka := am.addrs[addr]
+	if ka == nil {
+
+		ka = &KnownAddress{Addr: addr}
+		am.addrs[addr] = ka
+	}
+
+	ka.Services = services
+	ka.LastSeen = now
+	ka.LastSuccess = now
+	ka.Attempts = 0
+	am.dirty = true
+}
+
+// 随机选择一个要连接的地址  services为需要的服务，exclude返回true的地址跳过  没有可选地址时返回空
+func (am *AddrManager) Select(services uint64, exclude func(addr string) bool) string {
+
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	now := time.Now().Unix()
+
+	var tried []*KnownAddress
+	var fresh []*KnownAddress
+	for _, ka := range am.addrs {
+
+		// 还不知道服务的地址(种子节点)也可以尝试
+		if ka.Services != 0 && ka.Services&services != services {
+
+			continue
+		}
+
+		if ka.IsBad(now) || now-ka.LastAttempt < addrRetryInterval || exclude(ka.Addr) {
+
+			continue
+		}
+
+		if ka.IsGood() {
+
+			tried = append(tried, ka)
+		} else {
+
+			fresh = append(fresh, ka)
+		}
+	}
+
+	// 一半机会选择连接成功过的地址
+	candidates := fresh
+	if len(tried) > 0 && (len(fresh) == 0 || rand.Intn(2) == 0) {
+
+		candidates = tried
+	}
+
+	if len(candidates) == 0 {
+
+		return ""
+	}
+
+	return candidates[rand.Intn(len(candidates))].Addr
+}
+
+// 回复getaddr的地址  不包括不可用的地址，最多max个
+func (am *AddrManager) AddressesToShare(max int) []*NetAddress {
+
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	now := time.Now().Unix()
+
+	var list []*NetAddress
+	for _, ka := range am.addrs {
+
+		if ka.IsBad(now) {
+
+			continue
+		}
+		list = append(list, &NetAddress{ka.Addr, ka.Services, ka.LastSeen})
+	}
+
+	rand.Shuffle(len(list), func(i, j int) {
+
+		list[i], list[j] = list[j], list[i]
+	})
+
+	if len(list) > max {
+
+		list = list[:max]
+	}
+
+	return list
+}
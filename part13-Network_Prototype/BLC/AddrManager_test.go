@@ -0,0 +1,229 @@
+package BLC
+
+import (
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 在临时目录中使用新的全局地址簿，测试结束后恢复
+func useTestAddrManager(t *testing.T, nodeID string) *AddrManager {
+
+	chdirTemp(t)
+
+	saved := addrManager
+	addrManager = LoadAddrManager(nodeID)
+	t.Cleanup(func() {
+
+		addrManager = saved
+	})
+
+	return addrManager
+}
+
+func TestAddrManagerAddAddress(t *testing.T) {
+
+	am := useTestAddrManager(t, "test")
+	now := time.Now().Unix()
+
+	savedAddress := nodeAddress
+	nodeAddress = "203.0.113.1:3000"
+	defer func() {
+
+		nodeAddress = savedAddress
+	}()
+
+	tests := []struct {
+		name      string
+		addr      string
+		services  uint64
+		timestamp int64
+		added     bool
+	}{
+		{"no port", "203.0.113.2", SERVICE_FULL_NODE, now, false},
+		{"port 0", "203.0.113.2:0", SERVICE_FULL_NODE, now, false},
+		{"own address", "203.0.113.1:3000", SERVICE_FULL_NODE, now, false},
+		{"too old", "203.0.113.3:3000", SERVICE_FULL_NODE, now - addrHorizon - 1, false},
+		{"new", "203.0.113.4:3000", SERVICE_FULL_NODE, now - 100, true},
+		// 已知地址只更新时间和服务
+		{"known", "203.0.113.4:3000", SERVICE_MINER, now, false},
+		{"future timestamp", "203.0.113.5:3000", SERVICE_FULL_NODE, now + 3600, true},
+	}
+
+	for _, test := range tests {
+
+		if added := am.AddAddress(test.addr, test.services, test.timestamp); added != test.added {
+
+			t.Errorf("%s: added %v, want %v", test.name, added, test.added)
+		}
+	}
+
+	if ka := am.addrs["203.0.113.4:3000"]; ka == nil || ka.Services != SERVICE_FULL_NODE|SERVICE_MINER || ka.LastSeen != now {
+
+		t.Errorf("known address %+v", ka)
+	}
+	// 不合理的时间按5天前处理
+	if ka := am.addrs["203.0.113.5:3000"]; ka == nil || ka.LastSeen > now-4*24*60*60 {
+
+		t.Errorf("future address %+v", ka)
+	}
+	if len(am.addrs) != 2 {
+
+		t.Errorf("%d addresses, want 2", len(am.addrs))
+	}
+}
+
+func TestKnownAddressIsBad(t *testing.T) {
+
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name string
+		ka   KnownAddress
+		bad  bool
+	}{
+		{"fresh", KnownAddress{LastSeen: now}, false},
+		{"not seen for too long", KnownAddress{LastSeen: now - addrHorizon - 1}, true},
+		{"never connected, retries left", KnownAddress{LastSeen: now, Attempts: addrMaxRetries - 1}, false},
+		{"never connected, out of retries", KnownAddress{LastSeen: now, Attempts: addrMaxRetries}, true},
+		// 连接成功过的地址可以多试几次
+		{"good, recent failures", KnownAddress{LastSeen: now, LastSuccess: now - 60, Attempts: addrMaxFailures}, false},
+		{"good long ago, many failures", KnownAddress{LastSeen: now, LastSuccess: now - addrMinFailSeconds - 1, Attempts: addrMaxFailures}, true},
+	}
+
+	for _, test := range tests {
+
+		if bad := test.ka.IsBad(now); bad != test.bad {
+
+			t.Errorf("%s: bad %v, want %v", test.name, bad, test.bad)
+		}
+	}
+}
+
+func TestAddrManagerSelect(t *testing.T) {
+
+	am := useTestAddrManager(t, "test")
+	now := time.Now().Unix()
+	none := func(addr string) bool {
+
+		return false
+	}
+
+	am.AddAddress("203.0.113.6:3000", SERVICE_SPV, now)
+	if addr := am.Select(SERVICE_FULL_NODE, none); addr != "" {
+
+		t.Errorf("selected %s without the full node service", addr)
+	}
+
+	// 服务未知的地址(种子节点)也可以选择
+	am.AddAddress("203.0.113.7:3000", 0, now)
+	if addr := am.Select(SERVICE_FULL_NODE, none); addr != "203.0.113.7:3000" {
+
+		t.Errorf("selected %q, want the seed", addr)
+	}
+	if addr := am.Select(SERVICE_FULL_NODE, func(addr string) bool { return addr == "203.0.113.7:3000" }); addr != "" {
+
+		t.Errorf("selected excluded address %s", addr)
+	}
+
+	// 刚尝试过的地址等一段时间再试
+	am.Attempt("203.0.113.7:3000")
+	if addr := am.Select(SERVICE_FULL_NODE, none); addr != "" {
+
+		t.Errorf("selected %s right after an attempt", addr)
+	}
+
+	// 连接成功后重置尝试次数
+	am.Good("203.0.113.7:3000", SERVICE_FULL_NODE)
+	am.addrs["203.0.113.7:3000"].LastAttempt = now - addrRetryInterval
+	if addr := am.Select(SERVICE_FULL_NODE, none); addr != "203.0.113.7:3000" || am.addrs[addr].Attempts != 0 {
+
+		t.Errorf("selected %q after a successful connection", addr)
+	}
+
+	// 分享的地址不包括不可用的
+	am.addrs["203.0.113.8:3000"] = &KnownAddress{"203.0.113.8:3000", SERVICE_FULL_NODE, now, 0, 0, addrMaxRetries}
+	shared := am.AddressesToShare(maxAddrPerMessage)
+	if len(shared) != 2 {
+
+		t.Errorf("shared %d addresses, want 2", len(shared))
+	}
+	if shared := am.AddressesToShare(1); len(shared) != 1 {
+
+		t.Errorf("shared %d addresses, want at most 1", len(shared))
+	}
+}
+
+// 地址簿只在有修改时写文件，损坏的文件当作空地址簿
+func TestAddrManagerPersists(t *testing.T) {
+
+	am := useTestAddrManager(t, "test")
+	am.AddAddress("203.0.113.9:3000", SERVICE_FULL_NODE, time.Now().Unix())
+	am.Good("203.0.113.10:3000", SERVICE_FULL_NODE|SERVICE_MINER)
+	am.Flush()
+
+	loaded := LoadAddrManager("test")
+	if len(loaded.addrs) != 2 || loaded.addrs["203.0.113.10:3000"].IsGood() == false || loaded.addrs["203.0.113.9:3000"].Services != SERVICE_FULL_NODE {
+
+		t.Fatalf("loaded %d addresses", len(loaded.addrs))
+	}
+
+	// 没有修改时不写文件
+	err := ioutil.WriteFile("Peers_test.dat", []byte{0xff}, 0644)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	am.Flush()
+	if loaded := LoadAddrManager("test"); len(loaded.addrs) != 0 {
+
+		t.Errorf("corrupt file loaded %d addresses", len(loaded.addrs))
+	}
+}
+
+// 少量新地址转发给其他全节点，超过上限的addr计入不良行为
+func TestHandleAddrRelay(t *testing.T) {
+
+	useTestAddrManager(t, "test")
+	useTestBanList(t, "test")
+
+	sender, _ := newPipeTestPeer(t, "203.0.113.20:3000", true, nil)
+	full, fullConn := newPipeTestPeer(t, "203.0.113.21:3000", false, nil)
+	spv, _ := newPipeTestPeer(t, "203.0.113.22:3000", false, nil)
+	full.Services = SERVICE_FULL_NODE
+	spv.Services = SERVICE_SPV
+	for _, peer := range []*Peer{sender, full, spv} {
+
+		close(peer.handshake)
+	}
+
+	now := time.Now().Unix()
+	handleAddr(sender, encodeMessage(&Addr{"203.0.113.20:3000", []*NetAddress{{"203.0.113.30:3000", SERVICE_FULL_NODE, now}}}), nil)
+
+	var relayed Addr
+	command, payload, err := readMessage(fullConn)
+	if err != nil || command != COMMAND_ADDR || decodeMessage(payload, &relayed) != nil {
+
+		t.Fatalf("read %s: %v, want addr", command, err)
+	}
+	if len(relayed.AddrList) != 1 || relayed.AddrList[0].Addr != "203.0.113.30:3000" {
+
+		t.Errorf("relayed %d addresses", len(relayed.AddrList))
+	}
+
+	tooMany := make([]*NetAddress, maxAddrPerMessage+1)
+	for i := range tooMany {
+
+		tooMany[i] = &NetAddress{"203.0.113.31:3000", SERVICE_FULL_NODE, now}
+	}
+	handleAddr(sender, encodeMessage(&Addr{"203.0.113.20:3000", tooMany}), nil)
+	if score := atomic.LoadInt32(&sender.banScore); score != scoreMalformed {
+
+		t.Errorf("score %d, want %d", score, scoreMalformed)
+	}
+	if _, ok := addrManager.addrs["203.0.113.31:3000"]; ok {
+
+		t.Errorf("address from an oversized addr recorded")
+	}
+}
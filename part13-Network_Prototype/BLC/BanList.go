@@ -0,0 +1,304 @@
+package BLC
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+/**
+封禁列表  按NODE_ID保存在Bans_<NODE_ID>.dat中
+
+1.节点发送无法解码的消息或无效的区块、交易时累计不良行为分数，达到banThreshold时断开并封禁一段时间
+2.封禁按IP地址记录，被封禁的IP连入时直接断开，也不会主动连接
+  本地测试网络的所有节点都在本机，本机地址改为按主动连接时使用的地址(host:port)记录
+  本机节点连入时只能看到临时端口，它在version中声明的监听地址没有经过验证，所以只断开不封禁；
+  声明的监听地址已被封禁时同样断开
+3.节点启动时读取文件，之后只查内存，封禁和解封时才写入文件
+  命令行增删封禁后文件的修改时间改变，运行中的节点在下一次检查连接时重新读取，并断开新封禁的节点
+*/
+
+//存储封禁列表的文件名
+const BansFile = "Bans_%s.dat"
+
+// 不良行为分数达到这个值时封禁
+const banThreshold = 100
+
+// 默认封禁时长  秒
+const defaultBanDuration = 24 * 60 * 60
+
+// 各种不良行为的分数
+// 消息无法解码或超出限制
+const scoreMalformed = 20
+
+// 区块或区块头校验不通过
+const scoreInvalidBlock = 50
+
+// 交易签名或金额无效
+const scoreInvalidTx = 10
+
+// 握手完成前发送其他消息
+const scoreProtocol = 10
+
+// 封禁时长  启动节点时可以用-bantime设置
+var banDuration int64 = defaultBanDuration
+
+type BanEntry struct {
+	// IP地址  本机地址为host:port
+	Host string
+	// 封禁到什么时候  Unix时间戳
+	BannedUntil int64
+	// 封禁原因
+	Reason string
+}
+
+// 封禁列表文件的内容
+type banBook struct {
+	Entries []*BanEntry
+}
+
+func (book *banBook) encode(w *binaryWriter) {
+
+	w.writeVarInt(uint64(len(book.Entries)))
+	for _, entry := range book.Entries {
+
+		w.writeString(entry.Host)
+		w.writeInt64(entry.BannedUntil)
+		w.writeString(entry.Reason)
+	}
+}
+
+func (book *banBook) decode(r *binaryReader) {
+
+	count := r.readCount()
+	for i := 0; i < count && r.err == nil; i++ {
+
+		entry := &BanEntry{}
+		entry.Host = r.readString()
+		entry.BannedUntil = r.readInt64()
+		entry.Reason = r.readString()
+		book.Entries = append(book.Entries, entry)
+	}
+}
+
+type BanList struct {
+	file string
+	lock sync.Mutex
+	// 内存中的封禁列表  文件改变时才重新读取
+	entries map[string]*BanEntry
+	// 上次读取或写入时文件的修改时间和大小
+	modTime time.Time
+	size    int64
+}
+
+// 节点使用的封禁列表  启动节点时设置
+var banList *BanList
+
+func NewBanList(nodeID string) *BanList {
+
+	bl := &BanList{file: fmt.Sprintf(BansFile, nodeID), entries: make(map[string]*BanEntry)}
+	bl.reload()
+
+	return bl
+}
+
+// 文件被修改过时重新读取  调用时已持有锁
+func (bl *BanList) reload() {
+
+	info, err := os.Stat(bl.file)
+	if os.IsNotExist(err) {
+
+		bl.entries = make(map[string]*BanEntry)
+		bl.modTime = time.Time{}
+		bl.size = 0
+		return
+	}
+	if err != nil {
+
+		log.Panic(err)
+	}
+
+	if info.ModTime().Equal(bl.modTime) && info.Size() == bl.size {
+
+		return
+	}
+	bl.modTime = info.ModTime()
+	bl.size = info.Size()
+
+	fileContent, err := ioutil.ReadFile(bl.file)
+	if err != nil {
+
+		log.Panic(err)
+	}
+
+	bl.entries = make(map[string]*BanEntry)
+
+	var book banBook
+	err = decodeMessage(fileContent, &book)
+	if err != nil {
+
+		// 文件损坏时当作没有封禁
+		fmt.Printf("Ignore %s: %s\n", bl.file, err)
+		return
+	}
+
+	now := time.Now().Unix()
+	for _, entry := range book.Entries {
+
+		if entry.BannedUntil > now {
+
+			bl.entries[entry.Host] = entry
+		}
+	}
+}
+
+// 保存封禁列表  只在封禁或解封时调用，已到期的记录不再写入  调用时已持有锁
+func (bl *BanList) save() {
+
+	book := &banBook{}
+	now := time.Now().Unix()
+	for host, entry := range bl.entries {
+
+		if entry.BannedUntil <= now {
+
+			delete(bl.entries, host)
+			continue
+		}
+		book.Entries = append(book.Entries, entry)
+	}
+
+	err := ioutil.WriteFile(bl.file, encodeMessage(book), 0644)
+	if err != nil {
+
+		log.Panic(err)
+	}
+
+	// 记录自己写入后的文件状态，下次检查时不必重新读取
+	info, err := os.Stat(bl.file)
+	if err == nil {
+
+		bl.modTime = info.ModTime()
+		bl.size = info.Size()
+	}
+}
+
+// 重新读取命令行修改过的封禁列表  运行中的节点定期调用
+func (bl *BanList) Reload() {
+
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+
+	bl.reload()
+}
+
+// 封禁一个IP duration秒  已经封禁时延长到较晚的时间
+func (bl *BanList) Ban(host string, duration int64, reason string) {
+
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+
+	// 先读取命令行可能做的修改，避免被覆盖
+	bl.reload()
+
+	until := time.Now().Unix() + duration
+	if entry := bl.entries[host]; entry != nil && entry.BannedUntil > until {
+
+		until = entry.BannedUntil
+	}
+	bl.entries[host] = &BanEntry{host, until, reason}
+
+	bl.save()
+}
+
+// 解除封禁  返回是否在封禁列表中
+func (bl *BanList) Unban(host string) bool {
+
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+
+	bl.reload()
+	if bl.entries[host] == nil {
+
+		return false
+	}
+
+	delete(bl.entries, host)
+	bl.save()
+
+	return true
+}
+
+// IP是否被封禁  只查内存中的列表
+func (bl *BanList) IsBanned(host string) bool {
+
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+
+	entry := bl.entries[host]
+
+	return entry != nil && entry.BannedUntil > time.Now().Unix()
+}
+
+// 所有还没有到期的封禁  按IP排列
+func (bl *BanList) List() []*BanEntry {
+
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+
+	now := time.Now().Unix()
+	var list []*BanEntry
+	for _, entry := range bl.entries {
+
+		if entry.BannedUntil > now {
+
+			list = append(list, entry)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+
+		return list[i].Host < list[j].Host
+	})
+
+	return list
+}
+
+// 本机地址  本地测试网络的所有节点都从这里连入，按IP封禁会断开整个网络，改为按host:port封禁
+func isLoopbackHost(host string) bool {
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// 连接的对方IP
+func remoteHost(conn net.Conn) string {
+
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+
+		return conn.RemoteAddr().String()
+	}
+
+	return host
+}
+
+// 断开已被封禁的节点  命令行封禁的IP在这里读取并断开
+func disconnectBannedPeers() {
+
+	banList.Reload()
+
+	for _, peer := range allPeers() {
+
+		if peer.IsBanned(banList) {
+
+			fmt.Printf("Disconnect %s: banned\n", peer)
+			peer.Close()
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package BLC
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// 在临时目录中使用封禁列表文件
+func chdirTemp(t *testing.T) {
+
+	cwd, err := os.Getwd()
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+
+		os.Chdir(cwd)
+	})
+}
+
+// 远端地址可以指定的连接
+type banTestConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (conn *banTestConn) RemoteAddr() net.Addr {
+
+	return conn.remote
+}
+
+func newBanTestPeer(t *testing.T, remote string, addr string, inbound bool) *Peer {
+
+	remoteAddr, err := net.ResolveTCPAddr("tcp", remote)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	local, other := net.Pipe()
+	t.Cleanup(func() {
+
+		other.Close()
+	})
+
+	return newPeer(&banTestConn{local, remoteAddr}, addr, inbound)
+}
+
+// 使用新的全局封禁列表，测试结束后恢复
+func useTestBanList(t *testing.T, nodeID string) *BanList {
+
+	saved := banList
+	banList = NewBanList(nodeID)
+	t.Cleanup(func() {
+
+		banList = saved
+	})
+
+	return banList
+}
+
+func TestPeerMisbehavingBans(t *testing.T) {
+
+	chdirTemp(t)
+
+	tests := []struct {
+		name       string
+		remote     string
+		addr       string
+		inbound    bool
+		listenAddr string
+		// 封禁的键  为空表示只断开不封禁
+		key string
+	}{
+		{"inbound remote ip", "203.0.113.5:40000", "203.0.113.5:40000", true, "203.0.113.5:3000", "203.0.113.5"},
+		{"outbound remote ip", "203.0.113.6:3000", "203.0.113.6:3000", false, "", "203.0.113.6"},
+		{"outbound loopback", "127.0.0.1:3001", "localhost:3001", false, "", "localhost:3001"},
+		// 声明的监听地址没有经过验证，不能用来封禁
+		{"inbound loopback", "127.0.0.1:53124", "127.0.0.1:53124", true, "localhost:3002", ""},
+	}
+
+	for i, test := range tests {
+
+		bl := useTestBanList(t, fmt.Sprintf("test%d", i))
+		peer := newBanTestPeer(t, test.remote, test.addr, test.inbound)
+		peer.setListenAddr(test.listenAddr)
+
+		if peer.BanKey() != test.key {
+
+			t.Errorf("%s: ban key %q, want %q", test.name, peer.BanKey(), test.key)
+		}
+
+		// 分数没有达到banThreshold时只记录
+		peer.Misbehaving(scoreInvalidBlock, "invalid block")
+		if isPeerClosed(peer) || len(bl.List()) != 0 {
+
+			t.Fatalf("%s: banned below threshold", test.name)
+		}
+
+		peer.Misbehaving(scoreInvalidBlock, "invalid block")
+		if isPeerClosed(peer) == false {
+
+			t.Errorf("%s: not disconnected at threshold", test.name)
+		}
+
+		list := bl.List()
+		if test.key == "" {
+
+			if len(list) != 0 {
+
+				t.Errorf("%s: banned %s", test.name, list[0].Host)
+			}
+			if bl.IsBanned(test.listenAddr) {
+
+				t.Errorf("%s: claimed listen address banned", test.name)
+			}
+			continue
+		}
+
+		if len(list) != 1 || list[0].Host != test.key || list[0].Reason != "invalid block" || bl.IsBanned(test.key) == false {
+
+			t.Errorf("%s: ban list %+v, want %s", test.name, list, test.key)
+		}
+	}
+}
+
+// 连入的本机节点声明被封禁的监听地址时同样断开
+func TestPeerIsBannedByClaimedListenAddr(t *testing.T) {
+
+	chdirTemp(t)
+
+	bl := NewBanList("test")
+	bl.Ban("localhost:3002", 3600, "test")
+	bl.Ban("203.0.113.5", 3600, "test")
+
+	tests := []struct {
+		name       string
+		remote     string
+		inbound    bool
+		listenAddr string
+		banned     bool
+	}{
+		{"loopback claims banned address", "127.0.0.1:53124", true, "localhost:3002", true},
+		{"loopback claims other address", "127.0.0.1:53125", true, "localhost:3003", false},
+		// 不是本机地址时只看IP
+		{"remote ip claims banned address", "203.0.113.7:40000", true, "localhost:3002", false},
+		{"banned remote ip", "203.0.113.5:40000", true, "203.0.113.5:3000", true},
+	}
+
+	for _, test := range tests {
+
+		peer := newBanTestPeer(t, test.remote, test.remote, test.inbound)
+		peer.setListenAddr(test.listenAddr)
+		if peer.IsBanned(bl) != test.banned {
+
+			t.Errorf("%s: banned = %v, want %v", test.name, peer.IsBanned(bl), test.banned)
+		}
+	}
+}
+
+func TestBanListPersistsAndExpires(t *testing.T) {
+
+	chdirTemp(t)
+
+	bl := NewBanList("test")
+	bl.Ban("10.0.0.1", 3600, "spam")
+	// 已经封禁时保留较晚的到期时间
+	bl.Ban("10.0.0.1", 60, "again")
+	// 立即到期的封禁不生效，也不写入文件
+	bl.Ban("10.0.0.2", 0, "expired")
+
+	list := bl.List()
+	if len(list) != 1 || list[0].Host != "10.0.0.1" || list[0].BannedUntil < time.Now().Unix()+3600-5 {
+
+		t.Fatalf("ban list %+v", list)
+	}
+	if bl.IsBanned("10.0.0.2") {
+
+		t.Errorf("expired ban still active")
+	}
+
+	// 重新打开时从文件读取
+	reopened := NewBanList("test")
+	if reopened.IsBanned("10.0.0.1") == false || len(reopened.List()) != 1 {
+
+		t.Fatalf("reopened ban list %+v", reopened.List())
+	}
+
+	// 命令行修改文件后，运行中的节点重新读取
+	NewBanList("test").Ban("10.0.0.5", 3600, "command line")
+	if bl.IsBanned("10.0.0.5") {
+
+		t.Errorf("ban visible before reload")
+	}
+	bl.Reload()
+	if bl.IsBanned("10.0.0.5") == false {
+
+		t.Errorf("ban from another instance not reloaded")
+	}
+
+	if bl.Unban("10.0.0.1") == false || bl.Unban("10.0.0.9") {
+
+		t.Errorf("unban results wrong")
+	}
+	if NewBanList("test").IsBanned("10.0.0.1") {
+
+		t.Errorf("unban not written to the file")
+	}
+
+	// 文件中已到期的记录读取时丢弃
+	now := time.Now().Unix()
+	book := &banBook{[]*BanEntry{{"10.0.0.3", now - 1, "old"}, {"10.0.0.4", now + 100, "new"}}}
+	err := ioutil.WriteFile(bl.file, encodeMessage(book), 0644)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	list = NewBanList("test").List()
+	if len(list) != 1 || list[0].Host != "10.0.0.4" {
+
+		t.Errorf("ban list %+v, want only 10.0.0.4", list)
+	}
+
+	// 损坏的文件当作没有封禁
+	err = ioutil.WriteFile(bl.file, []byte{0xff, 0x01}, 0644)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if list := NewBanList("test").List(); len(list) != 0 {
+
+		t.Errorf("corrupt file gave %+v", list)
+	}
+}
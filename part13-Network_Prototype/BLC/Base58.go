@@ -44,7 +44,8 @@ func Base58Encode(input []byte) []byte {
 	}
 
 	ReverseBytes(result)
-	for b := range input {
+	//每个前导的0x00字节编码为一个'1'
+	for _, b := range input {
 
 		if b == 0x00 {
 
@@ -64,11 +65,15 @@ func Base58Decode(input []byte) []byte {
 	result := big.NewInt(0)
 	zeroBytes := 0
 
-	for b := range input {
+	//每个前导的'1'解码为一个0x00字节
+	for _, b := range input {
 
-		if b == 0x00 {
+		if b == b58Alphabet[0] {
 
 			zeroBytes++
+		} else {
+
+			break
 		}
 	}
 
@@ -11,82 +11,119 @@
 package BLC
 
 import (
-	"time"
 	"bytes"
-	"encoding/gob"
+	"context"
+	"time"
 	"log"
 	"fmt"
 )
 
 type Block struct {
-	//1.区块高度
-	Height int64
-	//2.上一个区块HAsh
-	PrevBlockHash []byte
-	//3.交易数据
+	//1.区块头  版本、上一个区块Hash、默克尔根、时间戳、难度、Nonce、高度
+	BlockHeader
+	//2.交易数据
 	Txs [] *Transaction
-	//4.时间戳
-	Timestamp int64
-	//5.Hash
+	//3.Hash  区块头的哈希
 	Hash []byte
-	//6.Nonce  符合工作量证明的随机数
-	Nonce int64
 }
 
 //区块序列化
+//编码版本 + 定长区块头 + 交易个数 + 交易  区块哈希由区块头计算，不单独存储
 func (block *Block) Serialize() []byte  {
 
-	var result bytes.Buffer
+	w := &binaryWriter{}
+	w.writeByte(encodingVersion)
+	block.BlockHeader.encode(w)
+
+	w.writeVarInt(uint64(len(block.Txs)))
+	for _, tx := range block.Txs {
+
+		tx.encode(w)
+	}
+
+	return w.Bytes()
+}
+
+//区块解码
+func DecodeBlock(blockBytes []byte) (*Block, error) {
+
+	r := newBinaryReader(blockBytes)
+	r.readVersion()
+
+	block := &Block{}
+	block.BlockHeader = decodeBlockHeader(r)
 
-	encoder := gob.NewEncoder(&result)
+	count := r.readCount()
+	for i := 0; i < count && r.err == nil; i++ {
 
-	err := encoder.Encode(block)
+		block.Txs = append(block.Txs, decodeTransaction(r))
+	}
+
+	err := r.finish()
 	if err != nil {
-		log.Panic(err)
+
+		return nil, err
 	}
+	block.Hash = block.BlockHeader.Hash()
 
-	return result.Bytes()
+	return block, nil
 }
 
-//区块反序列化
+//区块反序列化  旧数据库中gob编码的区块在打开数据库时由migrateLegacyChain转换
 func DeSerializeBlock(blockBytes []byte) *Block  {
 
-	var block Block
+	block, err := DecodeBlock(blockBytes)
+	if err != nil {
 
-	decoder := gob.NewDecoder(bytes.NewReader(blockBytes))
+		log.Panic(err)
+	}
+
+	return block
+}
 
-	err := decoder.Decode(&block)
+//1.创建新的区块
+func NewBlock(txs []*Transaction, height int64, prevBlockHash []byte, targetBits int64) *Block {
 
+	block, err := MineBlock(context.Background(), txs, height, prevBlockHash, targetBits)
 	if err != nil {
 
 		log.Panic(err)
 	}
 
-	return &block
+	return block
 }
 
-
-//1.创建新的区块
-func NewBlock(txs []*Transaction, height int64, prevBlockHash []byte) *Block {
+//创建新的区块  ctx取消时放弃挖矿并返回错误
+func MineBlock(ctx context.Context, txs []*Transaction, height int64, prevBlockHash []byte, targetBits int64) (*Block, error) {
 
 	//创建区块
 	block := &Block{
-		Height:        height,
-		PrevBlockHash: prevBlockHash,
-		Txs:           txs,
-		Timestamp:     time.Now().Unix(),
-		Hash:          nil,
-		Nonce:         0}
+		BlockHeader: BlockHeader{
+			Version:       BlockVersion,
+			PrevBlockHash: prevBlockHash,
+			Timestamp:     time.Now().Unix(),
+			TargetBits:    targetBits,
+			Nonce:         0,
+			Height:        height},
+		Txs:  txs,
+		Hash: nil}
+
+	//默克尔根只在打包时计算一次，挖矿过程中只改变区块头的Nonce和时间戳
+	block.MerkleRoot = block.HashTransactions()
 
 	//调用工作量证明返回有效的Hash
 	pow := NewProofOfWork(block)
-	hash, nonce := pow.Run()
+	hash, nonce, err := pow.Mine(ctx, minerWorkers)
+	if err != nil {
+
+		return nil, err
+	}
 	block.Hash = hash[:]
 	block.Nonce = nonce
 
 	fmt.Printf("\r######%d-%x\n", nonce, hash)
 
-	return block
+	return block, nil
 }
 
 //单独方法生成创世区块
@@ -96,6 +133,7 @@ func CreateGenesisBlock(txs []*Transaction) *Block {
 		txs,
 		1,
 		[]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		genesisTargetBits,
 		)
 }
 
@@ -114,14 +152,59 @@ func (block *Block) HashTransactions() []byte  {
 	//
 	//return TxHash[:]
 
-	//默克尔树根节点表示交易哈希
+	//默克尔树根节点表示交易哈希  没有交易或默克尔树被篡改时返回nil
+	mTree, err := block.MerkleTree()
+	if err != nil {
+
+		return nil
+	}
+
+	return mTree.RootNode.Data
+}
+
+// 区块交易的默克尔树  叶子为包含解锁脚本的交易哈希
+// 交易哈希不包含解锁脚本，用它做叶子时篡改区块中的签名不会改变区块哈希，节点会把篡改后无效的区块哈希记为无效
+func (block *Block) MerkleTree() (*MerkleTree, error) {
+
 	var transactions [][]byte
 
 	for _, tx := range block.Txs {
 
-		transactions = append(transactions, tx.Serialize())
+		transactions = append(transactions, tx.SignedHash())
 	}
-	mTree := NewMerkleTree(transactions)
 
-	return mTree.RootNode.Data
+	return NewMerkleTree(transactions)
+}
+
+// 交易在区块中的默克尔证明  返回从叶子到根的兄弟节点哈希和交易的位置
+// 位置的第i位为1表示第i层的节点是右节点
+func (block *Block) MerkleProof(txHash []byte) ([][]byte, int, error) {
+
+	var signedHash []byte
+	for _, tx := range block.Txs {
+
+		if bytes.Compare(tx.TxHash, txHash) == 0 {
+
+			signedHash = tx.SignedHash()
+			break
+		}
+	}
+	if signedHash == nil {
+
+		return nil, 0, fmt.Errorf("tx %x is not in block %x", txHash, block.Hash)
+	}
+
+	mTree, err := block.MerkleTree()
+	if err != nil {
+
+		return nil, 0, err
+	}
+
+	branch, index, ok := mTree.MerkleBranch(signedHash)
+	if ok == false {
+
+		return nil, 0, fmt.Errorf("tx %x is not in block %x", txHash, block.Hash)
+	}
+
+	return branch, index, nil
 }
@@ -0,0 +1,114 @@
+package BLC
+
+import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+/**
+区块过滤器  每个区块一个GCS过滤器，轻节点不必告诉全节点自己的地址
+
+元素：
+1.所有可花费输出的锁定脚本(数据输出和空脚本除外)
+2.非创币交易花费的所有输出位置：交易哈希 + 输出下标(uint32小端序)
+钱包用自己地址的锁定脚本和未花费输出的位置去匹配，匹配上才下载完整区块
+*/
+
+// 过滤器类型  目前只有基础过滤器
+const BasicFilterType = byte(0)
+
+// 输出位置在过滤器中的编码
+func filterOutPoint(txHash []byte, vout int) []byte {
+
+	w := &binaryWriter{}
+	w.buf.Write(txHash)
+	w.writeUint32(uint32(vout))
+
+	return w.Bytes()
+}
+
+// 区块过滤器的元素
+func (block *Block) FilterItems() [][]byte {
+
+	var items [][]byte
+
+	for _, tx := range block.Txs {
+
+		if tx.IsCoinbaseTransaction() == false {
+
+			for _, in := range tx.Vins {
+
+				items = append(items, filterOutPoint(in.TxHash, in.Vout))
+			}
+		}
+
+		for _, out := range tx.Vouts {
+
+			if len(out.ScriptPubKey) == 0 || IsUnspendable(out.ScriptPubKey) {
+
+				continue
+			}
+			items = append(items, out.ScriptPubKey)
+		}
+	}
+
+	return items
+}
+
+// 计算区块的基础过滤器
+func NewBlockFilter(block *Block) *GCSFilter {
+
+	return NewGCSFilter(gcsFilterKey(block.Hash), block.FilterItems())
+}
+
+// 保存区块过滤器
+func putBlockFilter(tx *bolt.Tx, block *Block) error {
+
+	b, err := tx.CreateBucketIfNotExists([]byte(blockFilterTableName))
+	if err != nil {
+
+		return err
+	}
+
+	return b.Put(block.Hash, NewBlockFilter(block).Serialize())
+}
+
+// 从过滤器表读取区块过滤器，旧数据库中没有的由完整区块计算
+func loadBlockFilter(tx *bolt.Tx, hash []byte) []byte {
+
+	if b := tx.Bucket([]byte(blockFilterTableName)); b != nil {
+
+		if filterBytes := b.Get(hash); filterBytes != nil {
+
+			return filterBytes
+		}
+	}
+
+	block := loadBlock(tx.Bucket([]byte(blockTableName)), hash)
+	if block == nil {
+
+		return nil
+	}
+
+	return NewBlockFilter(block).Serialize()
+}
+
+// 获取对应哈希的区块过滤器
+func (blc *Blockchain) GetBlockFilter(hash []byte) ([]byte, error) {
+
+	var filterBytes []byte
+
+	err := blc.DB.View(func(tx *bolt.Tx) error {
+
+		filterBytes = loadBlockFilter(tx, hash)
+		if filterBytes == nil {
+
+			return fmt.Errorf("block filter %x is not found", hash)
+		}
+
+		return nil
+	})
+
+	return filterBytes, err
+}
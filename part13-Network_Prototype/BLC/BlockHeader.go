@@ -0,0 +1,124 @@
+package BLC
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// 当前区块版本
+const BlockVersion = 1
+
+// 区块头编码后的固定长度
+// 版本(4) + 上一个区块哈希(32) + 默克尔根(32) + 时间戳(8) + 难度(4) + Nonce(8) + 高度(8)
+const blockHeaderSize = 96
+
+// Nonce在区块头编码中的偏移，挖矿时只需要改写这8个字节
+const blockHeaderNonceOffset = 4 + 32 + 32 + 8 + 4
+
+// 区块头  区块哈希只由区块头计算，交易通过默克尔根关联
+type BlockHeader struct {
+	//1.版本
+	Version int64
+	//2.上一个区块HAsh
+	PrevBlockHash []byte
+	//3.交易的默克尔根
+	MerkleRoot []byte
+	//4.时间戳
+	Timestamp int64
+	//5.难度 期望Hash值前面至少要有多少个零
+	TargetBits int64
+	//6.Nonce  符合工作量证明的随机数
+	Nonce int64
+	//7.区块高度
+	Height int64
+}
+
+// 区块头编码  定长，哈希字段固定32字节
+func (header *BlockHeader) encode(w *binaryWriter) {
+
+	w.writeUint32(uint32(header.Version))
+	w.writeHash(header.PrevBlockHash)
+	w.writeHash(header.MerkleRoot)
+	w.writeInt64(header.Timestamp)
+	w.writeUint32(uint32(header.TargetBits))
+	w.writeInt64(header.Nonce)
+	w.writeInt64(header.Height)
+}
+
+func decodeBlockHeader(r *binaryReader) BlockHeader {
+
+	header := BlockHeader{}
+	header.Version = int64(r.readUint32())
+	header.PrevBlockHash = r.readHash()
+	header.MerkleRoot = r.readHash()
+	header.Timestamp = r.readInt64()
+	header.TargetBits = int64(r.readUint32())
+	header.Nonce = r.readInt64()
+	header.Height = r.readInt64()
+
+	return header
+}
+
+// 区块头序列化
+func (header *BlockHeader) Serialize() []byte {
+
+	w := &binaryWriter{}
+	header.encode(w)
+
+	return w.Bytes()
+}
+
+// 区块头反序列化
+func DecodeBlockHeader(data []byte) (*BlockHeader, error) {
+
+	if len(data) != blockHeaderSize {
+
+		return nil, fmt.Errorf("block header must be %d bytes, got %d", blockHeaderSize, len(data))
+	}
+
+	r := newBinaryReader(data)
+	header := decodeBlockHeader(r)
+
+	return &header, r.finish()
+}
+
+// 区块哈希
+func (header *BlockHeader) Hash() []byte {
+
+	hash := sha256.Sum256(header.Serialize())
+
+	return hash[:]
+}
+
+// 难度位数是否在合法范围内  TargetBits来自网络，超出范围时移位量会溢出
+func (header *BlockHeader) validTargetBits() bool {
+
+	return header.TargetBits >= minTargetBits && header.TargetBits <= maxTargetBits
+}
+
+// 区块头哈希是否满足自身携带的难度目标
+func (header *BlockHeader) CheckProofOfWork() bool {
+
+	if header.validTargetBits() == false {
+
+		return false
+	}
+
+	target := new(big.Int).Lsh(big.NewInt(1), uint(256-header.TargetBits))
+	hashInt := new(big.Int).SetBytes(header.Hash())
+
+	return hashInt.Cmp(target) < 0
+}
+
+// 区块本身的工作量  期望的哈希次数为2^TargetBits
+// 难度位数不合法的区块头没有工作量
+func (header *BlockHeader) Work() *big.Int {
+
+	if header.validTargetBits() == false {
+
+		return new(big.Int)
+	}
+
+	return new(big.Int).Lsh(big.NewInt(1), uint(header.TargetBits))
+}
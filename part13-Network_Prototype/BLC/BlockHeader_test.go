@@ -0,0 +1,106 @@
+package BLC
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// 区块哈希只由区块头计算  交易只通过默克尔根影响哈希
+func TestBlockHashCoversHeaderOnly(t *testing.T) {
+
+	block := vectorBlock()
+	if bytes.Compare(block.Hash, block.BlockHeader.Hash()) != 0 {
+
+		t.Fatalf("block hash %x, header hash %x", block.Hash, block.BlockHeader.Hash())
+	}
+
+	data := block.BlockHeader.Serialize()
+	if len(data) != blockHeaderSize {
+
+		t.Fatalf("header %d bytes, want %d", len(data), blockHeaderSize)
+	}
+	if nonce := int64(binary.LittleEndian.Uint64(data[blockHeaderNonceOffset:])); nonce != block.Nonce {
+
+		t.Errorf("nonce at offset %d is %d, want %d", blockHeaderNonceOffset, nonce, block.Nonce)
+	}
+
+	decoded, err := DecodeBlockHeader(data)
+	if err != nil || bytes.Compare(decoded.Serialize(), data) != 0 {
+
+		t.Errorf("decode header: %v", err)
+	}
+	for _, size := range []int{0, blockHeaderSize - 1, blockHeaderSize + 1} {
+
+		if _, err := DecodeBlockHeader(make([]byte, size)); err == nil {
+
+			t.Errorf("%d byte header decoded", size)
+		}
+	}
+
+	// 不改变默克尔根时，交易不参与哈希
+	withoutTxs := *block
+	withoutTxs.Txs = nil
+	if bytes.Compare(withoutTxs.BlockHeader.Hash(), block.Hash) != 0 {
+
+		t.Errorf("hash depends on the transaction list")
+	}
+
+	changes := map[string]func(header *BlockHeader){
+		"version":     func(header *BlockHeader) { header.Version++ },
+		"prev hash":   func(header *BlockHeader) { header.PrevBlockHash = repeatHash(0x01) },
+		"merkle root": func(header *BlockHeader) { header.MerkleRoot = repeatHash(0x02) },
+		"timestamp":   func(header *BlockHeader) { header.Timestamp++ },
+		"target bits": func(header *BlockHeader) { header.TargetBits++ },
+		"nonce":       func(header *BlockHeader) { header.Nonce++ },
+		"height":      func(header *BlockHeader) { header.Height++ },
+	}
+	for name, change := range changes {
+
+		header := block.BlockHeader
+		change(&header)
+		if bytes.Compare(header.Hash(), block.Hash) == 0 {
+
+			t.Errorf("%s does not change the hash", name)
+		}
+	}
+}
+
+// 区块头单独存储，旧数据库中没有区块头表的记录时从完整区块中读取
+func TestGetBlockHeader(t *testing.T) {
+
+	blc := newTestBlockchain(t)
+	block := mineTestBlock(t, blc, tipBlock(t, blc))
+	mustAddBlock(t, blc, block)
+
+	header, err := blc.GetBlockHeader(block.Hash)
+	if err != nil || bytes.Compare(header.Serialize(), block.BlockHeader.Serialize()) != 0 {
+
+		t.Fatalf("header %+v: %v", header, err)
+	}
+	if blc.GetBestHeight() != block.Height {
+
+		t.Errorf("best height %d, want %d", blc.GetBestHeight(), block.Height)
+	}
+
+	err = blc.DB.Update(func(tx *bolt.Tx) error {
+
+		return tx.Bucket([]byte(blockHeaderTableName)).Delete(block.Hash)
+	})
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	header, err = blc.GetBlockHeader(block.Hash)
+	if err != nil || bytes.Compare(header.Hash(), block.Hash) != 0 {
+
+		t.Errorf("header from the block table: %v", err)
+	}
+
+	if _, err := blc.GetBlockHeader(repeatHash(0x03)); err == nil {
+
+		t.Errorf("unknown header found")
+	}
+}
@@ -0,0 +1,344 @@
+package BLC
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+/**
+先同步区块头，再下载区块
+
+1.请求方用区块定位器(block locator)描述自己的主链：从链顶端开始的10个区块，之后每次间隔加倍，最后是创世区块
+2.对方在定位器中找到第一个在自己主链上的区块(分叉点)，返回其后最多maxHeadersPerMessage个区块头
+3.请求方校验每个区块头(工作量证明、难度、时间戳、高度)后保存，再逐个下载区块体
+4.区块体下载完后继续请求下一批区块头，中断后重新握手，从本地主链的定位器继续同步
+
+主链高度索引  键为8字节大端序高度，值为区块哈希，切换主链时同步更新
+定位器和区块头查找都只读取需要的高度，不必遍历整条链
+*/
+const mainChainTableName = "chaorsMainChain"
+
+// 定位器中连续的区块个数，之后间隔加倍
+const locatorDenseCount = 10
+
+// 区块头的父区块头还没有收到
+var errHeaderOrphan = errors.New("parent header is unknown")
+
+// 区块头没有通过校验(工作量证明、难度、时间戳、高度)  只有这种错误计入对方的不良行为
+var errInvalidHeader = errors.New("invalid header")
+
+// 分支上没有区块体的区块头太多
+var errTooManyHeaders = errors.New("too many headers without blocks")
+
+// 一条分支上连续没有区块体的区块头最多保存多少个
+// 区块头在下载区块体之前就写入数据库，不限制时对方可以用低难度的分支不断填充区块头表
+// 正常同步时一批区块头下载完区块体后才请求下一批，留出一批的余量
+var maxHeadersWithoutBlocks = 2 * maxHeadersPerMessage
+
+// 高度索引的键
+func heightKey(height int64) []byte {
+
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+
+	return key
+}
+
+// 主链上某个高度的区块哈希  索引中没有返回nil
+func mainChainHash(tx *bolt.Tx, height int64) []byte {
+
+	b := tx.Bucket([]byte(mainChainTableName))
+	if b == nil {
+
+		return nil
+	}
+
+	return b.Get(heightKey(height))
+}
+
+// 切换主链时更新高度索引  还没有建立索引的旧数据库由ensureMainChainIndex一次性建立
+func updateMainChainIndex(tx *bolt.Tx, detached []*Block, attached []*Block) error {
+
+	b := tx.Bucket([]byte(mainChainTableName))
+	if b == nil {
+
+		return nil
+	}
+
+	for _, block := range detached {
+
+		err := b.Delete(heightKey(block.Height))
+		if err != nil {
+
+			return err
+		}
+	}
+
+	for _, block := range attached {
+
+		err := b.Put(heightKey(block.Height), block.Hash)
+		if err != nil {
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 没有高度索引时从链顶端向前遍历一次建立索引
+func (blc *Blockchain) ensureMainChainIndex() {
+
+	err := blc.DB.Update(func(tx *bolt.Tx) error {
+
+		if tx.Bucket([]byte(mainChainTableName)) != nil {
+
+			return nil
+		}
+
+		b, err := tx.CreateBucket([]byte(mainChainTableName))
+		if err != nil {
+
+			return err
+		}
+
+		header := loadBlockHeader(tx, blc.Tip)
+		for header != nil {
+
+			err = b.Put(heightKey(header.Height), header.Hash())
+			if err != nil {
+
+				return err
+			}
+			header = loadBlockHeader(tx, header.PrevBlockHash)
+		}
+
+		return nil
+	})
+	if err != nil {
+
+		log.Panic(err)
+	}
+}
+
+// 定位器需要的区块高度  从tipHeight开始，前10个连续，之后间隔加倍，最后是创世区块
+func locatorHeights(tipHeight int64) []int64 {
+
+	var heights []int64
+
+	step := int64(1)
+	for height := tipHeight; height > 1; height -= step {
+
+		heights = append(heights, height)
+		if len(heights) >= locatorDenseCount {
+
+			step *= 2
+		}
+	}
+
+	return append(heights, 1)
+}
+
+// 本地主链的区块定位器
+func (blc *Blockchain) BlockLocator() [][]byte {
+
+	var locator [][]byte
+
+	err := blc.DB.View(func(tx *bolt.Tx) error {
+
+		tip := loadBlockHeader(tx, blc.Tip)
+		if tip == nil {
+
+			return nil
+		}
+
+		for _, height := range locatorHeights(tip.Height) {
+
+			if hash := mainChainHash(tx, height); hash != nil {
+
+				locator = append(locator, hash)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+
+		log.Panic(err)
+	}
+
+	return locator
+}
+
+// 没有高度索引时沿父区块向前查找，生成以tip为顶端的定位器
+func headerLocator(tip *BlockHeader, lookup func(hash []byte) *BlockHeader) [][]byte {
+
+	var locator [][]byte
+
+	header := tip
+	for _, height := range locatorHeights(tip.Height) {
+
+		header = ancestorHeader(header, height, lookup)
+		if header == nil {
+
+			break
+		}
+		locator = append(locator, header.Hash())
+	}
+
+	return locator
+}
+
+// 在主链上找到定位器中的第一个区块，返回其后最多maxCount个区块头，到stopHash为止
+// 定位器中没有主链上的区块时从创世区块开始
+func (blc *Blockchain) HeadersAfterLocator(locator [][]byte, stopHash []byte, maxCount int) []*BlockHeader {
+
+	var headers []*BlockHeader
+
+	err := blc.DB.View(func(tx *bolt.Tx) error {
+
+		forkHeight := int64(0)
+		for _, hash := range locator {
+
+			header := loadBlockHeader(tx, hash)
+			if header != nil && bytes.Compare(mainChainHash(tx, header.Height), hash) == 0 {
+
+				forkHeight = header.Height
+				break
+			}
+		}
+
+		for height := forkHeight + 1; len(headers) < maxCount; height++ {
+
+			hash := mainChainHash(tx, height)
+			if hash == nil {
+
+				break
+			}
+
+			header := loadBlockHeader(tx, hash)
+			if header == nil {
+
+				return fmt.Errorf("block header %x is not found", hash)
+			}
+			headers = append(headers, header)
+
+			if bytes.Compare(hash, stopHash) == 0 {
+
+				break
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+
+		log.Panic(err)
+	}
+
+	return headers
+}
+
+// 主链上从startHeight到stopHash之间的区块哈希，最多maxCount个  stopHash不在主链上时返回nil
+func (blc *Blockchain) MainChainHashes(startHeight int64, stopHash []byte, maxCount int) [][]byte {
+
+	var hashes [][]byte
+
+	err := blc.DB.View(func(tx *bolt.Tx) error {
+
+		stop := loadBlockHeader(tx, stopHash)
+		if stop == nil || bytes.Compare(mainChainHash(tx, stop.Height), stopHash) != 0 {
+
+			return nil
+		}
+
+		for height := startHeight; height <= stop.Height && len(hashes) < maxCount; height++ {
+
+			hash := mainChainHash(tx, height)
+			if hash == nil {
+
+				break
+			}
+			hashes = append(hashes, hash)
+		}
+
+		return nil
+	})
+	if err != nil {
+
+		log.Panic(err)
+	}
+
+	return hashes
+}
+
+// 校验并保存一个区块头  区块体下载前调用，父区块头必须已知
+// 返回区块体是否还需要下载
+func (blc *Blockchain) AcceptHeader(header *BlockHeader) (bool, error) {
+
+	hash := header.Hash()
+
+	blockBytes, err := blc.GetBlock(hash)
+	if err != nil {
+
+		return false, err
+	}
+	if blockBytes != nil {
+
+		return false, nil
+	}
+
+	parent := blc.lookupBlockHeader(header.PrevBlockHash)
+	if parent == nil {
+
+		return false, fmt.Errorf("header %x: %w", hash, errHeaderOrphan)
+	}
+
+	err = checkHeader(header, parent, blc.lookupBlockHeader)
+	if err != nil {
+
+		return false, fmt.Errorf("%w: %s", errInvalidHeader, err)
+	}
+
+	err = blc.DB.Update(func(tx *bolt.Tx) error {
+
+		if headersWithoutBlocks(tx, header.PrevBlockHash, maxHeadersWithoutBlocks) >= maxHeadersWithoutBlocks {
+
+			return fmt.Errorf("header %x: %w", hash, errTooManyHeaders)
+		}
+
+		return putBlockHeader(tx, hash, header)
+	})
+
+	return true, err
+}
+
+// 从hash开始沿父区块往回数连续没有区块体的区块头个数，最多数到limit
+func headersWithoutBlocks(tx *bolt.Tx, hash []byte, limit int) int {
+
+	blocks := tx.Bucket([]byte(blockTableName))
+
+	count := 0
+	for count < limit {
+
+		if blocks.Get(hash) != nil {
+
+			break
+		}
+
+		header := loadBlockHeader(tx, hash)
+		if header == nil {
+
+			break
+		}
+		count++
+		hash = header.PrevBlockHash
+	}
+
+	return count
+}
@@ -0,0 +1,233 @@
+package BLC
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLocatorHeights(t *testing.T) {
+
+	tests := []struct {
+		tip  int64
+		want []int64
+	}{
+		{1, []int64{1}},
+		{5, []int64{5, 4, 3, 2, 1}},
+		{11, []int64{11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}},
+		// 前10个连续，之后间隔加倍，最后是创世区块
+		{15, []int64{15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 4, 1}},
+		{40, []int64{40, 39, 38, 37, 36, 35, 34, 33, 32, 31, 29, 25, 17, 1}},
+	}
+
+	for _, test := range tests {
+
+		if got := locatorHeights(test.tip); reflect.DeepEqual(got, test.want) == false {
+
+			t.Errorf("tip %d: got %v, want %v", test.tip, got, test.want)
+		}
+	}
+}
+
+// 区块头的哈希
+func headerHashes(headers []*BlockHeader) [][]byte {
+
+	var hashes [][]byte
+	for _, header := range headers {
+
+		hashes = append(hashes, header.Hash())
+	}
+
+	return hashes
+}
+
+func TestHeadersAfterLocator(t *testing.T) {
+
+	blc := newTestBlockchain(t)
+
+	// 主链 genesis - b2 - b3 - b4 - b5，侧链 genesis - s2 - s3
+	chain := []*Block{tipBlock(t, blc)}
+	for i := 0; i < 4; i++ {
+
+		block := mineTestBlock(t, blc, chain[len(chain)-1])
+		mustAddBlock(t, blc, block)
+		chain = append(chain, block)
+	}
+	s2 := mineTestBlock(t, blc, chain[0])
+	mustAddBlock(t, blc, s2)
+	s3 := mineTestBlock(t, blc, s2)
+	mustAddBlock(t, blc, s3)
+	if bytes.Compare(blc.Tip, chain[4].Hash) != 0 {
+
+		t.Fatalf("tip %x, want %x", blc.Tip, chain[4].Hash)
+	}
+
+	locator := blc.BlockLocator()
+	if reflect.DeepEqual(locator, [][]byte{chain[4].Hash, chain[3].Hash, chain[2].Hash, chain[1].Hash, chain[0].Hash}) == false {
+
+		t.Errorf("locator %x", locator)
+	}
+	if reflect.DeepEqual(headerLocator(&chain[4].BlockHeader, blc.lookupBlockHeader), locator) == false {
+
+		t.Errorf("header locator differs from the main chain index")
+	}
+
+	hashes := func(blocks ...*Block) [][]byte {
+
+		var hashes [][]byte
+		for _, block := range blocks {
+
+			hashes = append(hashes, block.Hash)
+		}
+
+		return hashes
+	}
+
+	tests := []struct {
+		name     string
+		locator  [][]byte
+		stopHash []byte
+		maxCount int
+		want     [][]byte
+	}{
+		{"from fork point", [][]byte{chain[2].Hash, chain[0].Hash}, nil, 10, hashes(chain[3], chain[4])},
+		// 侧链上的区块跳过，找到定位器中第一个在主链上的区块
+		{"skip side branch", [][]byte{s3.Hash, s2.Hash, chain[0].Hash}, nil, 10, hashes(chain[1:]...)},
+		// 都不在主链上时从创世区块开始，包括创世区块
+		{"unknown locator", [][]byte{repeatHash(0x01)}, nil, 10, hashes(chain...)},
+		{"empty locator", nil, nil, 10, hashes(chain...)},
+		{"stop hash", [][]byte{chain[0].Hash}, chain[2].Hash, 10, hashes(chain[1], chain[2])},
+		{"max count", [][]byte{chain[0].Hash}, nil, 2, hashes(chain[1], chain[2])},
+		{"up to date", locator, nil, 10, nil},
+	}
+
+	for _, test := range tests {
+
+		got := headerHashes(blc.HeadersAfterLocator(test.locator, test.stopHash, test.maxCount))
+		if reflect.DeepEqual(got, test.want) == false {
+
+			t.Errorf("%s: got %x, want %x", test.name, got, test.want)
+		}
+	}
+
+	if got := blc.MainChainHashes(2, chain[3].Hash, 10); reflect.DeepEqual(got, hashes(chain[1], chain[2], chain[3])) == false {
+
+		t.Errorf("main chain hashes %x", got)
+	}
+	if got := blc.MainChainHashes(2, s3.Hash, 10); got != nil {
+
+		t.Errorf("main chain hashes up to a side block %x", got)
+	}
+}
+
+func TestAcceptHeader(t *testing.T) {
+
+	blc := newTestBlockchain(t)
+	genesis := tipBlock(t, blc)
+
+	saved := maxHeadersWithoutBlocks
+	maxHeadersWithoutBlocks = 2
+	defer func() {
+
+		maxHeadersWithoutBlocks = saved
+	}()
+
+	b2 := mineTestBlock(t, blc, genesis)
+	b3 := mineTestBlock(t, blc, b2)
+	b4 := mineTestBlock(t, blc, b3)
+
+	// 已有区块体的区块头不需要下载
+	if need, err := blc.AcceptHeader(&genesis.BlockHeader); need || err != nil {
+
+		t.Errorf("genesis: need %v, %v", need, err)
+	}
+
+	if _, err := blc.AcceptHeader(&b3.BlockHeader); errors.Is(err, errHeaderOrphan) == false {
+
+		t.Errorf("orphan header: %v, want %v", err, errHeaderOrphan)
+	}
+
+	forged := b2.BlockHeader
+	forged.Timestamp++
+	if _, err := blc.AcceptHeader(&forged); errors.Is(err, errInvalidHeader) == false {
+
+		t.Errorf("forged header: %v, want %v", err, errInvalidHeader)
+	}
+
+	for _, block := range []*Block{b2, b3} {
+
+		if need, err := blc.AcceptHeader(&block.BlockHeader); need == false || err != nil {
+
+			t.Fatalf("header %d: need %v, %v", block.Height, need, err)
+		}
+	}
+
+	// 分支上已经有2个没有区块体的区块头
+	if _, err := blc.AcceptHeader(&b4.BlockHeader); errors.Is(err, errTooManyHeaders) == false {
+
+		t.Errorf("header beyond the cap: %v, want %v", err, errTooManyHeaders)
+	}
+	if blc.lookupBlockHeader(b4.Hash) != nil {
+
+		t.Errorf("header beyond the cap was stored")
+	}
+
+	// 区块体下载后可以继续接收
+	mustAddBlock(t, blc, b2)
+	if need, err := blc.AcceptHeader(&b4.BlockHeader); need == false || err != nil {
+
+		t.Errorf("header after blocks arrived: need %v, %v", need, err)
+	}
+}
+
+// 只有校验不通过的区块头计入不良行为
+func TestHandleHeadersScoresInvalidHeadersOnly(t *testing.T) {
+
+	blc := newTestBlockchain(t)
+	genesis := tipBlock(t, blc)
+	useTestBanList(t, "test")
+
+	saved := maxHeadersWithoutBlocks
+	maxHeadersWithoutBlocks = 1
+	defer func() {
+
+		maxHeadersWithoutBlocks = saved
+	}()
+
+	b2 := mineTestBlock(t, blc, genesis)
+	b3 := mineTestBlock(t, blc, b2)
+	forged := b2.BlockHeader
+	forged.Nonce++
+	_, err := blc.AcceptHeader(&b2.BlockHeader)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		headers []*BlockHeader
+		score   int32
+	}{
+		{"known block", []*BlockHeader{&genesis.BlockHeader}, 0},
+		{"orphan", []*BlockHeader{&mineTestBlock(t, blc, b3).BlockHeader}, 0},
+		{"too many without blocks", []*BlockHeader{&b3.BlockHeader}, 0},
+		{"invalid", []*BlockHeader{&forged}, scoreInvalidBlock},
+		// 无效区块头之后的区块头一起丢弃
+		{"invalid then valid", []*BlockHeader{&forged, &b3.BlockHeader}, scoreInvalidBlock},
+	}
+
+	for i, test := range tests {
+
+		peer := newBanTestPeer(t, fmt.Sprintf("203.0.113.%d:3000", i+1), fmt.Sprintf("203.0.113.%d:3000", i+1), false)
+		handleHeaders(peer, encodeMessage(&Headers{"203.0.113.1:3000", test.headers}), blc)
+
+		if score := atomic.LoadInt32(&peer.banScore); score != test.score {
+
+			t.Errorf("%s: score %d, want %d", test.name, score, test.score)
+		}
+	}
+}
@@ -28,6 +28,10 @@ import (
 const dbName = "chaorsBlockchain_%s.db"
 const blockTableName = "chaorsBlocks"
 const newestBlockKey = "chNewestBlockKey"
+//区块头单独存储  键为区块哈希，值为定长区块头
+const blockHeaderTableName = "chaorsBlockHeaders"
+//区块过滤器  键为区块哈希，值为GCS过滤器
+const blockFilterTableName = "chaorsBlockFilters"
 
 type Blockchain struct {
 	//最新区块的Hash
@@ -55,6 +59,7 @@ func CreateBlockchainWithGensisBlock(address string, nodeID string) *Blockchain
 		if err != nil {
 			log.Fatal(err)
 		}
+		openLegacyChain(db)
 
 		var block *Block
 		err = db.View(func(tx *bolt.Tx) error {
@@ -62,7 +67,7 @@ func CreateBlockchainWithGensisBlock(address string, nodeID string) *Blockchain
 			b := tx.Bucket([]byte(blockTableName))
 			if b != nil {
 
-				hash := b.Get([]byte(newestBlockKey))
+				hash := append([]byte{}, b.Get([]byte(newestBlockKey))...)
 				blockBytes := b.Get(hash)
 				block = DeSerializeBlock(blockBytes)
 				fmt.Printf("\r######%d-%x\n", block.Nonce, hash)
@@ -101,7 +106,7 @@ func CreateBlockchainWithGensisBlock(address string, nodeID string) *Blockchain
 		if b != nil {
 
 			//创币交易
-			txCoinbase := NewCoinbaseTransaction(address)
+			txCoinbase := NewCoinbaseTransaction(address, 1, 0)
 			//创世区块
 			gensisBlock := CreateGenesisBlock([]*Transaction{txCoinbase})
 			//存入数据库
@@ -110,12 +115,40 @@ func CreateBlockchainWithGensisBlock(address string, nodeID string) *Blockchain
 				log.Panic(err)
 			}
 
+			//存储区块头
+			err = putBlockHeader(tx, gensisBlock.Hash, &gensisBlock.BlockHeader)
+			if err != nil {
+				log.Panic(err)
+			}
+
+			//存储区块过滤器
+			err = putBlockFilter(tx, gensisBlock)
+			if err != nil {
+				log.Panic(err)
+			}
+
+			//建立主链高度索引
+			mainChain, err := tx.CreateBucket([]byte(mainChainTableName))
+			if err != nil {
+				log.Panic(err)
+			}
+			err = mainChain.Put(heightKey(gensisBlock.Height), gensisBlock.Hash)
+			if err != nil {
+				log.Panic(err)
+			}
+
 			//存储最新区块hash
 			err = b.Put([]byte(newestBlockKey), gensisBlock.Hash)
 			if err != nil {
 				log.Panic(err)
 			}
 
+			//记录累计工作量
+			err = putChainWork(tx, gensisBlock.Hash, gensisBlock.Work())
+			if err != nil {
+				log.Panic(err)
+			}
+
 			blc = &Blockchain{gensisBlock.Hash, db}
 		}
 
@@ -134,7 +167,7 @@ func CreateBlockchainWithGensisBlock(address string, nodeID string) *Blockchain
 }
 
 //2.新增一个区块到区块链 --> 包含交易的挖矿
-func (blc *Blockchain) MineNewBlock(from []string, to []string, amount []string, nodeID string) *Block {
+func (blc *Blockchain) MineNewBlock(from []string, to []string, amount []string, feeRate int64, lockTime uint32, sequence uint32, nodeID string) (*Block, error) {
 
 	//send -from '["chaors"]' -to '["xyx"]' -amount '["5"]'
 
@@ -143,18 +176,44 @@ func (blc *Blockchain) MineNewBlock(from []string, to []string, amount []string,
 
 	var txs []*Transaction
 
-	//作为奖励给矿工的奖励  暂时将这笔奖励给from[0]  挖矿成功后再转给挖矿的矿工
-	tx := NewCoinbaseTransaction(from[0])
-	txs = append(txs, tx)
-
 	//1.通过相关算法建立Transaction数组
 	for index, address := range from {
 
 		value, _ := strconv.Atoi(amount[index])
-		tx := NewTransaction(address, to[index], int64(value), utxoSet, txs, nodeID)
+		tx := NewTransaction(address, to[index], int64(value), feeRate, lockTime, sequence, utxoSet, txs, nodeID)
 		txs = append(txs, tx)
 	}
 
+	//作为奖励给矿工的奖励  暂时将这笔奖励给from[0]  挖矿成功后再转给挖矿的矿工
+	return blc.MineTransactions(txs, from[0])
+}
+
+//把已经签名的交易打包成新区块  奖励为区块奖励加上打包交易的手续费，支付给rewardAddress
+//交易的时间锁未到期或验签失败时不挖矿，返回错误由调用方决定如何处理
+func (blc *Blockchain) MineTransactions(txs []*Transaction, rewardAddress string) (*Block, error) {
+
+	//获取UTXO集
+	utxoSet := &UTXOSet{blc}
+
+	for _, tx := range txs {
+
+		// 时间锁还没有到期的交易打包进区块会被拒绝
+		err := utxoSet.CheckTransactionLocks(tx)
+		if err != nil {
+
+			return nil, fmt.Errorf("tx %x can not be mined yet: %s", tx.TxHash, err)
+		}
+	}
+
+	//奖励为区块奖励加上打包交易的手续费
+	var fees int64
+	for _, tx := range txs {
+
+		fees += utxoSet.TransactionFee(tx, txs)
+	}
+	tx := NewCoinbaseTransaction(rewardAddress, blc.GetBestHeight()+1, fees)
+	txs = append([]*Transaction{tx}, txs...)
+
 	//2.挖矿
 	//取上个区块的哈希和高度值
 	var block *Block
@@ -172,7 +231,7 @@ func (blc *Blockchain) MineNewBlock(from []string, to []string, amount []string,
 	})
 	if err != nil {
 
-		log.Panic(err)
+		return nil, err
 	}
 
 	//建立新区快前需要对交易进行验签
@@ -182,47 +241,23 @@ func (blc *Blockchain) MineNewBlock(from []string, to []string, amount []string,
 
 		if blc.VerifyTransaction(tx, verifiedTxs) == false {
 
-			log.Printf("The Tx:%x verify failed.\n", tx.TxHash)
+			// 不合法的交易打包进区块也会被拒绝，没必要挖矿
+			return nil, fmt.Errorf("tx %x verify failed", tx.TxHash)
 		}
 		verifiedTxs = append(verifiedTxs, tx)
 	}
 
-	//3.建立新区块
-	block = NewBlock(txs, block.Height+1, block.Hash)
-
-	//4.存储新区块
-	err = blc.DB.Update(func(tx *bolt.Tx) error {
-
-		b := tx.Bucket([]byte(blockTableName))
-		if b != nil {
-
-			//fmt.Printf("444---%x\n\n", block.Txs[0].Vins[0].TxHash)
-			//fmt.Println(block)
-
-			err = b.Put(block.Hash, block.Serialize())
-			if err != nil {
-
-				log.Panic(err)
-			}
-
-			err = b.Put([]byte(newestBlockKey), block.Hash)
-			if err != nil {
-
-				log.Panic(err)
-			}
-
-			blc.Tip = block.Hash
-		}
+	//3.建立新区块  难度按调整规则计算
+	block = NewBlock(txs, block.Height+1, block.Hash, blc.NextTargetBits(&block.BlockHeader))
 
-		return nil
-	})
+	//4.存储新区块  同时更新UTXOSet和交易池
+	err = blc.AddBlock(block)
 	if err != nil {
 
-		log.Panic(err)
-		//fmt.Print(err)
+		return nil, err
 	}
 
-	return block
+	return block, nil
 }
 
 //3.X 优化区块链遍历方法
@@ -238,11 +273,14 @@ func (blc *Blockchain) Printchain() {
 		block := blcIterator.Next()
 
 		fmt.Println("------------------------------")
+		fmt.Printf("Version：%d\n", block.Version)
 		fmt.Printf("Height：%d\n", block.Height)
 		fmt.Printf("PrevBlockHash：%x\n", block.PrevBlockHash)
 		fmt.Printf("Timestamp：%s\n", time.Unix(block.Timestamp, 0).Format("2006-01-02 03:04:05 PM"))
+		fmt.Printf("MerkleRoot：%x\n", block.MerkleRoot)
 		fmt.Printf("Hash：%x\n", block.Hash)
 		fmt.Printf("Nonce：%d\n", block.Nonce)
+		fmt.Printf("TargetBits：%d\n", block.TargetBits)
 		fmt.Println("Txs:")
 		for _, tx := range block.Txs {
 
@@ -251,17 +289,18 @@ func (blc *Blockchain) Printchain() {
 			for _, in := range tx.Vins {
 				fmt.Printf("TxHash:%x\n", in.TxHash)
 				fmt.Printf("Vout:%d\n", in.Vout)
-				fmt.Printf("Signature:%x\n\n", in.Signature)
-				fmt.Printf("PublicKey:%x\n\n", in.PublicKey)
+				fmt.Printf("ScriptSig:%s\n", DisasmScript(in.ScriptSig))
+				fmt.Printf("Sequence:%x\n\n", in.Sequence)
 			}
 
 			fmt.Println("Vouts:")
 			for _, out := range tx.Vouts {
 				fmt.Printf("Value:%d\n", out.Value)
-				fmt.Printf("Ripemd160Hash:%x\n\n", out.Ripemd160Hash)
+				fmt.Printf("ScriptPubKey:%s\n\n", DisasmScript(out.ScriptPubKey))
 			}
+			fmt.Printf("LockTime:%d\n", tx.LockTime)
 		}
-		fmt.Println("------------------------------\n\n")
+		fmt.Print("------------------------------\n\n\n")
 
 		var hashInt big.Int
 		hashInt.SetBytes(block.PrevBlockHash)
@@ -292,13 +331,15 @@ func GetBlockchain(nodeID string) *Blockchain {
 		if err != nil {
 			log.Fatal(err)
 		}
+		openLegacyChain(db)
 
 		err = db.View(func(tx *bolt.Tx) error {
 
 			b := tx.Bucket([]byte(blockTableName))
 			if b != nil {
 
-				hash := b.Get([]byte(newestBlockKey))
+				// 复制一份，数据库中的切片只在事务内有效
+				hash := append([]byte{}, b.Get([]byte(newestBlockKey))...)
 				blc = &Blockchain{hash, db}
 			}
 
@@ -393,12 +434,12 @@ func (blc *Blockchain) UTXOs(address string, txs []*Transaction) []*UTXO {
 
 							if isUnSpentUTXO {
 
-								utxo := &UTXO{tx.TxHash, index, out}
+								utxo := &UTXO{tx.TxHash, index, out, block.Height, tx.IsCoinbaseTransaction()}
 								utxos = append(utxos, utxo)
 							}
 						} else {
 
-							utxo := &UTXO{tx.TxHash, index, out}
+							utxo := &UTXO{tx.TxHash, index, out, block.Height, tx.IsCoinbaseTransaction()}
 							utxos = append(utxos, utxo)
 						}
 					}
@@ -463,19 +504,19 @@ func (blc *Blockchain) UTXOs(address string, txs []*Transaction) []*UTXO {
 
 								if isUnSpentUTXO {
 
-									utxo := &UTXO{tx.TxHash, index, out}
+									utxo := &UTXO{tx.TxHash, index, out, 0, tx.IsCoinbaseTransaction()}
 									utxos = append(utxos, utxo)
 								}
 							}
 						} else {
 
-							utxo := &UTXO{tx.TxHash, index, out}
+							utxo := &UTXO{tx.TxHash, index, out, 0, tx.IsCoinbaseTransaction()}
 							utxos = append(utxos, utxo)
 						}
 					}
 				} else {
 
-					utxo := &UTXO{tx.TxHash, index, out}
+					utxo := &UTXO{tx.TxHash, index, out, 0, tx.IsCoinbaseTransaction()}
 					utxos = append(utxos, utxo)
 				}
 			}
@@ -510,7 +551,7 @@ func (blc *Blockchain) FindSpendableUTXOs(address string, amount int, txs []*Tra
 	//余额不足
 	if value < int64(amount) {
 
-		fmt.Printf("%s found.余额不足...", value)
+		fmt.Printf("%d found.余额不足...", value)
 		os.Exit(1)
 	}
 
@@ -626,10 +667,12 @@ func (blc *Blockchain) VerifyTransaction(tx *Transaction, txs []*Transaction) bo
 
 	for _, vin := range tx.Vins {
 
+		// 引用的交易找不到时交易无效  交易来自网络，不能因此退出
 		prevTX, err := blc.FindTransaction(vin.TxHash, txs)
 		if err != nil {
 
-			log.Panic(err)
+			fmt.Printf("tx %x: input %x: %s\n", tx.TxHash, vin.TxHash, err)
+			return false
 		}
 		prevTXs[hex.EncodeToString(prevTX.TxHash)] = prevTX
 	}
@@ -677,6 +720,12 @@ func (blc *Blockchain) FindUTXOMap() map[string]*TXOutputs  {
 		WorkOutLoop:
 			for index,out := range tx.Vouts  {
 
+				// 数据输出不可花费，不进入UTXOSet
+				if IsUnspendable(out.ScriptPubKey) {
+
+					continue
+				}
+
 				txInputs := spentableUTXOsMap[TxHash]
 
 				if len(txInputs) > 0 {
@@ -685,29 +734,24 @@ func (blc *Blockchain) FindUTXOMap() map[string]*TXOutputs  {
 
 					for _,in := range  txInputs {
 
-						outPublicKey := out.Ripemd160Hash
-						inPublicKey := in.PublicKey
-
-						if bytes.Compare(outPublicKey,Ripemd160Hash(inPublicKey)) == 0{
-
-							if index == in.Vout {
+						// 同一笔交易的输出由下标区分
+						if index == in.Vout {
 
-								isUnSpent = false
-								continue WorkOutLoop
-							}
+							isUnSpent = false
+							continue WorkOutLoop
 						}
 
 					}
 
 					if isUnSpent {
 
-						utxo := &UTXO{tx.TxHash,index,out}
+						utxo := &UTXO{tx.TxHash, index, out, block.Height, tx.IsCoinbaseTransaction()}
 						txOutputs.UTXOS = append(txOutputs.UTXOS, utxo)
 					}
 
 				} else {
 
-					utxo := &UTXO{tx.TxHash,index,out}
+					utxo := &UTXO{tx.TxHash, index, out, block.Height, tx.IsCoinbaseTransaction()}
 					txOutputs.UTXOS = append(txOutputs.UTXOS, utxo)
 				}
 
@@ -730,12 +774,16 @@ func (blc *Blockchain) FindUTXOMap() map[string]*TXOutputs  {
 	return utxoMaps
 }
 
-// 获取区块链最大高度
+// 获取区块链最大高度  只需要读取区块头
 func (blc *Blockchain) GetBestHeight() int64 {
 
-	block := blc.Iterator().Next()
+	header, err := blc.GetBlockHeader(blc.Tip)
+	if err != nil {
 
-	return block.Height
+		log.Panic(err)
+	}
+
+	return header.Height
 }
 
 // 获取区块所有哈希
@@ -802,49 +850,154 @@ func (blc *Blockchain) GetBlock(bHash []byte) ([]byte, error)  {
 	return blockBytes, err
 }
 
-// 将同步请求的主链区块添加到区块链
+// 保存区块头
+func putBlockHeader(tx *bolt.Tx, hash []byte, header *BlockHeader) error {
 
-func (blc *Blockchain) AddBlock(block *Block) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(blockHeaderTableName))
+	if err != nil {
 
-	var err error
+		return err
+	}
 
-	err = blc.DB.Update(func(tx *bolt.Tx) error {
+	return b.Put(hash, header.Serialize())
+}
 
-		b := tx.Bucket([]byte(blockTableName))
-		if b != nil {
+// 从区块头表读取区块头，旧数据库中没有的从完整区块中取出
+func loadBlockHeader(tx *bolt.Tx, hash []byte) *BlockHeader {
 
-			blockExist := b.Get(block.Hash)
-			if blockExist != nil {
+	if b := tx.Bucket([]byte(blockHeaderTableName)); b != nil {
 
-				// 如果存在，不需要做任何过多的处理
-				return nil
-			}
+		if headerBytes := b.Get(hash); headerBytes != nil {
 
-			err := b.Put(block.Hash,block.Serialize())
-			if err != nil {
+			header, err := DecodeBlockHeader(headerBytes)
+			if err == nil {
 
-				log.Panic(err)
+				return header
 			}
+		}
+	}
 
-			// 最新的区块链的Hash
-			blockHash := b.Get([]byte(newestBlockKey))
-			blockInDB := DeSerializeBlock(b.Get(blockHash))
+	block := loadBlock(tx.Bucket([]byte(blockTableName)), hash)
+	if block == nil {
 
-			if blockInDB.Height < block.Height {
+		return nil
+	}
 
-				b.Put([]byte(newestBlockKey), block.Hash)
-				blc.Tip = block.Hash
-			}
+	return &block.BlockHeader
+}
+
+// 获取对应哈希的区块头
+func (blc *Blockchain) GetBlockHeader(hash []byte) (*BlockHeader, error) {
+
+	var header *BlockHeader
+
+	err := blc.DB.View(func(tx *bolt.Tx) error {
+
+		header = loadBlockHeader(tx, hash)
+		if header == nil {
+
+			return fmt.Errorf("block header %x is not found", hash)
 		}
 
 		return nil
 	})
 
+	return header, err
+}
+
+// 按哈希读取区块头，找不到返回nil
+func (blc *Blockchain) lookupBlockHeader(hash []byte) *BlockHeader {
+
+	header, err := blc.GetBlockHeader(hash)
 	if err != nil {
-		log.Panic(err)
+
+		return nil
+	}
+
+	return header
+}
+
+// 将区块添加到区块链
+// 父区块已知时经过ValidateBlock校验，交易输入(双花、签名、金额)在连接到主链时校验
+// 父区块未知的区块只做与链无关的检查后放入孤块池，父区块到达后再经过ValidateBlock校验
+// 按累计工作量选择主链，必要时回滚到分叉点重组
+func (blc *Blockchain) AddBlock(block *Block) error {
+
+	// 已经存在，不需要做任何过多的处理
+	blockExist, err := blc.GetBlock(block.Hash)
+	if err != nil {
+
+		return err
+	}
+	if blockExist != nil {
+
+		return nil
+	}
+
+	parentBytes, err := blc.GetBlock(block.PrevBlockHash)
+	if err != nil {
+
+		return err
+	}
+	if parentBytes == nil {
+
+		// 与链无关的检查：工作量证明、交易结构、创币交易位置等
+		err = CheckBlockSanity(block)
+		if err != nil {
+
+			return err
+		}
+
+		// 父区块还没收到  还不能按调整规则校验难度，至少不能比主链当前允许的最低难度更低
+		minBits := blc.minOrphanTargetBits(block.Height)
+		if block.TargetBits < minBits {
+
+			return rejectBlock(block, RejectBadDifficulty, "orphan target bits %d below minimum %d", block.TargetBits, minBits)
+		}
+
+		fmt.Printf("Block %x is an orphan, waiting for %x\n", block.Hash, block.PrevBlockHash)
+		addOrphanBlock(block)
+		return nil
+	}
+
+	err = blc.connectBlockWithParent(block, DeSerializeBlock(parentBytes))
+	if err != nil {
+
+		return err
+	}
+
+	// 依次处理等待该区块的孤块
+	queue := []*Block{block}
+	for len(queue) > 0 {
+
+		parent := queue[0]
+		queue = queue[1:]
+
+		for _, orphan := range takeOrphanBlocks(parent.Hash) {
+
+			err := blc.connectBlockWithParent(orphan, parent)
+			if err != nil {
+
+				fmt.Printf("reject orphan block %x: %s\n", orphan.Hash, err)
+				continue
+			}
+			queue = append(queue, orphan)
+		}
+	}
+
+	return nil
+}
+
+// 经过ValidateBlock校验后接收，连接到主链时再校验交易输入
+func (blc *Blockchain) connectBlockWithParent(block *Block, parent *Block) error {
+
+	err := blc.ValidateBlock(block, parent)
+	if err != nil {
+
+		return err
 	}
 
-	return err
+	return blc.acceptBlock(block)
 }
 
 //判断数据库是否存在
@@ -0,0 +1,51 @@
+package BLC
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// 不能打包的交易返回错误，不挖矿也不改变链
+func TestMineTransactionsErrors(t *testing.T) {
+
+	blc := newTestBlockchain(t)
+
+	alice := NewWallet()
+	bob := NewWallet()
+	fund := fundTestWallets(t, blc, 10, alice)
+	tip := blc.Tip
+
+	// 锁定到很远的区块高度
+	locked := &Transaction{[]byte{}, []*TXInput{{fund.TxHash, 0, nil, MaxTxInSequenceNum - 1}}, []*TXOutput{NewTXOutput(9, testAddress())}, TxVersion, 1000}
+	blc.SignTransaction(locked, alice.PrivateKey, nil)
+	locked.HashTransactions()
+
+	tests := []struct {
+		name string
+		tx   *Transaction
+		err  string
+	}{
+		{"locked", locked, "can not be mined yet"},
+		{"wrong key", newTestSpend(t, blc, bob, fund, 0, NewTXOutput(9, testAddress())), "verify failed"},
+	}
+
+	for _, test := range tests {
+
+		block, err := blc.MineTransactions([]*Transaction{test.tx}, testAddress())
+		if err == nil || strings.Contains(err.Error(), test.err) == false || block != nil {
+
+			t.Errorf("%s: got %v, want %q", test.name, err, test.err)
+		}
+		if bytes.Compare(blc.Tip, tip) != 0 {
+
+			t.Errorf("%s: tip changed", test.name)
+		}
+	}
+
+	block, err := blc.MineTransactions([]*Transaction{newTestSpend(t, blc, alice, fund, 0, NewTXOutput(9, testAddress()))}, testAddress())
+	if err != nil || bytes.Compare(blc.Tip, block.Hash) != 0 {
+
+		t.Fatalf("valid spend: %v", err)
+	}
+}
@@ -1,10 +1,12 @@
 package BLC
 
 import (
+	"encoding/hex"
 	"fmt"
 	"flag"
 	"os"
 	"log"
+	"strings"
 )
 
 type CLI struct {
@@ -15,13 +17,34 @@ type CLI struct {
 func printUsage() {
 	fmt.Println("Usage:")
 	fmt.Println("\tcreateBlockchain -address --创世区块地址 ")
-	fmt.Println("\tsend -from FROM -to TO -amount AMOUNT --交易明细")
+	fmt.Println("\tsend -from FROM -to TO -amount AMOUNT [-feerate RATE] [-locktime LOCKTIME] [-relblocks N | -relseconds S] [-mine] [-workers N] --交易明细，RATE为每千字节的手续费")
+	fmt.Println("\t\tLOCKTIME小于500000000为区块高度，否则为Unix时间戳；-relblocks/-relseconds为输入在确认后需要等待的区块数/秒数")
 	fmt.Println("\tprintchain --打印所有区块信息")
-	fmt.Println("\tgetbalance -address -- 输出区块信息.")
+	fmt.Println("\tgetbalance -address [-spv] -- 输出区块信息，-spv查询轻节点同步到的余额.")
 	fmt.Println("\tcreateWallet -- 创建钱包.")
 	fmt.Println("\tgetAddressList -- 输出所有钱包地址.")
 	fmt.Println("\tresetUTXOset -- 测试UTXOSet.")
-	fmt.Println("\tstartnode -miner ADDRESS -- 启动节点服务器，并且指定挖矿奖励的地址.")
+	fmt.Println("\tgetsupply [-height HEIGHT] -- 输出到某个高度为止的发行量，默认为当前高度.")
+	fmt.Println("\tstartnode -miner ADDRESS [-workers N] [-bantime SECONDS] -- 启动节点服务器，并且指定挖矿奖励的地址，-bantime为不良节点的封禁时长，默认24小时.")
+	fmt.Println("\tstartnode -spv [-cfilters] -- 启动轻节点，只同步区块头和钱包相关交易的证明，-cfilters用区块过滤器在本地匹配，只下载有关的区块.")
+	fmt.Println("\tgetpubkey -address ADDRESS -- 输出本地钱包地址的公钥.")
+	fmt.Println("\tcreatemultisig -m M -pubkeys PUBKEYS -- 根据N个公钥创建M-of-N多重签名地址.")
+	fmt.Println("\tcreatemultisigtx -redeemscript SCRIPT -to TO -amount AMOUNT [-feerate RATE] -file FILE -- 构造未签名的多重签名交易.")
+	fmt.Println("\tsignmultisigtx -file FILE -address ADDRESS -- 用本地钱包地址为多重签名交易签名.")
+	fmt.Println("\tsendmultisigtx -file FILE -- 签名完成后广播多重签名交易.")
+	fmt.Println("\tinitiate -from FROM -to PARTICIPANT -amount AMOUNT [-locktime SECONDS] [-feerate RATE] [-mine] -- 发起原子交换，生成秘密并创建合约，默认48小时后可以退款.")
+	fmt.Println("\tparticipate -from FROM -to INITIATOR -amount AMOUNT -secrethash HASH [-locktime SECONDS] [-feerate RATE] [-mine] -- 参与原子交换，默认24小时后可以退款.")
+	fmt.Println("\tredeem -address ADDRESS -contract CONTRACT -contracttx TXHASH -secret SECRET [-feerate RATE] [-mine] -- 用秘密赎回合约.")
+	fmt.Println("\trefund -address ADDRESS -contract CONTRACT -contracttx TXHASH [-feerate RATE] [-mine] -- 锁定时间过后取回合约.")
+	fmt.Println("\tnotarize -from FROM -file FILE [-feerate RATE] [-mine] -- 把文件的SHA-256哈希写入区块链.")
+	fmt.Println("\tverify-notarization -file FILE -- 输出文件哈希所在的区块和默克尔证明.")
+	fmt.Println("\tgettxproof -txid TXHASH [-file FILE] -- 生成交易的默克尔证明.")
+	fmt.Println("\tverifytxproof -proof PROOF | -file FILE -- 用本地区块头验证交易证明.")
+	fmt.Println("\tauditcontract -contract CONTRACT -contracttx TXHASH -- 审核合约，已被赎回时输出秘密.")
+	fmt.Println("\tlistbans -- 输出被封禁的节点IP和到期时间.")
+	fmt.Println("\tban -host HOST [-duration SECONDS] -- 封禁节点IP，本机节点用监听地址(如localhost:3001)，默认24小时，运行中的节点也会断开与它的连接.")
+	fmt.Println("\tunban -host HOST -- 解除封禁.")
+	fmt.Println("环境变量：NODE_ID 节点ID(端口)，SEED_NODES 种子节点，多个地址用逗号分隔，默认localhost:8000；GENESIS_HASH 轻节点接受的创世区块哈希")
 }
 
 func isValidArgs() {
@@ -47,6 +70,31 @@ func (cli *CLI) Run() {
 	}
 	fmt.Printf("NODE_ID:%s\n", nodeID)
 
+	//种子节点  可以通过 export SEED_NODES=localhost:8000,localhost:3001 设置，多个地址用逗号分隔
+	if seeds := os.Getenv("SEED_NODES"); seeds != "" {
+
+		seedNodes = nil
+		for _, seed := range strings.Split(seeds, ",") {
+
+			if seed = strings.TrimSpace(seed); seed != "" {
+
+				seedNodes = append(seedNodes, seed)
+			}
+		}
+	}
+
+	//创世区块哈希  轻节点只接受这个创世区块，可以通过 export GENESIS_HASH=<全节点printchain输出的创世区块哈希> 设置
+	if genesis := os.Getenv("GENESIS_HASH"); genesis != "" {
+
+		hash, err := hex.DecodeString(genesis)
+		if err != nil || len(hash) != 32 {
+
+			fmt.Printf("GENESIS_HASH must be a 32 byte hex hash\n")
+			os.Exit(1)
+		}
+		genesisBlockHash = hash
+	}
+
 	//自定义cli命令
 	sendBlockCmd := flag.NewFlagSet("send", flag.ExitOnError)
 	printchainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
@@ -56,15 +104,93 @@ func (cli *CLI) Run() {
 	getAddressListCmd := flag.NewFlagSet("getAddressList", flag.ExitOnError)
 	resetUTXOsetCmd := flag.NewFlagSet("resetUTXOset", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	getSupplyCmd := flag.NewFlagSet("getsupply", flag.ExitOnError)
+	getPubKeyCmd := flag.NewFlagSet("getpubkey", flag.ExitOnError)
+	createMultiSigCmd := flag.NewFlagSet("createmultisig", flag.ExitOnError)
+	createMultiSigTxCmd := flag.NewFlagSet("createmultisigtx", flag.ExitOnError)
+	signMultiSigTxCmd := flag.NewFlagSet("signmultisigtx", flag.ExitOnError)
+	sendMultiSigTxCmd := flag.NewFlagSet("sendmultisigtx", flag.ExitOnError)
+	initiateCmd := flag.NewFlagSet("initiate", flag.ExitOnError)
+	participateCmd := flag.NewFlagSet("participate", flag.ExitOnError)
+	redeemCmd := flag.NewFlagSet("redeem", flag.ExitOnError)
+	refundCmd := flag.NewFlagSet("refund", flag.ExitOnError)
+	auditContractCmd := flag.NewFlagSet("auditcontract", flag.ExitOnError)
+	notarizeCmd := flag.NewFlagSet("notarize", flag.ExitOnError)
+	verifyNotarizationCmd := flag.NewFlagSet("verify-notarization", flag.ExitOnError)
+	getTxProofCmd := flag.NewFlagSet("gettxproof", flag.ExitOnError)
+	verifyTxProofCmd := flag.NewFlagSet("verifytxproof", flag.ExitOnError)
+	listBansCmd := flag.NewFlagSet("listbans", flag.ExitOnError)
+	banCmd := flag.NewFlagSet("ban", flag.ExitOnError)
+	unbanCmd := flag.NewFlagSet("unban", flag.ExitOnError)
 
 	//addBlockCmd 设置默认参数
 	flagSendBlockMine := sendBlockCmd.Bool("mine",false,"是否在当前节点中立即验证....")
 	flagSendBlockFrom := sendBlockCmd.String("from", "", "源地址")
 	flagSendBlockTo := sendBlockCmd.String("to", "", "目标地址")
 	flagSendBlockAmount := sendBlockCmd.String("amount", "", "转账金额")
+	flagSendBlockFeeRate := sendBlockCmd.Int64("feerate", defaultFeeRate, "手续费率(每千字节)")
+	flagSendBlockWorkers := sendBlockCmd.Int("workers", minerWorkers, "挖矿线程数")
+	flagSendBlockLockTime := sendBlockCmd.Uint("locktime", 0, "锁定时间(区块高度或Unix时间戳)")
+	flagSendBlockRelBlocks := sendBlockCmd.Int64("relblocks", -1, "输入确认后需要等待的区块数")
+	flagSendBlockRelSeconds := sendBlockCmd.Int64("relseconds", -1, "输入确认后需要等待的秒数")
 	flagCreateBlockchainAddress := createBlockchainCmd.String("address", "", "创世区块地址")
 	flagBlanceBlockAddress := blanceBlockCmd.String("address", "", "输出区块信息")
+	flagBlanceBlockSPV := blanceBlockCmd.Bool("spv", false, "从轻节点的钱包UTXO视图查询")
 	flagMiner := startNodeCmd.String("miner","","定义挖矿奖励的地址......")
+	flagMinerWorkers := startNodeCmd.Int("workers", minerWorkers, "挖矿线程数")
+	flagStartNodeSPV := startNodeCmd.Bool("spv", false, "以轻节点模式启动，只同步区块头")
+	flagStartNodeCFilters := startNodeCmd.Bool("cfilters", false, "轻节点用区块过滤器查找钱包交易，不透露钱包地址")
+	flagStartNodeBanTime := startNodeCmd.Int64("bantime", defaultBanDuration, "不良节点的封禁时长(秒)")
+	flagSupplyHeight := getSupplyCmd.Int64("height", 0, "区块高度")
+	flagPubKeyAddress := getPubKeyCmd.String("address", "", "钱包地址")
+	flagMultiSigM := createMultiSigCmd.Int("m", 0, "需要的签名数")
+	flagMultiSigPubKeys := createMultiSigCmd.String("pubkeys", "", "公钥数组")
+	flagMultiSigTxRedeemScript := createMultiSigTxCmd.String("redeemscript", "", "赎回脚本")
+	flagMultiSigTxTo := createMultiSigTxCmd.String("to", "", "目标地址")
+	flagMultiSigTxAmount := createMultiSigTxCmd.Int64("amount", 0, "转账金额")
+	flagMultiSigTxFeeRate := createMultiSigTxCmd.Int64("feerate", defaultFeeRate, "手续费率(每千字节)")
+	flagMultiSigTxFile := createMultiSigTxCmd.String("file", "", "交易文件")
+	flagSignMultiSigTxFile := signMultiSigTxCmd.String("file", "", "交易文件")
+	flagSignMultiSigTxAddress := signMultiSigTxCmd.String("address", "", "签名地址")
+	flagSendMultiSigTxFile := sendMultiSigTxCmd.String("file", "", "交易文件")
+	flagInitiateFrom := initiateCmd.String("from", "", "发起方地址")
+	flagInitiateTo := initiateCmd.String("to", "", "参与方地址")
+	flagInitiateAmount := initiateCmd.Int64("amount", 0, "交换金额")
+	flagInitiateLockTime := initiateCmd.Int64("locktime", 48*60*60, "多少秒后可以退款")
+	flagInitiateFeeRate := initiateCmd.Int64("feerate", defaultFeeRate, "手续费率(每千字节)")
+	flagInitiateMine := initiateCmd.Bool("mine", false, "是否在当前节点中立即打包")
+	flagParticipateFrom := participateCmd.String("from", "", "参与方地址")
+	flagParticipateTo := participateCmd.String("to", "", "发起方地址")
+	flagParticipateAmount := participateCmd.Int64("amount", 0, "交换金额")
+	flagParticipateSecretHash := participateCmd.String("secrethash", "", "发起方的秘密哈希")
+	flagParticipateLockTime := participateCmd.Int64("locktime", 24*60*60, "多少秒后可以退款")
+	flagParticipateFeeRate := participateCmd.Int64("feerate", defaultFeeRate, "手续费率(每千字节)")
+	flagParticipateMine := participateCmd.Bool("mine", false, "是否在当前节点中立即打包")
+	flagRedeemAddress := redeemCmd.String("address", "", "接收方地址")
+	flagRedeemContract := redeemCmd.String("contract", "", "合约")
+	flagRedeemContractTx := redeemCmd.String("contracttx", "", "合约交易哈希")
+	flagRedeemSecret := redeemCmd.String("secret", "", "秘密")
+	flagRedeemFeeRate := redeemCmd.Int64("feerate", defaultFeeRate, "手续费率(每千字节)")
+	flagRedeemMine := redeemCmd.Bool("mine", false, "是否在当前节点中立即打包")
+	flagRefundAddress := refundCmd.String("address", "", "退款方地址")
+	flagRefundContract := refundCmd.String("contract", "", "合约")
+	flagRefundContractTx := refundCmd.String("contracttx", "", "合约交易哈希")
+	flagRefundFeeRate := refundCmd.Int64("feerate", defaultFeeRate, "手续费率(每千字节)")
+	flagRefundMine := refundCmd.Bool("mine", false, "是否在当前节点中立即打包")
+	flagAuditContract := auditContractCmd.String("contract", "", "合约")
+	flagAuditContractTx := auditContractCmd.String("contracttx", "", "合约交易哈希")
+	flagNotarizeFrom := notarizeCmd.String("from", "", "支付手续费的地址")
+	flagNotarizeFile := notarizeCmd.String("file", "", "公证的文件")
+	flagNotarizeFeeRate := notarizeCmd.Int64("feerate", defaultFeeRate, "手续费率(每千字节)")
+	flagNotarizeMine := notarizeCmd.Bool("mine", false, "是否在当前节点中立即打包")
+	flagVerifyNotarizationFile := verifyNotarizationCmd.String("file", "", "公证的文件")
+	flagGetTxProofTxID := getTxProofCmd.String("txid", "", "交易哈希")
+	flagGetTxProofFile := getTxProofCmd.String("file", "", "保存证明的文件")
+	flagVerifyTxProof := verifyTxProofCmd.String("proof", "", "交易证明")
+	flagVerifyTxProofFile := verifyTxProofCmd.String("file", "", "交易证明文件")
+	flagBanHost := banCmd.String("host", "", "封禁的IP或主机名，本机节点为监听地址")
+	flagBanDuration := banCmd.Int64("duration", defaultBanDuration, "封禁时长(秒)")
+	flagUnbanHost := unbanCmd.String("host", "", "解除封禁的IP或主机名")
 
 	//解析输入的第二个参数是addBlock还是printchain，第一个参数为./main
 	switch os.Args[1] {
@@ -109,6 +235,96 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "getsupply":
+		err := getSupplyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getpubkey":
+		err := getPubKeyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createmultisig":
+		err := createMultiSigCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createmultisigtx":
+		err := createMultiSigTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signmultisigtx":
+		err := signMultiSigTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "sendmultisigtx":
+		err := sendMultiSigTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "initiate":
+		err := initiateCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "participate":
+		err := participateCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "redeem":
+		err := redeemCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "refund":
+		err := refundCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "auditcontract":
+		err := auditContractCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "notarize":
+		err := notarizeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verify-notarization":
+		err := verifyNotarizationCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "gettxproof":
+		err := getTxProofCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifytxproof":
+		err := verifyTxProofCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listbans":
+		err := listBansCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "ban":
+		err := banCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "unban":
+		err := unbanCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	default:
 		printUsage()
 		os.Exit(1)
@@ -150,8 +366,42 @@ func (cli *CLI) Run() {
 		}
 
 		amount := Json2Array(*flagSendBlockAmount)
+		SetMinerWorkers(*flagSendBlockWorkers)
+
+		if *flagSendBlockFeeRate < 0 {
+
+			printUsage()
+			os.Exit(1)
+		}
+
+		if *flagSendBlockLockTime > MaxTxInSequenceNum || (*flagSendBlockRelBlocks >= 0 && *flagSendBlockRelSeconds >= 0) {
+
+			printUsage()
+			os.Exit(1)
+		}
 
-		cli.send(from, to, amount, nodeID, *flagSendBlockMine)
+		//默认不启用时间锁；只设置了锁定时间时，输入序号需要小于最大值才能让锁定时间生效
+		sequence := uint32(MaxTxInSequenceNum)
+		if *flagSendBlockLockTime > 0 {
+
+			sequence = MaxTxInSequenceNum - 1
+		}
+
+		var err error
+		if *flagSendBlockRelBlocks >= 0 {
+
+			sequence, err = SequenceForBlocks(*flagSendBlockRelBlocks)
+		} else if *flagSendBlockRelSeconds >= 0 {
+
+			sequence, err = SequenceForSeconds(*flagSendBlockRelSeconds)
+		}
+		if err != nil {
+
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		cli.send(from, to, amount, *flagSendBlockFeeRate, uint32(*flagSendBlockLockTime), sequence, nodeID, *flagSendBlockMine)
 	}
 	//对printchainCmd命令的解析
 	if printchainCmd.Parsed() {
@@ -179,7 +429,13 @@ func (cli *CLI) Run() {
 			os.Exit(1)
 		}
 
-		cli.getBlance(*flagBlanceBlockAddress, nodeID)
+		if *flagBlanceBlockSPV {
+
+			cli.getSPVBalance(*flagBlanceBlockAddress, nodeID)
+		} else {
+
+			cli.getBlance(*flagBlanceBlockAddress, nodeID)
+		}
 	}
 
 	//创建钱包
@@ -200,9 +456,251 @@ func (cli *CLI) Run() {
 		cli.ResetUTXOSet(nodeID)
 	}
 
+	//查询发行量
+	if getSupplyCmd.Parsed() {
+
+		cli.getSupply(*flagSupplyHeight, nodeID)
+	}
+
+	//查询公钥
+	if getPubKeyCmd.Parsed() {
+
+		if *flagPubKeyAddress == "" {
+
+			printUsage()
+			os.Exit(1)
+		}
+
+		cli.getPubKey(*flagPubKeyAddress, nodeID)
+	}
+
+	//创建多重签名地址
+	if createMultiSigCmd.Parsed() {
+
+		if *flagMultiSigM <= 0 || *flagMultiSigPubKeys == "" {
+
+			printUsage()
+			os.Exit(1)
+		}
+
+		cli.createMultiSig(*flagMultiSigM, Json2Array(*flagMultiSigPubKeys))
+	}
+
+	//构造多重签名交易
+	if createMultiSigTxCmd.Parsed() {
+
+		if *flagMultiSigTxRedeemScript == "" || *flagMultiSigTxFile == "" || *flagMultiSigTxAmount <= 0 || *flagMultiSigTxFeeRate < 0 {
+
+			printUsage()
+			os.Exit(1)
+		}
+
+		if IsValidForAddress([]byte(*flagMultiSigTxTo)) == false {
+
+			fmt.Printf("Address:%s incalid", *flagMultiSigTxTo)
+			os.Exit(1)
+		}
+
+		cli.createMultiSigTx(*flagMultiSigTxRedeemScript, *flagMultiSigTxTo, *flagMultiSigTxAmount, *flagMultiSigTxFeeRate, *flagMultiSigTxFile, nodeID)
+	}
+
+	//多重签名交易签名
+	if signMultiSigTxCmd.Parsed() {
+
+		if *flagSignMultiSigTxFile == "" || *flagSignMultiSigTxAddress == "" {
+
+			printUsage()
+			os.Exit(1)
+		}
+
+		cli.signMultiSigTx(*flagSignMultiSigTxFile, *flagSignMultiSigTxAddress, nodeID)
+	}
+
+	//广播多重签名交易
+	if sendMultiSigTxCmd.Parsed() {
+
+		if *flagSendMultiSigTxFile == "" {
+
+			printUsage()
+			os.Exit(1)
+		}
+
+		cli.sendMultiSigTx(*flagSendMultiSigTxFile, nodeID)
+	}
+
+	//发起原子交换
+	if initiateCmd.Parsed() {
+
+		if *flagInitiateAmount <= 0 || *flagInitiateLockTime <= 0 || *flagInitiateFeeRate < 0 {
+
+			printUsage()
+			os.Exit(1)
+		}
+
+		if IsValidForAddress([]byte(*flagInitiateFrom)) == false || IsValidForAddress([]byte(*flagInitiateTo)) == false {
+
+			fmt.Printf("Address:%s or %s incalid", *flagInitiateFrom, *flagInitiateTo)
+			os.Exit(1)
+		}
+
+		cli.initiateSwap(*flagInitiateFrom, *flagInitiateTo, *flagInitiateAmount, *flagInitiateLockTime, *flagInitiateFeeRate, nodeID, *flagInitiateMine)
+	}
+
+	//参与原子交换
+	if participateCmd.Parsed() {
+
+		if *flagParticipateAmount <= 0 || *flagParticipateSecretHash == "" || *flagParticipateLockTime <= 0 || *flagParticipateFeeRate < 0 {
+
+			printUsage()
+			os.Exit(1)
+		}
+
+		if IsValidForAddress([]byte(*flagParticipateFrom)) == false || IsValidForAddress([]byte(*flagParticipateTo)) == false {
+
+			fmt.Printf("Address:%s or %s incalid", *flagParticipateFrom, *flagParticipateTo)
+			os.Exit(1)
+		}
+
+		cli.participateSwap(*flagParticipateFrom, *flagParticipateTo, *flagParticipateAmount, *flagParticipateSecretHash, *flagParticipateLockTime, *flagParticipateFeeRate, nodeID, *flagParticipateMine)
+	}
+
+	//赎回合约
+	if redeemCmd.Parsed() {
+
+		if *flagRedeemAddress == "" || *flagRedeemContract == "" || *flagRedeemContractTx == "" || *flagRedeemSecret == "" || *flagRedeemFeeRate < 0 {
+
+			printUsage()
+			os.Exit(1)
+		}
+
+		cli.redeemSwap(*flagRedeemAddress, *flagRedeemContract, *flagRedeemContractTx, *flagRedeemSecret, *flagRedeemFeeRate, nodeID, *flagRedeemMine)
+	}
+
+	//合约退款
+	if refundCmd.Parsed() {
+
+		if *flagRefundAddress == "" || *flagRefundContract == "" || *flagRefundContractTx == "" || *flagRefundFeeRate < 0 {
+
+			printUsage()
+			os.Exit(1)
+		}
+
+		cli.refundSwap(*flagRefundAddress, *flagRefundContract, *flagRefundContractTx, *flagRefundFeeRate, nodeID, *flagRefundMine)
+	}
+
+	//审核合约
+	if auditContractCmd.Parsed() {
+
+		if *flagAuditContract == "" || *flagAuditContractTx == "" {
+
+			printUsage()
+			os.Exit(1)
+		}
+
+		cli.auditSwapContract(*flagAuditContract, *flagAuditContractTx, nodeID)
+	}
+
+	//公证文件
+	if notarizeCmd.Parsed() {
+
+		if *flagNotarizeFile == "" || *flagNotarizeFeeRate < 0 {
+
+			printUsage()
+			os.Exit(1)
+		}
+
+		if IsValidForAddress([]byte(*flagNotarizeFrom)) == false {
+
+			fmt.Printf("Address:%s incalid", *flagNotarizeFrom)
+			os.Exit(1)
+		}
+
+		cli.notarize(*flagNotarizeFrom, *flagNotarizeFile, *flagNotarizeFeeRate, nodeID, *flagNotarizeMine)
+	}
+
+	//查询文件公证
+	if verifyNotarizationCmd.Parsed() {
+
+		if *flagVerifyNotarizationFile == "" {
+
+			printUsage()
+			os.Exit(1)
+		}
+
+		cli.verifyNotarization(*flagVerifyNotarizationFile, nodeID)
+	}
+
+	//生成交易证明
+	if getTxProofCmd.Parsed() {
+
+		if *flagGetTxProofTxID == "" {
+
+			printUsage()
+			os.Exit(1)
+		}
+
+		cli.getTxProof(*flagGetTxProofTxID, *flagGetTxProofFile, nodeID)
+	}
+
+	//验证交易证明
+	if verifyTxProofCmd.Parsed() {
+
+		if (*flagVerifyTxProof == "") == (*flagVerifyTxProofFile == "") {
+
+			printUsage()
+			os.Exit(1)
+		}
+
+		cli.verifyTxProof(*flagVerifyTxProof, *flagVerifyTxProofFile, nodeID)
+	}
+
+	//查看封禁列表
+	if listBansCmd.Parsed() {
+
+		cli.listBans(nodeID)
+	}
+
+	//封禁节点
+	if banCmd.Parsed() {
+
+		if *flagBanHost == "" || *flagBanDuration <= 0 {
+
+			printUsage()
+			os.Exit(1)
+		}
+
+		cli.ban(*flagBanHost, *flagBanDuration, nodeID)
+	}
+
+	//解除封禁
+	if unbanCmd.Parsed() {
+
+		if *flagUnbanHost == "" {
+
+			printUsage()
+			os.Exit(1)
+		}
+
+		cli.unban(*flagUnbanHost, nodeID)
+	}
+
 	//设置挖矿节点
 	if startNodeCmd.Parsed() {
 
+		if *flagStartNodeBanTime <= 0 {
+
+			printUsage()
+			os.Exit(1)
+		}
+		banDuration = *flagStartNodeBanTime
+
+		if *flagStartNodeSPV {
+
+			cli.startSPVNode(nodeID, *flagStartNodeCFilters)
+			return
+		}
+
+		SetMinerWorkers(*flagMinerWorkers)
 		cli.startNode(nodeID, *flagMiner)
 	}
 }
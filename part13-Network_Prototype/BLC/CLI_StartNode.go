@@ -16,11 +16,24 @@ func (cli *CLI) startNode(nodeID string, minerAdd string)  {
 			fmt.Printf("Miner:%s is ready to mining...\n", minerAdd)
 		}else {
 
-			fmt.Println("Server address invalid....\n")
+			fmt.Print("Server address invalid....\n\n")
 			os.Exit(0)
 		}
 	}
 
 	// 启动服务器
 	StartServer(nodeID, minerAdd)
-}
\ No newline at end of file
+}
+//启动轻节点
+func (cli *CLI) startSPVNode(nodeID string, useFilters bool) {
+
+	if genesisBlockHash == nil {
+
+		fmt.Printf("GENESIS_HASH env var is not set!\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("start SPV Server:localhost:%s\n", nodeID)
+
+	StartSPVServer(nodeID, useFilters)
+}
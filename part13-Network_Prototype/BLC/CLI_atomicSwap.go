@@ -0,0 +1,258 @@
+package BLC
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+)
+
+//发起原子交换  生成秘密，创建支付给合约的交易
+func (cli *CLI) initiateSwap(from string, to string, amount int64, lockSeconds int64, feeRate int64, nodeID string, mineNow bool) {
+
+	secret, err := NewAtomicSwapSecret()
+	if err != nil {
+
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	secretHash := AtomicSwapSecretHash(secret)
+
+	fmt.Printf("秘密：%x\n", secret)
+	fmt.Printf("秘密哈希：%x\n", secretHash)
+
+	cli.createSwapContract(from, to, amount, secretHash, lockSeconds, feeRate, nodeID, mineNow)
+}
+
+//参与原子交换  用发起方的秘密哈希创建支付给合约的交易
+func (cli *CLI) participateSwap(from string, to string, amount int64, secretHashHex string, lockSeconds int64, feeRate int64, nodeID string, mineNow bool) {
+
+	secretHash, err := hex.DecodeString(secretHashHex)
+	if err != nil || len(secretHash) != 32 {
+
+		fmt.Printf("SecretHash:%s invalid\n", secretHashHex)
+		os.Exit(1)
+	}
+
+	cli.createSwapContract(from, to, amount, secretHash, lockSeconds, feeRate, nodeID, mineNow)
+}
+
+//创建合约  接收方为to，退款方为from，lockSeconds秒后可以退款
+func (cli *CLI) createSwapContract(from string, to string, amount int64, secretHash []byte, lockSeconds int64, feeRate int64, nodeID string, mineNow bool) {
+
+	recipientPubKeyHash := ExtractPubKeyHash(PayToAddrScript(to))
+	refundPubKeyHash := ExtractPubKeyHash(PayToAddrScript(from))
+	if recipientPubKeyHash == nil || refundPubKeyHash == nil {
+
+		fmt.Println("Atomic swap addresses must be pay-to-pubkey-hash addresses")
+		os.Exit(1)
+	}
+
+	lockTime := time.Now().Unix() + lockSeconds
+	contract := AtomicSwapContract(secretHash, recipientPubKeyHash, refundPubKeyHash, lockTime)
+	contractAddress := string(ScriptHashAddress(contract))
+
+	blc := GetBlockchain(nodeID)
+	defer blc.DB.Close()
+
+	utxoSet := &UTXOSet{blc}
+	tx := NewTransaction(from, contractAddress, amount, feeRate, 0, MaxTxInSequenceNum, utxoSet, []*Transaction{}, nodeID)
+
+	fmt.Printf("合约：%x\n", contract)
+	fmt.Printf("合约地址：%s\n", contractAddress)
+	fmt.Printf("锁定时间：%d (%s)\n", lockTime, time.Unix(lockTime, 0).Format("2006-01-02 15:04:05"))
+	fmt.Printf("合约交易：%x\n", tx.TxHash)
+
+	cli.submitTransaction(blc, tx, from, nodeID, mineNow)
+}
+
+//赎回对方的合约  解锁脚本会公开秘密
+func (cli *CLI) redeemSwap(address string, contractHex string, contractTxHex string, secretHex string, feeRate int64, nodeID string, mineNow bool) {
+
+	secret, err := hex.DecodeString(secretHex)
+	if err != nil {
+
+		fmt.Printf("Secret:%s invalid\n", secretHex)
+		os.Exit(1)
+	}
+
+	cli.spendSwapContract(address, contractHex, contractTxHex, secret, feeRate, nodeID, mineNow)
+}
+
+//锁定时间过后取回自己的合约
+func (cli *CLI) refundSwap(address string, contractHex string, contractTxHex string, feeRate int64, nodeID string, mineNow bool) {
+
+	cli.spendSwapContract(address, contractHex, contractTxHex, nil, feeRate, nodeID, mineNow)
+}
+
+func (cli *CLI) spendSwapContract(address string, contractHex string, contractTxHex string, secret []byte, feeRate int64, nodeID string, mineNow bool) {
+
+	wallets, _ := NewWallets(nodeID)
+	wallet := wallets.Wallets[address]
+	if wallet == nil {
+
+		fmt.Printf("Address:%s is not in the wallet\n", address)
+		os.Exit(1)
+	}
+
+	blc := GetBlockchain(nodeID)
+	defer blc.DB.Close()
+
+	contract, contractTx := loadSwapContract(blc, contractHex, contractTxHex)
+
+	tx, err := NewAtomicSwapSpend(contract, contractTx, secret, wallet, address, feeRate)
+	if err != nil {
+
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	//广播前先在本地验证脚本
+	utxoSet := &UTXOSet{blc}
+	utxo := utxoSet.FindUTXO(tx.Vins[0].TxHash, tx.Vins[0].Vout)
+	if utxo == nil {
+
+		fmt.Printf("Contract output %x:%d is spent or unconfirmed\n", tx.Vins[0].TxHash, tx.Vins[0].Vout)
+		os.Exit(1)
+	}
+
+	err = tx.VerifyScripts([]*TXOutput{utxo.Output})
+	if err != nil {
+
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("交易：%x\n", tx.TxHash)
+
+	cli.submitTransaction(blc, tx, address, nodeID, mineNow)
+}
+
+//审核合约  检查合约交易和合约内容，已被赎回时取出秘密
+func (cli *CLI) auditSwapContract(contractHex string, contractTxHex string, nodeID string) {
+
+	blc := GetBlockchain(nodeID)
+	defer blc.DB.Close()
+
+	contract, contractTx := loadSwapContract(blc, contractHex, contractTxHex)
+	swap, _ := ExtractAtomicSwap(contract)
+
+	index, out, err := atomicSwapOutput(contractTx, contract)
+	if err != nil {
+
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("合约地址：%s\n", ScriptHashAddress(contract))
+	fmt.Printf("合约金额：%d\n", out.Value)
+	fmt.Printf("接收方地址：%s\n", hashToAddress(AddVersion, swap.RecipientPubKeyHash))
+	fmt.Printf("退款方地址：%s\n", hashToAddress(AddVersion, swap.RefundPubKeyHash))
+	fmt.Printf("秘密哈希：%x\n", swap.SecretHash)
+
+	tip, err := blc.GetBlockHeader(blc.Tip)
+	if err != nil {
+
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	medianTime := blc.medianTimePast(tip)
+	fmt.Printf("锁定时间：%d (%s)\n", swap.LockTime, time.Unix(swap.LockTime, 0).Format("2006-01-02 15:04:05"))
+	if swap.LockTime < medianTime {
+
+		fmt.Println("锁定时间已过，退款方可以取回")
+	} else {
+
+		fmt.Printf("距离可以退款还有%d秒(按过去时间中位数)\n", swap.LockTime-medianTime)
+	}
+
+	utxoSet := &UTXOSet{blc}
+	if utxoSet.FindUTXO(contractTx.TxHash, index) != nil {
+
+		fmt.Println("状态：未花费")
+		return
+	}
+
+	spend := findSpendingTransaction(blc, contractTx.TxHash, index)
+	if spend == nil {
+
+		fmt.Println("状态：未确认")
+		return
+	}
+
+	for _, vin := range spend.Vins {
+
+		if bytes.Compare(vin.TxHash, contractTx.TxHash) != 0 || vin.Vout != index {
+
+			continue
+		}
+
+		secret := ExtractAtomicSwapSecret(vin.ScriptSig, swap.SecretHash)
+		if secret != nil {
+
+			fmt.Printf("状态：已被交易%x赎回\n", spend.TxHash)
+			fmt.Printf("秘密：%x\n", secret)
+		} else {
+
+			fmt.Printf("状态：已被交易%x退款\n", spend.TxHash)
+		}
+	}
+}
+
+//解析合约，并在区块链上找到合约交易
+func loadSwapContract(blc *Blockchain, contractHex string, contractTxHex string) ([]byte, *Transaction) {
+
+	contract, err := hex.DecodeString(contractHex)
+	if err != nil {
+
+		fmt.Printf("Contract:%s invalid\n", contractHex)
+		os.Exit(1)
+	}
+	if _, ok := ExtractAtomicSwap(contract); ok == false {
+
+		fmt.Println("Contract is not an atomic swap contract")
+		os.Exit(1)
+	}
+
+	contractTxHash, err := hex.DecodeString(contractTxHex)
+	if err != nil {
+
+		fmt.Printf("ContractTx:%s invalid\n", contractTxHex)
+		os.Exit(1)
+	}
+
+	contractTx, err := blc.FindTransaction(contractTxHash, []*Transaction{})
+	if err != nil {
+
+		fmt.Printf("ContractTx:%x is not found in the blockchain\n", contractTxHash)
+		os.Exit(1)
+	}
+
+	return contract, &contractTx
+}
+
+//在主链上找到花费某个输出的交易
+func findSpendingTransaction(blc *Blockchain, txHash []byte, index int) *Transaction {
+
+	blcIterator := blc.Iterator()
+	for {
+
+		block := blcIterator.Next()
+		if block == nil {
+
+			return nil
+		}
+
+		for _, tx := range block.Txs {
+
+			for _, vin := range tx.Vins {
+
+				if bytes.Compare(vin.TxHash, txHash) == 0 && vin.Vout == index {
+
+					return tx
+				}
+			}
+		}
+	}
+}
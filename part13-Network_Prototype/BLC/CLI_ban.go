@@ -0,0 +1,76 @@
+package BLC
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+//输出封禁列表
+func (cli *CLI) listBans(nodeID string) {
+
+	bans := NewBanList(nodeID).List()
+	if len(bans) == 0 {
+
+		fmt.Println("没有封禁的节点")
+		return
+	}
+
+	for _, entry := range bans {
+
+		fmt.Printf("%s  到期：%s  原因：%s\n", entry.Host, time.Unix(entry.BannedUntil, 0).Format("2006-01-02 15:04:05"), entry.Reason)
+	}
+}
+
+//封禁节点IP  运行中的节点在下一次检查连接时断开
+func (cli *CLI) ban(host string, duration int64, nodeID string) {
+
+	for _, ip := range resolveBanHost(host) {
+
+		NewBanList(nodeID).Ban(ip, duration, "manually banned")
+		fmt.Printf("已封禁 %s %d秒\n", ip, duration)
+	}
+}
+
+//解除封禁
+func (cli *CLI) unban(host string, nodeID string) {
+
+	for _, ip := range resolveBanHost(host) {
+
+		if NewBanList(nodeID).Unban(ip) {
+
+			fmt.Printf("已解除封禁 %s\n", ip)
+		} else {
+
+			fmt.Printf("%s 不在封禁列表中\n", ip)
+		}
+	}
+}
+
+//封禁按IP记录  去掉端口，主机名解析为IP  本机地址带端口时按host:port记录
+func resolveBanHost(host string) []string {
+
+	if h, port, err := net.SplitHostPort(host); err == nil {
+
+		if h == "localhost" || isLoopbackHost(h) {
+
+			return []string{net.JoinHostPort(h, port)}
+		}
+		host = h
+	}
+
+	if net.ParseIP(host) != nil {
+
+		return []string{host}
+	}
+
+	ips, err := net.LookupHost(host)
+	if err != nil {
+
+		fmt.Printf("Host:%s invalid: %s\n", host, err)
+		os.Exit(1)
+	}
+
+	return ips
+}
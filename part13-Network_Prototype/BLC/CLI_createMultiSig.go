@@ -0,0 +1,48 @@
+package BLC
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+//输出本地钱包地址对应的公钥，用于创建多重签名地址
+func (cli *CLI) getPubKey(address string, nodeID string) {
+
+	wallets, _ := NewWallets(nodeID)
+	wallet := wallets.Wallets[address]
+	if wallet == nil {
+
+		fmt.Printf("Address:%s is not in the wallet\n", address)
+		os.Exit(1)
+	}
+
+	fmt.Printf("公钥：%x\n", wallet.PublicKey)
+}
+
+//根据m和n个公钥创建M-of-N多重签名地址
+func (cli *CLI) createMultiSig(m int, pubKeys []string) {
+
+	var publicKeys [][]byte
+	for _, pubKey := range pubKeys {
+
+		publicKey, err := hex.DecodeString(pubKey)
+		if err != nil {
+
+			fmt.Printf("PublicKey:%s invalid\n", pubKey)
+			os.Exit(1)
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+
+	redeemScript, err := MultiSigScript(m, publicKeys)
+	if err != nil {
+
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("多重签名地址：%s\n", ScriptHashAddress(redeemScript))
+	fmt.Printf("赎回脚本：%x\n", redeemScript)
+	fmt.Printf("%d-of-%d，花费时需要提供赎回脚本\n", m, len(publicKeys))
+}
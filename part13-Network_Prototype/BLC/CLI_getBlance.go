@@ -14,7 +14,30 @@ func (cli *CLI) getBlance(address string, nodeID string) {
 	//amount := blockchain.GetBalance(address)  引入UTXOSet前的方法
 
 	utxoSet := &UTXOSet{blockchain}
-	amount := utxoSet.GetBalance(address)
+	mature, immature := utxoSet.GetBalance(address)
 
-	fmt.Printf("%s一共有%d个Token\n", address, amount)
+	fmt.Printf("%s一共有%d个Token\n", address, mature+immature)
+	fmt.Printf("可用：%d  未成熟：%d\n", mature, immature)
+}
+
+//查询轻节点同步到的余额
+func (cli *CLI) getSPVBalance(address string, nodeID string) {
+
+	fmt.Println("地址：" + address)
+
+	spv := OpenSPVChain(nodeID)
+	defer spv.DB.Close()
+
+	tip := spv.TipHeader()
+	if tip == nil {
+
+		fmt.Println("No block headers yet, run startnode -spv first")
+		return
+	}
+
+	mature, immature := spv.GetBalance(address)
+
+	fmt.Printf("区块头高度：%d\n", tip.Height)
+	fmt.Printf("%s一共有%d个Token\n", address, mature+immature)
+	fmt.Printf("可用：%d  未成熟：%d\n", mature, immature)
 }
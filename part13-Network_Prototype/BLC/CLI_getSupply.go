@@ -0,0 +1,19 @@
+package BLC
+
+import "fmt"
+
+//查询发行量  height小于等于0时使用当前最大高度
+func (cli *CLI) getSupply(height int64, nodeID string) {
+
+	if height <= 0 {
+
+		blockchain := GetBlockchain(nodeID)
+		height = blockchain.GetBestHeight()
+		blockchain.DB.Close()
+	}
+
+	fmt.Printf("高度：%d\n", height)
+	fmt.Printf("区块奖励：%d\n", BlockSubsidy(height))
+	fmt.Printf("已发行：%d\n", TotalSupply(height))
+	fmt.Printf("发行总量：%d\n", MaxSupply())
+}
@@ -0,0 +1,140 @@
+package BLC
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+//构造未签名的多重签名交易并保存到文件
+func (cli *CLI) createMultiSigTx(redeemScriptHex string, to string, amount int64, feeRate int64, file string, nodeID string) {
+
+	redeemScript, err := hex.DecodeString(redeemScriptHex)
+	if err != nil {
+
+		fmt.Printf("RedeemScript:%s invalid\n", redeemScriptHex)
+		os.Exit(1)
+	}
+
+	blc := GetBlockchain(nodeID)
+	defer blc.DB.Close()
+
+	utxoSet := &UTXOSet{blc}
+	tx, err := NewMultiSigTransaction(redeemScript, to, amount, feeRate, utxoSet, []*Transaction{})
+	if err != nil {
+
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	saveTransactionFile(file, tx)
+	fmt.Printf("未签名交易已保存到%s\n", file)
+}
+
+//用本地钱包中的私钥为多重签名交易签名，签名后写回文件
+func (cli *CLI) signMultiSigTx(file string, address string, nodeID string) {
+
+	wallets, _ := NewWallets(nodeID)
+	wallet := wallets.Wallets[address]
+	if wallet == nil {
+
+		fmt.Printf("Address:%s is not in the wallet\n", address)
+		os.Exit(1)
+	}
+
+	tx := loadTransactionFile(file)
+	signed, err := tx.SignMultiSig(wallet.PrivateKey)
+	if err != nil {
+
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	saveTransactionFile(file, tx)
+
+	have, need, _ := tx.MultiSigStatus()
+	fmt.Printf("已签名%d个输入，签名数：%d/%d\n", signed, have, need)
+}
+
+//签名完成后验证并广播多重签名交易
+func (cli *CLI) sendMultiSigTx(file string, nodeID string) {
+
+	tx := loadTransactionFile(file)
+	err := tx.FinalizeMultiSig()
+	if err != nil {
+
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	blc := GetBlockchain(nodeID)
+	utxoSet := &UTXOSet{blc}
+
+	//广播前先在本地验证脚本
+	var prevOuts []*TXOutput
+	for _, vin := range tx.Vins {
+
+		utxo := utxoSet.FindUTXO(vin.TxHash, vin.Vout)
+		if utxo == nil {
+
+			fmt.Printf("Input %x:%d is spent or unknown\n", vin.TxHash, vin.Vout)
+			os.Exit(1)
+		}
+		prevOuts = append(prevOuts, utxo.Output)
+	}
+	blc.DB.Close()
+
+	err = tx.VerifyScripts(prevOuts)
+	if err != nil {
+
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("交易：%x\n", tx.TxHash)
+
+	// 将交易发送给本节点或种子节点，由它转发给整个网络
+	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
+	err = submitTx(tx)
+	if err != nil {
+
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+//交易以十六进制文本保存
+func saveTransactionFile(file string, tx *Transaction) {
+
+	err := ioutil.WriteFile(file, []byte(hex.EncodeToString(tx.Serialize())+"\n"), 0664)
+	if err != nil {
+
+		log.Panic(err)
+	}
+}
+
+func loadTransactionFile(file string) *Transaction {
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+
+		log.Panic(err)
+	}
+
+	data, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+
+		log.Panic(err)
+	}
+
+	tx, err := DecodeTransaction(data)
+	if err != nil {
+
+		log.Panic(err)
+	}
+
+	return tx
+}
@@ -0,0 +1,121 @@
+package BLC
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+//把文件的SHA-256哈希写入区块链  由from支付手续费
+func (cli *CLI) notarize(from string, file string, feeRate int64, nodeID string, mineNow bool) {
+
+	fileHash := fileSHA256(file)
+	fmt.Printf("文件哈希：%x\n", fileHash)
+
+	blc := GetBlockchain(nodeID)
+	defer blc.DB.Close()
+
+	utxoSet := &UTXOSet{blc}
+	tx, err := NewDataTransaction(from, fileHash, feeRate, utxoSet, []*Transaction{}, nodeID)
+	if err != nil {
+
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("交易：%x\n", tx.TxHash)
+
+	cli.submitTransaction(blc, tx, from, nodeID, mineNow)
+}
+
+//查找文件哈希所在的区块，输出交易的默克尔证明
+func (cli *CLI) verifyNotarization(file string, nodeID string) {
+
+	fileHash := fileSHA256(file)
+	fmt.Printf("文件哈希：%x\n", fileHash)
+
+	blc := GetBlockchain(nodeID)
+	defer blc.DB.Close()
+
+	blcIterator := blc.Iterator()
+	for {
+
+		block := blcIterator.Next()
+		if block == nil {
+
+			break
+		}
+
+		for _, tx := range block.Txs {
+
+			if transactionCarriesData(tx, fileHash) == false {
+
+				continue
+			}
+
+			branch, index, err := block.MerkleProof(tx.TxHash)
+			if err != nil {
+
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("区块：%x\n", block.Hash)
+			fmt.Printf("高度：%d\n", block.Height)
+			fmt.Printf("时间：%s\n", time.Unix(block.Timestamp, 0).Format("2006-01-02 15:04:05"))
+			fmt.Printf("确认数：%d\n", blc.GetBestHeight()-block.Height+1)
+			fmt.Printf("交易：%x\n", tx.TxHash)
+			fmt.Printf("默克尔根：%x\n", block.MerkleRoot)
+			fmt.Printf("交易位置：%d\n", index)
+			fmt.Println("默克尔证明：")
+			for _, hash := range branch {
+
+				fmt.Printf("%x\n", hash)
+			}
+
+			if VerifyMerkleProof(block.MerkleRoot, tx.SignedHash(), branch, index) == false {
+
+				fmt.Println("默克尔证明与默克尔根不符")
+				os.Exit(1)
+			}
+			fmt.Println("默克尔证明验证通过")
+
+			return
+		}
+	}
+
+	fmt.Println("文件没有被公证")
+	os.Exit(1)
+}
+
+//交易是否有携带该数据的数据输出
+func transactionCarriesData(tx *Transaction, data []byte) bool {
+
+	for _, out := range tx.Vouts {
+
+		if IsUnspendable(out.ScriptPubKey) && bytes.Compare(ExtractNullData(out.ScriptPubKey), data) == 0 {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+//文件的SHA-256哈希
+func fileSHA256(file string) []byte {
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	hash := sha256.Sum256(content)
+
+	return hash[:]
+}
@@ -2,11 +2,12 @@ package BLC
 
 import (
 	"fmt"
+	"os"
 	"strconv"
 )
 
 //转账
-func (cli *CLI) send(from []string, to []string, amount []string, nodeID string, mineNow bool)  {
+func (cli *CLI) send(from []string, to []string, amount []string, feeRate int64, lockTime uint32, sequence uint32, nodeID string, mineNow bool)  {
 
 	blc := GetBlockchain(nodeID)
 	defer blc.DB.Close()
@@ -16,10 +17,13 @@ func (cli *CLI) send(from []string, to []string, amount []string, nodeID string,
 	// 由交易的第一个转账地址进行打包交易并挖矿
 	if mineNow {
 
-		blc.MineNewBlock(from, to, amount, nodeID)
+		// 新区块加入区块链时会同步更新UTXOSet
+		_, err := blc.MineNewBlock(from, to, amount, feeRate, lockTime, sequence, nodeID)
+		if err != nil {
 
-		// 转账成功以后，需要更新UTXOSet
-		utxoSet.Update()
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	}else {
 
 		// 把交易发送到矿工节点去进行验证
@@ -31,11 +35,59 @@ func (cli *CLI) send(from []string, to []string, amount []string, nodeID string,
 		for index, address := range from {
 
 			value, _ := strconv.Atoi(amount[index])
-			tx := NewTransaction(address, to[index], int64(value), utxoSet, txs, nodeID)
+			tx := NewTransaction(address, to[index], int64(value), feeRate, lockTime, sequence, utxoSet, txs, nodeID)
+
+			// 时间锁没有到期的交易会被节点拒绝
+			err := utxoSet.CheckTransactionLocks(tx)
+			if err != nil {
+
+				fmt.Printf("The Tx:%x can not be sent yet: %s\n", tx.TxHash, err)
+				os.Exit(1)
+			}
 			txs = append(txs, tx)
 
-			// 将交易发送给主节点
-			sendTx(knowedNodes[0], tx)
+			// 将交易发送给本节点或种子节点，由它转发给整个网络
+			err = submitTx(tx)
+			if err != nil {
+
+				fmt.Println(err)
+				os.Exit(1)
+			}
 		}
 	}
-}
\ No newline at end of file
+}
+
+//立即打包交易，或者把交易发送到网络
+func (cli *CLI) submitTransaction(blc *Blockchain, tx *Transaction, rewardAddress string, nodeID string, mineNow bool) {
+
+	// 时间锁没有到期的交易会被节点拒绝
+	utxoSet := &UTXOSet{blc}
+	err := utxoSet.CheckTransactionLocks(tx)
+	if err != nil {
+
+		fmt.Printf("The Tx:%x can not be sent yet: %s\n", tx.TxHash, err)
+		os.Exit(1)
+	}
+
+	if mineNow {
+
+		_, err = blc.MineTransactions([]*Transaction{tx}, rewardAddress)
+		if err != nil {
+
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Println("miner deal with the Tx...")
+
+	// 将交易发送给本节点或种子节点，由它转发给整个网络
+	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
+	err = submitTx(tx)
+	if err != nil {
+
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,146 @@
+package BLC
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+//生成交易证明  file不为空时同时保存到文件
+func (cli *CLI) getTxProof(txHashHex string, file string, nodeID string) {
+
+	txHash, err := hex.DecodeString(txHashHex)
+	if err != nil {
+
+		fmt.Printf("TxHash:%s invalid\n", txHashHex)
+		os.Exit(1)
+	}
+
+	blc := GetBlockchain(nodeID)
+	defer blc.DB.Close()
+
+	block := findTransactionBlock(blc, txHash)
+	if block == nil {
+
+		fmt.Printf("Tx:%x is not found in the blockchain\n", txHash)
+		os.Exit(1)
+	}
+
+	proof, err := NewTxProof(block, txHash)
+	if err != nil {
+
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	proofHex := hex.EncodeToString(proof.Serialize())
+	fmt.Printf("区块：%x\n", block.Hash)
+	fmt.Printf("高度：%d\n", block.Height)
+	fmt.Printf("证明：%s\n", proofHex)
+
+	if file != "" {
+
+		err = ioutil.WriteFile(file, []byte(proofHex+"\n"), 0664)
+		if err != nil {
+
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("证明已保存到%s\n", file)
+	}
+}
+
+//验证交易证明  只使用本地的区块头
+func (cli *CLI) verifyTxProof(proofHex string, file string, nodeID string) {
+
+	if file != "" {
+
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		proofHex = strings.TrimSpace(string(content))
+	}
+
+	data, err := hex.DecodeString(proofHex)
+	if err != nil {
+
+		fmt.Println("Proof invalid")
+		os.Exit(1)
+	}
+
+	proof, err := DecodeTxProof(data)
+	if err != nil {
+
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = proof.Verify()
+	if err != nil {
+
+		fmt.Printf("证明无效：%s\n", err)
+		os.Exit(1)
+	}
+
+	blockHash := proof.Header.Hash()
+	fmt.Printf("交易：%x\n", proof.Tx.TxHash)
+	fmt.Printf("区块：%x\n", blockHash)
+	fmt.Printf("高度：%d\n", proof.Header.Height)
+	for index, out := range proof.Tx.Vouts {
+
+		address := ExtractAddress(out.ScriptPubKey)
+		if address == "" {
+
+			address = DisasmScript(out.ScriptPubKey)
+		}
+		fmt.Printf("输出%d：%d -> %s\n", index, out.Value, address)
+	}
+
+	//区块头是否在本地最长链上
+	blc := GetBlockchain(nodeID)
+	defer blc.DB.Close()
+
+	tip, err := blc.GetBlockHeader(blc.Tip)
+	if err != nil {
+
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	header := ancestorHeader(tip, proof.Header.Height, blc.lookupBlockHeader)
+	if header == nil || bytes.Compare(header.Hash(), blockHash) != 0 {
+
+		fmt.Println("证明有效，但区块不在本地最长链上")
+		os.Exit(1)
+	}
+
+	fmt.Printf("证明有效，确认数：%d\n", tip.Height-proof.Header.Height+1)
+}
+
+//在主链上找到包含某笔交易的区块
+func findTransactionBlock(blc *Blockchain, txHash []byte) *Block {
+
+	blcIterator := blc.Iterator()
+	for {
+
+		block := blcIterator.Next()
+		if block == nil {
+
+			return nil
+		}
+
+		for _, tx := range block.Txs {
+
+			if bytes.Compare(tx.TxHash, txHash) == 0 {
+
+				return block
+			}
+		}
+	}
+}
@@ -1,18 +1,51 @@
 package BLC
 
 const PROTOCOL  = "tcp"
-//发送消息的前12个字节指定了命令名(version)
+//消息头中的命令名占12个字节(version)
 const COMMANDLENGTH  = 12
-const NODE_VERSION  = 1
+const NODE_VERSION  = 2
+// 能够通信的最低协议版本  更低的节点在握手时断开
+const MIN_PROTOCOL_VERSION  = 2
+// 节点软件名称和版本
+const USER_AGENT  = "/publicChaorsChain:0.13/"
+
+// 节点提供的服务  在version中以标志位发送
+// 全节点：保存完整区块，提供区块、区块头、交易证明和区块过滤器
+const SERVICE_FULL_NODE  = uint64(1 << 0)
+// 轻节点：只保存区块头，不转发区块和交易
+const SERVICE_SPV  = uint64(1 << 1)
+// 矿工：打包交易挖矿
+const SERVICE_MINER  = uint64(1 << 2)
 
 // 命令
 const COMMAND_VERSION  = "version"
+const COMMAND_VERACK  = "verack"
 const COMMAND_ADDR  = "addr"
+const COMMAND_GETADDR  = "getaddr"
 const COMMAND_BLOCK  = "block"
 const COMMAND_INV  = "inv"
-const COMMAND_GETBLOCKS  = "getblocks"
 const COMMAND_GETDATA  = "getdata"
 const COMMAND_TX  = "tx"
+const COMMAND_GETHEADERS  = "getheaders"
+const COMMAND_HEADERS  = "headers"
+const COMMAND_GETTXPROOFS  = "gettxproofs"
+const COMMAND_TXPROOFS  = "txproofs"
+const COMMAND_GETCFILTERS  = "getcfilters"
+const COMMAND_CFILTER  = "cfilter"
+const COMMAND_PING  = "ping"
+const COMMAND_PONG  = "pong"
+
+// 一条headers消息最多携带的区块头数
+const maxHeadersPerMessage = 2000
+
+// 一条getcfilters最多请求的过滤器数
+const maxCFiltersPerRequest = 1000
+
+// 一条gettxproofs最多查找的区块数
+const maxTxProofBlocks = 1000
+
+// 一条txproofs中交易证明的总字节数上限  超过后剩下的区块留给下一次请求
+const maxTxProofBytes = maxMessagePayload / 2
 
 // 类型
 const BLOCK_TYPE  = "block"
@@ -0,0 +1,103 @@
+package BLC
+
+import (
+	"fmt"
+	"math"
+)
+
+// 难度调整相关参数
+// 创世区块的难度 期望计算的Hash值前面至少要有18个零
+const genesisTargetBits = 18
+
+// 每隔多少个区块调整一次难度
+const retargetInterval = 10
+
+// 期望的出块间隔(秒)
+const targetBlockSpacing = 10
+
+// 单次调整最多改变的难度位数，防止时间戳作假导致难度剧烈波动
+const maxRetargetStep = 2
+
+// 难度上下限
+const minTargetBits = 1
+const maxTargetBits = 255
+
+// 计算parent之后下一个区块应当使用的难度
+// 规则类似比特币：每retargetInterval个区块，根据这段时间的实际出块耗时与期望耗时之比调整难度
+// 只需要区块头，同步区块头时也可以校验难度
+func (blc *Blockchain) NextTargetBits(parent *BlockHeader) int64 {
+
+	return nextTargetBitsFrom(parent, blc.lookupBlockHeader)
+}
+
+// 用lookup按哈希读取祖先区块头计算下一个区块的难度  轻节点用自己的区块头表计算
+func nextTargetBitsFrom(parent *BlockHeader, lookup func(hash []byte) *BlockHeader) int64 {
+
+	// 还没到调整周期，沿用父区块难度
+	if parent.Height%retargetInterval != 0 {
+
+		return parent.TargetBits
+	}
+
+	// 找到本周期的第一个区块
+	first := parent
+	for i := 0; i < retargetInterval-1; i++ {
+
+		header := lookup(first.PrevBlockHash)
+		if header == nil {
+
+			// 周期内区块不全(比如还在同步中)，不调整
+			return parent.TargetBits
+		}
+		first = header
+	}
+
+	return retargetBits(parent.TargetBits, parent.Timestamp-first.Timestamp)
+}
+
+// 根据实际耗时调整难度位数
+// 目标值每减半难度位数加1，所以调整量为log2(期望耗时/实际耗时)
+func retargetBits(bits int64, actualTimespan int64) int64 {
+
+	expectedTimespan := int64((retargetInterval - 1) * targetBlockSpacing)
+
+	// 防止时间戳倒退或为0
+	if actualTimespan < 1 {
+
+		actualTimespan = 1
+	}
+
+	step := int64(math.Round(math.Log2(float64(expectedTimespan) / float64(actualTimespan))))
+	if step > maxRetargetStep {
+
+		step = maxRetargetStep
+	}
+	if step < -maxRetargetStep {
+
+		step = -maxRetargetStep
+	}
+
+	bits += step
+	if bits < minTargetBits {
+
+		bits = minTargetBits
+	}
+	if bits > maxTargetBits {
+
+		bits = maxTargetBits
+	}
+
+	return bits
+}
+
+// 校验区块声明的难度是否符合调整规则
+func (blc *Blockchain) CheckTargetBits(header *BlockHeader, parent *BlockHeader) error {
+
+	expected := blc.NextTargetBits(parent)
+	if header.TargetBits != expected {
+
+		return fmt.Errorf("bad difficulty bits: got %d, want %d", header.TargetBits, expected)
+	}
+
+	return nil
+}
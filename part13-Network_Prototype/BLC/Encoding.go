@@ -0,0 +1,322 @@
+package BLC
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+/**
+区块、交易、UTXO和网络消息的二进制编码
+
+1.整数：固定宽度小端序，int64占8字节，int32占4字节
+2.变长整数：与比特币CompactSize相同
+	< 0xfd             1字节
+	<= 0xffff          0xfd + 2字节
+	<= 0xffffffff      0xfe + 4字节
+	其他               0xff + 8字节
+3.字节数组、字符串：变长整数表示长度 + 内容
+4.数组：变长整数表示元素个数 + 依次编码每个元素
+5.区块头：定长，哈希字段固定32字节
+6.顶层对象(区块、交易、UTXO表记录、网络消息)最前面有1字节的编码版本
+
+同一个对象只有一种编码结果，哈希不再依赖encoding/gob的内部实现，其他语言也可以解析
+*/
+
+// 编码格式版本
+const encodingVersion = byte(1)
+
+// 单个字节数组的最大长度，防止恶意数据申请过大内存
+const maxVarBytesLength = 32 * 1024 * 1024
+
+// 单个数组的最大元素个数
+const maxArrayLength = 1024 * 1024
+
+var errNonCanonicalVarInt = errors.New("non-canonical varint")
+
+// 编码器
+type binaryWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *binaryWriter) writeByte(b byte) {
+
+	w.buf.WriteByte(b)
+}
+
+func (w *binaryWriter) writeUint16(n uint16) {
+
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], n)
+	w.buf.Write(b[:])
+}
+
+func (w *binaryWriter) writeUint32(n uint32) {
+
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], n)
+	w.buf.Write(b[:])
+}
+
+func (w *binaryWriter) writeUint64(n uint64) {
+
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], n)
+	w.buf.Write(b[:])
+}
+
+func (w *binaryWriter) writeInt32(n int32) {
+
+	w.writeUint32(uint32(n))
+}
+
+func (w *binaryWriter) writeInt64(n int64) {
+
+	w.writeUint64(uint64(n))
+}
+
+// 变长整数
+func (w *binaryWriter) writeVarInt(n uint64) {
+
+	switch {
+	case n < 0xfd:
+		w.writeByte(byte(n))
+	case n <= 0xffff:
+		w.writeByte(0xfd)
+		w.writeUint16(uint16(n))
+	case n <= 0xffffffff:
+		w.writeByte(0xfe)
+		w.writeUint32(uint32(n))
+	default:
+		w.writeByte(0xff)
+		w.writeUint64(n)
+	}
+}
+
+func (w *binaryWriter) writeVarBytes(data []byte) {
+
+	w.writeVarInt(uint64(len(data)))
+	w.buf.Write(data)
+}
+
+// 定长32字节的哈希  不足的部分补零
+func (w *binaryWriter) writeHash(hash []byte) {
+
+	var b [32]byte
+	copy(b[:], hash)
+	w.buf.Write(b[:])
+}
+
+func (w *binaryWriter) writeString(s string) {
+
+	w.writeVarBytes([]byte(s))
+}
+
+func (w *binaryWriter) Bytes() []byte {
+
+	return w.buf.Bytes()
+}
+
+// 解码器  出错后记录第一个错误，后续读取都返回零值，最后统一检查err
+type binaryReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func newBinaryReader(data []byte) *binaryReader {
+
+	return &binaryReader{r: bytes.NewReader(data)}
+}
+
+func (r *binaryReader) read(n int) []byte {
+
+	if r.err != nil {
+
+		return nil
+	}
+
+	if n > r.r.Len() {
+
+		r.err = fmt.Errorf("unexpected end of data: need %d bytes, have %d", n, r.r.Len())
+		return nil
+	}
+
+	b := make([]byte, n)
+	r.r.Read(b)
+
+	return b
+}
+
+func (r *binaryReader) readByte() byte {
+
+	b := r.read(1)
+	if b == nil {
+
+		return 0
+	}
+
+	return b[0]
+}
+
+func (r *binaryReader) readUint16() uint16 {
+
+	b := r.read(2)
+	if b == nil {
+
+		return 0
+	}
+
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (r *binaryReader) readUint32() uint32 {
+
+	b := r.read(4)
+	if b == nil {
+
+		return 0
+	}
+
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *binaryReader) readUint64() uint64 {
+
+	b := r.read(8)
+	if b == nil {
+
+		return 0
+	}
+
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (r *binaryReader) readInt32() int32 {
+
+	return int32(r.readUint32())
+}
+
+func (r *binaryReader) readInt64() int64 {
+
+	return int64(r.readUint64())
+}
+
+// 变长整数  只接受最短编码，保证编码唯一
+func (r *binaryReader) readVarInt() uint64 {
+
+	var n, min uint64
+
+	switch prefix := r.readByte(); prefix {
+	case 0xfd:
+		n, min = uint64(r.readUint16()), 0xfd
+	case 0xfe:
+		n, min = uint64(r.readUint32()), 0x10000
+	case 0xff:
+		n, min = r.readUint64(), 0x100000000
+	default:
+		return uint64(prefix)
+	}
+
+	if r.err == nil && n < min {
+
+		r.err = errNonCanonicalVarInt
+		return 0
+	}
+
+	return n
+}
+
+// 读取数组长度
+func (r *binaryReader) readCount() int {
+
+	n := r.readVarInt()
+	if r.err == nil && n > maxArrayLength {
+
+		r.err = fmt.Errorf("array length %d too large", n)
+		return 0
+	}
+
+	return int(n)
+}
+
+func (r *binaryReader) readVarBytes() []byte {
+
+	n := r.readVarInt()
+	if r.err == nil && n > maxVarBytesLength {
+
+		r.err = fmt.Errorf("byte array length %d too large", n)
+		return nil
+	}
+
+	b := r.read(int(n))
+	if b == nil || len(b) == 0 {
+
+		// 空数组统一解码为nil
+		return nil
+	}
+
+	return b
+}
+
+func (r *binaryReader) readHash() []byte {
+
+	return r.read(32)
+}
+
+func (r *binaryReader) readString() string {
+
+	return string(r.readVarBytes())
+}
+
+// 读取并检查编码版本
+func (r *binaryReader) readVersion() {
+
+	version := r.readByte()
+	if r.err == nil && version != encodingVersion {
+
+		r.err = fmt.Errorf("unknown encoding version %d", version)
+	}
+}
+
+// 解码结束后检查：没有错误且没有多余的数据
+func (r *binaryReader) finish() error {
+
+	if r.err != nil {
+
+		return r.err
+	}
+
+	if r.r.Len() != 0 {
+
+		return fmt.Errorf("%d trailing bytes", r.r.Len())
+	}
+
+	return nil
+}
+
+// 网络消息的编解码接口
+type wireMessage interface {
+	encode(w *binaryWriter)
+	decode(r *binaryReader)
+}
+
+// 将网络消息编码成字节数组
+func encodeMessage(msg wireMessage) []byte {
+
+	w := &binaryWriter{}
+	w.writeByte(encodingVersion)
+	msg.encode(w)
+
+	return w.Bytes()
+}
+
+// 从字节数组解码网络消息
+func decodeMessage(data []byte, msg wireMessage) error {
+
+	r := newBinaryReader(data)
+	r.readVersion()
+	msg.decode(r)
+
+	return r.finish()
+}
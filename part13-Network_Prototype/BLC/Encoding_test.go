@@ -0,0 +1,311 @@
+package BLC
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// 测试向量与 二进制编码格式.md 中的十六进制串一致，编码格式改变时两边一起更新
+
+// 32个相同字节
+func repeatHash(b byte) []byte {
+
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+
+	data, err := hex.DecodeString(s)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// 文档中的创币交易
+func vectorCoinbaseTx() *Transaction {
+
+	pkh := bytes.Repeat([]byte{0x22}, 20)
+
+	tx := &Transaction{
+		nil,
+		[]*TXInput{{nil, -1, newScriptBuilder().AddInt64(2).Script(), MaxTxInSequenceNum}},
+		[]*TXOutput{{25, PayToPubKeyHashScript(pkh)}},
+		1,
+		0,
+	}
+	tx.TxHash = tx.Hash()
+
+	return tx
+}
+
+// 文档中的普通交易
+func vectorTx() *Transaction {
+
+	pkh := bytes.Repeat([]byte{0x22}, 20)
+
+	tx := &Transaction{
+		nil,
+		[]*TXInput{{repeatHash(0x11), 0, SignatureScript([]byte{0xaa, 0xbb}, []byte{0xcc}), 7}},
+		[]*TXOutput{{5, PayToPubKeyHashScript(pkh)}, {20, PayToPubKeyHashScript(pkh)}},
+		1,
+		100,
+	}
+	tx.TxHash = tx.Hash()
+
+	return tx
+}
+
+// 文档中的区块  只包含上面的创币交易
+func vectorBlock() *Block {
+
+	block := &Block{BlockHeader: BlockHeader{Version: 1, Height: 2, PrevBlockHash: repeatHash(0x00), Timestamp: 1700000000, Nonce: 42, TargetBits: 18}}
+	block.Txs = []*Transaction{vectorCoinbaseTx()}
+	block.MerkleRoot = block.HashTransactions()
+	block.Hash = block.BlockHeader.Hash()
+
+	return block
+}
+
+func TestVarIntVectors(t *testing.T) {
+
+	vectors := []struct {
+		value   uint64
+		encoded string
+	}{
+		{0, "00"},
+		{252, "fc"},
+		{253, "fdfd00"},
+		{65535, "fdffff"},
+		{65536, "fe00000100"},
+		{4294967296, "ff0000000001000000"},
+	}
+
+	for _, v := range vectors {
+
+		w := &binaryWriter{}
+		w.writeVarInt(v.value)
+		if got := hex.EncodeToString(w.Bytes()); got != v.encoded {
+
+			t.Errorf("varint %d: got %s, want %s", v.value, got, v.encoded)
+		}
+
+		r := newBinaryReader(mustDecodeHex(t, v.encoded))
+		if got := r.readVarInt(); got != v.value || r.finish() != nil {
+
+			t.Errorf("decode %s: got %d, %v", v.encoded, got, r.err)
+		}
+	}
+}
+
+// 能用更短形式表示的varint必须拒绝，否则同一个值有多种编码，哈希就不唯一
+func TestNonCanonicalVarInt(t *testing.T) {
+
+	for _, encoded := range []string{"fd0000", "fdfc00", "fe0000000000", "feffff0000", "ff0000000000000000", "ffffffffff00000000"} {
+
+		r := newBinaryReader(mustDecodeHex(t, encoded))
+		r.readVarInt()
+		if r.err != errNonCanonicalVarInt {
+
+			t.Errorf("%s: got %v, want %v", encoded, r.err, errNonCanonicalVarInt)
+		}
+	}
+}
+
+func TestTransactionVectors(t *testing.T) {
+
+	vectors := []struct {
+		name    string
+		tx      *Transaction
+		encoded string
+		txid    string
+	}{
+		{
+			"coinbase",
+			vectorCoinbaseTx(),
+			"01010000000000000020c5862b833babd95734f32295368d7dc8fc1bd00894ffebfc2fc9b84d89788a890100ffffffff0152ffffffff0119000000000000001976a914222222222222222222222222222222222222222288ac00000000",
+			"c5862b833babd95734f32295368d7dc8fc1bd00894ffebfc2fc9b84d89788a89",
+		},
+		{
+			"p2pkh",
+			vectorTx(),
+			"0101000000000000002024dbaff609f9bf1856b12e3dc2da9a9cb1336725d8f4c37b67453748d96800c601201111111111111111111111111111111111111111111111111111111111111111000000000502aabb01cc070000000205000000000000001976a914222222222222222222222222222222222222222288ac14000000000000001976a914222222222222222222222222222222222222222288ac64000000",
+			"24dbaff609f9bf1856b12e3dc2da9a9cb1336725d8f4c37b67453748d96800c6",
+		},
+	}
+
+	for _, v := range vectors {
+
+		if got := hex.EncodeToString(v.tx.Serialize()); got != v.encoded {
+
+			t.Errorf("%s: encoded\n got %s\nwant %s", v.name, got, v.encoded)
+		}
+		if got := hex.EncodeToString(v.tx.TxHash); got != v.txid {
+
+			t.Errorf("%s: txid got %s, want %s", v.name, got, v.txid)
+		}
+
+		// 解码后重新编码必须得到相同的字节
+		decoded, err := DecodeTransaction(mustDecodeHex(t, v.encoded))
+		if err != nil {
+
+			t.Fatalf("%s: %s", v.name, err)
+		}
+		if bytes.Compare(decoded.Serialize(), v.tx.Serialize()) != 0 || decoded.CheckTxHash() != nil {
+
+			t.Errorf("%s: round trip mismatch", v.name)
+		}
+	}
+}
+
+// 交易哈希不包含普通交易的解锁脚本，区块的默克尔根包含
+func TestTxHashIgnoresScriptSig(t *testing.T) {
+
+	tx := vectorTx()
+	malleated := vectorTx()
+	malleated.Vins[0].ScriptSig = SignatureScript([]byte{0xaa, 0xbb, 0x00}, []byte{0xcc})
+
+	if bytes.Compare(malleated.Hash(), tx.TxHash) != 0 || malleated.CheckTxHash() != nil {
+
+		t.Errorf("txid changed with the script sig")
+	}
+	if bytes.Compare(malleated.SignedHash(), tx.SignedHash()) == 0 {
+
+		t.Errorf("signed hash ignores the script sig")
+	}
+
+	// 创币交易的解锁脚本是区块高度，参与哈希
+	coinbase := vectorCoinbaseTx()
+	coinbase.Vins[0].ScriptSig = newScriptBuilder().AddInt64(3).Script()
+	if bytes.Compare(coinbase.Hash(), vectorCoinbaseTx().TxHash) == 0 {
+
+		t.Errorf("coinbase txid ignores the height")
+	}
+
+	// 改写解锁脚本后默克尔根改变，原来的默克尔证明不再成立
+	block := vectorBlock()
+	block.Txs = append(block.Txs, tx)
+	root := block.HashTransactions()
+	branch, index, err := block.MerkleProof(tx.TxHash)
+	if err != nil || VerifyMerkleProof(root, tx.SignedHash(), branch, index) == false {
+
+		t.Fatalf("merkle proof: %v", err)
+	}
+	block.Txs[1] = malleated
+	if bytes.Compare(block.HashTransactions(), root) == 0 {
+
+		t.Errorf("merkle root ignores the script sig")
+	}
+	if VerifyMerkleProof(root, malleated.SignedHash(), branch, index) {
+
+		t.Errorf("merkle proof accepted a malleated tx")
+	}
+
+	// 内容和哈希不一致、旧版本的交易都拒绝
+	changed := vectorTx()
+	changed.Vouts[0].Value++
+	legacy := vectorTx()
+	legacy.Version = 0
+	legacy.TxHash = legacy.Hash()
+	for _, bad := range []*Transaction{changed, legacy} {
+
+		if bad.CheckTxHash() == nil {
+
+			t.Errorf("tx %+v passed CheckTxHash", bad)
+		}
+	}
+}
+
+func TestBlockVectors(t *testing.T) {
+
+	block := vectorBlock()
+
+	const merkleRoot = "4f8b39d207b1e2283caaef99406f31ea10b2fb918ac08e301e5b165970d96095"
+	const header = "0100000000000000000000000000000000000000000000000000000000000000000000004f8b39d207b1e2283caaef99406f31ea10b2fb918ac08e301e5b165970d9609500f1536500000000120000002a000000000000000200000000000000"
+	const blockHash = "b67e04dd2474099005b8aa6e6d114eb9fd03c13f9b4e3c708f06247a7f1a93eb"
+	const encoded = "010100000000000000000000000000000000000000000000000000000000000000000000004f8b39d207b1e2283caaef99406f31ea10b2fb918ac08e301e5b165970d9609500f1536500000000120000002a00000000000000020000000000000001010000000000000020c5862b833babd95734f32295368d7dc8fc1bd00894ffebfc2fc9b84d89788a890100ffffffff0152ffffffff0119000000000000001976a914222222222222222222222222222222222222222288ac00000000"
+
+	if got := hex.EncodeToString(block.MerkleRoot); got != merkleRoot {
+
+		t.Errorf("merkle root got %s, want %s", got, merkleRoot)
+	}
+	if got := hex.EncodeToString(block.BlockHeader.Serialize()); got != header {
+
+		t.Errorf("header\n got %s\nwant %s", got, header)
+	}
+	if got := hex.EncodeToString(block.Hash); got != blockHash {
+
+		t.Errorf("block hash got %s, want %s", got, blockHash)
+	}
+	if got := hex.EncodeToString(block.Serialize()); got != encoded {
+
+		t.Errorf("block\n got %s\nwant %s", got, encoded)
+	}
+
+	decoded, err := DecodeBlock(mustDecodeHex(t, encoded))
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if bytes.Compare(decoded.Serialize(), block.Serialize()) != 0 || hex.EncodeToString(decoded.Hash) != blockHash {
+
+		t.Error("block round trip mismatch")
+	}
+
+	decodedHeader, err := DecodeBlockHeader(mustDecodeHex(t, header))
+	if err != nil || hex.EncodeToString(decodedHeader.Hash()) != blockHash {
+
+		t.Errorf("header round trip: %v", err)
+	}
+
+	// 末尾多出的字节不能忽略
+	_, err = DecodeBlock(append(mustDecodeHex(t, encoded), 0))
+	if err == nil {
+
+		t.Error("trailing byte accepted")
+	}
+}
+
+func TestUTXOVectors(t *testing.T) {
+
+	cb := vectorCoinbaseTx()
+	tx := vectorTx()
+
+	const encoded = "01022024dbaff609f9bf1856b12e3dc2da9a9cb1336725d8f4c37b67453748d96800c60114000000000000001976a914222222222222222222222222222222222222222288ac07000000000000000020c5862b833babd95734f32295368d7dc8fc1bd00894ffebfc2fc9b84d89788a890019000000000000001976a914222222222222222222222222222222222222222288ac020000000000000001"
+
+	outputs := &TXOutputs{[]*UTXO{{tx.TxHash, 1, tx.Vouts[1], 7, false}, {cb.TxHash, 0, cb.Vouts[0], 2, true}}}
+	if got := hex.EncodeToString(outputs.Serialize()); got != encoded {
+
+		t.Errorf("utxo\n got %s\nwant %s", got, encoded)
+	}
+
+	if bytes.Compare(DeserializeTXOutputs(mustDecodeHex(t, encoded)).Serialize(), outputs.Serialize()) != 0 {
+
+		t.Error("utxo round trip mismatch")
+	}
+}
+
+func TestMessageVectors(t *testing.T) {
+
+	const version = "010200000000000000010000000000000000f15365000000000807060504030201182f7075626c69634368616f7273436861696e3a302e31332f07000000000000000e6c6f63616c686f73743a33303030"
+	const inv = "010e6c6f63616c686f73743a3330303005626c6f636b01204444444444444444444444444444444444444444444444444444444444444444"
+
+	if got := hex.EncodeToString(encodeMessage(&Version{2, 1, 1700000000, 0x0102030405060708, "/publicChaorsChain:0.13/", 7, "localhost:3000"})); got != version {
+
+		t.Errorf("version\n got %s\nwant %s", got, version)
+	}
+	if got := hex.EncodeToString(encodeMessage(&Inv{"localhost:3000", "block", [][]byte{repeatHash(0x44)}})); got != inv {
+
+		t.Errorf("inv\n got %s\nwant %s", got, inv)
+	}
+
+	var payload Version
+	err := decodeMessage(mustDecodeHex(t, version), &payload)
+	if err != nil || payload.Nonce != 0x0102030405060708 || payload.AddrFrom != "localhost:3000" {
+
+		t.Errorf("version round trip: %v", err)
+	}
+}
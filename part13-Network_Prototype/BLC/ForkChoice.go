@@ -0,0 +1,401 @@
+package BLC
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/boltdb/bolt"
+)
+
+// 存储每个区块累计工作量的表  键为区块哈希
+const blockWorkTableName = "chaorsBlockWork"
+
+// 切换主链时校验失败的区块及其后代  键为区块哈希，这些分支不再参与主链选择
+const invalidBlockTableName = "chaorsInvalidBlocks"
+
+// 孤块池最多缓存的区块数
+const maxOrphanBlocks = 1024
+
+// 孤块池最多占用的字节数  孤块还不能校验难度调整，防止用低难度的大区块占满内存
+const maxOrphanBytes = 20 * maxBlockSize
+
+// 父区块还没收到的孤块  键为父区块哈希
+var orphanBlocks = make(map[string][]*Block)
+var orphanCount = 0
+var orphanBytes = 0
+
+var errNoCommonAncestor = errors.New("no common ancestor with the current chain")
+
+// 从区块表中读取区块
+func loadBlock(b *bolt.Bucket, hash []byte) *Block {
+
+	blockBytes := b.Get(hash)
+	if blockBytes == nil {
+
+		return nil
+	}
+
+	return DeSerializeBlock(blockBytes)
+}
+
+// 保存区块的累计工作量
+func putChainWork(tx *bolt.Tx, hash []byte, work *big.Int) error {
+
+	b, err := tx.CreateBucketIfNotExists([]byte(blockWorkTableName))
+	if err != nil {
+
+		return err
+	}
+
+	return b.Put(hash, work.Bytes())
+}
+
+// 读取区块的累计工作量，旧数据库中没有记录的区块向前追溯计算后补写
+func chainWork(tx *bolt.Tx, hash []byte) (*big.Int, error) {
+
+	blocks := tx.Bucket([]byte(blockTableName))
+
+	// 向前找到第一个已记录工作量的祖先
+	var path []*Block
+	work := big.NewInt(0)
+	for {
+
+		if wb := tx.Bucket([]byte(blockWorkTableName)); wb != nil {
+
+			if workBytes := wb.Get(hash); workBytes != nil {
+
+				work.SetBytes(workBytes)
+				break
+			}
+		}
+
+		block := loadBlock(blocks, hash)
+		if block == nil {
+
+			// 追溯到创世区块之前
+			break
+		}
+		path = append(path, block)
+		hash = block.PrevBlockHash
+	}
+
+	// 从祖先开始依次累加
+	for i := len(path) - 1; i >= 0; i-- {
+
+		work = new(big.Int).Add(work, path[i].Work())
+		err := putChainWork(tx, path[i].Hash, work)
+		if err != nil {
+
+			return nil, err
+		}
+	}
+
+	return work, nil
+}
+
+// 孤块允许的最低难度  从主链顶端开始，每经过一个调整周期难度最多降低maxRetargetStep位
+// 再多留一个周期的余量，分叉点在顶端之前的孤块也能通过
+func (blc *Blockchain) minOrphanTargetBits(height int64) int64 {
+
+	tip := blc.lookupBlockHeader(blc.Tip)
+	if tip == nil {
+
+		return minTargetBits
+	}
+
+	retargets := int64(1)
+	if height > tip.Height {
+
+		retargets += (height - tip.Height) / retargetInterval
+	}
+
+	bits := tip.TargetBits - retargets*maxRetargetStep
+	if bits < minTargetBits {
+
+		bits = minTargetBits
+	}
+
+	return bits
+}
+
+// 区块是否在被标记为无效的分支上
+func isInvalidBlock(tx *bolt.Tx, hash []byte) bool {
+
+	b := tx.Bucket([]byte(invalidBlockTableName))
+
+	return b != nil && b.Get(hash) != nil
+}
+
+// 标记无效区块
+func markInvalidBlock(tx *bolt.Tx, hash []byte) error {
+
+	b, err := tx.CreateBucketIfNotExists([]byte(invalidBlockTableName))
+	if err != nil {
+
+		return err
+	}
+
+	return b.Put(hash, []byte{1})
+}
+
+// 从分支顶端tip向前标记到校验失败的区块为止
+func markInvalidBranch(tx *bolt.Tx, failedHash []byte, tip []byte) error {
+
+	hash := tip
+	for {
+
+		err := markInvalidBlock(tx, hash)
+		if err != nil {
+
+			return err
+		}
+
+		header := loadBlockHeader(tx, hash)
+		if bytes.Compare(hash, failedHash) == 0 || header == nil {
+
+			return nil
+		}
+		hash = header.PrevBlockHash
+	}
+}
+
+// 缓存孤块
+func addOrphanBlock(block *Block) {
+
+	prevHash := hex.EncodeToString(block.PrevBlockHash)
+	for _, orphan := range orphanBlocks[prevHash] {
+
+		if bytes.Compare(orphan.Hash, block.Hash) == 0 {
+
+			return
+		}
+	}
+
+	size := len(block.Serialize())
+
+	// 孤块池满了，随便丢弃几组直到放得下
+	for len(orphanBlocks) > 0 && (orphanCount >= maxOrphanBlocks || orphanBytes+size > maxOrphanBytes) {
+
+		for key, blocks := range orphanBlocks {
+
+			removeOrphanBlocks(key, blocks)
+			break
+		}
+	}
+
+	orphanBlocks[prevHash] = append(orphanBlocks[prevHash], block)
+	orphanCount++
+	orphanBytes += size
+}
+
+// 从孤块池删除一组孤块
+func removeOrphanBlocks(key string, blocks []*Block) {
+
+	delete(orphanBlocks, key)
+	orphanCount -= len(blocks)
+	for _, block := range blocks {
+
+		orphanBytes -= len(block.Serialize())
+	}
+}
+
+// 取出以hash为父区块的孤块
+func takeOrphanBlocks(hash []byte) []*Block {
+
+	key := hex.EncodeToString(hash)
+	blocks := orphanBlocks[key]
+	removeOrphanBlocks(key, blocks)
+
+	return blocks
+}
+
+// 切换到工作量更大的分支
+// 从旧链顶端回滚到分叉点，再依次连接新分支，全部在同一个数据库事务中完成，失败则整体回滚
+func (blc *Blockchain) reorganize(tx *bolt.Tx, newTip *Block) (detached []*Block, attached []*Block, err error) {
+
+	b := tx.Bucket([]byte(blockTableName))
+
+	oldTip := loadBlock(b, b.Get([]byte(newestBlockKey)))
+	oldBlock, newBlock := oldTip, newTip
+
+	// 找到分叉点
+	for bytes.Compare(oldBlock.Hash, newBlock.Hash) != 0 {
+
+		if oldBlock.Height >= newBlock.Height {
+
+			detached = append(detached, oldBlock)
+			oldBlock = loadBlock(b, oldBlock.PrevBlockHash)
+		} else {
+
+			attached = append([]*Block{newBlock}, attached...)
+			newBlock = loadBlock(b, newBlock.PrevBlockHash)
+		}
+
+		if oldBlock == nil || newBlock == nil {
+
+			return nil, nil, errNoCommonAncestor
+		}
+	}
+
+	if len(detached) > 0 {
+
+		fmt.Printf("Reorganize: fork at %x, disconnect %d blocks, connect %d blocks\n",
+			oldBlock.Hash, len(detached), len(attached))
+	}
+
+	utxoSet := &UTXOSet{blc}
+
+	// 回滚旧链
+	for _, block := range detached {
+
+		err = utxoSet.disconnectBlock(tx, block)
+		if err != nil {
+
+			return nil, nil, err
+		}
+	}
+
+	// 连接新分支
+	for _, block := range attached {
+
+		err = utxoSet.connectBlock(tx, block, true)
+		if err != nil {
+
+			return nil, nil, err
+		}
+	}
+
+	err = updateMainChainIndex(tx, detached, attached)
+	if err != nil {
+
+		return nil, nil, err
+	}
+
+	err = b.Put([]byte(newestBlockKey), newTip.Hash)
+	if err != nil {
+
+		return nil, nil, err
+	}
+
+	return detached, attached, nil
+}
+
+// 接收一个父区块已存在的区块，工作量更大时切换主链
+// 先保存区块，再在另一个事务中切换主链；切换失败时把校验失败的区块到这个区块标记为无效
+func (blc *Blockchain) acceptBlock(block *Block) error {
+
+	var detached, attached []*Block
+	invalidParent := false
+	reorg := false
+
+	err := blc.DB.Update(func(tx *bolt.Tx) error {
+
+		// 父区块在无效分支上，这个区块也无效  只做标记，不保存
+		if isInvalidBlock(tx, block.PrevBlockHash) {
+
+			invalidParent = true
+			return markInvalidBlock(tx, block.Hash)
+		}
+
+		b := tx.Bucket([]byte(blockTableName))
+
+		err := b.Put(block.Hash, block.Serialize())
+		if err != nil {
+
+			return err
+		}
+
+		err = putBlockHeader(tx, block.Hash, &block.BlockHeader)
+		if err != nil {
+
+			return err
+		}
+
+		err = putBlockFilter(tx, block)
+		if err != nil {
+
+			return err
+		}
+
+		parentWork, err := chainWork(tx, block.PrevBlockHash)
+		if err != nil {
+
+			return err
+		}
+		work := new(big.Int).Add(parentWork, block.Work())
+		err = putChainWork(tx, block.Hash, work)
+		if err != nil {
+
+			return err
+		}
+
+		tipWork, err := chainWork(tx, b.Get([]byte(newestBlockKey)))
+		if err != nil {
+
+			return err
+		}
+
+		// 工作量不比当前主链大，作为侧链保存
+		if work.Cmp(tipWork) <= 0 {
+
+			fmt.Printf("Block %x stored on a side branch\n", block.Hash)
+			return nil
+		}
+		reorg = true
+
+		return nil
+	})
+	if err != nil {
+
+		return err
+	}
+
+	if invalidParent {
+
+		return rejectBlock(block, RejectInvalidAncestor, "parent %x is on an invalid branch", block.PrevBlockHash)
+	}
+
+	if reorg == false {
+
+		return nil
+	}
+
+	err = blc.DB.Update(func(tx *bolt.Tx) error {
+
+		detached, attached, err = blc.reorganize(tx, block)
+
+		return err
+	})
+	if err != nil {
+
+		// 侧链保存时没有校验交易输入，切换失败说明分支上有无效区块，以后不再尝试切换到这个分支
+		var rejectErr *BlockRejectError
+		if errors.As(err, &rejectErr) {
+
+			markErr := blc.DB.Update(func(tx *bolt.Tx) error {
+
+				return markInvalidBranch(tx, rejectErr.Hash, block.Hash)
+			})
+			if markErr != nil {
+
+				return markErr
+			}
+		}
+
+		return err
+	}
+
+	if len(attached) > 0 {
+
+		blc.Tip = block.Hash
+		updateMempoolAfterReorg(&UTXOSet{blc}, detached, attached)
+
+		// 正在挖的区块已经过时
+		notifyTipChanged()
+	}
+
+	return nil
+}
@@ -0,0 +1,475 @@
+package BLC
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// 在临时目录中创建一条只有创世区块的链，测试结束后关闭数据库
+func newTestBlockchain(t *testing.T) *Blockchain {
+
+	cwd, err := os.Getwd()
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	blc := CreateBlockchainWithGensisBlock(testAddress(), "test")
+	t.Cleanup(func() {
+
+		blc.DB.Close()
+		os.Chdir(cwd)
+	})
+
+	return blc
+}
+
+// 每次调用返回一个新地址  不同地址的创币交易哈希不同
+func testAddress() string {
+
+	return string(NewWallet().GetAddress())
+}
+
+// 在parent之后挖一个包含txs的区块
+func mineTestBlockWithTxs(t *testing.T, blc *Blockchain, parent *Block, txs []*Transaction) *Block {
+
+	return NewBlock(txs, parent.Height+1, parent.Hash, blc.NextTargetBits(&parent.BlockHeader))
+}
+
+// 在parent之后挖一个只有创币交易的区块
+func mineTestBlock(t *testing.T, blc *Blockchain, parent *Block) *Block {
+
+	return mineTestBlockWithTxs(t, blc, parent, []*Transaction{NewCoinbaseTransaction(testAddress(), parent.Height+1, 0)})
+}
+
+func mustGetBlock(t *testing.T, blc *Blockchain, hash []byte) *Block {
+
+	blockBytes, err := blc.GetBlock(hash)
+	if err != nil || blockBytes == nil {
+
+		t.Fatalf("block %x: %v", hash, err)
+	}
+
+	return DeSerializeBlock(blockBytes)
+}
+
+func tipBlock(t *testing.T, blc *Blockchain) *Block {
+
+	return mustGetBlock(t, blc, blc.Tip)
+}
+
+func mustAddBlock(t *testing.T, blc *Blockchain, block *Block) {
+
+	t.Helper()
+
+	err := blc.AddBlock(block)
+	if err != nil {
+
+		t.Fatalf("add block %d: %s", block.Height, err)
+	}
+}
+
+// 把区块直接接到主链顶端，不校验交易  用来构造不满足成熟期或签名的测试数据
+func appendTestBlock(t *testing.T, blc *Blockchain, block *Block) {
+
+	err := blc.DB.Update(func(tx *bolt.Tx) error {
+
+		work, err := chainWork(tx, block.PrevBlockHash)
+		if err != nil {
+
+			return err
+		}
+
+		blocks := tx.Bucket([]byte(blockTableName))
+		err = blocks.Put(block.Hash, block.Serialize())
+		if err != nil {
+
+			return err
+		}
+		err = putBlockHeader(tx, block.Hash, &block.BlockHeader)
+		if err != nil {
+
+			return err
+		}
+		err = putBlockFilter(tx, block)
+		if err != nil {
+
+			return err
+		}
+		err = putChainWork(tx, block.Hash, work.Add(work, block.Work()))
+		if err != nil {
+
+			return err
+		}
+		err = tx.Bucket([]byte(mainChainTableName)).Put(heightKey(block.Height), block.Hash)
+		if err != nil {
+
+			return err
+		}
+		err = (&UTXOSet{blc}).connectBlock(tx, block, false)
+		if err != nil {
+
+			return err
+		}
+
+		return blocks.Put([]byte(newestBlockKey), block.Hash)
+	})
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	blc.Tip = block.Hash
+}
+
+// 花费创世区块的创币输出，给每个钱包一个金额为value的普通输出，输出不受创币交易成熟期的限制
+func fundTestWallets(t *testing.T, blc *Blockchain, value int64, wallets ...*Wallet) *Transaction {
+
+	var genesis *Block
+	err := blc.DB.View(func(tx *bolt.Tx) error {
+
+		genesis = loadBlock(tx.Bucket([]byte(blockTableName)), mainChainHash(tx, 1))
+		return nil
+	})
+	if err != nil || genesis == nil {
+
+		t.Fatalf("genesis: %v", err)
+	}
+
+	var outputs []*TXOutput
+	for _, wallet := range wallets {
+
+		outputs = append(outputs, NewTXOutput(value, string(wallet.GetAddress())))
+	}
+	fund := &Transaction{[]byte{}, []*TXInput{{genesis.Txs[0].TxHash, 0, nil, MaxTxInSequenceNum}}, outputs, TxVersion, 0}
+	fund.HashTransactions()
+
+	parent := tipBlock(t, blc)
+	appendTestBlock(t, blc, mineTestBlockWithTxs(t, blc, parent, []*Transaction{NewCoinbaseTransaction(testAddress(), parent.Height+1, 0), fund}))
+
+	return fund
+}
+
+// wallet花费prevTx的第vout个输出，签名后计算交易哈希
+func newTestSpend(t *testing.T, blc *Blockchain, wallet *Wallet, prevTx *Transaction, vout int, outputs ...*TXOutput) *Transaction {
+
+	tx := &Transaction{[]byte{}, []*TXInput{{prevTx.TxHash, vout, nil, MaxTxInSequenceNum}}, outputs, TxVersion, 0}
+	blc.SignTransaction(tx, wallet.PrivateKey, []*Transaction{prevTx})
+	tx.HashTransactions()
+
+	return tx
+}
+
+// 按输出列出UTXO表的内容，和输出在表中的先后顺序无关
+func utxoSnapshot(t *testing.T, blc *Blockchain) map[string]string {
+
+	snapshot := make(map[string]string)
+	err := blc.DB.View(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(UTXOTableName))
+		if b == nil {
+
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+
+			for _, utxo := range DeserializeTXOutputs(v).UTXOS {
+
+				key := fmt.Sprintf("%x:%d", utxo.TxHash, utxo.Index)
+				snapshot[key] = fmt.Sprintf("%d %x %d %t", utxo.Output.Value, utxo.Output.ScriptPubKey, utxo.Height, utxo.IsCoinbase)
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	return snapshot
+}
+
+func assertSameUTXOs(t *testing.T, got map[string]string, want map[string]string) {
+
+	t.Helper()
+
+	if len(got) != len(want) {
+
+		t.Errorf("utxo set has %d outputs, want %d", len(got), len(want))
+	}
+	for key, value := range want {
+
+		if got[key] != value {
+
+			t.Errorf("utxo %s: got %s, want %s", key, got[key], value)
+		}
+	}
+}
+
+func blockIsInvalid(t *testing.T, blc *Blockchain, hash []byte) bool {
+
+	invalid := false
+	err := blc.DB.View(func(tx *bolt.Tx) error {
+
+		invalid = isInvalidBlock(tx, hash)
+		return nil
+	})
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	return invalid
+}
+
+// 重组后的UTXO表要和从主链重建的结果一致
+func assertUTXOSetMatchesReindex(t *testing.T, blc *Blockchain) {
+
+	t.Helper()
+
+	got := utxoSnapshot(t, blc)
+	(&UTXOSet{blc}).ResetUTXOSet()
+	assertSameUTXOs(t, got, utxoSnapshot(t, blc))
+}
+
+// 两条竞争分支，工作量更大的分支到达后切换主链，被断开区块的创币输出要被删除
+func TestReorganizeToHeavierBranch(t *testing.T) {
+
+	blc := newTestBlockchain(t)
+	genesis := tipBlock(t, blc)
+
+	a1 := mineTestBlock(t, blc, genesis)
+	mustAddBlock(t, blc, a1)
+	if bytes.Compare(blc.Tip, a1.Hash) != 0 {
+
+		t.Fatalf("tip %x, want a1 %x", blc.Tip, a1.Hash)
+	}
+
+	// 工作量相同，作为侧链保存
+	b1 := mineTestBlock(t, blc, genesis)
+	mustAddBlock(t, blc, b1)
+	if bytes.Compare(blc.Tip, a1.Hash) != 0 {
+
+		t.Fatalf("equal work branch became tip %x", blc.Tip)
+	}
+
+	b2 := mineTestBlock(t, blc, b1)
+	mustAddBlock(t, blc, b2)
+	if bytes.Compare(blc.Tip, b2.Hash) != 0 {
+
+		t.Fatalf("tip %x, want b2 %x", blc.Tip, b2.Hash)
+	}
+
+	utxoSet := &UTXOSet{blc}
+	if utxoSet.FindUTXO(a1.Txs[0].TxHash, 0) != nil {
+
+		t.Errorf("coinbase of detached block a1 is still unspent")
+	}
+	for _, block := range []*Block{b1, b2} {
+
+		if utxoSet.FindUTXO(block.Txs[0].TxHash, 0) == nil {
+
+			t.Errorf("coinbase of block %d is missing", block.Height)
+		}
+	}
+
+	hashes := blc.GetBlockHashes()
+	if len(hashes) != 3 || bytes.Compare(hashes[0], b2.Hash) != 0 || bytes.Compare(hashes[1], b1.Hash) != 0 {
+
+		t.Errorf("main chain %x, want b2, b1, genesis", hashes)
+	}
+
+	assertUTXOSetMatchesReindex(t, blc)
+}
+
+// 先收到子区块时放入孤块池，父区块到达后一起连接
+func TestAddBlockConnectsOrphans(t *testing.T) {
+
+	blc := newTestBlockchain(t)
+	genesis := tipBlock(t, blc)
+
+	a1 := mineTestBlock(t, blc, genesis)
+	a2 := mineTestBlock(t, blc, a1)
+
+	mustAddBlock(t, blc, a2)
+	if bytes.Compare(blc.Tip, genesis.Hash) != 0 {
+
+		t.Fatalf("orphan became tip %x", blc.Tip)
+	}
+
+	mustAddBlock(t, blc, a1)
+	if bytes.Compare(blc.Tip, a2.Hash) != 0 {
+
+		t.Fatalf("tip %x, want a2 %x", blc.Tip, a2.Hash)
+	}
+
+	assertUTXOSetMatchesReindex(t, blc)
+}
+
+// 切换到的分支上有区块连接失败时，从失败区块到分支顶端都被标记为无效，主链保持不变
+func TestReorganizeMarksInvalidBranch(t *testing.T) {
+
+	blc := newTestBlockchain(t)
+	genesis := tipBlock(t, blc)
+
+	a1 := mineTestBlock(t, blc, genesis)
+	mustAddBlock(t, blc, a1)
+	before := utxoSnapshot(t, blc)
+
+	// b1的创币交易多领奖励  与链无关的检查无法发现，只有连接时才会失败
+	b1 := mineTestBlockWithTxs(t, blc, genesis, []*Transaction{NewCoinbaseTransaction(testAddress(), genesis.Height+1, 1)})
+	mustAddBlock(t, blc, b1)
+
+	b2 := mineTestBlock(t, blc, b1)
+	err := blc.AddBlock(b2)
+	rejectErr, ok := err.(*BlockRejectError)
+	if ok == false || rejectErr.Reason != RejectBadCoinbase || bytes.Compare(rejectErr.Hash, b1.Hash) != 0 {
+
+		t.Fatalf("got %v, want %s for b1", err, RejectBadCoinbase)
+	}
+
+	if bytes.Compare(blc.Tip, a1.Hash) != 0 {
+
+		t.Fatalf("tip %x, want a1 %x", blc.Tip, a1.Hash)
+	}
+	for _, block := range []*Block{b1, b2} {
+
+		if blockIsInvalid(t, blc, block.Hash) == false {
+
+			t.Errorf("block %d not marked invalid", block.Height)
+		}
+	}
+	for _, block := range []*Block{genesis, a1} {
+
+		if blockIsInvalid(t, blc, block.Hash) {
+
+			t.Errorf("main chain block %d marked invalid", block.Height)
+		}
+	}
+
+	// 回滚失败的重组后UTXO表没有变化
+	assertSameUTXOs(t, utxoSnapshot(t, blc), before)
+	assertUTXOSetMatchesReindex(t, blc)
+
+	// 无效分支上的新区块直接拒绝
+	b3 := mineTestBlock(t, blc, b2)
+	err = blc.AddBlock(b3)
+	rejectErr, ok = err.(*BlockRejectError)
+	if ok == false || rejectErr.Reason != RejectInvalidAncestor {
+
+		t.Fatalf("got %v, want %s", err, RejectInvalidAncestor)
+	}
+	if blockIsInvalid(t, blc, b3.Hash) == false {
+
+		t.Errorf("child of invalid branch not marked invalid")
+	}
+	if bytes.Compare(blc.Tip, a1.Hash) != 0 {
+
+		t.Errorf("tip %x, want a1 %x", blc.Tip, a1.Hash)
+	}
+}
+
+// 连接再断开一个区块后UTXO表恢复原样，包括区块内部花费的输出
+func TestConnectDisconnectBlockRestoresUTXOs(t *testing.T) {
+
+	blc := newTestBlockchain(t)
+	utxoSet := &UTXOSet{blc}
+	address := testAddress()
+
+	// 区块外已有的一个输出，有两个下标
+	prevHash := repeatHash(0x11)
+	err := blc.DB.Update(func(tx *bolt.Tx) error {
+
+		prevOuts := &TXOutputs{[]*UTXO{
+			{prevHash, 0, NewTXOutput(30, address), 1, false},
+			{prevHash, 1, NewTXOutput(20, address), 1, false},
+		}}
+
+		return tx.Bucket([]byte(UTXOTableName)).Put(prevHash, prevOuts.Serialize())
+	})
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	before := utxoSnapshot(t, blc)
+
+	// tx1花费区块外输出的下标1，tx2花费tx1的输出
+	tx1 := &Transaction{[]byte{}, []*TXInput{{prevHash, 1, nil, MaxTxInSequenceNum}}, []*TXOutput{NewTXOutput(15, address), NewTXOutput(5, address)}, TxVersion, 0}
+	tx1.HashTransactions()
+	tx2 := &Transaction{[]byte{}, []*TXInput{{tx1.TxHash, 0, nil, MaxTxInSequenceNum}}, []*TXOutput{NewTXOutput(15, address)}, TxVersion, 0}
+	tx2.HashTransactions()
+
+	block := &Block{
+		BlockHeader: BlockHeader{Height: 2, PrevBlockHash: blc.Tip},
+		Txs:         []*Transaction{NewCoinbaseTransaction(address, 2, 0), tx1, tx2},
+		Hash:        repeatHash(0x22)}
+
+	err = blc.DB.Update(func(tx *bolt.Tx) error {
+
+		return utxoSet.connectBlock(tx, block, false)
+	})
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	connected := utxoSnapshot(t, blc)
+	for _, key := range []string{
+		fmt.Sprintf("%x:%d", prevHash, 1),
+		fmt.Sprintf("%x:%d", tx1.TxHash, 0),
+	} {
+
+		if _, ok := connected[key]; ok {
+
+			t.Errorf("spent output %s still in utxo set", key)
+		}
+	}
+	for _, key := range []string{
+		fmt.Sprintf("%x:%d", prevHash, 0),
+		fmt.Sprintf("%x:%d", tx1.TxHash, 1),
+		fmt.Sprintf("%x:%d", tx2.TxHash, 0),
+	} {
+
+		if _, ok := connected[key]; ok == false {
+
+			t.Errorf("output %s missing after connect", key)
+		}
+	}
+
+	err = blc.DB.Update(func(tx *bolt.Tx) error {
+
+		return utxoSet.disconnectBlock(tx, block)
+	})
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	assertSameUTXOs(t, utxoSnapshot(t, blc), before)
+
+	// 回滚数据已经用掉
+	err = blc.DB.View(func(tx *bolt.Tx) error {
+
+		if tx.Bucket([]byte(utxoUndoTableName)).Get(block.Hash) != nil {
+
+			t.Errorf("undo data for %s kept after disconnect", hex.EncodeToString(block.Hash))
+		}
+		return nil
+	})
+	if err != nil {
+
+		t.Fatal(err)
+	}
+}
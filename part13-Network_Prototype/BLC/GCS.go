@@ -0,0 +1,315 @@
+package BLC
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"sort"
+)
+
+/**
+紧凑区块过滤器(BIP158)  Golomb编码集合(GCS)
+
+1.每个元素用SipHash-2-4(密钥为区块哈希的前16字节)得到64位哈希，再映射到[0, N*M)
+2.映射后的值排序，相邻差值用参数P做Golomb-Rice编码：商用一元编码(q个1加一个0)，余数取低P位
+3.过滤器编码：元素个数N(变长整数) + 比特流
+匹配时按同样方法计算查询元素的值，在解码出的有序集合中查找，存在误报但没有漏报，误报率约为1/M
+*/
+
+// 基础过滤器的Golomb-Rice参数和误报率倒数
+const gcsFilterP = 19
+const gcsFilterM = 784931
+
+var errGCSFilterTruncated = errors.New("gcs filter is truncated")
+
+// Golomb编码集合
+type GCSFilter struct {
+	// 元素个数
+	N uint64
+	// Golomb-Rice编码的比特流
+	Data []byte
+}
+
+// 用区块哈希生成SipHash密钥
+func gcsFilterKey(blockHash []byte) [16]byte {
+
+	var key [16]byte
+	copy(key[:], blockHash)
+
+	return key
+}
+
+// 把元素映射到[0, N*M)
+func gcsHashToRange(key [16]byte, item []byte, f uint64) uint64 {
+
+	hash := sipHash24(key, item)
+	hi, _ := bits.Mul64(hash, f)
+
+	return hi
+}
+
+// 构造过滤器  重复元素只保留一个
+func NewGCSFilter(key [16]byte, items [][]byte) *GCSFilter {
+
+	unique := make(map[string]bool)
+	for _, item := range items {
+
+		unique[string(item)] = true
+	}
+
+	n := uint64(len(unique))
+	f := n * gcsFilterM
+
+	values := make([]uint64, 0, n)
+	for item := range unique {
+
+		values = append(values, gcsHashToRange(key, []byte(item), f))
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	w := &bitWriter{}
+	var last uint64
+	for _, value := range values {
+
+		delta := value - last
+		last = value
+
+		// 商用一元编码
+		for q := delta >> gcsFilterP; q > 0; q-- {
+
+			w.writeBit(1)
+		}
+		w.writeBit(0)
+
+		// 余数取低P位
+		w.writeBits(delta, gcsFilterP)
+	}
+
+	return &GCSFilter{n, w.bytes}
+}
+
+// 解码出有序的映射值
+func (filter *GCSFilter) values() ([]uint64, error) {
+
+	r := &bitReader{data: filter.Data}
+	values := make([]uint64, 0, filter.N)
+
+	var last uint64
+	for i := uint64(0); i < filter.N; i++ {
+
+		var q uint64
+		for {
+
+			bit, err := r.readBit()
+			if err != nil {
+
+				return nil, err
+			}
+			if bit == 0 {
+
+				break
+			}
+			q++
+		}
+
+		remainder, err := r.readBits(gcsFilterP)
+		if err != nil {
+
+			return nil, err
+		}
+
+		last += q<<gcsFilterP | remainder
+		values = append(values, last)
+	}
+
+	return values, nil
+}
+
+// 是否可能包含某个元素
+func (filter *GCSFilter) Match(key [16]byte, item []byte) bool {
+
+	return filter.MatchAny(key, [][]byte{item})
+}
+
+// 是否可能包含任意一个元素  过滤器无法解码时按匹配处理，由调用方下载完整区块
+func (filter *GCSFilter) MatchAny(key [16]byte, items [][]byte) bool {
+
+	if filter.N == 0 || len(items) == 0 {
+
+		return false
+	}
+
+	values, err := filter.values()
+	if err != nil {
+
+		return true
+	}
+
+	f := filter.N * gcsFilterM
+	for _, item := range items {
+
+		value := gcsHashToRange(key, item, f)
+		index := sort.Search(len(values), func(i int) bool { return values[i] >= value })
+		if index < len(values) && values[index] == value {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// 编码：元素个数(变长整数) + 比特流
+func (filter *GCSFilter) Serialize() []byte {
+
+	w := &binaryWriter{}
+	w.writeVarInt(filter.N)
+	w.buf.Write(filter.Data)
+
+	return w.Bytes()
+}
+
+// 解码过滤器
+func DecodeGCSFilter(data []byte) (*GCSFilter, error) {
+
+	r := newBinaryReader(data)
+	n := r.readVarInt()
+	if r.err != nil {
+
+		return nil, r.err
+	}
+
+	// 每个元素至少占P+1位
+	bitstream := data[len(data)-r.r.Len():]
+	if n > uint64(len(bitstream))*8/(gcsFilterP+1) {
+
+		return nil, errGCSFilterTruncated
+	}
+
+	return &GCSFilter{n, bitstream}, nil
+}
+
+// 按高位在前的顺序写入比特
+type bitWriter struct {
+	bytes []byte
+	// 最后一个字节已经写入的比特数
+	used uint
+}
+
+func (w *bitWriter) writeBit(bit byte) {
+
+	if w.used == 0 {
+
+		w.bytes = append(w.bytes, 0)
+	}
+
+	if bit != 0 {
+
+		w.bytes[len(w.bytes)-1] |= 1 << (7 - w.used)
+	}
+	w.used = (w.used + 1) % 8
+}
+
+// 写入n的低count位
+func (w *bitWriter) writeBits(n uint64, count uint) {
+
+	for i := count; i > 0; i-- {
+
+		w.writeBit(byte(n >> (i - 1) & 1))
+	}
+}
+
+type bitReader struct {
+	data []byte
+	// 已经读取的比特数
+	pos uint64
+}
+
+func (r *bitReader) readBit() (byte, error) {
+
+	if r.pos >= uint64(len(r.data))*8 {
+
+		return 0, errGCSFilterTruncated
+	}
+
+	bit := r.data[r.pos/8] >> (7 - r.pos%8) & 1
+	r.pos++
+
+	return bit, nil
+}
+
+func (r *bitReader) readBits(count uint) (uint64, error) {
+
+	var n uint64
+	for i := uint(0); i < count; i++ {
+
+		bit, err := r.readBit()
+		if err != nil {
+
+			return 0, err
+		}
+		n = n<<1 | uint64(bit)
+	}
+
+	return n, nil
+}
+
+// SipHash-2-4
+func sipHash24(key [16]byte, data []byte) uint64 {
+
+	k0 := binary.LittleEndian.Uint64(key[:8])
+	k1 := binary.LittleEndian.Uint64(key[8:])
+
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	length := len(data)
+	for len(data) >= 8 {
+
+		m := binary.LittleEndian.Uint64(data)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+		data = data[8:]
+	}
+
+	// 最后一个分组：剩余字节 + 长度的低8位放在最高字节
+	var last [8]byte
+	copy(last[:], data)
+	last[7] = byte(length)
+	m := binary.LittleEndian.Uint64(last[:])
+
+	v3 ^= m
+	round()
+	round()
+	v0 ^= m
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+
+	return v0 ^ v1 ^ v2 ^ v3
+}
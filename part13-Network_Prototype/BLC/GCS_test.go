@@ -0,0 +1,202 @@
+package BLC
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+// SipHash-2-4论文附带的测试向量  密钥为00..0f，消息为00..(len-1)
+func TestSipHash24Vectors(t *testing.T) {
+
+	vectors := []struct {
+		length int
+		hash   uint64
+	}{
+		{0, 0x726fdb47dd0e0e31},
+		{1, 0x74f839c593dc67fd},
+		{2, 0x0d6c8009d9a94f5a},
+		{3, 0x85676696d7fb7e2d},
+		{4, 0xcf2794e0277187b7},
+		{5, 0x18765564cd99a68d},
+		{7, 0xab0200f58b01d137},
+		{8, 0x93f5f5799a932462},
+		{15, 0xa129ca6149be45e5},
+		{63, 0x958a324ceb064572},
+	}
+
+	var key [16]byte
+	for i := range key {
+
+		key[i] = byte(i)
+	}
+
+	for _, v := range vectors {
+
+		msg := make([]byte, v.length)
+		for i := range msg {
+
+			msg[i] = byte(i)
+		}
+
+		if got := sipHash24(key, msg); got != v.hash {
+
+			t.Errorf("length %d: got %016x, want %016x", v.length, got, v.hash)
+		}
+	}
+}
+
+// BIP158测试向量中testnet创世区块的基础过滤器  只有创币交易输出的锁定脚本一个元素
+func TestGCSFilterBIP158Vector(t *testing.T) {
+
+	// 比特币显示的区块哈希是反序的，过滤器密钥取内部字节序的前16字节
+	blockHash := mustDecodeHex(t, "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943")
+	ReverseBytes(blockHash)
+
+	script := mustDecodeHex(t, "4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac")
+
+	filter := NewGCSFilter(gcsFilterKey(blockHash), [][]byte{script})
+	if got := hex.EncodeToString(filter.Serialize()); got != "019dfca8" {
+
+		t.Fatalf("filter %s, want 019dfca8", got)
+	}
+
+	if filter.Match(gcsFilterKey(blockHash), script) == false {
+
+		t.Errorf("filter does not match its own element")
+	}
+
+	// 没有元素的过滤器只有元素个数0
+	empty := NewGCSFilter(gcsFilterKey(blockHash), nil)
+	if got := hex.EncodeToString(empty.Serialize()); got != "00" {
+
+		t.Errorf("empty filter %s, want 00", got)
+	}
+	if empty.Match(gcsFilterKey(blockHash), script) {
+
+		t.Errorf("empty filter matched")
+	}
+}
+
+// 编码再解码后所有元素都能匹配，不在集合中的元素基本不匹配
+func TestGCSFilterMatchRoundTrip(t *testing.T) {
+
+	key := gcsFilterKey(repeatHash(0x5a))
+
+	var items [][]byte
+	for i := 0; i < 500; i++ {
+
+		items = append(items, []byte(fmt.Sprintf("member-%d", i)))
+	}
+	// 重复元素只计一次
+	items = append(items, items[0], items[1])
+
+	filter, err := DecodeGCSFilter(NewGCSFilter(key, items).Serialize())
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if filter.N != 500 {
+
+		t.Fatalf("N = %d, want 500", filter.N)
+	}
+
+	for _, item := range items {
+
+		if filter.Match(key, item) == false {
+
+			t.Fatalf("%s: not matched", item)
+		}
+	}
+
+	// 误报率约为1/M，1000个查询几乎不会误报
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+
+		if filter.Match(key, []byte(fmt.Sprintf("outsider-%d", i))) {
+
+			falsePositives++
+		}
+	}
+	if falsePositives > 2 {
+
+		t.Errorf("%d false positives in 1000 queries", falsePositives)
+	}
+
+	// 任意一个元素在集合中即匹配
+	if filter.MatchAny(key, [][]byte{[]byte("outsider"), items[42]}) == false {
+
+		t.Errorf("MatchAny missed a member")
+	}
+	if filter.MatchAny(key, nil) {
+
+		t.Errorf("MatchAny matched an empty query")
+	}
+
+	// 换一个区块(密钥)后映射的值不同
+	otherKey := gcsFilterKey(repeatHash(0xa5))
+	matched := 0
+	for _, item := range items[:100] {
+
+		if filter.Match(otherKey, item) {
+
+			matched++
+		}
+	}
+	if matched > 2 {
+
+		t.Errorf("%d of 100 members matched with another key", matched)
+	}
+}
+
+func TestDecodeGCSFilterMalformed(t *testing.T) {
+
+	full := NewGCSFilter(gcsFilterKey(repeatHash(0x01)), [][]byte{[]byte("a"), []byte("b"), []byte("c")}).Serialize()
+
+	vectors := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated count", []byte{0xfd, 0x01}},
+		// 每个元素至少占P+1位，比特流装不下声明的元素个数
+		{"count larger than bitstream", append([]byte{0x04}, full[1:]...)},
+		{"huge count", append(mustDecodeHex(t, "ffffffffffffffffff"), full[1:]...)},
+		{"bitstream cut", full[:len(full)-4]},
+	}
+
+	for _, v := range vectors {
+
+		if _, err := DecodeGCSFilter(v.data); err == nil {
+
+			t.Errorf("%s: decoded %x", v.name, v.data)
+		}
+	}
+
+	// 大小检查通过但一元编码没有结束，无法解码时按匹配处理，由调用方下载完整区块
+	filter, err := DecodeGCSFilter([]byte{0x01, 0xff, 0xff, 0xff})
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if _, err := filter.values(); err != errGCSFilterTruncated {
+
+		t.Errorf("values: got %v, want %v", err, errGCSFilterTruncated)
+	}
+	if filter.Match(gcsFilterKey(repeatHash(0x01)), []byte("anything")) == false {
+
+		t.Errorf("undecodable filter did not match")
+	}
+
+	// 解码后重新编码结果不变
+	decoded, err := DecodeGCSFilter(full)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if bytes.Compare(decoded.Serialize(), full) != 0 {
+
+		t.Errorf("round trip %x, want %x", decoded.Serialize(), full)
+	}
+}
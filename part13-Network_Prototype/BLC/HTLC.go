@@ -0,0 +1,239 @@
+package BLC
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+)
+
+/**
+哈希时间锁合约(HTLC)  用于两条链之间的原子交换
+
+合约作为P2SH的赎回脚本：
+	OP_IF
+		OP_SIZE 32 OP_EQUALVERIFY OP_SHA256 <秘密哈希> OP_EQUALVERIFY OP_DUP OP_HASH160 <接收方公钥哈希>
+	OP_ELSE
+		<锁定时间> OP_CHECKLOCKTIMEVERIFY OP_DROP OP_DUP OP_HASH160 <退款方公钥哈希>
+	OP_ENDIF
+	OP_EQUALVERIFY OP_CHECKSIG
+
+赎回：<签名> <公钥> <秘密> OP_1 <合约>  接收方在任何时候都可以用秘密赎回
+退款：<签名> <公钥> OP_0 <合约>  锁定时间过后退款方才能取回，交易的LockTime需要不小于合约的锁定时间
+
+交换流程：
+1.发起方生成秘密，在链A上创建合约，接收方为参与方，锁定时间较长
+2.参与方审核链A上的合约，用同一个秘密哈希在链B上创建合约，接收方为发起方，锁定时间较短
+3.发起方用秘密赎回链B上的合约，秘密随赎回交易公开
+4.参与方从链B的赎回交易中取出秘密，赎回链A上的合约
+任何一方中途退出，另一方在锁定时间过后都可以退款
+*/
+
+// 秘密的长度
+const atomicSwapSecretSize = 32
+
+// 生成随机秘密
+func NewAtomicSwapSecret() ([]byte, error) {
+
+	secret := make([]byte, atomicSwapSecretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// 秘密哈希  SHA256(秘密)
+func AtomicSwapSecretHash(secret []byte) []byte {
+
+	hash := sha256.Sum256(secret)
+
+	return hash[:]
+}
+
+// 原子交换合约脚本
+func AtomicSwapContract(secretHash []byte, recipientPubKeyHash []byte, refundPubKeyHash []byte, lockTime int64) []byte {
+
+	return newScriptBuilder().
+		AddOp(OP_IF).
+		AddOp(OP_SIZE).
+		AddInt64(atomicSwapSecretSize).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_SHA256).
+		AddData(secretHash).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_DUP).
+		AddOp(OP_HASH160).
+		AddData(recipientPubKeyHash).
+		AddOp(OP_ELSE).
+		AddInt64(lockTime).
+		AddOp(OP_CHECKLOCKTIMEVERIFY).
+		AddOp(OP_DROP).
+		AddOp(OP_DUP).
+		AddOp(OP_HASH160).
+		AddData(refundPubKeyHash).
+		AddOp(OP_ENDIF).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_CHECKSIG).
+		Script()
+}
+
+// 原子交换合约的内容
+type AtomicSwap struct {
+	//秘密哈希
+	SecretHash []byte
+	//接收方公钥哈希
+	RecipientPubKeyHash []byte
+	//退款方公钥哈希
+	RefundPubKeyHash []byte
+	//锁定时间
+	LockTime int64
+}
+
+// 解析原子交换合约，不是合约脚本时ok为false
+func ExtractAtomicSwap(script []byte) (swap *AtomicSwap, ok bool) {
+
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 20 {
+
+		return nil, false
+	}
+
+	lockTime, err := scriptNumFromBytes(ops[11].data, 5)
+	if err != nil || ops[11].isPush() == false {
+
+		return nil, false
+	}
+
+	swap = &AtomicSwap{ops[5].data, ops[9].data, ops[16].data, lockTime}
+	if len(swap.SecretHash) != sha256.Size || len(swap.RecipientPubKeyHash) != 20 || len(swap.RefundPubKeyHash) != 20 {
+
+		return nil, false
+	}
+
+	// 按解析出的参数重新生成脚本，与原脚本一致才是合约
+	if bytes.Compare(script, AtomicSwapContract(swap.SecretHash, swap.RecipientPubKeyHash, swap.RefundPubKeyHash, swap.LockTime)) != 0 {
+
+		return nil, false
+	}
+
+	return swap, true
+}
+
+// 赎回的解锁脚本  <签名> <公钥> <秘密> OP_1 <合约>
+func atomicSwapRedeemScript(signature []byte, publicKey []byte, secret []byte, contract []byte) []byte {
+
+	return newScriptBuilder().AddData(signature).AddData(publicKey).AddData(secret).AddInt64(1).AddData(contract).Script()
+}
+
+// 退款的解锁脚本  <签名> <公钥> OP_0 <合约>
+func atomicSwapRefundScript(signature []byte, publicKey []byte, contract []byte) []byte {
+
+	return newScriptBuilder().AddData(signature).AddData(publicKey).AddInt64(0).AddData(contract).Script()
+}
+
+// 从花费合约的解锁脚本中取出秘密，不是赎回时返回nil
+func ExtractAtomicSwapSecret(scriptSig []byte, secretHash []byte) []byte {
+
+	ops, err := parseScript(scriptSig)
+	if err != nil || len(ops) != 5 || isPushOnly(ops) == false {
+
+		return nil
+	}
+
+	secret := ops[2].data
+	if bytes.Compare(AtomicSwapSecretHash(secret), secretHash) != 0 {
+
+		return nil
+	}
+
+	return secret
+}
+
+// 找到交易中支付给合约的输出
+func atomicSwapOutput(contractTx *Transaction, contract []byte) (int, *TXOutput, error) {
+
+	script := PayToScriptHashScript(Ripemd160Hash(contract))
+	for index, out := range contractTx.Vouts {
+
+		if bytes.Compare(out.ScriptPubKey, script) == 0 {
+
+			return index, out, nil
+		}
+	}
+
+	return 0, nil, fmt.Errorf("transaction %x does not pay to the contract", contractTx.TxHash)
+}
+
+// 构造花费合约的交易，全部金额扣除手续费后支付给to
+// secret不为nil时为赎回，否则为退款
+func NewAtomicSwapSpend(contract []byte, contractTx *Transaction, secret []byte, wallet *Wallet, to string, feeRate int64) (*Transaction, error) {
+
+	swap, ok := ExtractAtomicSwap(contract)
+	if ok == false {
+
+		return nil, fmt.Errorf("not an atomic swap contract")
+	}
+
+	index, out, err := atomicSwapOutput(contractTx, contract)
+	if err != nil {
+
+		return nil, err
+	}
+
+	pubKeyHash := Ripemd160Hash(wallet.PublicKey)
+	if secret != nil {
+
+		if bytes.Compare(AtomicSwapSecretHash(secret), swap.SecretHash) != 0 {
+
+			return nil, fmt.Errorf("secret does not match the secret hash")
+		}
+		if bytes.Compare(pubKeyHash, swap.RecipientPubKeyHash) != 0 {
+
+			return nil, fmt.Errorf("wallet is not the recipient of the contract")
+		}
+	} else if bytes.Compare(pubKeyHash, swap.RefundPubKeyHash) != 0 {
+
+		return nil, fmt.Errorf("wallet is not the refund address of the contract")
+	}
+
+	return buildTransactionWithFee(feeRate, func(fee int64) (*Transaction, int, error) {
+
+		if out.Value-fee <= 0 {
+
+			return nil, 0, fmt.Errorf("contract value %d can not pay the fee %d", out.Value, fee)
+		}
+
+		//退款交易需要LockTime生效才能通过OP_CHECKLOCKTIMEVERIFY
+		lockTime := uint32(0)
+		sequence := uint32(MaxTxInSequenceNum)
+		if secret == nil {
+
+			lockTime = uint32(swap.LockTime)
+			sequence = MaxTxInSequenceNum - 1
+		}
+
+		txInput := &TXInput{contractTx.TxHash, index, nil, sequence}
+		txOutput := NewTXOutput(out.Value-fee, to)
+		tx := &Transaction{[]byte{}, []*TXInput{txInput}, []*TXOutput{txOutput}, TxVersion, lockTime}
+
+		//被签名的脚本为合约
+		signature, err := signHash(&wallet.PrivateKey, tx.SignatureHash(0, contract))
+		if err != nil {
+
+			return nil, 0, err
+		}
+		if secret != nil {
+
+			txInput.ScriptSig = atomicSwapRedeemScript(signature, wallet.PublicKey, secret, contract)
+		} else {
+
+			txInput.ScriptSig = atomicSwapRefundScript(signature, wallet.PublicKey, contract)
+		}
+		tx.HashTransactions()
+
+		return tx, len(tx.Serialize()), nil
+	})
+}
@@ -0,0 +1,222 @@
+package BLC
+
+import (
+	"bytes"
+	"testing"
+)
+
+// 原子交换测试用的合约  锁定到高度1000
+type atomicSwapTest struct {
+	secret    []byte
+	recipient *Wallet
+	refunder  *Wallet
+	contract  []byte
+}
+
+func newAtomicSwapTest(t *testing.T) *atomicSwapTest {
+
+	secret, err := NewAtomicSwapSecret()
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	recipient := NewWallet()
+	refunder := NewWallet()
+	contract := AtomicSwapContract(AtomicSwapSecretHash(secret), Ripemd160Hash(recipient.PublicKey), Ripemd160Hash(refunder.PublicKey), 1000)
+
+	return &atomicSwapTest{secret, recipient, refunder, contract}
+}
+
+// 用wallet对交易的输入0签名并赎回合约
+func (swap *atomicSwapTest) redeem(t *testing.T, wallet *Wallet, tx *Transaction, secret []byte) []byte {
+
+	return atomicSwapRedeemScript(scriptTestSign(t, wallet.PrivateKey, tx, swap.contract), wallet.PublicKey, secret, swap.contract)
+}
+
+// 用wallet对交易的输入0签名并退款
+func (swap *atomicSwapTest) refund(t *testing.T, wallet *Wallet, tx *Transaction) []byte {
+
+	return atomicSwapRefundScript(scriptTestSign(t, wallet.PrivateKey, tx, swap.contract), wallet.PublicKey, swap.contract)
+}
+
+func TestVerifyScriptAtomicSwap(t *testing.T) {
+
+	swap := newAtomicSwapTest(t)
+	scriptPubKey := PayToScriptHashScript(Ripemd160Hash(swap.contract))
+	wrongSecret := bytes.Repeat([]byte{0x42}, atomicSwapSecretSize)
+
+	// 赎回不需要时间锁
+	claimTx := scriptTestTx(0, MaxTxInSequenceNum)
+	badSignature := atomicSwapRedeemScript(scriptTestSign(t, swap.recipient.PrivateKey, claimTx, scriptPubKey), swap.recipient.PublicKey, swap.secret, swap.contract)
+	otherContract := AtomicSwapContract(AtomicSwapSecretHash(swap.secret), Ripemd160Hash(swap.recipient.PublicKey), Ripemd160Hash(swap.refunder.PublicKey), 1001)
+	runScriptTests(t, claimTx, []scriptTestCase{
+		{"claim", swap.redeem(t, swap.recipient, claimTx, swap.secret), scriptPubKey, ""},
+		{"claim with wrong secret", swap.redeem(t, swap.recipient, claimTx, wrongSecret), scriptPubKey, "OP_EQUALVERIFY failed"},
+		// 秘密长度不是32字节时在哈希之前就失败
+		{"claim with short secret", swap.redeem(t, swap.recipient, claimTx, swap.secret[:31]), scriptPubKey, "OP_EQUALVERIFY failed"},
+		{"claim with refund key", swap.redeem(t, swap.refunder, claimTx, swap.secret), scriptPubKey, "OP_EQUALVERIFY failed"},
+		{"claim with bad signature", badSignature, scriptPubKey, "redeem script evaluated to false"},
+		{"claim with another contract", atomicSwapRedeemScript(nil, swap.recipient.PublicKey, swap.secret, otherContract), scriptPubKey, "script evaluated to false"},
+		{"refund without lock time", swap.refund(t, swap.refunder, claimTx), scriptPubKey, "locked until"},
+	})
+
+	// 锁定时间刚好到达的退款交易
+	refundTx := scriptTestTx(1000, MaxTxInSequenceNum-1)
+	runScriptTests(t, refundTx, []scriptTestCase{
+		{"refund", swap.refund(t, swap.refunder, refundTx), scriptPubKey, ""},
+		{"refund with recipient key", swap.refund(t, swap.recipient, refundTx), scriptPubKey, "OP_EQUALVERIFY failed"},
+		// 锁定时间过后接收方仍然可以赎回
+		{"claim after lock time", swap.redeem(t, swap.recipient, refundTx, swap.secret), scriptPubKey, ""},
+	})
+
+	tests := []struct {
+		name     string
+		lockTime uint32
+		sequence uint32
+		err      string
+	}{
+		{"refund one block early", 999, MaxTxInSequenceNum - 1, "locked until"},
+		{"refund after lock time", 2000, MaxTxInSequenceNum - 1, ""},
+		{"refund with final sequence", 1000, MaxTxInSequenceNum, "sequence disables lock time"},
+		{"refund with time lock", lockTimeThreshold + 1, MaxTxInSequenceNum - 1, "type mismatch"},
+	}
+	for _, test := range tests {
+
+		tx := scriptTestTx(test.lockTime, test.sequence)
+		runScriptTests(t, tx, []scriptTestCase{
+			{test.name, swap.refund(t, swap.refunder, tx), scriptPubKey, test.err},
+		})
+	}
+}
+
+func TestExtractAtomicSwap(t *testing.T) {
+
+	swap := newAtomicSwapTest(t)
+
+	parsed, ok := ExtractAtomicSwap(swap.contract)
+	if ok == false {
+
+		t.Fatalf("contract %x not recognized", swap.contract)
+	}
+	if bytes.Compare(parsed.SecretHash, AtomicSwapSecretHash(swap.secret)) != 0 ||
+		bytes.Compare(parsed.RecipientPubKeyHash, Ripemd160Hash(swap.recipient.PublicKey)) != 0 ||
+		bytes.Compare(parsed.RefundPubKeyHash, Ripemd160Hash(swap.refunder.PublicKey)) != 0 ||
+		parsed.LockTime != 1000 {
+
+		t.Errorf("parsed %+v", parsed)
+	}
+
+	// 按时间锁定的合约，锁定时间需要5字节编码
+	timeContract := AtomicSwapContract(parsed.SecretHash, parsed.RecipientPubKeyHash, parsed.RefundPubKeyHash, 1<<32)
+	if parsed, ok := ExtractAtomicSwap(timeContract); ok == false || parsed.LockTime != 1<<32 {
+
+		t.Errorf("time contract parsed %+v, %v", parsed, ok)
+	}
+
+	notContracts := [][]byte{
+		nil,
+		PayToPubKeyHashScript(parsed.RecipientPubKeyHash),
+		append(append([]byte{}, swap.contract...), OP_NOP),
+		AtomicSwapContract(parsed.SecretHash[:31], parsed.RecipientPubKeyHash, parsed.RefundPubKeyHash, 1000),
+		AtomicSwapContract(parsed.SecretHash, parsed.RecipientPubKeyHash[:19], parsed.RefundPubKeyHash, 1000),
+	}
+	for _, script := range notContracts {
+
+		if _, ok := ExtractAtomicSwap(script); ok {
+
+			t.Errorf("%x recognized as a contract", script)
+		}
+	}
+
+	tx := scriptTestTx(1000, MaxTxInSequenceNum-1)
+	if secret := ExtractAtomicSwapSecret(swap.redeem(t, swap.recipient, tx, swap.secret), parsed.SecretHash); bytes.Compare(secret, swap.secret) != 0 {
+
+		t.Errorf("extracted secret %x, want %x", secret, swap.secret)
+	}
+	if secret := ExtractAtomicSwapSecret(swap.refund(t, swap.refunder, tx), parsed.SecretHash); secret != nil {
+
+		t.Errorf("extracted secret %x from a refund", secret)
+	}
+	if secret := ExtractAtomicSwapSecret(swap.redeem(t, swap.recipient, tx, swap.secret), repeatHash(0x01)); secret != nil {
+
+		t.Errorf("extracted secret %x for another secret hash", secret)
+	}
+}
+
+func TestNewAtomicSwapSpend(t *testing.T) {
+
+	swap := newAtomicSwapTest(t)
+	to := string(NewWallet().GetAddress())
+	const feeRate = 1000
+
+	contractOut := &TXOutput{100000, PayToScriptHashScript(Ripemd160Hash(swap.contract))}
+	contractTx := &Transaction{[]byte{}, []*TXInput{{repeatHash(0x11), 0, nil, MaxTxInSequenceNum}}, []*TXOutput{NewTXOutput(5, to), contractOut}, TxVersion, 0}
+	contractTx.HashTransactions()
+
+	tests := []struct {
+		name     string
+		secret   []byte
+		wallet   *Wallet
+		lockTime uint32
+		sequence uint32
+	}{
+		{"claim", swap.secret, swap.recipient, 0, MaxTxInSequenceNum},
+		{"refund", nil, swap.refunder, 1000, MaxTxInSequenceNum - 1},
+	}
+	for _, test := range tests {
+
+		tx, err := NewAtomicSwapSpend(swap.contract, contractTx, test.secret, test.wallet, to, feeRate)
+		if err != nil {
+
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		in := tx.Vins[0]
+		if bytes.Compare(in.TxHash, contractTx.TxHash) != 0 || in.Vout != 1 || in.Sequence != test.sequence || tx.LockTime != test.lockTime {
+
+			t.Errorf("%s: spends %x:%d sequence %x lock time %d", test.name, in.TxHash, in.Vout, in.Sequence, tx.LockTime)
+		}
+		if err := tx.VerifyScripts([]*TXOutput{contractOut}); err != nil {
+
+			t.Errorf("%s: %s", test.name, err)
+		}
+		if err := tx.CheckTxHash(); err != nil {
+
+			t.Errorf("%s: %s", test.name, err)
+		}
+
+		fee := contractOut.Value - tx.Vouts[0].Value
+		if required := TransactionFeeForSize(len(tx.Serialize()), feeRate); fee < required {
+
+			t.Errorf("%s: fee %d, want at least %d", test.name, fee, required)
+		}
+	}
+
+	noContractTx := &Transaction{[]byte{}, contractTx.Vins, []*TXOutput{NewTXOutput(5, to)}, TxVersion, 0}
+	noContractTx.HashTransactions()
+	dustTx := &Transaction{[]byte{}, contractTx.Vins, []*TXOutput{{1, contractOut.ScriptPubKey}}, TxVersion, 0}
+	dustTx.HashTransactions()
+
+	errorTests := []struct {
+		name       string
+		contract   []byte
+		contractTx *Transaction
+		secret     []byte
+		wallet     *Wallet
+	}{
+		{"not a contract", PayToPubKeyHashScript(Ripemd160Hash(swap.recipient.PublicKey)), contractTx, swap.secret, swap.recipient},
+		{"no contract output", swap.contract, noContractTx, swap.secret, swap.recipient},
+		{"wrong secret", swap.contract, contractTx, bytes.Repeat([]byte{0x42}, atomicSwapSecretSize), swap.recipient},
+		{"claim by refunder", swap.contract, contractTx, swap.secret, swap.refunder},
+		{"refund by recipient", swap.contract, contractTx, nil, swap.recipient},
+		{"value below fee", swap.contract, dustTx, swap.secret, swap.recipient},
+	}
+	for _, test := range errorTests {
+
+		if _, err := NewAtomicSwapSpend(test.contract, test.contractTx, test.secret, test.wallet, to, feeRate); err == nil {
+
+			t.Errorf("%s: spend created", test.name)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package BLC
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+
+	"github.com/boltdb/bolt"
+)
+
+/*
+旧版本数据库迁移
+
+改用二进制编码之前，区块表中的区块是gob编码的：交易输出直接记录公钥哈希，输入记录签名和公钥，
+区块哈希和交易哈希也都按旧的方式计算。打开数据库时如果链顶端的区块还是gob编码，就把整条主链一次性转换：
+1.输出转换为P2PKH锁定脚本，输入转换为<签名> <公钥>解锁脚本，创币交易的解锁脚本压入区块高度
+2.交易按当前版本重新计算哈希，后续交易中引用旧哈希的输入同步改为新哈希
+3.区块头指向转换后父区块的哈希，按区块头重新计算区块哈希，区块表按新哈希重写
+4.区块头、累计工作量、主链索引和过滤器按新哈希重建，UTXO表从主链重建
+
+旧签名是对旧的交易副本签的，旧区块的工作量证明也是按旧的区块哈希计算的，在当前规则下都不能通过校验，
+所以迁移后的区块只作为本地已确认的历史使用，重建UTXO表时不校验脚本，侧链上的旧区块直接丢弃
+*/
+
+// 迁移后的区块头版本  与按当前规则挖出的区块区分
+const legacyBlockVersion = 0
+
+// 旧版本gob编码的交易输入
+type legacyTXInput struct {
+	TxHash    []byte
+	Vout      int
+	Signature []byte
+	PublicKey []byte
+}
+
+// 旧版本gob编码的交易输出  锁定到公钥哈希
+type legacyTXOutput struct {
+	Value         int64
+	Ripemd160Hash []byte
+}
+
+// 旧版本gob编码的交易
+type legacyTransaction struct {
+	TxHash []byte
+	Vins   []*legacyTXInput
+	Vouts  []*legacyTXOutput
+}
+
+// 旧版本gob编码的区块  没有记录难度的区块使用创世区块难度
+type legacyBlock struct {
+	Height        int64
+	PrevBlockHash []byte
+	Txs           []*legacyTransaction
+	Timestamp     int64
+	Hash          []byte
+	Nonce         int64
+	TargetBits    int64
+}
+
+// 解码gob编码的旧区块
+func decodeLegacyBlock(blockBytes []byte) (*legacyBlock, error) {
+
+	var legacy legacyBlock
+
+	decoder := gob.NewDecoder(bytes.NewReader(blockBytes))
+	err := decoder.Decode(&legacy)
+	if err != nil {
+
+		return nil, err
+	}
+
+	return &legacy, nil
+}
+
+// 转换为当前的交易结构  txHashes记录已转换交易的旧哈希到新哈希的对应关系
+func (legacy *legacyTransaction) convert(height int64, txHashes map[string][]byte) (*Transaction, error) {
+
+	var txInputs []*TXInput
+	for _, in := range legacy.Vins {
+
+		if len(in.TxHash) == 0 && in.Vout == -1 {
+
+			//创币交易的解锁脚本压入区块高度，每个区块的创币交易哈希都不同
+			txInputs = append(txInputs, &TXInput{[]byte{}, -1, newScriptBuilder().AddInt64(height).Script(), MaxTxInSequenceNum})
+			continue
+		}
+
+		prevHash := txHashes[hex.EncodeToString(in.TxHash)]
+		if prevHash == nil {
+
+			return nil, fmt.Errorf("tx %x spends unknown tx %x", legacy.TxHash, in.TxHash)
+		}
+		txInputs = append(txInputs, &TXInput{prevHash, in.Vout, SignatureScript(in.Signature, in.PublicKey), MaxTxInSequenceNum})
+	}
+
+	var txOutputs []*TXOutput
+	for _, out := range legacy.Vouts {
+
+		txOutputs = append(txOutputs, &TXOutput{out.Value, PayToPubKeyHashScript(out.Ripemd160Hash)})
+	}
+
+	tx := &Transaction{[]byte{}, txInputs, txOutputs, TxVersion, 0}
+	tx.HashTransactions()
+	txHashes[hex.EncodeToString(legacy.TxHash)] = tx.TxHash
+
+	return tx, nil
+}
+
+// 转换为当前的区块结构  prevHash为转换后父区块的哈希
+func (legacy *legacyBlock) convert(prevHash []byte, txHashes map[string][]byte) (*Block, error) {
+
+	var txs []*Transaction
+	for _, legacyTx := range legacy.Txs {
+
+		tx, err := legacyTx.convert(legacy.Height, txHashes)
+		if err != nil {
+
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+
+	targetBits := legacy.TargetBits
+	if targetBits == 0 {
+
+		targetBits = genesisTargetBits
+	}
+
+	block := &Block{
+		BlockHeader: BlockHeader{
+			Version:       legacyBlockVersion,
+			PrevBlockHash: prevHash,
+			Timestamp:     legacy.Timestamp,
+			TargetBits:    targetBits,
+			Nonce:         legacy.Nonce,
+			Height:        legacy.Height},
+		Txs: txs}
+	block.MerkleRoot = block.HashTransactions()
+	block.Hash = block.BlockHeader.Hash()
+
+	return block, nil
+}
+
+// 链顶端的区块还是gob编码时，把整条主链转换为当前格式  返回是否做了迁移
+// 迁移后需要重建UTXO表
+func migrateLegacyChain(db *bolt.DB) (bool, error) {
+
+	migrated := false
+
+	err := db.Update(func(tx *bolt.Tx) error {
+
+		blocks := tx.Bucket([]byte(blockTableName))
+		if blocks == nil {
+
+			return nil
+		}
+
+		tipBytes := blocks.Get(blocks.Get([]byte(newestBlockKey)))
+		if tipBytes == nil {
+
+			return nil
+		}
+		if _, err := DecodeBlock(tipBytes); err == nil {
+
+			return nil
+		}
+
+		// 从链顶端往回找到主链上的所有旧区块
+		var chain []*legacyBlock
+		for hash := blocks.Get([]byte(newestBlockKey)); ; {
+
+			blockBytes := blocks.Get(hash)
+			if blockBytes == nil {
+
+				break
+			}
+
+			legacy, err := decodeLegacyBlock(blockBytes)
+			if err != nil {
+
+				return fmt.Errorf("legacy block %x: %s", hash, err)
+			}
+			chain = append(chain, legacy)
+			hash = legacy.PrevBlockHash
+		}
+		fmt.Printf("正在迁移旧版本数据库，共%d个区块...\n", len(chain))
+
+		// 按旧哈希存储的表全部删除后重建
+		for _, table := range []string{blockTableName, blockHeaderTableName, blockFilterTableName, blockWorkTableName, invalidBlockTableName, mainChainTableName, UTXOTableName, utxoUndoTableName} {
+
+			if tx.Bucket([]byte(table)) != nil {
+
+				err := tx.DeleteBucket([]byte(table))
+				if err != nil {
+
+					return err
+				}
+			}
+		}
+
+		blocks, err := tx.CreateBucket([]byte(blockTableName))
+		if err != nil {
+
+			return err
+		}
+		mainChain, err := tx.CreateBucket([]byte(mainChainTableName))
+		if err != nil {
+
+			return err
+		}
+
+		// 从创世区块开始转换，子区块指向转换后的父区块
+		txHashes := make(map[string][]byte)
+		prevHash := chain[len(chain)-1].PrevBlockHash
+		work := big.NewInt(0)
+		for i := len(chain) - 1; i >= 0; i-- {
+
+			block, err := chain[i].convert(prevHash, txHashes)
+			if err != nil {
+
+				return err
+			}
+
+			err = blocks.Put(block.Hash, block.Serialize())
+			if err != nil {
+
+				return err
+			}
+			err = putBlockHeader(tx, block.Hash, &block.BlockHeader)
+			if err != nil {
+
+				return err
+			}
+			err = putBlockFilter(tx, block)
+			if err != nil {
+
+				return err
+			}
+			err = mainChain.Put(heightKey(block.Height), block.Hash)
+			if err != nil {
+
+				return err
+			}
+			work.Add(work, block.Work())
+			err = putChainWork(tx, block.Hash, work)
+			if err != nil {
+
+				return err
+			}
+
+			prevHash = block.Hash
+		}
+
+		migrated = true
+		return blocks.Put([]byte(newestBlockKey), prevHash)
+	})
+
+	return migrated, err
+}
+
+// 打开数据库后迁移旧版本的链  迁移后从主链重建UTXO表
+func openLegacyChain(db *bolt.DB) {
+
+	migrated, err := migrateLegacyChain(db)
+	if err != nil {
+
+		log.Panic(err)
+	}
+	if migrated == false {
+
+		return
+	}
+
+	var tip []byte
+	err = db.View(func(tx *bolt.Tx) error {
+
+		tip = append([]byte{}, tx.Bucket([]byte(blockTableName)).Get([]byte(newestBlockKey))...)
+		return nil
+	})
+	if err != nil {
+
+		log.Panic(err)
+	}
+
+	utxoSet := &UTXOSet{&Blockchain{tip, db}}
+	utxoSet.ResetUTXOSet()
+	fmt.Printf("迁移完成，链顶端区块%x\n", tip)
+}
@@ -0,0 +1,202 @@
+package BLC
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// 与改用二进制编码前的结构逐字段相同，用来生成旧数据库
+type baselineTXInput struct {
+	TxHash    []byte
+	Vout      int
+	Signature []byte
+	PublicKey []byte
+}
+
+type baselineTXOutput struct {
+	Value         int64
+	Ripemd160Hash []byte
+}
+
+type baselineTransaction struct {
+	TxHash []byte
+	Vins   []*baselineTXInput
+	Vouts  []*baselineTXOutput
+}
+
+type baselineBlock struct {
+	Height        int64
+	PrevBlockHash []byte
+	Txs           []*baselineTransaction
+	Timestamp     int64
+	Hash          []byte
+	Nonce         int64
+}
+
+type baselineUTXO struct {
+	TxHash []byte
+	Index  int
+	Output *baselineTXOutput
+}
+
+type baselineTXOutputs struct {
+	UTXOS []*baselineUTXO
+}
+
+func gobBytes(t *testing.T, value interface{}) []byte {
+
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(value)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// 旧数据库迁移后区块和交易按新格式重新计算哈希，旧输出可以按P2PKH花费
+func TestMigrateLegacyChainAndSpend(t *testing.T) {
+
+	cwd, err := os.Getwd()
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	alice := NewWallet()
+	bob := NewWallet()
+	aliceHash := Ripemd160Hash(alice.PublicKey)
+	bobHash := Ripemd160Hash(bob.PublicKey)
+
+	// 创世区块的创币交易给alice，第二个区块中alice转10给bob
+	coinbase1 := &baselineTransaction{repeatHash(0x01), []*baselineTXInput{{[]byte{}, -1, nil, nil}}, []*baselineTXOutput{{25, aliceHash}}}
+	coinbase2 := &baselineTransaction{repeatHash(0x02), []*baselineTXInput{{[]byte{}, -1, nil, nil}}, []*baselineTXOutput{{25, bobHash}}}
+	spend := &baselineTransaction{
+		repeatHash(0x03),
+		[]*baselineTXInput{{repeatHash(0x01), 0, []byte("baseline signature"), alice.PublicKey}},
+		[]*baselineTXOutput{{10, bobHash}, {15, aliceHash}}}
+
+	now := time.Now().Unix()
+	genesis := &baselineBlock{1, make([]byte, 32), []*baselineTransaction{coinbase1}, now - 1200, repeatHash(0xa1), 7}
+	block2 := &baselineBlock{2, genesis.Hash, []*baselineTransaction{coinbase2, spend}, now - 600, repeatHash(0xa2), 9}
+
+	db, err := bolt.Open("chaorsBlockchain_test.db", 0600, nil)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+
+		b, err := tx.CreateBucket([]byte(blockTableName))
+		if err != nil {
+
+			return err
+		}
+		b.Put(genesis.Hash, gobBytes(t, genesis))
+		b.Put(block2.Hash, gobBytes(t, block2))
+		b.Put([]byte(newestBlockKey), block2.Hash)
+
+		// 旧的UTXO表  迁移时整个重建
+		utxos, err := tx.CreateBucket([]byte(UTXOTableName))
+		if err != nil {
+
+			return err
+		}
+		return utxos.Put(spend.TxHash, gobBytes(t, &baselineTXOutputs{[]*baselineUTXO{{spend.TxHash, 0, spend.Vouts[0]}}}))
+	})
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	db.Close()
+
+	blc := GetBlockchain("test")
+	defer blc.DB.Close()
+
+	tip := tipBlock(t, blc)
+	parent := mustGetBlock(t, blc, tip.PrevBlockHash)
+	if tip.Height != 2 || parent.Height != 1 {
+
+		t.Fatalf("migrated heights %d, %d", tip.Height, parent.Height)
+	}
+	for _, block := range []*Block{tip, parent} {
+
+		if bytes.Compare(block.Hash, block.BlockHeader.Hash()) != 0 {
+
+			t.Errorf("block %d: hash %x does not match header", block.Height, block.Hash)
+		}
+		for _, tx := range block.Txs {
+
+			if err := tx.CheckTxHash(); err != nil {
+
+				t.Errorf("block %d: %s", block.Height, err)
+			}
+		}
+	}
+	if bytes.Compare(blc.Tip, tip.Hash) != 0 {
+
+		t.Errorf("tip %x, want %x", blc.Tip, tip.Hash)
+	}
+
+	// 输入引用转换后的交易哈希，输出转换为P2PKH
+	spendTx := tip.Txs[1]
+	if bytes.Compare(spendTx.Vins[0].TxHash, parent.Txs[0].TxHash) != 0 {
+
+		t.Errorf("spend input %x, want migrated coinbase %x", spendTx.Vins[0].TxHash, parent.Txs[0].TxHash)
+	}
+
+	utxoSet := &UTXOSet{blc}
+	if utxoSet.FindUTXO(parent.Txs[0].TxHash, 0) != nil {
+
+		t.Errorf("spent genesis output is still unspent")
+	}
+	utxo := utxoSet.FindUTXO(spendTx.TxHash, 0)
+	if utxo == nil || bytes.Compare(utxo.Output.ScriptPubKey, PayToPubKeyHashScript(bobHash)) != 0 {
+
+		t.Fatalf("migrated output %+v, want P2PKH to bob", utxo)
+	}
+	assertUTXOSetMatchesReindex(t, blc)
+
+	// 迁移后的输出只有bob能花费
+	aliceAddress := string(alice.GetAddress())
+	newSpend := func(wallet *Wallet) *Transaction {
+
+		tx := &Transaction{[]byte{}, []*TXInput{{spendTx.TxHash, 0, nil, MaxTxInSequenceNum}}, []*TXOutput{NewTXOutput(9, aliceAddress)}, TxVersion, 0}
+		blc.SignTransaction(tx, wallet.PrivateKey, nil)
+		tx.HashTransactions()
+
+		return tx
+	}
+	if blc.VerifyTransaction(newSpend(alice), nil) {
+
+		t.Fatalf("migrated output spent with another key")
+	}
+
+	tx := newSpend(bob)
+	block, err := blc.MineTransactions([]*Transaction{tx}, aliceAddress)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if bytes.Compare(blc.Tip, block.Hash) != 0 {
+
+		t.Fatalf("tip %x, want new block %x", blc.Tip, block.Hash)
+	}
+	if utxoSet.FindUTXO(spendTx.TxHash, 0) != nil || utxoSet.FindUTXO(tx.TxHash, 0) == nil {
+
+		t.Errorf("spend of migrated output not applied to the utxo set")
+	}
+	assertUTXOSetMatchesReindex(t, blc)
+}
@@ -0,0 +1,212 @@
+package BLC
+
+import "fmt"
+
+/**
+时间锁
+
+绝对锁定：Transaction.LockTime
+	0表示不锁定；小于lockTimeThreshold表示区块高度，否则表示Unix时间戳
+	交易只能打包进高度大于LockTime的区块，或父区块的过去时间中位数大于LockTime的区块
+	所有输入的Sequence都为MaxTxInSequenceNum时LockTime不生效
+
+相对锁定：TXInput.Sequence
+	最高位(SequenceLockTimeDisabled)为1时不生效
+	否则低16位为锁定时长，SequenceLockTimeIsSeconds位为0时单位为区块，为1时单位为512秒
+	从被花费的输出所在区块开始计算，锁定时长过去之前输入不能被花费
+*/
+
+// 锁定时间的分界  小于它表示区块高度，否则表示Unix时间戳
+const lockTimeThreshold = 500000000
+
+// 输入序号的最大值，不启用任何时间锁
+const MaxTxInSequenceNum = 0xffffffff
+
+// 相对锁定的标志位和掩码
+const (
+	// 最高位为1时相对锁定不生效
+	SequenceLockTimeDisabled = 1 << 31
+	// 为1时锁定时长以512秒为单位，否则以区块为单位
+	SequenceLockTimeIsSeconds = 1 << 22
+	// 锁定时长所在的低16位
+	SequenceLockTimeMask = 0x0000ffff
+	// 时间单位 2^9 = 512秒
+	SequenceLockTimeGranularity = 9
+)
+
+// 锁定n个区块的输入序号
+func SequenceForBlocks(blocks int64) (uint32, error) {
+
+	if blocks < 0 || blocks > SequenceLockTimeMask {
+
+		return 0, fmt.Errorf("relative lock of %d blocks out of range 0-%d", blocks, SequenceLockTimeMask)
+	}
+
+	return uint32(blocks), nil
+}
+
+// 锁定若干秒的输入序号  向上取整到512秒
+func SequenceForSeconds(seconds int64) (uint32, error) {
+
+	units := (seconds + (1 << SequenceLockTimeGranularity) - 1) >> SequenceLockTimeGranularity
+	if seconds < 0 || units > SequenceLockTimeMask {
+
+		return 0, fmt.Errorf("relative lock of %d seconds out of range", seconds)
+	}
+
+	return SequenceLockTimeIsSeconds | uint32(units), nil
+}
+
+// 交易在某个高度的区块中是否已经生效  medianTime为该区块父区块的过去时间中位数
+func (tx *Transaction) IsFinal(height int64, medianTime int64) bool {
+
+	if tx.LockTime == 0 {
+
+		return true
+	}
+
+	limit := height
+	if tx.LockTime >= lockTimeThreshold {
+
+		limit = medianTime
+	}
+	if int64(tx.LockTime) < limit {
+
+		return true
+	}
+
+	// 所有输入都不启用时间锁时忽略LockTime
+	for _, in := range tx.Vins {
+
+		if in.Sequence != MaxTxInSequenceNum {
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// 相对锁定的结果  区块高度大于MinHeight，且父区块的过去时间中位数大于MinTime时才能打包
+type sequenceLock struct {
+	MinHeight int64
+	MinTime   int64
+}
+
+// 是否可以打包进某个高度的区块  medianTime为该区块父区块的过去时间中位数
+func (lock *sequenceLock) satisfied(height int64, medianTime int64) bool {
+
+	return lock.MinHeight < height && lock.MinTime < medianTime
+}
+
+// 计算交易所有输入的相对锁定
+// utxoHeights为每个输入所花费输出所在的区块高度，medianTimeAt返回某个高度区块的过去时间中位数
+func (tx *Transaction) sequenceLock(utxoHeights []int64, medianTimeAt func(height int64) int64) *sequenceLock {
+
+	lock := &sequenceLock{-1, -1}
+	if tx.IsCoinbaseTransaction() {
+
+		return lock
+	}
+
+	for i, in := range tx.Vins {
+
+		if in.Sequence&SequenceLockTimeDisabled != 0 {
+
+			continue
+		}
+
+		value := int64(in.Sequence & SequenceLockTimeMask)
+		if in.Sequence&SequenceLockTimeIsSeconds != 0 {
+
+			// 从输出所在区块的父区块的过去时间中位数开始计时
+			minTime := medianTimeAt(utxoHeights[i]-1) + value<<SequenceLockTimeGranularity - 1
+			if minTime > lock.MinTime {
+
+				lock.MinTime = minTime
+			}
+		} else {
+
+			minHeight := utxoHeights[i] + value - 1
+			if minHeight > lock.MinHeight {
+
+				lock.MinHeight = minHeight
+			}
+		}
+	}
+
+	return lock
+}
+
+// 沿着区块头向前找到某个高度的祖先区块头，找不到返回nil
+func ancestorHeader(header *BlockHeader, height int64, lookup func(hash []byte) *BlockHeader) *BlockHeader {
+
+	for header != nil && header.Height > height {
+
+		header = lookup(header.PrevBlockHash)
+	}
+
+	if header == nil || header.Height != height {
+
+		return nil
+	}
+
+	return header
+}
+
+// 以tip为链顶端，计算交易在下一个区块中的时间锁是否满足
+// utxoHeights为每个输入所花费输出所在的区块高度
+func checkTransactionLocks(tx *Transaction, tip *BlockHeader, utxoHeights []int64, lookup func(hash []byte) *BlockHeader) error {
+
+	height := tip.Height + 1
+	medianTime := medianTimePastFrom(tip, lookup)
+
+	if tx.IsFinal(height, medianTime) == false {
+
+		return fmt.Errorf("locked until %d", tx.LockTime)
+	}
+
+	medianTimeAt := func(h int64) int64 {
+
+		header := ancestorHeader(tip, h, lookup)
+		if header == nil {
+
+			return 0
+		}
+
+		return medianTimePastFrom(header, lookup)
+	}
+
+	lock := tx.sequenceLock(utxoHeights, medianTimeAt)
+	if lock.satisfied(height, medianTime) == false {
+
+		return fmt.Errorf("relative lock not satisfied (height > %d, median time > %d)", lock.MinHeight, lock.MinTime)
+	}
+
+	return nil
+}
+
+// 以当前主链为准，检查交易的时间锁能否在下一个区块中满足
+// 输入引用的输出不在UTXOSet中时(还没打包)，按下一个区块的高度计算
+func (utxoSet *UTXOSet) CheckTransactionLocks(tx *Transaction) error {
+
+	blc := utxoSet.Blockchain
+	tip, err := blc.GetBlockHeader(blc.Tip)
+	if err != nil {
+
+		return err
+	}
+
+	var utxoHeights []int64
+	for _, in := range tx.Vins {
+
+		height := tip.Height + 1
+		if utxo := utxoSet.FindUTXO(in.TxHash, in.Vout); utxo != nil {
+
+			height = utxo.Height
+		}
+		utxoHeights = append(utxoHeights, height)
+	}
+
+	return checkTransactionLocks(tx, tip, utxoHeights, blc.lookupBlockHeader)
+}
@@ -0,0 +1,273 @@
+package BLC
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// 间隔600秒的一串区块头，返回各高度的区块头和按哈希查找的函数
+func lockTestChain(n int64) ([]*BlockHeader, func(hash []byte) *BlockHeader) {
+
+	headers := make([]*BlockHeader, n+1)
+	byHash := make(map[string]*BlockHeader)
+	prevHash := make([]byte, 32)
+	for height := int64(1); height <= n; height++ {
+
+		header := &BlockHeader{
+			Version:       BlockVersion,
+			PrevBlockHash: prevHash,
+			Timestamp:     1500000000 + 600*height,
+			TargetBits:    genesisTargetBits,
+			Height:        height}
+		prevHash = header.Hash()
+		headers[height] = header
+		byHash[hex.EncodeToString(prevHash)] = header
+	}
+
+	return headers, func(hash []byte) *BlockHeader {
+
+		return byHash[hex.EncodeToString(hash)]
+	}
+}
+
+func TestTransactionIsFinal(t *testing.T) {
+
+	const medianTime = 1600000000
+
+	tests := []struct {
+		name     string
+		lockTime uint32
+		sequence uint32
+		height   int64
+		final    bool
+	}{
+		{"no lock time", 0, 0, 1, true},
+		{"height reached", 99, 0, 100, true},
+		// LockTime必须小于区块高度，相等时还不能打包
+		{"height equal", 100, 0, 100, false},
+		{"height not reached", 101, 0, 100, false},
+		{"height not reached but sequence disables", 101, MaxTxInSequenceNum, 100, true},
+		{"last height value", lockTimeThreshold - 1, 0, lockTimeThreshold, true},
+		// 达到分界值后按时间比较，与区块高度无关
+		{"threshold is a time", lockTimeThreshold, 0, lockTimeThreshold + 1, true},
+		{"time reached", medianTime - 1, 0, 1, true},
+		{"time equal", medianTime, 0, 1 << 40, false},
+		{"time not reached", medianTime + 1, 0, 1, false},
+		{"time not reached but sequence disables", medianTime + 1, MaxTxInSequenceNum, 1, true},
+		{"time not reached with max-1 sequence", medianTime + 1, MaxTxInSequenceNum - 1, 1, false},
+	}
+
+	for _, test := range tests {
+
+		tx := scriptTestTx(test.lockTime, test.sequence)
+		if got := tx.IsFinal(test.height, medianTime); got != test.final {
+
+			t.Errorf("%s: final = %v, want %v", test.name, got, test.final)
+		}
+	}
+}
+
+func TestSequenceEncoding(t *testing.T) {
+
+	blockTests := []struct {
+		blocks   int64
+		sequence uint32
+		ok       bool
+	}{
+		{0, 0, true},
+		{144, 144, true},
+		{SequenceLockTimeMask, SequenceLockTimeMask, true},
+		{SequenceLockTimeMask + 1, 0, false},
+		{-1, 0, false},
+	}
+	for _, test := range blockTests {
+
+		sequence, err := SequenceForBlocks(test.blocks)
+		if (err == nil) != test.ok || sequence != test.sequence {
+
+			t.Errorf("SequenceForBlocks(%d) = %x, %v", test.blocks, sequence, err)
+		}
+	}
+
+	secondTests := []struct {
+		seconds  int64
+		sequence uint32
+		ok       bool
+	}{
+		{0, SequenceLockTimeIsSeconds, true},
+		// 向上取整到512秒
+		{1, SequenceLockTimeIsSeconds | 1, true},
+		{512, SequenceLockTimeIsSeconds | 1, true},
+		{513, SequenceLockTimeIsSeconds | 2, true},
+		{SequenceLockTimeMask << SequenceLockTimeGranularity, SequenceLockTimeIsSeconds | SequenceLockTimeMask, true},
+		{SequenceLockTimeMask<<SequenceLockTimeGranularity + 1, 0, false},
+		{-1, 0, false},
+	}
+	for _, test := range secondTests {
+
+		sequence, err := SequenceForSeconds(test.seconds)
+		if (err == nil) != test.ok || sequence != test.sequence {
+
+			t.Errorf("SequenceForSeconds(%d) = %x, %v", test.seconds, sequence, err)
+		}
+	}
+}
+
+func TestTransactionSequenceLock(t *testing.T) {
+
+	// 每个高度的过去时间中位数为高度乘1000
+	medianTimeAt := func(height int64) int64 {
+
+		return height * 1000
+	}
+
+	tests := []struct {
+		name        string
+		sequences   []uint32
+		utxoHeights []int64
+		minHeight   int64
+		minTime     int64
+	}{
+		{"disabled", []uint32{SequenceLockTimeDisabled | 10}, []int64{50}, -1, -1},
+		{"max sequence", []uint32{MaxTxInSequenceNum}, []int64{50}, -1, -1},
+		{"zero blocks", []uint32{0}, []int64{50}, 49, -1},
+		{"ten blocks", []uint32{10}, []int64{50}, 59, -1},
+		// 掩码以外的位不影响锁定时长
+		{"ignored bits", []uint32{1<<16 | 10}, []int64{50}, 59, -1},
+		// 从输出所在区块的父区块开始计时
+		{"two units of time", []uint32{SequenceLockTimeIsSeconds | 2}, []int64{50}, -1, 49000 + 1024 - 1},
+		{"largest lock wins", []uint32{10, 20, SequenceLockTimeDisabled}, []int64{50, 45, 1}, 64, -1},
+		{"blocks and time", []uint32{10, SequenceLockTimeIsSeconds | 1}, []int64{50, 30}, 59, 29000 + 512 - 1},
+	}
+
+	for _, test := range tests {
+
+		tx := scriptTestTx(0, 0)
+		tx.Vins = nil
+		for _, sequence := range test.sequences {
+
+			tx.Vins = append(tx.Vins, &TXInput{repeatHash(0x11), 0, nil, sequence})
+		}
+
+		lock := tx.sequenceLock(test.utxoHeights, medianTimeAt)
+		if lock.MinHeight != test.minHeight || lock.MinTime != test.minTime {
+
+			t.Errorf("%s: lock %+v, want {MinHeight:%d MinTime:%d}", test.name, *lock, test.minHeight, test.minTime)
+		}
+	}
+
+	// 创币交易没有相对锁定
+	coinbase := NewCoinbaseTransaction(string(NewWallet().GetAddress()), 5, 0)
+	coinbase.Vins[0].Sequence = 10
+	if lock := coinbase.sequenceLock([]int64{0}, medianTimeAt); lock.MinHeight != -1 || lock.MinTime != -1 {
+
+		t.Errorf("coinbase lock %+v", *lock)
+	}
+
+	lock := &sequenceLock{59, 1000}
+	if lock.satisfied(59, 2000) || lock.satisfied(60, 1000) || lock.satisfied(60, 1001) == false {
+
+		t.Errorf("satisfied boundaries wrong for %+v", *lock)
+	}
+}
+
+// 按链上的区块头计算下一个区块中的时间锁
+func TestCheckTransactionLocks(t *testing.T) {
+
+	headers, lookup := lockTestChain(30)
+
+	tests := []struct {
+		name       string
+		lockTime   uint32
+		sequence   uint32
+		tipHeight  int64
+		utxoHeight int64
+		ok         bool
+	}{
+		{"unlocked", 0, MaxTxInSequenceNum, 20, 10, true},
+		{"absolute height reached", 20, 0, 20, 10, true},
+		{"absolute height not reached", 21, 0, 20, 10, false},
+		// 时间按父区块的过去时间中位数比较，高度20的中位数为高度15的时间戳
+		{"absolute time reached", uint32(headers[15].Timestamp - 1), 0, 20, 10, true},
+		{"absolute time equal", uint32(headers[15].Timestamp), 0, 20, 10, false},
+		{"relative 5 blocks reached", 0, 5, 14, 10, true},
+		{"relative 5 blocks not reached", 0, 5, 13, 10, false},
+		// 输出所在区块的父区块中位数为高度14的时间戳，1024秒后要再过两个区块
+		{"relative time reached", 0, SequenceLockTimeIsSeconds | 2, 21, 20, true},
+		{"relative time not reached", 0, SequenceLockTimeIsSeconds | 2, 20, 20, false},
+		{"relative lock disabled", 0, SequenceLockTimeDisabled | 5, 10, 10, true},
+	}
+
+	for _, test := range tests {
+
+		tx := scriptTestTx(test.lockTime, test.sequence)
+		err := checkTransactionLocks(tx, headers[test.tipHeight], []int64{test.utxoHeight}, lookup)
+		if (err == nil) != test.ok {
+
+			t.Errorf("%s: got %v, want ok = %v", test.name, err, test.ok)
+		}
+	}
+}
+
+// OP_CHECKLOCKTIMEVERIFY和OP_CHECKSEQUENCEVERIFY的边界
+func TestVerifyScriptTimeLocks(t *testing.T) {
+
+	cltv := func(n int64) []byte {
+
+		return newScriptBuilder().AddInt64(n).AddOp(OP_CHECKLOCKTIMEVERIFY).AddOp(OP_DROP).AddOp(OP_TRUE).Script()
+	}
+	csv := func(n int64) []byte {
+
+		return newScriptBuilder().AddInt64(n).AddOp(OP_CHECKSEQUENCEVERIFY).AddOp(OP_DROP).AddOp(OP_TRUE).Script()
+	}
+
+	// 按高度锁定的交易
+	runScriptTests(t, scriptTestTx(1000, 0), []scriptTestCase{
+		{"cltv height below", nil, cltv(999), ""},
+		{"cltv height equal", nil, cltv(1000), ""},
+		{"cltv height above", nil, cltv(1001), "locked until"},
+		{"cltv time against height", nil, cltv(lockTimeThreshold), "type mismatch"},
+		{"cltv negative", nil, cltv(-1), "negative lock time"},
+		{"cltv empty stack", nil, []byte{OP_CHECKLOCKTIMEVERIFY}, "stack underflow"},
+		{"csv with lock time only", nil, csv(0), ""},
+	})
+
+	// 按时间锁定的交易
+	runScriptTests(t, scriptTestTx(lockTimeThreshold+100, 0), []scriptTestCase{
+		{"cltv time equal", nil, cltv(lockTimeThreshold + 100), ""},
+		{"cltv threshold", nil, cltv(lockTimeThreshold), ""},
+		{"cltv time above", nil, cltv(lockTimeThreshold + 101), "locked until"},
+		{"cltv height against time", nil, cltv(lockTimeThreshold - 1), "type mismatch"},
+	})
+
+	// 输入序号为最大值时交易的LockTime不生效
+	runScriptTests(t, scriptTestTx(1000, MaxTxInSequenceNum), []scriptTestCase{
+		{"cltv with final sequence", nil, cltv(500), "sequence disables lock time"},
+		{"csv with final sequence", nil, csv(5), "disables relative lock"},
+		// 参数设置了禁用位时相当于OP_NOP
+		{"csv disabled argument", nil, csv(SequenceLockTimeDisabled | 5), ""},
+	})
+
+	// 相对锁定10个区块的输入
+	runScriptTests(t, scriptTestTx(0, 10), []scriptTestCase{
+		{"csv blocks below", nil, csv(9), ""},
+		{"csv blocks equal", nil, csv(10), ""},
+		{"csv blocks above", nil, csv(11), "relative lock 11"},
+		{"csv seconds against blocks", nil, csv(SequenceLockTimeIsSeconds | 1), "type mismatch"},
+		// 只比较掩码内的值
+		{"csv ignored bits", nil, csv(1<<16 | 10), ""},
+		{"cltv height against zero lock time", nil, cltv(0), ""},
+	})
+
+	// 相对锁定10个512秒的输入
+	runScriptTests(t, scriptTestTx(0, SequenceLockTimeIsSeconds|10), []scriptTestCase{
+		{"csv seconds equal", nil, csv(SequenceLockTimeIsSeconds | 10), ""},
+		{"csv seconds above", nil, csv(SequenceLockTimeIsSeconds | 11), "relative lock 11"},
+		{"csv blocks against seconds", nil, csv(5), "type mismatch"},
+	})
+
+	// 禁用了相对锁定的输入不能满足OP_CHECKSEQUENCEVERIFY
+	runScriptTests(t, scriptTestTx(0, SequenceLockTimeDisabled|10), []scriptTestCase{
+		{"csv disabled sequence", nil, csv(5), "disables relative lock"},
+	})
+}
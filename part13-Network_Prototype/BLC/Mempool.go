@@ -0,0 +1,282 @@
+package BLC
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// 打包区块时为区块头和创币交易预留的字节数
+const blockReservedSize = 1000
+
+// 加入交易池并登记它花费的输出
+func addMempoolTx(tx *Transaction) {
+
+	id := hex.EncodeToString(tx.TxHash)
+	memTxPool[id] = *tx
+	for _, in := range tx.Vins {
+
+		memTxSpends[fmt.Sprintf("%x:%d", in.TxHash, in.Vout)] = id
+	}
+}
+
+// 从交易池移除交易及它登记的花费
+func removeMempoolTx(id string) {
+
+	tx, ok := memTxPool[id]
+	if ok == false {
+
+		return
+	}
+
+	delete(memTxPool, id)
+	for _, in := range tx.Vins {
+
+		outpoint := fmt.Sprintf("%x:%d", in.TxHash, in.Vout)
+		if memTxSpends[outpoint] == id {
+
+			delete(memTxSpends, outpoint)
+		}
+	}
+}
+
+// 交易池中与tx花费同一个输出的交易  没有冲突时返回空串
+func mempoolConflict(tx *Transaction) string {
+
+	id := hex.EncodeToString(tx.TxHash)
+	for _, in := range tx.Vins {
+
+		spender, ok := memTxSpends[fmt.Sprintf("%x:%d", in.TxHash, in.Vout)]
+		if ok && spender != id {
+
+			return spender
+		}
+	}
+
+	return ""
+}
+
+// 链重组后更新交易池
+// 被回滚区块中的普通交易放回交易池，新连接区块中的交易从交易池移除，最后剔除输入已失效的交易
+func updateMempoolAfterReorg(utxoSet *UTXOSet, detached []*Block, attached []*Block) {
+
+	for _, block := range detached {
+
+		for _, tx := range block.Txs {
+
+			if tx.IsCoinbaseTransaction() == false {
+
+				// 可能与交易池中的交易冲突，先直接放入，由pruneMempool剔除并重建花费索引
+				memTxPool[hex.EncodeToString(tx.TxHash)] = *tx
+			}
+		}
+	}
+
+	for _, block := range attached {
+
+		for _, tx := range block.Txs {
+
+			removeMempoolTx(hex.EncodeToString(tx.TxHash))
+		}
+	}
+
+	pruneMempool(utxoSet)
+}
+
+// 剔除交易池中输入已经被花费或不存在的交易  池内双花的交易只保留一笔，最后重建花费索引
+func pruneMempool(utxoSet *UTXOSet) {
+
+	nextHeight := utxoSet.Blockchain.GetBestHeight() + 1
+
+	for {
+
+		removed := false
+		// 交易池中已被引用的输出，防止池内双花
+		spent := make(map[string]bool)
+
+		for id, tx := range memTxPool {
+
+			valid := true
+			for _, in := range tx.Vins {
+
+				outpoint := fmt.Sprintf("%x:%d", in.TxHash, in.Vout)
+				_, inPool := memTxPool[hex.EncodeToString(in.TxHash)]
+
+				if spent[outpoint] {
+
+					valid = false
+					break
+				}
+
+				// 引用链上输出时输出必须存在，且创币交易的输出在下一个区块已经成熟
+				if inPool == false {
+
+					utxo := utxoSet.FindUTXO(in.TxHash, in.Vout)
+					if utxo == nil || utxo.IsMature(nextHeight) == false {
+
+						valid = false
+						break
+					}
+				}
+				spent[outpoint] = true
+			}
+
+			// 链重组后高度或时间可能回退，时间锁重新变为未到期
+			if valid && utxoSet.CheckTransactionLocks(&tx) != nil {
+
+				valid = false
+			}
+
+			if valid == false {
+
+				fmt.Printf("Remove tx %x from mempool: inputs are no longer available, immature or locked\n", tx.TxHash)
+				removeMempoolTx(id)
+				removed = true
+			}
+		}
+
+		// 删除一笔交易可能导致依赖它的交易失效，直到没有变化为止
+		if removed == false {
+
+			break
+		}
+	}
+
+	memTxSpends = make(map[string]string)
+	for id := range memTxPool {
+
+		tx := memTxPool[id]
+		addMempoolTx(&tx)
+	}
+}
+
+// 按手续费率从高到低选取交易池中的交易打包，区块大小不超过maxBlockSize
+// 交易依赖交易池中另一笔交易时，被依赖的交易排在前面  与已选交易花费同一输出的交易跳过
+func selectMempoolTransactions(utxoSet *UTXOSet) []*Transaction {
+
+	pool := mempoolTransactions()
+
+	// 每笔交易的手续费率  每千字节的手续费
+	feeRates := make(map[string]int64)
+	for _, tx := range pool {
+
+		fee := utxoSet.TransactionFee(tx, pool)
+		feeRates[hex.EncodeToString(tx.TxHash)] = fee * 1000 / int64(len(tx.Serialize()))
+	}
+
+	sort.SliceStable(pool, func(i, j int) bool {
+
+		return feeRates[hex.EncodeToString(pool[i].TxHash)] > feeRates[hex.EncodeToString(pool[j].TxHash)]
+	})
+
+	var selected []*Transaction
+	added := make(map[string]bool)
+	// 已选交易花费的输出  同一个输出只能被区块中的一笔交易花费
+	spent := make(map[string]bool)
+	size := blockReservedSize
+
+	// 先加入交易依赖的池内交易，再加入交易本身
+	var add func(tx *Transaction) bool
+	add = func(tx *Transaction) bool {
+
+		id := hex.EncodeToString(tx.TxHash)
+		if added[id] {
+
+			return true
+		}
+
+		for _, in := range tx.Vins {
+
+			parent, inPool := memTxPool[hex.EncodeToString(in.TxHash)]
+			if inPool && add(&parent) == false {
+
+				return false
+			}
+		}
+
+		for _, in := range tx.Vins {
+
+			if spent[fmt.Sprintf("%x:%d", in.TxHash, in.Vout)] {
+
+				return false
+			}
+		}
+
+		txSize := len(tx.Serialize())
+		if size+txSize > maxBlockSize {
+
+			return false
+		}
+
+		size += txSize
+		added[id] = true
+		selected = append(selected, tx)
+		for _, in := range tx.Vins {
+
+			spent[fmt.Sprintf("%x:%d", in.TxHash, in.Vout)] = true
+		}
+
+		return true
+	}
+
+	for _, tx := range pool {
+
+		add(tx)
+	}
+
+	return selected
+}
+
+// 交易池中的所有交易
+func mempoolTransactions() []*Transaction {
+
+	var txs []*Transaction
+	for id := range memTxPool {
+
+		tx := memTxPool[id]
+		txs = append(txs, &tx)
+	}
+
+	return txs
+}
+
+// 交易引用的创币交易输出在下一个区块是否已经成熟
+func checkMempoolMaturity(utxoSet *UTXOSet, tx *Transaction) error {
+
+	nextHeight := utxoSet.Blockchain.GetBestHeight() + 1
+
+	for _, in := range tx.Vins {
+
+		utxo := utxoSet.FindUTXO(in.TxHash, in.Vout)
+		if utxo != nil && utxo.IsMature(nextHeight) == false {
+
+			return fmt.Errorf("spends immature coinbase %x from height %d", utxo.TxHash, utxo.Height)
+		}
+	}
+
+	return nil
+}
+
+// 交易每个输入引用的输出  从交易池或UTXOSet中查找，有输入找不到时返回错误
+// 找不到可能是已经花费，也可能是还没收到被依赖的交易，不算对方的错误
+func mempoolPrevOuts(utxoSet *UTXOSet, tx *Transaction) ([]*TXOutput, error) {
+
+	var prevOuts []*TXOutput
+	for _, in := range tx.Vins {
+
+		if prevTx, ok := memTxPool[hex.EncodeToString(in.TxHash)]; ok && in.Vout >= 0 && in.Vout < len(prevTx.Vouts) {
+
+			prevOuts = append(prevOuts, prevTx.Vouts[in.Vout])
+			continue
+		}
+
+		utxo := utxoSet.FindUTXO(in.TxHash, in.Vout)
+		if utxo == nil {
+
+			return nil, fmt.Errorf("input %x:%d is missing or spent", in.TxHash, in.Vout)
+		}
+		prevOuts = append(prevOuts, utxo.Output)
+	}
+
+	return prevOuts, nil
+}
@@ -0,0 +1,268 @@
+package BLC
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+// 使用空的交易池和指定的挖矿地址，测试结束后恢复
+func useTestMempool(t *testing.T, address string) {
+
+	savedPool, savedSpends, savedAddress := memTxPool, memTxSpends, miningAddress
+	memTxPool = make(map[string]Transaction)
+	memTxSpends = make(map[string]string)
+	miningAddress = address
+	t.Cleanup(func() {
+
+		memTxPool, memTxSpends, miningAddress = savedPool, savedSpends, savedAddress
+	})
+}
+
+func TestMempoolReadyToMine(t *testing.T) {
+
+	useTestMempool(t, "")
+
+	tx := vectorTx()
+	addMempoolTx(tx)
+	if mempoolReadyToMine() {
+
+		t.Errorf("ready to mine without a mining address")
+	}
+
+	miningAddress = testAddress()
+	if mempoolReadyToMine() == false {
+
+		t.Errorf("not ready to mine with %d txs", len(memTxPool))
+	}
+
+	removeMempoolTx(hex.EncodeToString(tx.TxHash))
+	if mempoolReadyToMine() {
+
+		t.Errorf("ready to mine with an empty mempool")
+	}
+}
+
+// 交易池中两笔交易花费同一个输出时只打包一笔，另一笔在区块连接后被剔除，挖矿循环随之结束
+func TestMineMempoolConflict(t *testing.T) {
+
+	blc := newTestBlockchain(t)
+	useTestMempool(t, testAddress())
+
+	alice := NewWallet()
+	fund := fundTestWallets(t, blc, 10, alice)
+	tx1 := newTestSpend(t, blc, alice, fund, 0, NewTXOutput(9, testAddress()))
+	tx2 := newTestSpend(t, blc, alice, fund, 0, NewTXOutput(8, testAddress()))
+	addMempoolTx(tx1)
+	addMempoolTx(tx2)
+	height := blc.GetBestHeight()
+
+	mineMempool(blc)
+
+	if blc.GetBestHeight() != height+1 {
+
+		t.Fatalf("height %d, want one block at %d", blc.GetBestHeight(), height+1)
+	}
+	block := tipBlock(t, blc)
+	if len(block.Txs) != 2 || (bytes.Compare(block.Txs[1].TxHash, tx1.TxHash) != 0 && bytes.Compare(block.Txs[1].TxHash, tx2.TxHash) != 0) {
+
+		t.Errorf("block txs %d, want coinbase and one of the conflicting txs", len(block.Txs))
+	}
+	if len(memTxPool) != 0 || len(memTxSpends) != 0 {
+
+		t.Errorf("mempool %d txs, %d spends after mining", len(memTxPool), len(memTxSpends))
+	}
+	if atomic.LoadInt32(&isMining) != 0 {
+
+		t.Errorf("mining flag still set")
+	}
+	assertUTXOSetMatchesReindex(t, blc)
+}
+
+// 已经在挖矿时不启动新的挖矿任务
+func TestMineMempoolWhileMining(t *testing.T) {
+
+	blc := newTestBlockchain(t)
+	useTestMempool(t, testAddress())
+
+	alice := NewWallet()
+	fund := fundTestWallets(t, blc, 10, alice)
+	addMempoolTx(newTestSpend(t, blc, alice, fund, 0, NewTXOutput(9, testAddress())))
+	height := blc.GetBestHeight()
+
+	atomic.StoreInt32(&isMining, 1)
+	defer atomic.StoreInt32(&isMining, 0)
+	mineMempool(blc)
+
+	if blc.GetBestHeight() != height || len(memTxPool) != 1 {
+
+		t.Errorf("mined while another mining task was running")
+	}
+}
+
+func TestTransactionFeeForSize(t *testing.T) {
+
+	tests := []struct {
+		size    int
+		feeRate int64
+		fee     int64
+	}{
+		{0, 1, 0},
+		{1, 1, 1},
+		{999, 1, 1},
+		{1000, 1, 1},
+		{1001, 1, 2},
+		{250, 1000, 250},
+		{250, 0, 0},
+	}
+
+	for _, test := range tests {
+
+		if fee := TransactionFeeForSize(test.size, test.feeRate); fee != test.fee {
+
+			t.Errorf("size %d rate %d: fee %d, want %d", test.size, test.feeRate, fee, test.fee)
+		}
+	}
+}
+
+// 加上手续费后交易变大，需要的手续费随之增加，直到手续费够用为止
+func TestBuildTransactionWithFee(t *testing.T) {
+
+	var fees []int64
+	tx, err := buildTransactionWithFee(1000, func(fee int64) (*Transaction, int, error) {
+
+		fees = append(fees, fee)
+		// 不加手续费时1000字节，加上手续费后变成1001字节，需要再构造一次
+		size := 1000
+		if fee > 0 {
+
+			size = 1001
+		}
+
+		return &Transaction{TxHash: []byte{byte(len(fees))}}, size, nil
+	})
+	if err != nil || reflect.DeepEqual(fees, []int64{0, 1000, 1001}) == false || tx.TxHash[0] != 3 {
+
+		t.Errorf("fees %v, tx %v, %v", fees, tx, err)
+	}
+
+	buildErr := errors.New("insufficient funds")
+	_, err = buildTransactionWithFee(1, func(fee int64) (*Transaction, int, error) {
+
+		return nil, 0, buildErr
+	})
+	if err != buildErr {
+
+		t.Errorf("build error %v, want %v", err, buildErr)
+	}
+}
+
+// 交易池的花费索引  同一个输出只登记一笔交易
+func TestMempoolConflict(t *testing.T) {
+
+	blc := newTestBlockchain(t)
+	useTestMempool(t, "")
+
+	alice := NewWallet()
+	fund := fundTestWallets(t, blc, 10, alice)
+	tx1 := newTestSpend(t, blc, alice, fund, 0, NewTXOutput(9, testAddress()))
+	tx2 := newTestSpend(t, blc, alice, fund, 0, NewTXOutput(8, testAddress()))
+
+	addMempoolTx(tx1)
+	id1 := hex.EncodeToString(tx1.TxHash)
+	if mempoolConflict(tx2) != id1 {
+
+		t.Errorf("conflict %q, want %s", mempoolConflict(tx2), id1)
+	}
+	if mempoolConflict(tx1) != "" {
+
+		t.Errorf("tx conflicts with itself")
+	}
+
+	removeMempoolTx(id1)
+	if mempoolConflict(tx2) != "" || len(memTxSpends) != 0 {
+
+		t.Errorf("spends %v left after removing the tx", memTxSpends)
+	}
+}
+
+// 池内交易：low(手续费1)、依赖low的child(手续费8)、high(手续费5)，rival(手续费2)与low花费同一个输出
+func testMempoolFamily(t *testing.T, blc *Blockchain) (low, child, high, rival *Transaction) {
+
+	alice := NewWallet()
+	bob := NewWallet()
+	carol := NewWallet()
+	fund := fundTestWallets(t, blc, 10, alice, bob)
+
+	low = newTestSpend(t, blc, alice, fund, 0, NewTXOutput(9, string(carol.GetAddress())))
+	child = newTestSpend(t, blc, carol, low, 0, NewTXOutput(1, testAddress()))
+	high = newTestSpend(t, blc, bob, fund, 1, NewTXOutput(5, testAddress()))
+	rival = newTestSpend(t, blc, alice, fund, 0, NewTXOutput(8, testAddress()))
+
+	return low, child, high, rival
+}
+
+// 按手续费率打包，被依赖的交易排在前面，冲突的交易不打包
+func TestSelectMempoolTransactions(t *testing.T) {
+
+	blc := newTestBlockchain(t)
+	useTestMempool(t, "")
+
+	low, child, high, rival := testMempoolFamily(t, blc)
+	for _, tx := range []*Transaction{low, child, high, rival} {
+
+		memTxPool[hex.EncodeToString(tx.TxHash)] = *tx
+	}
+
+	var got [][]byte
+	for _, tx := range selectMempoolTransactions(&UTXOSet{blc}) {
+
+		got = append(got, tx.TxHash)
+	}
+	if want := [][]byte{low.TxHash, child.TxHash, high.TxHash}; reflect.DeepEqual(got, want) == false {
+
+		t.Errorf("selected %x, want low %x, child %x, high %x", got, low.TxHash, child.TxHash, high.TxHash)
+	}
+}
+
+// 池内双花只保留一笔，依赖被剔除交易的交易一起剔除
+func TestPruneMempool(t *testing.T) {
+
+	blc := newTestBlockchain(t)
+	useTestMempool(t, "")
+
+	low, child, high, rival := testMempoolFamily(t, blc)
+	for _, tx := range []*Transaction{low, child, high, rival} {
+
+		memTxPool[hex.EncodeToString(tx.TxHash)] = *tx
+	}
+
+	// high的输入已经在链上被花费
+	parent := tipBlock(t, blc)
+	appendTestBlock(t, blc, mineTestBlockWithTxs(t, blc, parent, []*Transaction{NewCoinbaseTransaction(testAddress(), parent.Height+1, 0), high}))
+
+	pruneMempool(&UTXOSet{blc})
+
+	_, hasLow := memTxPool[hex.EncodeToString(low.TxHash)]
+	_, hasChild := memTxPool[hex.EncodeToString(child.TxHash)]
+	_, hasHigh := memTxPool[hex.EncodeToString(high.TxHash)]
+	_, hasRival := memTxPool[hex.EncodeToString(rival.TxHash)]
+	if hasHigh || hasLow == hasRival || hasChild != hasLow {
+
+		t.Errorf("mempool low %v child %v high %v rival %v", hasLow, hasChild, hasHigh, hasRival)
+	}
+
+	// 花费索引与剩下的交易一致
+	spends := 0
+	for _, tx := range memTxPool {
+
+		spends += len(tx.Vins)
+	}
+	if len(memTxSpends) != spends {
+
+		t.Errorf("%d spends for %d inputs", len(memTxSpends), spends)
+	}
+}
@@ -1,6 +1,10 @@
 package BLC
 
-import "crypto/sha256"
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
 
 //默克尔树
 type MerkleTree struct {
@@ -40,18 +44,29 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	return &mNode
 }
 
-// 1 2 3 --> 1 2 3 3
-//新建默克尔树
-func NewMerkleTree(datas [][]byte) *MerkleTree {
+//默克尔树为空
+var ErrMerkleTreeEmpty = errors.New("merkle tree has no leaves")
 
-	var nodes []*MerkleNode
+//默克尔树被篡改  同一层中两个相邻的真实节点相同
+var ErrMerkleTreeMutated = errors.New("merkle tree has duplicate sibling nodes")
+
+// 1 2 3 --> (1 2) (3 3) --> (12 33)
+//新建默克尔树
+//每一层节点数为奇数时复制最后一个节点拼凑为偶数个，两两组合生成上一层，直到只剩根节点
+//只有一个叶子时也与自己组合一次
+//
+//复制最后一个节点会导致 1 2 3 和 1 2 3 3 得到相同的默克尔根(CVE-2012-2459)，
+//所以同一层中两个相邻的真实节点相同时返回ErrMerkleTreeMutated，树仍然完整构造
+func NewMerkleTree(datas [][]byte) (*MerkleTree, error) {
 
-	//如果是奇数，添加最后一个交易哈希拼凑为偶数个交易
-	if len(datas) % 2 != 0 {
+	if len(datas) == 0 {
 
-		datas = append(datas, datas[len(datas)-1])
+		return nil, ErrMerkleTreeEmpty
 	}
 
+	var nodes []*MerkleNode
+	var mutated bool
+
 	//将每一个交易哈希构造为默克尔树节点
 	for _, data := range datas {
 
@@ -59,8 +74,23 @@ func NewMerkleTree(datas [][]byte) *MerkleTree {
 		nodes = append(nodes, node)
 	}
 
-	//将所有节点两两组合生成新节点，直到最后只有一个更节点
-	for i := 0; i < len(datas)/2; i++ {
+	//将所有节点两两组合生成新节点，直到最后只有一个根节点
+	for {
+
+		//相邻的真实节点相同说明叶子被重复过
+		for j := 0; j+1 < len(nodes); j += 2 {
+
+			if bytes.Compare(nodes[j].Data, nodes[j+1].Data) == 0 {
+
+				mutated = true
+			}
+		}
+
+		//如果是奇数，复制最后一个节点拼凑为偶数个
+		if len(nodes)%2 != 0 {
+
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
 
 		var newLevel []*MerkleNode
 
@@ -71,12 +101,73 @@ func NewMerkleTree(datas [][]byte) *MerkleTree {
 		}
 
 		nodes = newLevel
+		if len(nodes) == 1 {
+
+			break
+		}
 	}
 
 	//取根节点返回
 	mTree := MerkleTree{nodes[0]}
 
-	return &mTree
+	if mutated {
+
+		return &mTree, ErrMerkleTreeMutated
+	}
+
+	return &mTree, nil
+}
+
+//某个叶子数据到根节点路径上的兄弟节点哈希  从叶子开始
+//index的第i位为1表示第i层路径上的节点是右节点
+func (mTree *MerkleTree) MerkleBranch(data []byte) (branch [][]byte, index int, ok bool) {
+
+	leaf := sha256.Sum256(data)
+
+	return merklePath(mTree.RootNode, leaf[:])
 }
 
+func merklePath(node *MerkleNode, leaf []byte) ([][]byte, int, bool) {
 
+	if node.Left == nil && node.Right == nil {
+
+		return nil, 0, bytes.Compare(node.Data, leaf) == 0
+	}
+
+	//叶子重复时取最左边的一个
+	if branch, index, ok := merklePath(node.Left, leaf); ok {
+
+		return append(branch, node.Right.Data), index, true
+	}
+
+	if branch, index, ok := merklePath(node.Right, leaf); ok {
+
+		return append(branch, node.Left.Data), index | 1<<uint(len(branch)), true
+	}
+
+	return nil, 0, false
+}
+
+//验证默克尔证明  用叶子数据和兄弟节点哈希逐层计算，结果与默克尔根相同则交易在区块中
+//区块的叶子数据为交易的SignedHash，index为交易在区块中的位置，branch为从叶子开始的兄弟节点哈希
+func VerifyMerkleProof(root []byte, data []byte, branch [][]byte, index int) bool {
+
+	if index < 0 || len(branch) >= 31 || index >= 1<<uint(len(branch)) {
+
+		return false
+	}
+
+	hash := sha256.Sum256(data)
+	for i, sibling := range branch {
+
+		if index>>uint(i)&1 == 1 {
+
+			hash = sha256.Sum256(append(append([]byte{}, sibling...), hash[:]...))
+		} else {
+
+			hash = sha256.Sum256(append(append([]byte{}, hash[:]...), sibling...))
+		}
+	}
+
+	return bytes.Compare(hash[:], root) == 0
+}
@@ -0,0 +1,132 @@
+package BLC
+
+import (
+	"bytes"
+	"testing"
+)
+
+// n个互不相同的叶子
+func merkleTestLeaves(n int) [][]byte {
+
+	var leaves [][]byte
+	for i := 0; i < n; i++ {
+
+		leaves = append(leaves, []byte{byte(i), byte(i >> 8), 0xa5})
+	}
+
+	return leaves
+}
+
+// 每个叶子生成的证明都能验证通过，位置正确；换位置或换叶子都不能通过
+func TestMerkleBranchAllLeaves(t *testing.T) {
+
+	for n := 1; n <= 64; n++ {
+
+		leaves := merkleTestLeaves(n)
+		tree, err := NewMerkleTree(leaves)
+		if err != nil {
+
+			t.Fatalf("n=%d: %s", n, err)
+		}
+		root := tree.RootNode.Data
+
+		for i, leaf := range leaves {
+
+			branch, index, ok := tree.MerkleBranch(leaf)
+			if ok == false || index != i {
+
+				t.Fatalf("n=%d leaf %d: ok=%v index=%d", n, i, ok, index)
+			}
+
+			if VerifyMerkleProof(root, leaf, branch, index) == false {
+
+				t.Fatalf("n=%d leaf %d: proof rejected", n, i)
+			}
+
+			// 最后一个叶子没有真实的兄弟节点时与自己组合，换到相邻位置结果相同
+			if i^1 < n && VerifyMerkleProof(root, leaf, branch, index^1) {
+
+				t.Fatalf("n=%d leaf %d: proof accepted at the wrong index", n, i)
+			}
+
+			if VerifyMerkleProof(root, []byte("not a leaf"), branch, index) {
+
+				t.Fatalf("n=%d leaf %d: proof accepted for another leaf", n, i)
+			}
+		}
+
+		if _, _, ok := tree.MerkleBranch([]byte("not a leaf")); ok {
+
+			t.Fatalf("n=%d: branch found for a missing leaf", n)
+		}
+	}
+}
+
+func TestMerkleTreeEmpty(t *testing.T) {
+
+	tree, err := NewMerkleTree(nil)
+	if err != ErrMerkleTreeEmpty || tree != nil {
+
+		t.Fatalf("got %v, %v", tree, err)
+	}
+}
+
+// CVE-2012-2459：叶子数为奇数时重复最后一个叶子得到相同的默克尔根，必须报告被篡改
+func TestMerkleTreeMutated(t *testing.T) {
+
+	for n := 1; n <= 64; n += 2 {
+
+		leaves := merkleTestLeaves(n)
+		tree, err := NewMerkleTree(leaves)
+		if err != nil {
+
+			t.Fatalf("n=%d: %s", n, err)
+		}
+
+		mutated, err := NewMerkleTree(append(leaves, leaves[n-1]))
+		if err != ErrMerkleTreeMutated {
+
+			t.Fatalf("n=%d: got %v, want %v", n, err, ErrMerkleTreeMutated)
+		}
+
+		if bytes.Compare(mutated.RootNode.Data, tree.RootNode.Data) != 0 {
+
+			t.Fatalf("n=%d: duplicated leaf changed the root", n)
+		}
+	}
+}
+
+// 中间层节点数为奇数时，重复最后一棵完整子树的全部叶子也得到相同的默克尔根
+// 这时叶子层没有相邻的相同叶子，只有逐层检查才能发现，例如 1..6 和 1..6 5 6
+func TestMerkleTreeMutatedIntermediate(t *testing.T) {
+
+	for n := 2; n <= 64; n++ {
+
+		for width := 2; width < n; width *= 2 {
+
+			// 宽度为width的子树都是完整的，并且这一层的节点数为奇数
+			if n%width != 0 || (n/width)%2 == 0 {
+
+				continue
+			}
+
+			leaves := merkleTestLeaves(n)
+			tree, err := NewMerkleTree(leaves)
+			if err != nil {
+
+				t.Fatalf("n=%d: %s", n, err)
+			}
+
+			mutated, err := NewMerkleTree(append(leaves, leaves[n-width:]...))
+			if err != ErrMerkleTreeMutated {
+
+				t.Fatalf("n=%d width=%d: got %v, want %v", n, width, err, ErrMerkleTreeMutated)
+			}
+
+			if bytes.Compare(mutated.RootNode.Data, tree.RootNode.Data) != 0 {
+
+				t.Fatalf("n=%d width=%d: duplicated subtree changed the root", n, width)
+			}
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package BLC
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 挖矿线程每计算多少次哈希检查一次是否被取消
+const minerCheckInterval = 1 << 12
+
+// 单轮搜索的Nonce上限，全部用完后更新时间戳重新搜索
+const maxNonce = math.MaxUint32
+
+// 输出哈希率的间隔
+const hashRateInterval = 2 * time.Second
+
+// 挖矿线程数，默认为CPU核数
+var minerWorkers = runtime.NumCPU()
+
+// 主链顶端变化时关闭该通道通知正在挖矿的线程
+var tipChanged = make(chan struct{})
+var tipChangedLock sync.Mutex
+
+// 找到的有效Nonce
+type nonceResult struct {
+	hash  []byte
+	nonce int64
+}
+
+// 设置挖矿线程数
+func SetMinerWorkers(workers int) {
+
+	if workers > 0 {
+
+		minerWorkers = workers
+	}
+}
+
+// 通知主链顶端已变化
+func notifyTipChanged() {
+
+	tipChangedLock.Lock()
+	defer tipChangedLock.Unlock()
+
+	close(tipChanged)
+	tipChanged = make(chan struct{})
+}
+
+// 返回一个在主链顶端变化时自动取消的context
+// 需要在读取链顶端之前调用，否则可能错过中间发生的变化
+func cancelOnTipChange(parent context.Context) (context.Context, context.CancelFunc) {
+
+	tipChangedLock.Lock()
+	changed := tipChanged
+	tipChangedLock.Unlock()
+
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+
+		select {
+		case <-changed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// 多线程挖矿  每个线程从不同起点按线程数步进搜索Nonce，ctx取消时返回ctx.Err()
+func (proofOfWork *ProofOfWork) Mine(ctx context.Context, workers int) ([]byte, int64, error) {
+
+	if workers < 1 {
+
+		workers = 1
+	}
+
+	fmt.Printf("正在挖矿... 线程数:%d\n", workers)
+
+	// 已计算的哈希次数
+	var hashes uint64
+	start := time.Now()
+
+	// 定期输出哈希率
+	reportDone := make(chan struct{})
+	defer close(reportDone)
+	go func() {
+
+		ticker := time.NewTicker(hashRateInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				fmt.Printf("\r哈希率:%.2f kH/s", hashRate(atomic.LoadUint64(&hashes), time.Since(start)))
+			case <-reportDone:
+				return
+			}
+		}
+	}()
+
+	for {
+
+		result := proofOfWork.searchRound(ctx, workers, &hashes)
+		if result != nil {
+
+			fmt.Printf("\r共计算%d次哈希，耗时%s，哈希率:%.2f kH/s\n",
+				hashes, time.Since(start).Round(time.Millisecond), hashRate(hashes, time.Since(start)))
+
+			return result.hash, result.nonce, nil
+		}
+
+		if ctx.Err() != nil {
+
+			fmt.Println("\r挖矿已取消")
+			return nil, 0, ctx.Err()
+		}
+
+		// Nonce用完，更新时间戳后区块头变化，重新搜索
+		timestamp := time.Now().Unix()
+		if timestamp <= proofOfWork.Block.Timestamp {
+
+			timestamp = proofOfWork.Block.Timestamp + 1
+		}
+		proofOfWork.Block.Timestamp = timestamp
+		fmt.Printf("\rNonce已用完，更新时间戳为%d\n", timestamp)
+	}
+}
+
+// 在当前区块头上搜索一轮Nonce，没有找到返回nil
+func (proofOfWork *ProofOfWork) searchRound(ctx context.Context, workers int, hashes *uint64) *nonceResult {
+
+	roundCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make(chan *nonceResult, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+
+		wg.Add(1)
+		go func(first int64) {
+
+			defer wg.Done()
+			proofOfWork.searchNonce(roundCtx, first, int64(workers), hashes, found)
+		}(int64(i))
+	}
+
+	done := make(chan struct{})
+	go func() {
+
+		wg.Wait()
+		close(done)
+	}()
+
+	var result *nonceResult
+	select {
+	case result = <-found:
+	case <-done:
+	}
+
+	// 通知其他线程退出并等待
+	cancel()
+	<-done
+
+	if result == nil {
+
+		select {
+		case result = <-found:
+		default:
+		}
+	}
+
+	return result
+}
+
+// 单个挖矿线程  区块头只编码一次，每次尝试只改写Nonce所在的8个字节
+func (proofOfWork *ProofOfWork) searchNonce(ctx context.Context, first int64, step int64, hashes *uint64, found chan<- *nonceResult) {
+
+	dataBytes := proofOfWork.prepareData(first)
+
+	var hashInt big.Int
+	var count uint64
+
+	for nonce := first; nonce <= maxNonce; nonce += step {
+
+		if count == minerCheckInterval {
+
+			atomic.AddUint64(hashes, count)
+			count = 0
+
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+		}
+
+		binary.LittleEndian.PutUint64(dataBytes[blockHeaderNonceOffset:], uint64(nonce))
+		hash := sha256.Sum256(dataBytes)
+		count++
+
+		hashInt.SetBytes(hash[:])
+		if proofOfWork.target.Cmp(&hashInt) == 1 {
+
+			atomic.AddUint64(hashes, count)
+			found <- &nonceResult{hash[:], nonce}
+			return
+		}
+	}
+
+	atomic.AddUint64(hashes, count)
+}
+
+// 每秒计算的哈希次数(千次)
+func hashRate(hashes uint64, elapsed time.Duration) float64 {
+
+	if elapsed <= 0 {
+
+		return 0
+	}
+
+	return float64(hashes) / elapsed.Seconds() / 1000
+}
@@ -0,0 +1,259 @@
+package BLC
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"fmt"
+)
+
+/**
+多重签名交易  花费P2SH多重签名地址中的币
+
+1.任意一方根据赎回脚本构造未签名的交易，每个输入的解锁脚本为 OP_0 x n <赎回脚本>
+  每个OP_0是一个签名位置，与赎回脚本中的公钥一一对应
+2.各签名方分别用自己的私钥填入对应位置的签名，签名数据不依赖解锁脚本，签名顺序任意
+3.签名数达到m后，去掉空位置得到 <签名1>...<签名m> <赎回脚本>，计算交易哈希后广播
+*/
+
+//构造未签名的多重签名交易  找零回到多重签名地址
+func NewMultiSigTransaction(redeemScript []byte, to string, amount int64, feeRate int64, utxoSet *UTXOSet, txs []*Transaction) (*Transaction, error) {
+
+	m, publicKeys, ok := ExtractMultiSig(redeemScript)
+	if ok == false {
+
+		return nil, fmt.Errorf("not a multisig redeem script")
+	}
+
+	from := string(ScriptHashAddress(redeemScript))
+
+	//交易大小按签名完成后计算
+	tx, err := buildTransactionWithFee(feeRate, func(fee int64) (*Transaction, int, error) {
+
+		money, spendableUTXODic := utxoSet.FindSpendableUTXOs(from, amount+fee, txs)
+
+		var txInputs []*TXInput
+		var txOutputs []*TXOutput
+
+		for TxHash, indexArr := range spendableUTXODic {
+
+			TxHashBytes, _ := hex.DecodeString(TxHash)
+			for _, index := range indexArr {
+
+				txInputs = append(txInputs, &TXInput{TxHashBytes, index, multiSigPlaceholderScript(len(publicKeys), redeemScript), MaxTxInSequenceNum})
+			}
+		}
+
+		txOutputs = append(txOutputs, NewTXOutput(amount, to))
+		if change := money - amount - fee; change > 0 {
+
+			txOutputs = append(txOutputs, NewTXOutput(change, from))
+		}
+
+		tx := &Transaction{[]byte{}, txInputs, txOutputs, TxVersion, 0}
+
+		return tx, multiSigFinalSize(tx, m, redeemScript), nil
+	})
+	if err != nil {
+
+		return nil, err
+	}
+	tx.HashTransactions()
+
+	return tx, nil
+}
+
+//未签名的解锁脚本  n个空签名位置加赎回脚本
+func multiSigPlaceholderScript(n int, redeemScript []byte) []byte {
+
+	builder := newScriptBuilder()
+	for i := 0; i < n; i++ {
+
+		builder.AddOp(OP_0)
+	}
+
+	return builder.AddData(redeemScript).Script()
+}
+
+//签名完成后的交易大小  每个输入包含m个64字节签名和赎回脚本
+func multiSigFinalSize(tx *Transaction, m int, redeemScript []byte) int {
+
+	txCopy := tx.TrimmedCopy()
+	for _, vin := range txCopy.Vins {
+
+		builder := newScriptBuilder()
+		for i := 0; i < m; i++ {
+
+			builder.AddData(make([]byte, 64))
+		}
+		vin.ScriptSig = builder.AddData(redeemScript).Script()
+	}
+	txCopy.HashTransactions()
+
+	return len(txCopy.Serialize())
+}
+
+//取出多重签名输入的签名位置和赎回脚本
+func multiSigSlots(scriptSig []byte) ([][]byte, []byte, error) {
+
+	ops, err := parseScript(scriptSig)
+	if err != nil {
+
+		return nil, nil, err
+	}
+	if len(ops) == 0 || isPushOnly(ops) == false {
+
+		return nil, nil, fmt.Errorf("unlocking script is not push only")
+	}
+
+	redeemScript := ops[len(ops)-1].data
+	_, publicKeys, ok := ExtractMultiSig(redeemScript)
+	if ok == false {
+
+		return nil, nil, fmt.Errorf("not a multisig redeem script")
+	}
+
+	var slots [][]byte
+	for _, op := range ops[:len(ops)-1] {
+
+		slots = append(slots, op.data)
+	}
+	if len(slots) != len(publicKeys) {
+
+		return nil, nil, fmt.Errorf("%d signature slots for %d public keys", len(slots), len(publicKeys))
+	}
+
+	return slots, redeemScript, nil
+}
+
+//用一个私钥为多重签名交易签名  返回签名的输入个数，私钥不属于该多重签名时返回错误
+func (tx *Transaction) SignMultiSig(privateKey ecdsa.PrivateKey) (int, error) {
+
+	publicKey := publicKeyBytes(&privateKey.PublicKey)
+
+	signed := 0
+	for inID, vin := range tx.Vins {
+
+		slots, redeemScript, err := multiSigSlots(vin.ScriptSig)
+		if err != nil {
+
+			return signed, fmt.Errorf("input %d: %s", inID, err)
+		}
+
+		//找到自己的签名位置
+		_, publicKeys, _ := ExtractMultiSig(redeemScript)
+		keyIndex := -1
+		for i, key := range publicKeys {
+
+			if string(key) == string(publicKey) {
+
+				keyIndex = i
+				break
+			}
+		}
+		if keyIndex < 0 {
+
+			return signed, fmt.Errorf("input %d: key is not part of the multisig", inID)
+		}
+
+		//被签名的脚本为赎回脚本
+		signature, err := signHash(&privateKey, tx.SignatureHash(inID, redeemScript))
+		if err != nil {
+
+			return signed, err
+		}
+
+		slots[keyIndex] = signature
+		tx.Vins[inID].ScriptSig = multiSigScriptFromSlots(slots, redeemScript, false)
+		signed++
+	}
+
+	tx.HashTransactions()
+
+	return signed, nil
+}
+
+//按签名位置重新生成解锁脚本  final为true时去掉空位置
+func multiSigScriptFromSlots(slots [][]byte, redeemScript []byte, final bool) []byte {
+
+	builder := newScriptBuilder()
+	for _, slot := range slots {
+
+		if len(slot) == 0 {
+
+			if final == false {
+
+				builder.AddOp(OP_0)
+			}
+			continue
+		}
+		builder.AddData(slot)
+	}
+
+	return builder.AddData(redeemScript).Script()
+}
+
+//统计每个输入已有的签名数和需要的签名数，取所有输入中最少的已有签名数
+func (tx *Transaction) MultiSigStatus() (have int, need int, err error) {
+
+	have = -1
+	for inID, vin := range tx.Vins {
+
+		slots, redeemScript, err := multiSigSlots(vin.ScriptSig)
+		if err != nil {
+
+			return 0, 0, fmt.Errorf("input %d: %s", inID, err)
+		}
+
+		m, _, _ := ExtractMultiSig(redeemScript)
+		count := 0
+		for _, slot := range slots {
+
+			if len(slot) != 0 {
+
+				count++
+			}
+		}
+
+		if have < 0 || count < have {
+
+			have = count
+		}
+		need = m
+	}
+
+	return have, need, nil
+}
+
+//签名完成  每个输入只保留前m个签名，重新计算交易哈希
+func (tx *Transaction) FinalizeMultiSig() error {
+
+	for inID, vin := range tx.Vins {
+
+		slots, redeemScript, err := multiSigSlots(vin.ScriptSig)
+		if err != nil {
+
+			return fmt.Errorf("input %d: %s", inID, err)
+		}
+
+		m, _, _ := ExtractMultiSig(redeemScript)
+
+		var signatures [][]byte
+		for _, slot := range slots {
+
+			if len(slot) != 0 && len(signatures) < m {
+
+				signatures = append(signatures, slot)
+			}
+		}
+		if len(signatures) < m {
+
+			return fmt.Errorf("input %d: %d of %d signatures", inID, len(signatures), m)
+		}
+
+		tx.Vins[inID].ScriptSig = multiSigScriptFromSlots(signatures, redeemScript, true)
+	}
+
+	tx.HashTransactions()
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package BLC
+
+import (
+	"bytes"
+	"testing"
+)
+
+// 2-of-3多重签名  签名方按任意顺序签名，完成后的解锁脚本按公钥顺序排列，交易可以打包
+func TestMultiSigSpend(t *testing.T) {
+
+	blc := newTestBlockchain(t)
+	utxoSet := &UTXOSet{blc}
+
+	var keys []*Wallet
+	var publicKeys [][]byte
+	for i := 0; i < 3; i++ {
+
+		wallet := NewWallet()
+		keys = append(keys, wallet)
+		publicKeys = append(publicKeys, wallet.PublicKey)
+	}
+	redeemScript, err := MultiSigScript(2, publicKeys)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	// 先给多重签名地址转账
+	alice := NewWallet()
+	fund := fundTestWallets(t, blc, 10, alice)
+	deposit := newTestSpend(t, blc, alice, fund, 0, NewTXOutput(10, string(ScriptHashAddress(redeemScript))))
+	parent := tipBlock(t, blc)
+	appendTestBlock(t, blc, mineTestBlockWithTxs(t, blc, parent, []*Transaction{NewCoinbaseTransaction(testAddress(), parent.Height+1, 0), deposit}))
+
+	tx, err := NewMultiSigTransaction(redeemScript, testAddress(), 5, defaultFeeRate, utxoSet, nil)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	txHash := tx.TxHash
+	if have, need, err := tx.MultiSigStatus(); have != 0 || need != 2 || err != nil {
+
+		t.Fatalf("unsigned status %d of %d: %v", have, need, err)
+	}
+	if err := tx.FinalizeMultiSig(); err == nil {
+
+		t.Fatalf("finalized without signatures")
+	}
+
+	if _, err := tx.SignMultiSig(alice.PrivateKey); err == nil {
+
+		t.Errorf("signed with a key outside the multisig")
+	}
+
+	// 倒序签名，同一个私钥签两次只占一个位置
+	for _, wallet := range []*Wallet{keys[2], keys[2], keys[0]} {
+
+		if signed, err := tx.SignMultiSig(wallet.PrivateKey); signed != 1 || err != nil {
+
+			t.Fatalf("sign: %d inputs, %v", signed, err)
+		}
+	}
+	if have, need, err := tx.MultiSigStatus(); have != 2 || need != 2 || err != nil {
+
+		t.Fatalf("signed status %d of %d: %v", have, need, err)
+	}
+
+	// 三方都签名时只保留公钥顺序上的前两个签名
+	allSigned := DeserializeTransaction(tx.Serialize())
+	if _, err := allSigned.SignMultiSig(keys[1].PrivateKey); err != nil {
+
+		t.Fatal(err)
+	}
+	slots, _, err := multiSigSlots(allSigned.Vins[0].ScriptSig)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	if err := allSigned.FinalizeMultiSig(); err != nil {
+
+		t.Fatal(err)
+	}
+	if want := multiSigScriptFromSlots(slots[:2], redeemScript, true); bytes.Compare(allSigned.Vins[0].ScriptSig, want) != 0 {
+
+		t.Errorf("finalized script keeps signatures %x", allSigned.Vins[0].ScriptSig)
+	}
+
+	if err := tx.FinalizeMultiSig(); err != nil {
+
+		t.Fatal(err)
+	}
+	// 交易哈希不包含签名，签名过程中不变
+	if bytes.Compare(tx.TxHash, txHash) != 0 {
+
+		t.Errorf("txid changed from %x to %x while signing", txHash, tx.TxHash)
+	}
+
+	for _, signed := range []*Transaction{tx, &allSigned} {
+
+		err := signed.VerifyScripts([]*TXOutput{deposit.Vouts[0]})
+		if err != nil {
+
+			t.Fatalf("verify: %s", err)
+		}
+	}
+
+	parent = tipBlock(t, blc)
+	fee := utxoSet.TransactionFee(tx, nil)
+	mustAddBlock(t, blc, mineTestBlockWithTxs(t, blc, parent, []*Transaction{NewCoinbaseTransaction(testAddress(), parent.Height+1, fee), tx}))
+	if mature, _ := utxoSet.GetBalance(string(ScriptHashAddress(redeemScript))); mature != 10-5-fee {
+
+		t.Errorf("multisig change %d, want %d", mature, 10-5-fee)
+	}
+}
@@ -4,12 +4,10 @@ import (
 	"math/big"
 	"bytes"
 	"crypto/sha256"
-	"fmt"
+	"context"
+	"log"
 )
 
-//期望计算的Hash值前面至少要有16个零
-const targetBits = 18
-
 type ProofOfWork struct {
 	//求工作量的block
 	Block *Block
@@ -30,33 +28,31 @@ func NewProofOfWork(block *Block) *ProofOfWork {
 
 	//1.创建一个初始值为1的target
 	target := big.NewInt(1)
-	//2.左移bits(Hash) - targetBit 位
-	target = target.Lsh(target, 256-targetBits)
+	//2.左移bits(Hash) - targetBit 位  难度由区块自身携带
+	target = target.Lsh(target, uint(256-block.TargetBits))
 
 	return &ProofOfWork{block, target}
 }
 
-//拼接区块属性，返回字节数组
-func (pow *ProofOfWork) prepareData(nonce int) []byte {
-
-	data := bytes.Join(
-		[][]byte{
-			pow.Block.PrevBlockHash,
-			pow.Block.HashTransactions(),
-			IntToHex(pow.Block.Timestamp),
-			IntToHex(int64(targetBits)),
-			IntToHex(int64(nonce)),
-			IntToHex(int64(pow.Block.Height)),
-		},
-		[]byte{},
-	)
-
-	return data
+//区块头编码，返回字节数组  只有区块头参与哈希，与交易数量无关
+func (pow *ProofOfWork) prepareData(nonce int64) []byte {
+
+	header := pow.Block.BlockHeader
+	header.Nonce = nonce
+
+	return header.Serialize()
 }
 
 //判断当前区块是否有效
 func (proofOfWork *ProofOfWork) IsValid() bool  {
 
+	//用区块自身的Nonce重新计算哈希，防止伪造Hash字段
+	hash := sha256.Sum256(proofOfWork.prepareData(proofOfWork.Block.Nonce))
+	if bytes.Compare(hash[:], proofOfWork.Block.Hash) != 0 {
+
+		return false
+	}
+
 	//比较当前区块哈希值与目标哈希值
 	var hashInt big.Int
 	hashInt.SetBytes(proofOfWork.Block.Hash)
@@ -69,41 +65,14 @@ func (proofOfWork *ProofOfWork) IsValid() bool  {
 	return false
 }
 
-//运行工作量证明
+//运行工作量证明  不可取消，使用默认线程数
 func (proofOfWork *ProofOfWork) Run() ([]byte, int64) {
 
-	//1.将Block属性拼接成字节数组
-
-	//2.生成hash
-	//3.判断Hash值有效性，如果满足条件跳出循环
+	hash, nonce, err := proofOfWork.Mine(context.Background(), minerWorkers)
+	if err != nil {
 
-	//用于寻找目标hash值的随机数
-	nonce := 0
-	//存储新生成的Hash值
-	var hashInt big.Int
-	var hash [32]byte
-
-	fmt.Println("正在挖矿...")
-
-	for {
-		//准备数据
-		dataBytes := proofOfWork.prepareData(nonce)
-		//生成Hash
-		hash = sha256.Sum256(dataBytes)
-
-		//\r将当前打印行覆盖
-		fmt.Printf("\r%x", hash)
-		//存储Hash到hashInt
-		hashInt.SetBytes(hash[:])
-		//验证Hash
-		if proofOfWork.target.Cmp(&hashInt) == 1 {
-
-			break
-		}
-		nonce++
+		log.Panic(err)
 	}
 
-	return hash[:], int64(nonce)
+	return hash, nonce
 }
-
-
@@ -0,0 +1,541 @@
+package BLC
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+/**
+轻节点(SPV)  只保存区块头，不保存完整区块
+
+1.向全节点请求区块头，校验父区块、高度、难度、时间戳和工作量证明后保存，累计工作量最大的分支为主链
+2.向全节点请求与钱包地址有关的交易及其默克尔证明，证明的区块头在本地主链上才接受
+3.按区块高度依次应用这些交易，得到钱包的UTXO视图，用于查询余额
+*/
+
+//轻节点数据库
+const spvDBName = "chaorsSPV_%s.db"
+
+//区块头表  键为区块哈希
+const spvHeaderTableName = "spvHeaders"
+
+//区块头累计工作量表  键为区块哈希
+const spvWorkTableName = "spvHeaderWork"
+
+//交易证明表  键为交易哈希
+const spvTxProofTableName = "spvTxProofs"
+
+//钱包UTXO表  键为交易哈希，值与全节点UTXO表相同
+const spvUTXOTableName = "spvUTXOs"
+
+//记录主链顶端和交易查找位置的表
+const spvStateTableName = "spvState"
+const spvTipKey = "tip"
+const spvScannedKey = "scanned"
+
+var errSPVHeaderOrphan = errors.New("parent header is unknown")
+
+//创世区块哈希  轻节点只接受这个创世区块头，可以用环境变量GENESIS_HASH设置
+//创世区块头没有父区块可以校验，只检查难度和工作量证明时任何人都可以挖一个伪造的创世区块，从它开始伪造整条链
+var genesisBlockHash []byte
+
+type SPVChain struct {
+	//主链顶端的区块哈希
+	Tip []byte
+	//存储区块头和钱包数据的数据库
+	DB *bolt.DB
+}
+
+//打开轻节点数据库，不存在时创建
+func OpenSPVChain(nodeID string) *SPVChain {
+
+	db, err := bolt.Open(fmt.Sprintf(spvDBName, nodeID), 0600, nil)
+	if err != nil {
+
+		log.Fatal(err)
+	}
+
+	spv := &SPVChain{nil, db}
+	err = db.Update(func(tx *bolt.Tx) error {
+
+		for _, name := range []string{spvHeaderTableName, spvWorkTableName, spvTxProofTableName, spvUTXOTableName, spvStateTableName} {
+
+			_, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+
+				return err
+			}
+		}
+
+		spv.Tip = tx.Bucket([]byte(spvStateTableName)).Get([]byte(spvTipKey))
+
+		return nil
+	})
+	if err != nil {
+
+		log.Panic(err)
+	}
+
+	return spv
+}
+
+//按哈希读取区块头，找不到返回nil
+func (spv *SPVChain) lookupHeader(hash []byte) *BlockHeader {
+
+	var header *BlockHeader
+
+	err := spv.DB.View(func(tx *bolt.Tx) error {
+
+		headerBytes := tx.Bucket([]byte(spvHeaderTableName)).Get(hash)
+		if headerBytes != nil {
+
+			header, _ = DecodeBlockHeader(headerBytes)
+		}
+
+		return nil
+	})
+	if err != nil {
+
+		log.Panic(err)
+	}
+
+	return header
+}
+
+//主链顶端的区块头，还没有区块头时返回nil
+func (spv *SPVChain) TipHeader() *BlockHeader {
+
+	if spv.Tip == nil {
+
+		return nil
+	}
+
+	return spv.lookupHeader(spv.Tip)
+}
+
+//主链顶端的高度，还没有区块头时为0
+func (spv *SPVChain) BestHeight() int64 {
+
+	tip := spv.TipHeader()
+	if tip == nil {
+
+		return 0
+	}
+
+	return tip.Height
+}
+
+//本地区块头链的定位器  还没有区块头时为空，对方从创世区块开始返回
+func (spv *SPVChain) BlockLocator() [][]byte {
+
+	tip := spv.TipHeader()
+	if tip == nil {
+
+		return nil
+	}
+
+	return headerLocator(tip, spv.lookupHeader)
+}
+
+//本地区块头链的创世区块哈希，还没有区块头时返回nil
+func (spv *SPVChain) GenesisHash() []byte {
+
+	genesis := ancestorHeader(spv.TipHeader(), 1, spv.lookupHeader)
+	if genesis == nil {
+
+		return nil
+	}
+
+	return genesis.Hash()
+}
+
+//区块头是否在主链上
+func (spv *SPVChain) IsInMainChain(hash []byte) bool {
+
+	header := spv.lookupHeader(hash)
+	tip := spv.TipHeader()
+	if header == nil || tip == nil {
+
+		return false
+	}
+
+	ancestor := ancestorHeader(tip, header.Height, spv.lookupHeader)
+
+	return ancestor != nil && bytes.Compare(ancestor.Hash(), hash) == 0
+}
+
+//校验并保存一个区块头  累计工作量超过当前主链时成为新的主链顶端
+//还没有任何区块头时只接受哈希为genesisBlockHash的创世区块头
+func (spv *SPVChain) AddHeader(header *BlockHeader) error {
+
+	hash := header.Hash()
+	if spv.lookupHeader(hash) != nil {
+
+		return nil
+	}
+
+	// 父区块的累计工作量  创世区块为0
+	work := new(big.Int)
+	if spv.Tip == nil {
+
+		if header.Height != 1 || new(big.Int).SetBytes(header.PrevBlockHash).Sign() != 0 {
+
+			return fmt.Errorf("header %x: first header must be the genesis block", hash)
+		}
+
+		if genesisBlockHash == nil {
+
+			return fmt.Errorf("header %x: genesis hash is not configured", hash)
+		}
+		if bytes.Compare(hash, genesisBlockHash) != 0 {
+
+			return fmt.Errorf("header %x: genesis hash is not %x", hash, genesisBlockHash)
+		}
+
+		// 创世区块没有父区块可以推算难度，只能用固定值，否则可以用很低的难度伪造整条链
+		if header.TargetBits != genesisTargetBits {
+
+			return fmt.Errorf("header %x: genesis target bits %d, want %d", hash, header.TargetBits, genesisTargetBits)
+		}
+
+		if header.CheckProofOfWork() == false {
+
+			return fmt.Errorf("header %x: proof of work is invalid", hash)
+		}
+	} else {
+
+		parent := spv.lookupHeader(header.PrevBlockHash)
+		if parent == nil {
+
+			return errSPVHeaderOrphan
+		}
+
+		err := checkHeader(header, parent, spv.lookupHeader)
+		if err != nil {
+
+			return err
+		}
+
+		work = spv.chainWork(header.PrevBlockHash)
+	}
+
+	// 校验通过后难度位数才在合法范围内，这时再计算工作量
+	work = new(big.Int).Add(work, header.Work())
+
+	return spv.DB.Update(func(tx *bolt.Tx) error {
+
+		err := tx.Bucket([]byte(spvHeaderTableName)).Put(hash, header.Serialize())
+		if err != nil {
+
+			return err
+		}
+
+		err = tx.Bucket([]byte(spvWorkTableName)).Put(hash, work.Bytes())
+		if err != nil {
+
+			return err
+		}
+
+		// 累计工作量更大才切换主链
+		if spv.Tip != nil && work.Cmp(spv.chainWorkIn(tx, spv.Tip)) <= 0 {
+
+			return nil
+		}
+
+		spv.Tip = hash
+
+		return tx.Bucket([]byte(spvStateTableName)).Put([]byte(spvTipKey), hash)
+	})
+}
+
+//区块头的累计工作量
+func (spv *SPVChain) chainWork(hash []byte) *big.Int {
+
+	work := big.NewInt(0)
+
+	err := spv.DB.View(func(tx *bolt.Tx) error {
+
+		work = spv.chainWorkIn(tx, hash)
+
+		return nil
+	})
+	if err != nil {
+
+		log.Panic(err)
+	}
+
+	return work
+}
+
+func (spv *SPVChain) chainWorkIn(tx *bolt.Tx, hash []byte) *big.Int {
+
+	return new(big.Int).SetBytes(tx.Bucket([]byte(spvWorkTableName)).Get(hash))
+}
+
+//校验并保存一笔交易证明  证明的区块头必须在本地主链上
+func (spv *SPVChain) AddTxProof(proof *TxProof) error {
+
+	err := proof.Verify()
+	if err != nil {
+
+		return err
+	}
+
+	blockHash := proof.Header.Hash()
+	if spv.IsInMainChain(blockHash) == false {
+
+		return fmt.Errorf("block %x is not in the local header chain", blockHash)
+	}
+
+	return spv.DB.Update(func(tx *bolt.Tx) error {
+
+		return tx.Bucket([]byte(spvTxProofTableName)).Put(proof.Tx.TxHash, proof.Serialize())
+	})
+}
+
+//下一次查找交易的起始高度
+//上次查找到的区块仍在主链上时从它之后开始，否则(链重组或从未查找)从创世区块开始
+func (spv *SPVChain) ScanHeight() int64 {
+
+	var scanned []byte
+	err := spv.DB.View(func(tx *bolt.Tx) error {
+
+		scanned = tx.Bucket([]byte(spvStateTableName)).Get([]byte(spvScannedKey))
+
+		return nil
+	})
+	if err != nil {
+
+		log.Panic(err)
+	}
+
+	if scanned == nil || spv.IsInMainChain(scanned) == false {
+
+		return 1
+	}
+
+	return spv.lookupHeader(scanned).Height + 1
+}
+
+//记录已经查找到的区块  不在本地主链上时不记录
+func (spv *SPVChain) SetScanned(hash []byte) {
+
+	if spv.IsInMainChain(hash) == false {
+
+		return
+	}
+
+	err := spv.DB.Update(func(tx *bolt.Tx) error {
+
+		return tx.Bucket([]byte(spvStateTableName)).Put([]byte(spvScannedKey), hash)
+	})
+	if err != nil {
+
+		log.Panic(err)
+	}
+}
+
+//主链上的交易证明  按区块高度和交易位置排列
+func (spv *SPVChain) mainChainProofs() []*TxProof {
+
+	var proofs []*TxProof
+
+	err := spv.DB.View(func(tx *bolt.Tx) error {
+
+		return tx.Bucket([]byte(spvTxProofTableName)).ForEach(func(k, v []byte) error {
+
+			proof, err := DecodeTxProof(v)
+			if err != nil {
+
+				return err
+			}
+			proofs = append(proofs, proof)
+
+			return nil
+		})
+	})
+	if err != nil {
+
+		log.Panic(err)
+	}
+
+	var result []*TxProof
+	for _, proof := range proofs {
+
+		if spv.IsInMainChain(proof.Header.Hash()) {
+
+			result = append(result, proof)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+
+		if result[i].Header.Height != result[j].Header.Height {
+
+			return result[i].Header.Height < result[j].Header.Height
+		}
+
+		return result[i].Index < result[j].Index
+	})
+
+	return result
+}
+
+//根据主链上的交易证明重新计算钱包的UTXO视图
+func (spv *SPVChain) RebuildUTXOs(addresses []string) {
+
+	utxos := make(map[string]*UTXO)
+
+	for _, proof := range spv.mainChainProofs() {
+
+		tx := proof.Tx
+		if tx.IsCoinbaseTransaction() == false {
+
+			for _, in := range tx.Vins {
+
+				delete(utxos, fmt.Sprintf("%x:%d", in.TxHash, in.Vout))
+			}
+		}
+
+		for index, out := range tx.Vouts {
+
+			for _, address := range addresses {
+
+				if out.UnLockScriptPubKeyWithAddress(address) {
+
+					utxos[fmt.Sprintf("%x:%d", tx.TxHash, index)] = &UTXO{tx.TxHash, index, out, proof.Header.Height, tx.IsCoinbaseTransaction()}
+					break
+				}
+			}
+		}
+	}
+
+	err := spv.DB.Update(func(tx *bolt.Tx) error {
+
+		err := tx.DeleteBucket([]byte(spvUTXOTableName))
+		if err != nil {
+
+			return err
+		}
+		b, err := tx.CreateBucket([]byte(spvUTXOTableName))
+		if err != nil {
+
+			return err
+		}
+
+		txOutputsMap := make(map[string]*TXOutputs)
+		for _, utxo := range utxos {
+
+			key := hex.EncodeToString(utxo.TxHash)
+			if txOutputsMap[key] == nil {
+
+				txOutputsMap[key] = &TXOutputs{[]*UTXO{}}
+			}
+			txOutputsMap[key].UTXOS = append(txOutputsMap[key].UTXOS, utxo)
+		}
+
+		for key, txOutputs := range txOutputsMap {
+
+			txHash, _ := hex.DecodeString(key)
+			err = b.Put(txHash, txOutputs.Serialize())
+			if err != nil {
+
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+
+		log.Panic(err)
+	}
+}
+
+//钱包UTXO视图中的所有输出
+func (spv *SPVChain) UTXOs() []*UTXO {
+
+	var utxos []*UTXO
+
+	err := spv.DB.View(func(tx *bolt.Tx) error {
+
+		return tx.Bucket([]byte(spvUTXOTableName)).ForEach(func(k, v []byte) error {
+
+			utxos = append(utxos, DeserializeTXOutputs(v).UTXOS...)
+
+			return nil
+		})
+	})
+	if err != nil {
+
+		log.Panic(err)
+	}
+
+	return utxos
+}
+
+//查询余额  返回可用余额和未成熟的创币奖励
+func (spv *SPVChain) GetBalance(address string) (int64, int64) {
+
+	nextHeight := int64(1)
+	if tip := spv.TipHeader(); tip != nil {
+
+		nextHeight = tip.Height + 1
+	}
+
+	var mature, immature int64
+	for _, utxo := range spv.UTXOs() {
+
+		if utxo.Output.UnLockScriptPubKeyWithAddress(address) == false {
+
+			continue
+		}
+
+		if utxo.IsMature(nextHeight) {
+
+			mature += utxo.Output.Value
+		} else {
+
+			immature += utxo.Output.Value
+		}
+	}
+
+	return mature, immature
+}
+
+//钱包在区块过滤器中要查找的元素：地址的锁定脚本和未花费输出的位置
+func (spv *SPVChain) WalletFilterItems(addresses []string) [][]byte {
+
+	var items [][]byte
+	for _, address := range addresses {
+
+		items = append(items, PayToAddrScript(address))
+	}
+
+	for _, utxo := range spv.UTXOs() {
+
+		items = append(items, filterOutPoint(utxo.TxHash, utxo.Index))
+	}
+
+	return items
+}
+
+//区块过滤器是否匹配钱包  匹配时才需要下载完整区块
+func (spv *SPVChain) MatchBlockFilter(blockHash []byte, filterBytes []byte, addresses []string) bool {
+
+	filter, err := DecodeGCSFilter(filterBytes)
+	if err != nil {
+
+		// 过滤器无法解码时下载区块，不会漏掉交易
+		return true
+	}
+
+	return filter.MatchAny(gcsFilterKey(blockHash), spv.WalletFilterItems(addresses))
+}
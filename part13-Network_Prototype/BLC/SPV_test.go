@@ -0,0 +1,107 @@
+package BLC
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// 在临时目录中打开轻节点数据库，使用指定的创世区块哈希
+func openTestSPVChain(t *testing.T, genesis []byte) *SPVChain {
+
+	chdirTemp(t)
+
+	saved := genesisBlockHash
+	genesisBlockHash = genesis
+	t.Cleanup(func() {
+
+		genesisBlockHash = saved
+	})
+
+	spv := OpenSPVChain("test")
+	t.Cleanup(func() {
+
+		spv.DB.Close()
+	})
+
+	return spv
+}
+
+func mineTestHeader(t *testing.T, address string, parent *BlockHeader) *BlockHeader {
+
+	height := int64(1)
+	prevHash := make([]byte, 32)
+	targetBits := int64(genesisTargetBits)
+	if parent != nil {
+
+		height = parent.Height + 1
+		prevHash = parent.Hash()
+		targetBits = nextTargetBitsFrom(parent, func(hash []byte) *BlockHeader {
+
+			return nil
+		})
+	}
+
+	block, err := MineBlock(context.Background(), []*Transaction{NewCoinbaseTransaction(address, height, 0)}, height, prevHash, targetBits)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	return &block.BlockHeader
+}
+
+// 难度和工作量证明都合法的伪造创世区块头也不能被接受
+func TestSPVAddHeaderPinsGenesis(t *testing.T) {
+
+	address := string(NewWallet().GetAddress())
+	genesis := mineTestHeader(t, address, nil)
+	forged := mineTestHeader(t, string(NewWallet().GetAddress()), nil)
+	child := mineTestHeader(t, address, genesis)
+	forgedChild := mineTestHeader(t, address, forged)
+
+	spv := openTestSPVChain(t, genesis.Hash())
+
+	if err := spv.AddHeader(forged); err == nil {
+
+		t.Fatalf("forged genesis %x accepted", forged.Hash())
+	}
+	if err := spv.AddHeader(forgedChild); err == nil {
+
+		t.Fatalf("child of a forged genesis accepted")
+	}
+	if spv.Tip != nil || spv.GenesisHash() != nil {
+
+		t.Fatalf("tip %x after rejected headers", spv.Tip)
+	}
+
+	for _, header := range []*BlockHeader{genesis, child} {
+
+		if err := spv.AddHeader(header); err != nil {
+
+			t.Fatalf("header %d: %s", header.Height, err)
+		}
+	}
+	if bytes.Compare(spv.Tip, child.Hash()) != 0 || bytes.Compare(spv.GenesisHash(), genesis.Hash()) != 0 {
+
+		t.Errorf("tip %x genesis %x", spv.Tip, spv.GenesisHash())
+	}
+
+	// 已经有创世区块后，伪造链的区块头是孤块
+	if err := spv.AddHeader(forgedChild); err != errSPVHeaderOrphan {
+
+		t.Errorf("forged child: %v, want %v", err, errSPVHeaderOrphan)
+	}
+}
+
+// 没有配置创世区块哈希时不接受任何区块头
+func TestSPVAddHeaderWithoutGenesisHash(t *testing.T) {
+
+	genesis := mineTestHeader(t, string(NewWallet().GetAddress()), nil)
+	spv := openTestSPVChain(t, nil)
+
+	if err := spv.AddHeader(genesis); err == nil {
+
+		t.Errorf("genesis accepted without a configured hash")
+	}
+}
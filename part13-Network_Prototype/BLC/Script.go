@@ -0,0 +1,377 @@
+package BLC
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/**
+脚本  与比特币脚本类似的基于栈的脚本语言
+
+交易输出携带锁定脚本(ScriptPubKey)，交易输入携带解锁脚本(ScriptSig)
+验证时先执行解锁脚本，再在同一个栈上执行锁定脚本，最后栈顶为真则验证通过
+
+例如P2PKH(支付到公钥哈希)：
+	锁定脚本  OP_DUP OP_HASH160 <公钥哈希> OP_EQUALVERIFY OP_CHECKSIG
+	解锁脚本  <签名> <公钥>
+
+P2SH(支付到脚本哈希)：
+	锁定脚本  OP_HASH160 <赎回脚本哈希> OP_EQUAL
+	解锁脚本  <赎回脚本需要的数据...> <赎回脚本>
+	先验证赎回脚本的哈希，再用剩下的数据执行赎回脚本
+*/
+
+// 操作码
+const (
+	OP_0         = 0x00
+	OP_FALSE     = OP_0
+	OP_DATA_1    = 0x01
+	OP_DATA_75   = 0x4b
+	OP_PUSHDATA1 = 0x4c
+	OP_PUSHDATA2 = 0x4d
+	OP_PUSHDATA4 = 0x4e
+	OP_1NEGATE   = 0x4f
+	OP_1         = 0x51
+	OP_TRUE      = OP_1
+	OP_16        = 0x60
+
+	// 流程控制
+	OP_NOP    = 0x61
+	OP_IF     = 0x63
+	OP_NOTIF  = 0x64
+	OP_ELSE   = 0x67
+	OP_ENDIF  = 0x68
+	OP_VERIFY = 0x69
+	OP_RETURN = 0x6a
+
+	// 栈操作
+	OP_DROP = 0x75
+	OP_DUP  = 0x76
+	OP_SWAP = 0x7c
+	OP_SIZE = 0x82
+
+	// 比较
+	OP_EQUAL       = 0x87
+	OP_EQUALVERIFY = 0x88
+
+	// 哈希与签名
+	OP_RIPEMD160           = 0xa6
+	OP_SHA256              = 0xa8
+	OP_HASH160             = 0xa9
+	OP_HASH256             = 0xaa
+	OP_CHECKSIG            = 0xac
+	OP_CHECKSIGVERIFY      = 0xad
+	OP_CHECKMULTISIG       = 0xae
+	OP_CHECKMULTISIGVERIFY = 0xaf
+
+	// 时间锁
+	OP_CHECKLOCKTIMEVERIFY = 0xb1
+	OP_CHECKSEQUENCEVERIFY = 0xb2
+)
+
+// 操作码名称，用于打印脚本
+var opcodeNames = map[byte]string{
+	OP_0:                   "OP_0",
+	OP_PUSHDATA1:           "OP_PUSHDATA1",
+	OP_PUSHDATA2:           "OP_PUSHDATA2",
+	OP_PUSHDATA4:           "OP_PUSHDATA4",
+	OP_1NEGATE:             "OP_1NEGATE",
+	OP_NOP:                 "OP_NOP",
+	OP_IF:                  "OP_IF",
+	OP_NOTIF:               "OP_NOTIF",
+	OP_ELSE:                "OP_ELSE",
+	OP_ENDIF:               "OP_ENDIF",
+	OP_VERIFY:              "OP_VERIFY",
+	OP_RETURN:              "OP_RETURN",
+	OP_DROP:                "OP_DROP",
+	OP_DUP:                 "OP_DUP",
+	OP_SWAP:                "OP_SWAP",
+	OP_SIZE:                "OP_SIZE",
+	OP_EQUAL:               "OP_EQUAL",
+	OP_EQUALVERIFY:         "OP_EQUALVERIFY",
+	OP_RIPEMD160:           "OP_RIPEMD160",
+	OP_SHA256:              "OP_SHA256",
+	OP_HASH160:             "OP_HASH160",
+	OP_HASH256:             "OP_HASH256",
+	OP_CHECKSIG:            "OP_CHECKSIG",
+	OP_CHECKSIGVERIFY:      "OP_CHECKSIGVERIFY",
+	OP_CHECKMULTISIG:       "OP_CHECKMULTISIG",
+	OP_CHECKMULTISIGVERIFY: "OP_CHECKMULTISIGVERIFY",
+	OP_CHECKLOCKTIMEVERIFY: "OP_CHECKLOCKTIMEVERIFY",
+	OP_CHECKSEQUENCEVERIFY: "OP_CHECKSEQUENCEVERIFY",
+}
+
+// 脚本最大字节数
+const maxScriptSize = 10000
+
+// 单个栈元素最大字节数
+const maxScriptElementSize = 520
+
+// 单个脚本最多执行的非压栈操作数
+const maxOpsPerScript = 201
+
+// 栈最大深度
+const maxStackSize = 1000
+
+// 脚本数字最多占用的字节数
+const maxScriptNumLength = 4
+
+// 多重签名最多的公钥数
+const maxPubKeysPerMultiSig = 20
+
+// 数据输出最多携带的字节数
+const maxDataCarrierSize = 80
+
+var errMalformedPush = errors.New("malformed push")
+
+// 解析后的一条指令  压栈指令的数据放在data中
+type scriptOp struct {
+	opcode byte
+	data   []byte
+}
+
+// 是否是压栈指令
+func (op *scriptOp) isPush() bool {
+
+	return op.opcode <= OP_16 && op.opcode != 0x50
+}
+
+// 把脚本解析成指令序列
+func parseScript(script []byte) ([]scriptOp, error) {
+
+	if len(script) > maxScriptSize {
+
+		return nil, fmt.Errorf("script size %d exceeds %d", len(script), maxScriptSize)
+	}
+
+	var ops []scriptOp
+	for i := 0; i < len(script); {
+
+		opcode := script[i]
+		i++
+
+		var n int
+		switch {
+		case opcode >= OP_DATA_1 && opcode <= OP_DATA_75:
+			n = int(opcode)
+		case opcode == OP_PUSHDATA1:
+			if i+1 > len(script) {
+
+				return nil, errMalformedPush
+			}
+			n = int(script[i])
+			i++
+		case opcode == OP_PUSHDATA2:
+			if i+2 > len(script) {
+
+				return nil, errMalformedPush
+			}
+			n = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		case opcode == OP_PUSHDATA4:
+			if i+4 > len(script) {
+
+				return nil, errMalformedPush
+			}
+			n = int(binary.LittleEndian.Uint32(script[i:]))
+			i += 4
+		default:
+			ops = append(ops, scriptOp{opcode, nil})
+			continue
+		}
+
+		if n < 0 || i+n > len(script) {
+
+			return nil, errMalformedPush
+		}
+		ops = append(ops, scriptOp{opcode, script[i : i+n]})
+		i += n
+	}
+
+	return ops, nil
+}
+
+// 脚本是否只包含压栈指令
+func isPushOnly(ops []scriptOp) bool {
+
+	for _, op := range ops {
+
+		if op.isPush() == false {
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// 脚本构造器
+type scriptBuilder struct {
+	script []byte
+}
+
+func newScriptBuilder() *scriptBuilder {
+
+	return &scriptBuilder{}
+}
+
+// 添加操作码
+func (builder *scriptBuilder) AddOp(opcode byte) *scriptBuilder {
+
+	builder.script = append(builder.script, opcode)
+
+	return builder
+}
+
+// 添加压栈数据  使用最短的压栈方式
+func (builder *scriptBuilder) AddData(data []byte) *scriptBuilder {
+
+	n := len(data)
+	switch {
+	case n == 0:
+		builder.script = append(builder.script, OP_0)
+	case n == 1 && data[0] >= 1 && data[0] <= 16:
+		builder.script = append(builder.script, OP_1+data[0]-1)
+	case n <= OP_DATA_75:
+		builder.script = append(builder.script, byte(n))
+	case n <= 0xff:
+		builder.script = append(builder.script, OP_PUSHDATA1, byte(n))
+	case n <= 0xffff:
+		builder.script = append(builder.script, OP_PUSHDATA2, byte(n), byte(n>>8))
+	default:
+		builder.script = append(builder.script, OP_PUSHDATA4, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	builder.script = append(builder.script, data...)
+
+	return builder
+}
+
+// 添加整数  -1和0到16使用专门的操作码
+func (builder *scriptBuilder) AddInt64(n int64) *scriptBuilder {
+
+	switch {
+	case n == 0:
+		return builder.AddOp(OP_0)
+	case n == -1:
+		return builder.AddOp(OP_1NEGATE)
+	case n >= 1 && n <= 16:
+		return builder.AddOp(byte(OP_1 + n - 1))
+	}
+
+	return builder.AddData(scriptNumBytes(n))
+}
+
+func (builder *scriptBuilder) Script() []byte {
+
+	return builder.script
+}
+
+// 脚本数字编码  小端序，最高位为符号位，使用最短编码
+func scriptNumBytes(n int64) []byte {
+
+	if n == 0 {
+
+		return nil
+	}
+
+	negative := n < 0
+	abs := uint64(n)
+	if negative {
+
+		abs = uint64(-n)
+	}
+
+	var result []byte
+	for abs > 0 {
+
+		result = append(result, byte(abs&0xff))
+		abs >>= 8
+	}
+
+	// 最高字节的最高位被占用时，额外添加一个字节存放符号
+	if result[len(result)-1]&0x80 != 0 {
+
+		extra := byte(0x00)
+		if negative {
+
+			extra = 0x80
+		}
+		result = append(result, extra)
+	} else if negative {
+
+		result[len(result)-1] |= 0x80
+	}
+
+	return result
+}
+
+// 脚本数字解码  要求最短编码
+func scriptNumFromBytes(data []byte, maxLength int) (int64, error) {
+
+	if len(data) > maxLength {
+
+		return 0, fmt.Errorf("script number length %d exceeds %d", len(data), maxLength)
+	}
+	if len(data) == 0 {
+
+		return 0, nil
+	}
+
+	// 最高字节除符号位外为0时，必须是因为次高字节的最高位被占用
+	last := data[len(data)-1]
+	if last&0x7f == 0 && (len(data) == 1 || data[len(data)-2]&0x80 == 0) {
+
+		return 0, fmt.Errorf("non-minimal script number %x", data)
+	}
+
+	var n int64
+	for i, b := range data {
+
+		n |= int64(b) << uint(8*i)
+	}
+
+	if last&0x80 != 0 {
+
+		n &= ^(int64(0x80) << uint(8*(len(data)-1)))
+		n = -n
+	}
+
+	return n, nil
+}
+
+// 把脚本转换成可读的文本
+func DisasmScript(script []byte) string {
+
+	ops, err := parseScript(script)
+	if err != nil {
+
+		return fmt.Sprintf("[error: %s] %x", err, script)
+	}
+
+	var parts []string
+	for _, op := range ops {
+
+		switch {
+		case op.opcode == OP_0:
+			parts = append(parts, "0")
+		case op.opcode == OP_1NEGATE:
+			parts = append(parts, "-1")
+		case op.opcode >= OP_1 && op.opcode <= OP_16:
+			parts = append(parts, fmt.Sprintf("%d", op.opcode-OP_1+1))
+		case op.data != nil:
+			parts = append(parts, hex.EncodeToString(op.data))
+		default:
+			name, ok := opcodeNames[op.opcode]
+			if ok == false {
+
+				name = fmt.Sprintf("OP_UNKNOWN_%x", op.opcode)
+			}
+			parts = append(parts, name)
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,612 @@
+package BLC
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/ripemd160"
+)
+
+// 脚本执行器  验证某笔交易的某个输入
+type scriptEngine struct {
+	//被验证的交易
+	tx *Transaction
+	//输入下标
+	inID int
+	//数据栈
+	stack [][]byte
+	//条件栈  记录嵌套的IF分支是否执行
+	condStack []bool
+	//已执行的非压栈操作数
+	numOps int
+}
+
+// 验证解锁脚本能否解锁锁定脚本
+func VerifyScript(scriptSig []byte, scriptPubKey []byte, tx *Transaction, inID int) error {
+
+	sigOps, err := parseScript(scriptSig)
+	if err != nil {
+
+		return err
+	}
+
+	// 解锁脚本只能压入数据，防止它改变锁定脚本的执行逻辑
+	if isPushOnly(sigOps) == false {
+
+		return fmt.Errorf("unlocking script is not push only")
+	}
+
+	engine := &scriptEngine{tx: tx, inID: inID}
+
+	err = engine.execute(scriptSig)
+	if err != nil {
+
+		return err
+	}
+
+	// P2SH需要用解锁脚本执行后的栈再执行一次赎回脚本
+	stackCopy := append([][]byte{}, engine.stack...)
+
+	err = engine.execute(scriptPubKey)
+	if err != nil {
+
+		return err
+	}
+
+	if engine.result() == false {
+
+		return fmt.Errorf("script evaluated to false")
+	}
+
+	if IsPayToScriptHash(scriptPubKey) == false {
+
+		return nil
+	}
+
+	// 栈顶是赎回脚本，锁定脚本已经验证过它的哈希
+	if len(stackCopy) == 0 {
+
+		return fmt.Errorf("missing redeem script")
+	}
+	redeemScript := stackCopy[len(stackCopy)-1]
+	engine.stack = stackCopy[:len(stackCopy)-1]
+
+	err = engine.execute(redeemScript)
+	if err != nil {
+
+		return fmt.Errorf("redeem script: %s", err)
+	}
+
+	if engine.result() == false {
+
+		return fmt.Errorf("redeem script evaluated to false")
+	}
+
+	return nil
+}
+
+// 执行结果  栈顶为真
+func (engine *scriptEngine) result() bool {
+
+	return len(engine.stack) != 0 && castToBool(engine.stack[len(engine.stack)-1])
+}
+
+// 当前是否处于执行的分支中
+func (engine *scriptEngine) executing() bool {
+
+	for _, cond := range engine.condStack {
+
+		if cond == false {
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// 执行一段脚本
+func (engine *scriptEngine) execute(script []byte) error {
+
+	ops, err := parseScript(script)
+	if err != nil {
+
+		return err
+	}
+
+	engine.condStack = nil
+	engine.numOps = 0
+
+	for _, op := range ops {
+
+		if len(op.data) > maxScriptElementSize {
+
+			return fmt.Errorf("push of %d bytes exceeds %d", len(op.data), maxScriptElementSize)
+		}
+
+		if op.isPush() == false {
+
+			engine.numOps++
+			if engine.numOps > maxOpsPerScript {
+
+				return fmt.Errorf("too many operations")
+			}
+		}
+
+		// 不执行的分支中只需要处理条件语句，维护嵌套关系
+		if engine.executing() == false && (op.opcode < OP_IF || op.opcode > OP_ENDIF) {
+
+			continue
+		}
+
+		err := engine.step(op, script)
+		if err != nil {
+
+			return err
+		}
+
+		if len(engine.stack) > maxStackSize {
+
+			return fmt.Errorf("stack size exceeds %d", maxStackSize)
+		}
+	}
+
+	if len(engine.condStack) != 0 {
+
+		return fmt.Errorf("unbalanced conditional")
+	}
+
+	return nil
+}
+
+func (engine *scriptEngine) push(data []byte) {
+
+	engine.stack = append(engine.stack, data)
+}
+
+func (engine *scriptEngine) pushBool(value bool) {
+
+	if value {
+
+		engine.push([]byte{1})
+	} else {
+
+		engine.push(nil)
+	}
+}
+
+func (engine *scriptEngine) pop() ([]byte, error) {
+
+	if len(engine.stack) == 0 {
+
+		return nil, fmt.Errorf("stack underflow")
+	}
+
+	data := engine.stack[len(engine.stack)-1]
+	engine.stack = engine.stack[:len(engine.stack)-1]
+
+	return data, nil
+}
+
+func (engine *scriptEngine) popBool() (bool, error) {
+
+	data, err := engine.pop()
+	if err != nil {
+
+		return false, err
+	}
+
+	return castToBool(data), nil
+}
+
+// 执行一条指令  script为当前执行的脚本，签名检查时作为被签名的脚本
+func (engine *scriptEngine) step(op scriptOp, script []byte) error {
+
+	switch {
+	case op.opcode == OP_0:
+		engine.push(nil)
+		return nil
+	case op.opcode <= OP_PUSHDATA4:
+		engine.push(op.data)
+		return nil
+	case op.opcode == OP_1NEGATE:
+		engine.push(scriptNumBytes(-1))
+		return nil
+	case op.opcode >= OP_1 && op.opcode <= OP_16:
+		engine.push(scriptNumBytes(int64(op.opcode - OP_1 + 1)))
+		return nil
+	}
+
+	switch op.opcode {
+	case OP_NOP:
+
+	case OP_IF, OP_NOTIF:
+		cond := false
+		if engine.executing() {
+
+			value, err := engine.popBool()
+			if err != nil {
+
+				return err
+			}
+			cond = value == (op.opcode == OP_IF)
+		}
+		engine.condStack = append(engine.condStack, cond)
+
+	case OP_ELSE:
+		if len(engine.condStack) == 0 {
+
+			return fmt.Errorf("OP_ELSE without OP_IF")
+		}
+		engine.condStack[len(engine.condStack)-1] = !engine.condStack[len(engine.condStack)-1]
+
+	case OP_ENDIF:
+		if len(engine.condStack) == 0 {
+
+			return fmt.Errorf("OP_ENDIF without OP_IF")
+		}
+		engine.condStack = engine.condStack[:len(engine.condStack)-1]
+
+	case OP_VERIFY:
+		value, err := engine.popBool()
+		if err != nil {
+
+			return err
+		}
+		if value == false {
+
+			return fmt.Errorf("OP_VERIFY failed")
+		}
+
+	case OP_RETURN:
+		return fmt.Errorf("OP_RETURN executed")
+
+	case OP_DROP:
+		_, err := engine.pop()
+		return err
+
+	case OP_DUP:
+		data, err := engine.pop()
+		if err != nil {
+
+			return err
+		}
+		engine.push(data)
+		engine.push(data)
+
+	case OP_SWAP:
+		a, err := engine.pop()
+		if err != nil {
+
+			return err
+		}
+		b, err := engine.pop()
+		if err != nil {
+
+			return err
+		}
+		engine.push(a)
+		engine.push(b)
+
+	case OP_SIZE:
+		if len(engine.stack) == 0 {
+
+			return fmt.Errorf("stack underflow")
+		}
+		engine.push(scriptNumBytes(int64(len(engine.stack[len(engine.stack)-1]))))
+
+	case OP_EQUAL, OP_EQUALVERIFY:
+		a, err := engine.pop()
+		if err != nil {
+
+			return err
+		}
+		b, err := engine.pop()
+		if err != nil {
+
+			return err
+		}
+		equal := bytes.Compare(a, b) == 0
+		if op.opcode == OP_EQUALVERIFY {
+
+			if equal == false {
+
+				return fmt.Errorf("OP_EQUALVERIFY failed")
+			}
+		} else {
+
+			engine.pushBool(equal)
+		}
+
+	case OP_RIPEMD160, OP_SHA256, OP_HASH160, OP_HASH256:
+		data, err := engine.pop()
+		if err != nil {
+
+			return err
+		}
+		engine.push(hashScriptData(op.opcode, data))
+
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+		publicKey, err := engine.pop()
+		if err != nil {
+
+			return err
+		}
+		signature, err := engine.pop()
+		if err != nil {
+
+			return err
+		}
+		valid := checkSignature(signature, publicKey, engine.tx.SignatureHash(engine.inID, script))
+		if op.opcode == OP_CHECKSIGVERIFY {
+
+			if valid == false {
+
+				return fmt.Errorf("OP_CHECKSIGVERIFY failed")
+			}
+		} else {
+
+			engine.pushBool(valid)
+		}
+
+	case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+		valid, err := engine.checkMultiSig(script)
+		if err != nil {
+
+			return err
+		}
+		if op.opcode == OP_CHECKMULTISIGVERIFY {
+
+			if valid == false {
+
+				return fmt.Errorf("OP_CHECKMULTISIGVERIFY failed")
+			}
+		} else {
+
+			engine.pushBool(valid)
+		}
+
+	case OP_CHECKLOCKTIMEVERIFY:
+		return engine.checkLockTimeVerify()
+
+	case OP_CHECKSEQUENCEVERIFY:
+		return engine.checkSequenceVerify()
+
+	default:
+		return fmt.Errorf("unknown opcode %x", op.opcode)
+	}
+
+	return nil
+}
+
+// 读取栈顶的时间锁参数但不弹出  时间锁的值可以用到5个字节
+func (engine *scriptEngine) peekLockTime() (int64, error) {
+
+	if len(engine.stack) == 0 {
+
+		return 0, fmt.Errorf("stack underflow")
+	}
+
+	n, err := scriptNumFromBytes(engine.stack[len(engine.stack)-1], 5)
+	if err != nil {
+
+		return 0, err
+	}
+	if n < 0 {
+
+		return 0, fmt.Errorf("negative lock time")
+	}
+
+	return n, nil
+}
+
+// 栈顶的锁定时间不大于交易的LockTime，并且类型(高度/时间)相同
+func (engine *scriptEngine) checkLockTimeVerify() error {
+
+	n, err := engine.peekLockTime()
+	if err != nil {
+
+		return err
+	}
+
+	lockTime := int64(engine.tx.LockTime)
+	if (n < lockTimeThreshold) != (lockTime < lockTimeThreshold) {
+
+		return fmt.Errorf("lock time type mismatch")
+	}
+	if n > lockTime {
+
+		return fmt.Errorf("locked until %d, tx lock time %d", n, lockTime)
+	}
+
+	// 输入序号为最大值时交易的LockTime不生效
+	if engine.tx.Vins[engine.inID].Sequence == MaxTxInSequenceNum {
+
+		return fmt.Errorf("input sequence disables lock time")
+	}
+
+	return nil
+}
+
+// 栈顶的相对锁定不大于输入的Sequence，并且单位(区块/时间)相同
+func (engine *scriptEngine) checkSequenceVerify() error {
+
+	n, err := engine.peekLockTime()
+	if err != nil {
+
+		return err
+	}
+
+	// 参数本身设置了禁用位时相当于OP_NOP
+	if n&SequenceLockTimeDisabled != 0 {
+
+		return nil
+	}
+
+	sequence := int64(engine.tx.Vins[engine.inID].Sequence)
+	if sequence&SequenceLockTimeDisabled != 0 {
+
+		return fmt.Errorf("input sequence disables relative lock")
+	}
+
+	if n&SequenceLockTimeIsSeconds != sequence&SequenceLockTimeIsSeconds {
+
+		return fmt.Errorf("relative lock type mismatch")
+	}
+	if n&SequenceLockTimeMask > sequence&SequenceLockTimeMask {
+
+		return fmt.Errorf("relative lock %d, input sequence %d", n&SequenceLockTimeMask, sequence&SequenceLockTimeMask)
+	}
+
+	return nil
+}
+
+// 弹出一个脚本数字
+func (engine *scriptEngine) popInt() (int64, error) {
+
+	data, err := engine.pop()
+	if err != nil {
+
+		return 0, err
+	}
+
+	return scriptNumFromBytes(data, maxScriptNumLength)
+}
+
+// 多重签名  栈中依次为 <签名1>...<签名m> <m> <公钥1>...<公钥n> <n>
+// 签名必须按公钥的顺序排列，每个公钥最多匹配一个签名
+func (engine *scriptEngine) checkMultiSig(script []byte) (bool, error) {
+
+	n, err := engine.popInt()
+	if err != nil {
+
+		return false, err
+	}
+	if n < 0 || n > maxPubKeysPerMultiSig {
+
+		return false, fmt.Errorf("invalid public key count %d", n)
+	}
+
+	// 每个公钥都计入操作数
+	engine.numOps += int(n)
+	if engine.numOps > maxOpsPerScript {
+
+		return false, fmt.Errorf("too many operations")
+	}
+
+	publicKeys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+
+		publicKeys[i], err = engine.pop()
+		if err != nil {
+
+			return false, err
+		}
+	}
+
+	m, err := engine.popInt()
+	if err != nil {
+
+		return false, err
+	}
+	if m < 0 || m > n {
+
+		return false, fmt.Errorf("invalid signature count %d", m)
+	}
+
+	signatures := make([][]byte, m)
+	for i := m - 1; i >= 0; i-- {
+
+		signatures[i], err = engine.pop()
+		if err != nil {
+
+			return false, err
+		}
+	}
+
+	hash := engine.tx.SignatureHash(engine.inID, script)
+
+	// 按顺序为每个签名寻找匹配的公钥，剩下的公钥不够时失败
+	keyIndex := 0
+	for _, signature := range signatures {
+
+		for keyIndex < len(publicKeys) && checkSignature(signature, publicKeys[keyIndex], hash) == false {
+
+			keyIndex++
+		}
+		if keyIndex == len(publicKeys) {
+
+			return false, nil
+		}
+		keyIndex++
+	}
+
+	return true, nil
+}
+
+// 哈希类操作码
+func hashScriptData(opcode byte, data []byte) []byte {
+
+	switch opcode {
+	case OP_RIPEMD160:
+		hasher := ripemd160.New()
+		hasher.Write(data)
+		return hasher.Sum(nil)
+	case OP_SHA256:
+		hash := sha256.Sum256(data)
+		return hash[:]
+	case OP_HASH160:
+		return Ripemd160Hash(data)
+	}
+
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+
+	return second[:]
+}
+
+// 栈元素转换为布尔值  全0(包括负0)为假
+func castToBool(data []byte) bool {
+
+	for i, b := range data {
+
+		if b != 0 {
+
+			// 最后一个字节为0x80表示负0
+			if i == len(data)-1 && b == 0x80 {
+
+				return false
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+// 校验签名  公钥为64字节的X||Y，签名为64字节的r||s
+func checkSignature(signature []byte, publicKey []byte, hash []byte) bool {
+
+	if len(signature) != 64 || len(publicKey) != 64 {
+
+		return false
+	}
+
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+
+	x := new(big.Int).SetBytes(publicKey[:32])
+	y := new(big.Int).SetBytes(publicKey[32:])
+
+	rawPubKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	if rawPubKey.Curve.IsOnCurve(x, y) == false {
+
+		return false
+	}
+
+	return ecdsa.Verify(&rawPubKey, hash, r, s)
+}
@@ -0,0 +1,256 @@
+package BLC
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"strings"
+	"testing"
+)
+
+// 花费一个输出的交易  签名数据与交易内容绑定
+func scriptTestTx(lockTime uint32, sequence uint32) *Transaction {
+
+	return &Transaction{
+		nil,
+		[]*TXInput{{repeatHash(0x11), 0, nil, sequence}},
+		[]*TXOutput{{5, PayToPubKeyHashScript(bytes.Repeat([]byte{0x22}, 20))}},
+		TxVersion,
+		lockTime,
+	}
+}
+
+// 对输入0签名  被签名的脚本为subScript
+func scriptTestSign(t *testing.T, privateKey ecdsa.PrivateKey, tx *Transaction, subScript []byte) []byte {
+
+	signature, err := signHash(&privateKey, tx.SignatureHash(0, subScript))
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	return signature
+}
+
+// 由压栈数据组成的解锁脚本
+func scriptTestPushes(items ...[]byte) []byte {
+
+	builder := newScriptBuilder()
+	for _, item := range items {
+
+		builder.AddData(item)
+	}
+
+	return builder.Script()
+}
+
+// 由同一个操作码重复n次组成的脚本
+func scriptTestRepeat(opcode byte, n int) []byte {
+
+	return bytes.Repeat([]byte{opcode}, n)
+}
+
+type scriptTestCase struct {
+	name         string
+	scriptSig    []byte
+	scriptPubKey []byte
+	// 期望的错误包含的文字  为空表示验证通过
+	err string
+}
+
+func runScriptTests(t *testing.T, tx *Transaction, tests []scriptTestCase) {
+
+	for _, test := range tests {
+
+		err := VerifyScript(test.scriptSig, test.scriptPubKey, tx, 0)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %s", test.name, err)
+		case test.err != "" && err == nil:
+			t.Errorf("%s: verified, want error %q", test.name, test.err)
+		case test.err != "" && strings.Contains(err.Error(), test.err) == false:
+			t.Errorf("%s: got error %q, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestVerifyScriptPayToPubKeyHash(t *testing.T) {
+
+	tx := scriptTestTx(0, MaxTxInSequenceNum)
+	key, publicKey := newKeyPair()
+	otherKey, otherPublicKey := newKeyPair()
+
+	scriptPubKey := PayToPubKeyHashScript(Ripemd160Hash(publicKey))
+	signature := scriptTestSign(t, key, tx, scriptPubKey)
+
+	runScriptTests(t, tx, []scriptTestCase{
+		{"valid", SignatureScript(signature, publicKey), scriptPubKey, ""},
+		{"wrong public key", SignatureScript(signature, otherPublicKey), scriptPubKey, "OP_EQUALVERIFY failed"},
+		{"signed by another key", SignatureScript(scriptTestSign(t, otherKey, tx, scriptPubKey), publicKey), scriptPubKey, "false"},
+		{"signed over another script", SignatureScript(scriptTestSign(t, key, tx, []byte{OP_TRUE}), publicKey), scriptPubKey, "false"},
+		{"truncated signature", SignatureScript(signature[:63], publicKey), scriptPubKey, "false"},
+		{"missing public key", scriptTestPushes(signature), scriptPubKey, "OP_EQUALVERIFY failed"},
+		{"empty unlocking script", nil, scriptPubKey, "stack underflow"},
+		{"non-push unlocking script", append(SignatureScript(signature, publicKey), OP_DUP), scriptPubKey, "push only"},
+	})
+
+	// 交易内容改变后签名失效
+	tx.Vouts[0].Value++
+	runScriptTests(t, tx, []scriptTestCase{
+		{"tx modified after signing", SignatureScript(signature, publicKey), scriptPubKey, "false"},
+	})
+}
+
+func TestVerifyScriptPayToScriptHash(t *testing.T) {
+
+	tx := scriptTestTx(0, MaxTxInSequenceNum)
+	secret := []byte("secret")
+
+	// 赎回脚本  <secret> OP_EQUAL
+	redeemScript := newScriptBuilder().AddData(secret).AddOp(OP_EQUAL).Script()
+	scriptPubKey := PayToScriptHashScript(Ripemd160Hash(redeemScript))
+	otherRedeemScript := newScriptBuilder().AddData([]byte("other")).AddOp(OP_EQUAL).Script()
+
+	runScriptTests(t, tx, []scriptTestCase{
+		{"valid", scriptTestPushes(secret, redeemScript), scriptPubKey, ""},
+		{"wrong redeem data", scriptTestPushes([]byte("guess"), redeemScript), scriptPubKey, "redeem script evaluated to false"},
+		{"redeem script hash mismatch", scriptTestPushes([]byte("other"), otherRedeemScript), scriptPubKey, "script evaluated to false"},
+		{"missing redeem data", scriptTestPushes(redeemScript), scriptPubKey, "redeem script: stack underflow"},
+		{"missing redeem script", nil, scriptPubKey, "stack underflow"},
+	})
+}
+
+func TestVerifyScriptMultiSig(t *testing.T) {
+
+	tx := scriptTestTx(0, MaxTxInSequenceNum)
+
+	var keys []ecdsa.PrivateKey
+	var publicKeys [][]byte
+	for i := 0; i < 3; i++ {
+
+		key, publicKey := newKeyPair()
+		keys = append(keys, key)
+		publicKeys = append(publicKeys, publicKey)
+	}
+
+	redeemScript, err := MultiSigScript(2, publicKeys)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	// 裸多重签名  赎回脚本直接作为锁定脚本，被签名的脚本就是它
+	sig0 := scriptTestSign(t, keys[0], tx, redeemScript)
+	sig1 := scriptTestSign(t, keys[1], tx, redeemScript)
+	sig2 := scriptTestSign(t, keys[2], tx, redeemScript)
+
+	runScriptTests(t, tx, []scriptTestCase{
+		{"bare keys 0,1", scriptTestPushes(sig0, sig1), redeemScript, ""},
+		{"bare keys 0,2", scriptTestPushes(sig0, sig2), redeemScript, ""},
+		{"bare keys 1,2", scriptTestPushes(sig1, sig2), redeemScript, ""},
+		// 签名必须按公钥顺序排列
+		{"bare keys out of order", scriptTestPushes(sig2, sig0), redeemScript, "false"},
+		{"bare same signature twice", scriptTestPushes(sig0, sig0), redeemScript, "false"},
+		{"bare one signature", scriptTestPushes(sig0), redeemScript, "stack underflow"},
+		// 没有比特币的多弹出一个元素的问题：正好弹出m个签名，前面多余的OP_0留在栈上不影响结果
+		{"bare leading dummy", append([]byte{OP_0}, scriptTestPushes(sig0, sig1)...), redeemScript, ""},
+		// OP_0也不能充当缺少的签名
+		{"bare dummy instead of signature", append([]byte{OP_0}, scriptTestPushes(sig0)...), redeemScript, "false"},
+	})
+
+	// P2SH多重签名  被签名的脚本为赎回脚本
+	scriptPubKey := PayToScriptHashScript(Ripemd160Hash(redeemScript))
+
+	runScriptTests(t, tx, []scriptTestCase{
+		{"p2sh keys 0,2", scriptTestPushes(sig0, sig2, redeemScript), scriptPubKey, ""},
+		{"p2sh keys out of order", scriptTestPushes(sig2, sig0, redeemScript), scriptPubKey, "redeem script evaluated to false"},
+		{"p2sh one signature", scriptTestPushes(sig1, redeemScript), scriptPubKey, "redeem script: stack underflow"},
+		{"p2sh signature over scriptPubKey", scriptTestPushes(scriptTestSign(t, keys[0], tx, scriptPubKey), sig1, redeemScript), scriptPubKey, "redeem script evaluated to false"},
+	})
+
+	// FinalizeMultiSig生成的解锁脚本没有前导的OP_0
+	unlocking := multiSigScriptFromSlots([][]byte{sig0, nil, sig2}, redeemScript, true)
+	runScriptTests(t, tx, []scriptTestCase{
+		{"finalized slots", unlocking, scriptPubKey, ""},
+	})
+}
+
+func TestVerifyScriptNullData(t *testing.T) {
+
+	tx := scriptTestTx(0, MaxTxInSequenceNum)
+
+	scriptPubKey, err := NullDataScript([]byte("notarized document hash"))
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	if IsUnspendable(scriptPubKey) == false || IsNullData(scriptPubKey) == false {
+
+		t.Fatalf("%x is not an unspendable data output", scriptPubKey)
+	}
+
+	if _, err := NullDataScript(make([]byte, maxDataCarrierSize+1)); err == nil {
+
+		t.Errorf("data larger than %d bytes accepted", maxDataCarrierSize)
+	}
+
+	// 无论解锁脚本是什么都不能花费
+	runScriptTests(t, tx, []scriptTestCase{
+		{"empty unlocking script", nil, scriptPubKey, "OP_RETURN executed"},
+		{"true unlocking script", []byte{OP_TRUE}, scriptPubKey, "OP_RETURN executed"},
+		{"op_return in branch not taken", []byte{OP_TRUE}, []byte{OP_FALSE, OP_IF, OP_RETURN, OP_ENDIF}, ""},
+		{"op_return in branch taken", []byte{OP_TRUE}, []byte{OP_IF, OP_RETURN, OP_ENDIF, OP_TRUE}, "OP_RETURN executed"},
+	})
+}
+
+func TestVerifyScriptLimits(t *testing.T) {
+
+	tx := scriptTestTx(0, MaxTxInSequenceNum)
+
+	bigPush := newScriptBuilder().AddData(make([]byte, maxScriptElementSize+1)).Script()
+	maxPush := newScriptBuilder().AddData(make([]byte, maxScriptElementSize)).AddOp(OP_DROP).AddOp(OP_TRUE).Script()
+
+	runScriptTests(t, tx, []scriptTestCase{
+		{"script too large", nil, scriptTestRepeat(OP_NOP, maxScriptSize+1), "script size"},
+		{"element at limit", nil, maxPush, ""},
+		{"element too large", nil, bigPush, "exceeds"},
+		{"ops at limit", nil, append(scriptTestRepeat(OP_NOP, maxOpsPerScript), OP_TRUE), ""},
+		{"too many ops", nil, append(scriptTestRepeat(OP_NOP, maxOpsPerScript+1), OP_TRUE), "too many operations"},
+		// 不执行的分支中的操作也计数
+		{"ops in branch not taken", nil, append(append([]byte{OP_FALSE, OP_IF}, scriptTestRepeat(OP_NOP, maxOpsPerScript)...), OP_ENDIF, OP_TRUE), "too many operations"},
+		{"stack at limit", nil, scriptTestRepeat(OP_TRUE, maxStackSize), ""},
+		{"stack too deep", nil, scriptTestRepeat(OP_TRUE, maxStackSize+1), "stack size"},
+		{"unknown opcode", nil, []byte{OP_TRUE, 0xff}, "unknown opcode"},
+		{"reserved opcode", nil, []byte{0x50}, "unknown opcode"},
+		{"truncated push", nil, []byte{OP_DATA_1 + 1, 0x01}, "malformed push"},
+		{"truncated pushdata2", nil, []byte{OP_PUSHDATA2, 0x01}, "malformed push"},
+		{"unbalanced if", nil, []byte{OP_TRUE, OP_IF, OP_TRUE}, "unbalanced conditional"},
+		{"else without if", nil, []byte{OP_ELSE}, "without OP_IF"},
+		{"endif without if", nil, []byte{OP_TRUE, OP_ENDIF}, "without OP_IF"},
+		{"verify false", nil, []byte{OP_FALSE, OP_VERIFY, OP_TRUE}, "OP_VERIFY failed"},
+		{"empty stack", nil, nil, "false"},
+		{"negative zero is false", nil, newScriptBuilder().AddData([]byte{0x80}).Script(), "false"},
+		{"multisig key count too large", nil, newScriptBuilder().AddInt64(0).AddInt64(maxPubKeysPerMultiSig + 1).AddOp(OP_CHECKMULTISIG).Script(), "invalid public key count"},
+	})
+}
+
+func TestScriptNumEncoding(t *testing.T) {
+
+	for _, n := range []int64{0, 1, -1, 16, 127, 128, -128, 255, 256, 32767, -32768, 1<<31 - 1, -(1<<31 - 1)} {
+
+		got, err := scriptNumFromBytes(scriptNumBytes(n), maxScriptNumLength)
+		if err != nil || got != n {
+
+			t.Errorf("%d: round trip gave %d, %v", n, got, err)
+		}
+	}
+
+	// 非最短编码和超长的数字必须拒绝
+	for _, data := range [][]byte{{0x00}, {0x80}, {0x01, 0x00}, {0x7f, 0x80}, {0x01, 0x02, 0x03, 0x04, 0x05}} {
+
+		if _, err := scriptNumFromBytes(data, maxScriptNumLength); err == nil {
+
+			t.Errorf("%x: accepted", data)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package BLC
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// 标准脚本模板
+
+// P2PKH锁定脚本  OP_DUP OP_HASH160 <公钥哈希> OP_EQUALVERIFY OP_CHECKSIG
+func PayToPubKeyHashScript(pubKeyHash []byte) []byte {
+
+	return newScriptBuilder().
+		AddOp(OP_DUP).
+		AddOp(OP_HASH160).
+		AddData(pubKeyHash).
+		AddOp(OP_EQUALVERIFY).
+		AddOp(OP_CHECKSIG).
+		Script()
+}
+
+// P2PKH解锁脚本  <签名> <公钥>
+func SignatureScript(signature []byte, publicKey []byte) []byte {
+
+	return newScriptBuilder().AddData(signature).AddData(publicKey).Script()
+}
+
+// 是否是P2PKH锁定脚本
+func IsPayToPubKeyHash(script []byte) bool {
+
+	return ExtractPubKeyHash(script) != nil
+}
+
+// 取出P2PKH锁定脚本中的公钥哈希，不是P2PKH时返回nil
+func ExtractPubKeyHash(script []byte) []byte {
+
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 5 {
+
+		return nil
+	}
+
+	if ops[0].opcode == OP_DUP && ops[1].opcode == OP_HASH160 && len(ops[2].data) == 20 &&
+		ops[3].opcode == OP_EQUALVERIFY && ops[4].opcode == OP_CHECKSIG {
+
+		return ops[2].data
+	}
+
+	return nil
+}
+
+// 取出P2PKH解锁脚本中的公钥，不是<签名> <公钥>格式时返回nil
+func ExtractSignaturePublicKey(scriptSig []byte) []byte {
+
+	ops, err := parseScript(scriptSig)
+	if err != nil || len(ops) != 2 || isPushOnly(ops) == false {
+
+		return nil
+	}
+
+	return ops[1].data
+}
+
+// M-of-N多重签名脚本  OP_m <公钥1>...<公钥n> OP_n OP_CHECKMULTISIG
+// 一般不直接放在输出中，而是作为P2SH的赎回脚本
+func MultiSigScript(m int, publicKeys [][]byte) ([]byte, error) {
+
+	n := len(publicKeys)
+	if n == 0 || n > maxPubKeysPerMultiSig {
+
+		return nil, fmt.Errorf("public key count %d out of range 1-%d", n, maxPubKeysPerMultiSig)
+	}
+	if m < 1 || m > n {
+
+		return nil, fmt.Errorf("required signatures %d out of range 1-%d", m, n)
+	}
+
+	builder := newScriptBuilder().AddInt64(int64(m))
+	for _, publicKey := range publicKeys {
+
+		if len(publicKey) != 64 {
+
+			return nil, fmt.Errorf("public key %x is not 64 bytes", publicKey)
+		}
+		builder.AddData(publicKey)
+	}
+
+	script := builder.AddInt64(int64(n)).AddOp(OP_CHECKMULTISIG).Script()
+	if len(script) > maxScriptElementSize {
+
+		return nil, fmt.Errorf("redeem script size %d exceeds %d", len(script), maxScriptElementSize)
+	}
+
+	return script, nil
+}
+
+// 取出多重签名脚本中需要的签名数和公钥，不是多重签名脚本时ok为false
+func ExtractMultiSig(script []byte) (m int, publicKeys [][]byte, ok bool) {
+
+	ops, err := parseScript(script)
+	if err != nil || len(ops) < 4 || ops[len(ops)-1].opcode != OP_CHECKMULTISIG {
+
+		return 0, nil, false
+	}
+
+	first := ops[0].opcode
+	last := ops[len(ops)-2].opcode
+	if first < OP_1 || first > OP_16 || last < OP_1 || last > OP_16 {
+
+		return 0, nil, false
+	}
+
+	m = int(first - OP_1 + 1)
+	n := int(last - OP_1 + 1)
+	if n != len(ops)-3 || m > n {
+
+		return 0, nil, false
+	}
+
+	for _, op := range ops[1 : len(ops)-2] {
+
+		if len(op.data) != 64 {
+
+			return 0, nil, false
+		}
+		publicKeys = append(publicKeys, op.data)
+	}
+
+	return m, publicKeys, true
+}
+
+// P2SH锁定脚本  OP_HASH160 <脚本哈希> OP_EQUAL
+func PayToScriptHashScript(scriptHash []byte) []byte {
+
+	return newScriptBuilder().AddOp(OP_HASH160).AddData(scriptHash).AddOp(OP_EQUAL).Script()
+}
+
+// 是否是P2SH锁定脚本
+func IsPayToScriptHash(script []byte) bool {
+
+	return ExtractScriptHash(script) != nil
+}
+
+// 取出P2SH锁定脚本中的脚本哈希，不是P2SH时返回nil
+func ExtractScriptHash(script []byte) []byte {
+
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 3 {
+
+		return nil
+	}
+
+	if ops[0].opcode == OP_HASH160 && len(ops[1].data) == 20 && ops[2].opcode == OP_EQUAL {
+
+		return ops[1].data
+	}
+
+	return nil
+}
+
+// 取出P2SH解锁脚本中的赎回脚本(最后一个压栈数据)，解锁脚本不是只压栈时返回nil
+func ExtractRedeemScript(scriptSig []byte) []byte {
+
+	ops, err := parseScript(scriptSig)
+	if err != nil || len(ops) == 0 || isPushOnly(ops) == false {
+
+		return nil
+	}
+
+	return ops[len(ops)-1].data
+}
+
+// 赎回脚本对应的P2SH地址
+func ScriptHashAddress(redeemScript []byte) []byte {
+
+	return hashToAddress(ScriptHashAddVersion, Ripemd160Hash(redeemScript))
+}
+
+// 数据输出的锁定脚本  OP_RETURN <数据>
+// 以OP_RETURN开头的脚本执行即失败，输出可以证明不可花费，不进入UTXOSet
+func NullDataScript(data []byte) ([]byte, error) {
+
+	if len(data) > maxDataCarrierSize {
+
+		return nil, fmt.Errorf("data size %d exceeds %d", len(data), maxDataCarrierSize)
+	}
+
+	return newScriptBuilder().AddOp(OP_RETURN).AddData(data).Script(), nil
+}
+
+// 锁定脚本是否不可花费
+func IsUnspendable(script []byte) bool {
+
+	return len(script) > 0 && script[0] == OP_RETURN
+}
+
+// 是否是合法的数据输出  OP_RETURN后只有压栈操作，脚本不超过数据上限加操作码和压栈长度
+func IsNullData(script []byte) bool {
+
+	ops, err := parseScript(script)
+	if err != nil || len(ops) == 0 || ops[0].opcode != OP_RETURN {
+
+		return false
+	}
+
+	return isPushOnly(ops[1:]) && len(script) <= maxDataCarrierSize+3
+}
+
+// 取出数据输出携带的数据，不是OP_RETURN <数据>格式时返回nil
+func ExtractNullData(script []byte) []byte {
+
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 2 || ops[0].opcode != OP_RETURN || ops[1].isPush() == false {
+
+		return nil
+	}
+
+	return ops[1].data
+}
+
+// 根据地址生成锁定脚本  无效地址返回nil
+func PayToAddrScript(address string) []byte {
+
+	version_pubKeyHash_checkSumBytes := Base58Decode([]byte(address))
+	if len(version_pubKeyHash_checkSumBytes) != 1+20+AddressChecksumLen {
+
+		return nil
+	}
+
+	version := version_pubKeyHash_checkSumBytes[0]
+	hash := version_pubKeyHash_checkSumBytes[1 : len(version_pubKeyHash_checkSumBytes)-AddressChecksumLen]
+
+	switch version {
+	case AddVersion:
+		return PayToPubKeyHashScript(hash)
+	case ScriptHashAddVersion:
+		return PayToScriptHashScript(hash)
+	}
+
+	return nil
+}
+
+// 标准锁定脚本对应的地址，不是P2PKH或P2SH时返回空字符串
+func ExtractAddress(script []byte) string {
+
+	if pubKeyHash := ExtractPubKeyHash(script); pubKeyHash != nil {
+
+		return string(hashToAddress(AddVersion, pubKeyHash))
+	}
+	if scriptHash := ExtractScriptHash(script); scriptHash != nil {
+
+		return string(hashToAddress(ScriptHashAddVersion, scriptHash))
+	}
+
+	return ""
+}
+
+// 锁定脚本是否支付给该地址
+func scriptPaysToAddress(script []byte, address string) bool {
+
+	addressScript := PayToAddrScript(address)
+
+	return addressScript != nil && bytes.Compare(script, addressScript) == 0
+}
@@ -1,21 +1,30 @@
 package BLC
 
 import (
+	"errors"
 	"net"
 	"fmt"
 	"log"
-	"io/ioutil"
+	"time"
 )
 
+// Accept出错后第一次重试前等待的时间  连续出错时加倍
+const acceptRetryDelay = 5 * time.Millisecond
+
+// Accept连续出错时最长的等待时间
+const maxAcceptRetryDelay = time.Second
+
 
 func StartServer(nodeID string, minerAdd string) {
 
 	// 当前节点IP地址
 	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
 	// 挖矿节点设置
+	localServices = SERVICE_FULL_NODE
 	if len(minerAdd) > 0 {
 
 		miningAddress = minerAdd
+		localServices |= SERVICE_MINER
 	}
 
 	// 启动网络监听服务
@@ -27,89 +36,201 @@ func StartServer(nodeID string, minerAdd string) {
 	defer ln.Close()
 
 	blc := GetBlockchain(nodeID)
+	// 旧数据库没有主链高度索引时先建立
+	blc.ensureMainChainIndex()
 	//fmt.Println("startserver\n")
 	//blc.Printchain()
 
-	// 第一个终端：端口为3000,启动的就是主节点
-	// 第二个终端：端口为3001，钱包节点
-	// 第三个终端：端口号为3002，矿工节点
-	if nodeAddress != knowedNodes[0] {
+	// 所有全节点地位相同：互相转发交易和区块，指定了-miner的节点还会挖矿
+	// 同一个连接上的消息依次处理，回复写回这个连接  不同连接的消息通过chainLock逐个处理
+	handler := func(peer *Peer, command string, payload []byte) {
 
-		// 该节点不是主节点，钱包节点向主节点请求数据
-		sendVersion(knowedNodes[0], blc)
+		chainLock.Lock()
+		defer chainLock.Unlock()
+
+		handleMessage(peer, command, payload, blc)
 	}
 
+	// 被封禁的IP不能连入，也不会主动连接
+	banList = NewBanList(nodeID)
+
+	// 从地址簿中选择节点主动连接  种子节点也在地址簿中
+	addrManager = LoadAddrManager(nodeID)
+	addSeedAddresses()
+	go addrManager.saveLoop()
+	go maintainOutboundPeers(blc, handler)
+
+	var retryDelay time.Duration
 	for {
 
-		// 接收客户端发来的数据
-		connc, err := ln.Accept()
+		// 接收客户端发来的连接  文件描述符用完等错误是暂时的，等一会儿再试
+		conn, err := ln.Accept()
 		if err != nil {
 
-			log.Panic(err)
+			if errors.Is(err, net.ErrClosed) {
+
+				log.Panic(err)
+			}
+
+			retryDelay *= 2
+			if retryDelay == 0 {
+
+				retryDelay = acceptRetryDelay
+			}
+			if retryDelay > maxAcceptRetryDelay {
+
+				retryDelay = maxAcceptRetryDelay
+			}
+			fmt.Printf("Accept failed: %s, retrying in %s\n", err, retryDelay)
+			time.Sleep(retryDelay)
+			continue
 		}
+		retryDelay = 0
 
-		// 不同的命令采取不同的处理方式
-		go handleConnection(connc, blc)
-	}
-}
+		if banList.IsBanned(remoteHost(conn)) {
 
-// 客户端命令处理器
-func handleConnection(conn net.Conn, blc *Blockchain) {
+			fmt.Printf("Reject connection from %s: banned\n", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
 
-	//fmt.Println("handleConnection:\n")
-	//blc.Printchain()
+		// 连入的连接会一直保持，数量要有上限
+		if inboundPeerCount() >= maxInboundPeers {
 
-	// 读取客户端发送过来的所有的数据
-	request, err := ioutil.ReadAll(conn)
-	if err != nil {
+			fmt.Printf("Reject connection from %s: too many inbound peers\n", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
 
-		log.Panic(err)
+		acceptPeer(conn, handler)
 	}
+}
 
-	fmt.Printf("\nReceive a Message:%s\n", request[:COMMANDLENGTH])
-
-	command := bytesToCommand(request[:COMMANDLENGTH])
+// 不同的命令采取不同的处理方式
+func handleMessage(peer *Peer, command string, payload []byte, blc *Blockchain) {
 
 	switch command {
 
 	case COMMAND_VERSION:
-		handleVersion(request, blc)
+		handleVersion(peer, payload, blc)
+
+	case COMMAND_VERACK:
+		handleVerack(peer, payload, blc)
 
 	case COMMAND_ADDR:
-		handleAddr(request, blc)
+		handleAddr(peer, payload, blc)
 
-	case COMMAND_BLOCK:
-		handleBlock(request, blc)
+	case COMMAND_GETADDR:
+		handleGetAddr(peer, payload, blc)
 
-	case COMMAND_GETBLOCKS:
-		handleGetblocks(request, blc)
+	case COMMAND_BLOCK:
+		handleBlock(peer, payload, blc)
 
 	case COMMAND_GETDATA:
-		handleGetData(request, blc)
+		handleGetData(peer, payload, blc)
 
 	case COMMAND_INV:
-		handleInv(request, blc)
+		handleInv(peer, payload, blc)
 
 	case COMMAND_TX:
-		handleTx(request, blc)
+		handleTx(peer, payload, blc)
+
+	case COMMAND_GETHEADERS:
+		handleGetHeaders(peer, payload, blc)
+
+	case COMMAND_HEADERS:
+		handleHeaders(peer, payload, blc)
+
+	case COMMAND_GETTXPROOFS:
+		handleGetTxProofs(peer, payload, blc)
+
+	case COMMAND_GETCFILTERS:
+		handleGetCFilters(peer, payload, blc)
 
 	default:
 		fmt.Println("Unknown command!")
 	}
+}
+
+// 种子节点加入地址簿
+func addSeedAddresses() {
+
+	for _, seed := range seedNodes {
+
+		addrManager.AddAddress(seed, 0, time.Now().Unix())
+	}
+}
+
+// 主动连接数不足时从地址簿中选择节点连接  同时断开已被封禁的节点
+func maintainOutboundPeers(blc *Blockchain, handler messageHandler) {
+
+	for {
+
+		disconnectBannedPeers()
+
+		for outboundPeerCount() < maxOutboundPeers {
+
+			addr := selectOutboundAddr(SERVICE_FULL_NODE)
+			if addr == "" {
 
-	defer conn.Close()
+				break
+			}
+
+			chainLock.Lock()
+			bestHeight := blc.GetBestHeight()
+			chainLock.Unlock()
+
+			if connectOutbound(addr, bestHeight, handler) == nil {
+
+				break
+			}
+		}
+
+		time.Sleep(outboundInterval)
+	}
 }
 
-// 节点是否在已知节点中
-func nodeIsKnown(addr string) bool {
+// 选择一个要主动连接的地址  地址簿中没有可用地址且没有任何主动连接时使用种子节点
+func selectOutboundAddr(services uint64) string {
 
-	for _, node := range knowedNodes {
+	addr := addrManager.Select(services, isConnectedAddr)
+	if addr != "" || outboundPeerCount() > 0 {
 
-		if node == addr {
+		return addr
+	}
 
-			return true
+	for _, seed := range seedNodes {
+
+		if seed != nodeAddress && isConnectedAddr(seed) == false {
+
+			return seed
 		}
 	}
 
-	return false
+	return ""
+}
+
+// 主动连接一个节点并发送version  失败时返回nil
+func connectOutbound(addr string, bestHeight int64, handler messageHandler) *Peer {
+
+	addrManager.Attempt(addr)
+
+	peer, err := connectPeer(addr, handler)
+	if err != nil {
+
+		fmt.Printf("Connect to %s failed: %s\n", addr, err)
+		return nil
+	}
+
+	// 地址簿中的地址可能解析到被封禁的IP
+	if peer.IsBanned(banList) {
+
+		fmt.Printf("Disconnect %s: banned\n", peer)
+		peer.Close()
+		return nil
+	}
+
+	sendVersion(peer, bestHeight)
+
+	return peer
 }
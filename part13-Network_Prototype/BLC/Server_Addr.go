@@ -0,0 +1,45 @@
+package BLC
+
+// 节点地址
+type NetAddress struct {
+	// 监听地址  host:port
+	Addr string
+	// 提供的服务
+	Services uint64
+	// 最后一次确认这个地址可用的时间  Unix时间戳
+	Timestamp int64
+}
+
+// 告诉对方一些节点地址  握手后通告自己的地址，或者回复getaddr
+type Addr struct {
+	// 节点地址
+	AddrFrom string
+	// 最多maxAddrPerMessage个地址
+	AddrList []*NetAddress
+}
+
+func (addr *Addr) encode(w *binaryWriter) {
+
+	w.writeString(addr.AddrFrom)
+	w.writeVarInt(uint64(len(addr.AddrList)))
+	for _, na := range addr.AddrList {
+
+		w.writeString(na.Addr)
+		w.writeUint64(na.Services)
+		w.writeInt64(na.Timestamp)
+	}
+}
+
+func (addr *Addr) decode(r *binaryReader) {
+
+	addr.AddrFrom = r.readString()
+	count := r.readCount()
+	for i := 0; i < count && r.err == nil; i++ {
+
+		na := &NetAddress{}
+		na.Addr = r.readString()
+		na.Services = r.readUint64()
+		na.Timestamp = r.readInt64()
+		addr.AddrList = append(addr.AddrList, na)
+	}
+}
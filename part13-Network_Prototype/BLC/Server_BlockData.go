@@ -7,3 +7,15 @@ type BlockData struct {
 	// 序列化区块
 	BlockBytes []byte
 }
+
+func (blockData *BlockData) encode(w *binaryWriter) {
+
+	w.writeString(blockData.AddrFrom)
+	w.writeVarBytes(blockData.BlockBytes)
+}
+
+func (blockData *BlockData) decode(r *binaryReader) {
+
+	blockData.AddrFrom = r.readString()
+	blockData.BlockBytes = r.readVarBytes()
+}
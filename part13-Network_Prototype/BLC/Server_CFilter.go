@@ -0,0 +1,29 @@
+package BLC
+
+// 一个区块的过滤器
+type CFilter struct {
+	// 节点地址
+	AddrFrom string
+	// 过滤器类型
+	FilterType byte
+	// 区块哈希
+	BlockHash []byte
+	// GCS过滤器编码
+	Filter []byte
+}
+
+func (cFilter *CFilter) encode(w *binaryWriter) {
+
+	w.writeString(cFilter.AddrFrom)
+	w.writeByte(cFilter.FilterType)
+	w.writeVarBytes(cFilter.BlockHash)
+	w.writeVarBytes(cFilter.Filter)
+}
+
+func (cFilter *CFilter) decode(r *binaryReader) {
+
+	cFilter.AddrFrom = r.readString()
+	cFilter.FilterType = r.readByte()
+	cFilter.BlockHash = r.readVarBytes()
+	cFilter.Filter = r.readVarBytes()
+}
@@ -0,0 +1,17 @@
+package BLC
+
+// 请求对方地址簿中的节点地址
+type GetAddr struct {
+	// 节点地址
+	AddrFrom string
+}
+
+func (getAddr *GetAddr) encode(w *binaryWriter) {
+
+	w.writeString(getAddr.AddrFrom)
+}
+
+func (getAddr *GetAddr) decode(r *binaryReader) {
+
+	getAddr.AddrFrom = r.readString()
+}
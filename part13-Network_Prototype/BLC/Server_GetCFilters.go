@@ -0,0 +1,29 @@
+package BLC
+
+// 轻节点请求区块过滤器  返回主链上StartHeight到StopHash之间的每个区块的过滤器
+type GetCFilters struct {
+	// 节点地址
+	AddrFrom string
+	// 过滤器类型
+	FilterType byte
+	// 起始区块高度
+	StartHeight int64
+	// 最后一个区块的哈希
+	StopHash []byte
+}
+
+func (getCFilters *GetCFilters) encode(w *binaryWriter) {
+
+	w.writeString(getCFilters.AddrFrom)
+	w.writeByte(getCFilters.FilterType)
+	w.writeInt64(getCFilters.StartHeight)
+	w.writeVarBytes(getCFilters.StopHash)
+}
+
+func (getCFilters *GetCFilters) decode(r *binaryReader) {
+
+	getCFilters.AddrFrom = r.readString()
+	getCFilters.FilterType = r.readByte()
+	getCFilters.StartHeight = r.readInt64()
+	getCFilters.StopHash = r.readVarBytes()
+}
@@ -9,3 +9,17 @@ type GetData struct {
 	// 区块哈希或交易哈希
 	Hash       []byte
 }
+
+func (getData *GetData) encode(w *binaryWriter) {
+
+	w.writeString(getData.AddrFrom)
+	w.writeString(getData.Type)
+	w.writeVarBytes(getData.Hash)
+}
+
+func (getData *GetData) decode(r *binaryReader) {
+
+	getData.AddrFrom = r.readString()
+	getData.Type = r.readString()
+	getData.Hash = r.readVarBytes()
+}
@@ -0,0 +1,34 @@
+package BLC
+
+// 请求区块头  返回主链上分叉点之后最多maxHeadersPerMessage个区块头
+// 分叉点为定位器中第一个在对方主链上的区块，都不在时从创世区块开始
+type GetHeaders struct {
+	// 节点地址
+	AddrFrom string
+	// 请求方主链的区块定位器  从链顶端到创世区块
+	Locator [][]byte
+	// 最后一个需要的区块哈希  为空时返回到链顶端
+	StopHash []byte
+}
+
+func (getHeaders *GetHeaders) encode(w *binaryWriter) {
+
+	w.writeString(getHeaders.AddrFrom)
+	w.writeVarInt(uint64(len(getHeaders.Locator)))
+	for _, hash := range getHeaders.Locator {
+
+		w.writeVarBytes(hash)
+	}
+	w.writeVarBytes(getHeaders.StopHash)
+}
+
+func (getHeaders *GetHeaders) decode(r *binaryReader) {
+
+	getHeaders.AddrFrom = r.readString()
+	count := r.readCount()
+	for i := 0; i < count && r.err == nil; i++ {
+
+		getHeaders.Locator = append(getHeaders.Locator, r.readVarBytes())
+	}
+	getHeaders.StopHash = r.readVarBytes()
+}
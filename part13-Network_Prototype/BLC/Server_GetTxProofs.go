@@ -0,0 +1,62 @@
+package BLC
+
+// 交易输出的位置
+type OutPoint struct {
+	// 交易哈希
+	TxHash []byte
+	// 在该交易Vouts里的下标
+	Vout int
+}
+
+// 轻节点请求与钱包有关的交易及其默克尔证明
+// 输出支付给PubKeyHashes中的公钥哈希或脚本哈希、输入花费了OutPoints中的输出或输入的公钥属于PubKeyHashes的交易都会返回
+type GetTxProofs struct {
+	// 节点地址
+	AddrFrom string
+	// 从该高度开始查找
+	FromHeight int64
+	// 钱包地址的公钥哈希或脚本哈希
+	PubKeyHashes [][]byte
+	// 钱包已有的输出
+	OutPoints []*OutPoint
+}
+
+func (getTxProofs *GetTxProofs) encode(w *binaryWriter) {
+
+	w.writeString(getTxProofs.AddrFrom)
+	w.writeInt64(getTxProofs.FromHeight)
+
+	w.writeVarInt(uint64(len(getTxProofs.PubKeyHashes)))
+	for _, hash := range getTxProofs.PubKeyHashes {
+
+		w.writeVarBytes(hash)
+	}
+
+	w.writeVarInt(uint64(len(getTxProofs.OutPoints)))
+	for _, outPoint := range getTxProofs.OutPoints {
+
+		w.writeVarBytes(outPoint.TxHash)
+		w.writeInt32(int32(outPoint.Vout))
+	}
+}
+
+func (getTxProofs *GetTxProofs) decode(r *binaryReader) {
+
+	getTxProofs.AddrFrom = r.readString()
+	getTxProofs.FromHeight = r.readInt64()
+
+	count := r.readCount()
+	for i := 0; i < count && r.err == nil; i++ {
+
+		getTxProofs.PubKeyHashes = append(getTxProofs.PubKeyHashes, r.readVarBytes())
+	}
+
+	count = r.readCount()
+	for i := 0; i < count && r.err == nil; i++ {
+
+		outPoint := &OutPoint{}
+		outPoint.TxHash = r.readVarBytes()
+		outPoint.Vout = int(r.readInt32())
+		getTxProofs.OutPoints = append(getTxProofs.OutPoints, outPoint)
+	}
+}
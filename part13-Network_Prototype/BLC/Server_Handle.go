@@ -1,328 +1,639 @@
 package BLC
 
 import (
-	"log"
-	"encoding/gob"
 	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
 	"fmt"
 	"encoding/hex"
+	"math/rand"
+	"time"
 	"github.com/boltdb/bolt"
 )
 
 // Version命令处理器
-func handleVersion(request []byte, blc *Blockchain)  {
+func handleVersion(peer *Peer, request []byte, blc *Blockchain)  {
 
-	var buff bytes.Buffer
-	var payload Version
+	acceptVersion(peer, request, blc.GetBestHeight())
+}
+
+// 握手完成  对方区块更多时先向它要区块头
+func handleVerack(peer *Peer, request []byte, blc *Blockchain) {
+
+	if acceptVerack(peer) == false {
+
+		return
+	}
+
+	if peer.HasService(SERVICE_FULL_NODE) && peer.StartHeight > blc.GetBestHeight() {
 
-	dataBytes := request[COMMANDLENGTH:]
+		sendGetHeaders(peer, blc.BlockLocator(), nil)
+	}
+
+	if peer.Inbound == false {
+
+		// 连接成功的地址记为可用，通告自己的地址，再向对方要更多地址
+		addrManager.Good(peer.Addr, peer.Services)
+		sendAddr(peer, []*NetAddress{{nodeAddress, localServices, time.Now().Unix()}})
+		sendGetAddr(peer)
+	} else if peer.HasService(SERVICE_FULL_NODE) {
+
+		// 连入节点的监听地址还没有验证过
+		addrManager.AddAddress(peer.ListenAddr(), peer.Services, time.Now().Unix())
+	}
+}
+
+// 记录收到的地址  少量新地址继续转发，让新节点的地址在网络中传播
+func handleAddr(peer *Peer, request []byte, blc *Blockchain)  {
+
+	var payload Addr
 
 	// 反序列化
-	buff.Write(dataBytes)
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodeMessage(request, &payload)
 	if err != nil {
 
-		log.Panic(err)
+		peer.Misbehaving(scoreMalformed, fmt.Sprintf("bad %s: %s", COMMAND_ADDR, err))
+		return
+	}
+
+	if len(payload.AddrList) > maxAddrPerMessage {
+
+		peer.Misbehaving(scoreMalformed, fmt.Sprintf("%d addresses in one addr", len(payload.AddrList)))
+		return
+	}
+
+	var fresh []*NetAddress
+	for _, na := range payload.AddrList {
+
+		if addrManager.AddAddress(na.Addr, na.Services, na.Timestamp) {
+
+			fresh = append(fresh, na)
+		}
+	}
+	fmt.Printf("%d addresses received, %d new\n", len(payload.AddrList), len(fresh))
+
+	if len(fresh) == 0 || len(payload.AddrList) > addrRelayLimit {
+
+		return
 	}
 
-	// 提取最大区块高度作比较
-	bestHeight := blc.GetBestHeight()
-	foreignerBestHeight := payload.BestHeight
+	// 随机转发给另外几个全节点
+	var relayPeers []*Peer
+	for _, node := range connectedPeers() {
+
+		if node != peer && node.HasService(SERVICE_FULL_NODE) {
 
-	if bestHeight > foreignerBestHeight {
+			relayPeers = append(relayPeers, node)
+		}
+	}
+	rand.Shuffle(len(relayPeers), func(i, j int) {
 
-		// 向请求节点回复自身Version信息
-		sendVersion(payload.AddrFrom, blc)
-	} else if bestHeight < foreignerBestHeight {
+		relayPeers[i], relayPeers[j] = relayPeers[j], relayPeers[i]
+	})
+	if len(relayPeers) > addrRelayPeers {
 
-		// 向请求节点要信息
-		sendGetBlocks(payload.AddrFrom)
+		relayPeers = relayPeers[:addrRelayPeers]
 	}
 
-	// 添加到已知节点中
-	if !nodeIsKnown(payload.AddrFrom) {
+	for _, node := range relayPeers {
 
-		knowedNodes = append(knowedNodes, payload.AddrFrom)
+		sendAddr(node, fresh)
 	}
 }
 
-func handleAddr(request []byte, blc *Blockchain)  {
+// 回复地址簿中可用的地址
+func handleGetAddr(peer *Peer, request []byte, blc *Blockchain) {
 
+	var payload GetAddr
 
+	// 反序列化
+	err := decodeMessage(request, &payload)
+	if err != nil {
 
+		peer.Misbehaving(scoreMalformed, fmt.Sprintf("bad %s: %s", COMMAND_GETADDR, err))
+		return
+	}
 
+	sendAddr(peer, addrManager.AddressesToShare(maxAddrPerMessage))
 }
 
-func handleGetblocks(request []byte, blc *Blockchain)  {
+// 回复pong  对方据此确认连接正常
+func handlePing(peer *Peer, request []byte) {
+
+	var payload Ping
 
-	var buff bytes.Buffer
-	var payload GetBlocks
+	// 反序列化
+	err := decodeMessage(request, &payload)
+	if err != nil {
 
-	dataBytes := request[COMMANDLENGTH:]
+		fmt.Printf("Bad ping from %s: %s\n", peer, err)
+		return
+	}
+
+	sendPong(peer, payload.Nonce)
+}
+
+// 回复定位器分叉点之后的区块头
+func handleGetHeaders(peer *Peer, request []byte, blc *Blockchain) {
+
+	var payload GetHeaders
 
 	// 反序列化
-	buff.Write(dataBytes)
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodeMessage(request, &payload)
 	if err != nil {
-		log.Panic(err)
+
+		peer.Misbehaving(scoreMalformed, fmt.Sprintf("bad %s: %s", COMMAND_GETHEADERS, err))
+		return
 	}
 
-	blocks := blc.GetBlockHashes()
+	headers := blc.HeadersAfterLocator(payload.Locator, payload.StopHash, maxHeadersPerMessage)
 
-	sendInv(payload.AddrFrom, BLOCK_TYPE, blocks)
+	sendHeaders(peer, headers)
 }
 
-func handleGetData(request []byte, blc *Blockchain)  {
+// 校验收到的区块头，再逐个下载还没有的区块
+func handleHeaders(peer *Peer, request []byte, blc *Blockchain) {
 
-	var buff bytes.Buffer
-	var payload GetData
+	var payload Headers
+
+	// 反序列化
+	err := decodeMessage(request, &payload)
+	if err != nil {
+
+		peer.Misbehaving(scoreMalformed, fmt.Sprintf("bad %s: %s", COMMAND_HEADERS, err))
+		return
+	}
 
-	dataBytes := request[COMMANDLENGTH:]
+	var needed []queuedBlock
+	accepted := 0
+	for _, header := range payload.Headers {
+
+		need, err := blc.AcceptHeader(header)
+		if err != nil {
+
+			// 后面的区块头都基于这个区块头，一起丢弃  只有校验不通过才计入不良行为：
+			// 父区块头未知可能只是分叉点找错了，没有区块体的区块头太多时等区块下载完再继续，本地数据库错误也不是对方的问题
+			fmt.Printf("reject %s\n", err)
+			if errors.Is(err, errInvalidHeader) {
+
+				peer.Misbehaving(scoreInvalidBlock, fmt.Sprintf("invalid header %x", header.Hash()))
+			}
+			break
+		}
+		accepted++
+
+		if need && isQueuedHash(header.Hash()) == false {
+
+			needed = append(needed, queuedBlock{header.Hash(), peer})
+		}
+	}
+	fmt.Printf("%d headers received, %d blocks to download\n", len(payload.Headers), len(needed))
+
+	// 一批区块头装满且全部有效时，对方可能还有更多区块，下载完这一批再从最后一个区块头继续请求
+	headersSyncPeer = nil
+	if accepted >= maxHeadersPerMessage {
+
+		headersSyncPeer = peer
+		headersSyncTip = payload.Headers[accepted-1].Hash()
+	}
+
+	if len(needed) == 0 {
+
+		continueHeadersSync(blc)
+		return
+	}
+
+	// 正在下载上一批时接在后面，否则开始下载
+	if len(unslovedHashes) > 0 {
+
+		unslovedHashes = append(unslovedHashes, needed...)
+		return
+	}
+
+	sendGetData(peer, BLOCK_TYPE, needed[0].Hash)
+	unslovedHashes = needed[1:]
+}
+
+func handleGetData(peer *Peer, request []byte, blc *Blockchain)  {
+
+	var payload GetData
 
 	// 反序列化
-	buff.Write(dataBytes)
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodeMessage(request, &payload)
 	if err != nil {
 
-		log.Panic(err)
+		peer.Misbehaving(scoreMalformed, fmt.Sprintf("bad %s: %s", COMMAND_GETDATA, err))
+		return
 	}
 
 	if payload.Type == BLOCK_TYPE {
 
+		// 没有这个区块时不回复  发送空数据会让对方以为收到了错误的区块
 		block, err := blc.GetBlock([]byte(payload.Hash))
-		if err != nil {
+		if err != nil || block == nil {
 
 			return
 		}
 
-		sendBlock(payload.AddrFrom, block)
+		sendBlock(peer, block)
 	}
 
 	if payload.Type == TX_TYPE {
 
-		// 取出交易
+		// 取出交易  已经打包或不在交易池中时不回复
 		TxHash := hex.EncodeToString(payload.Hash)
-		tx := memTxPool[TxHash]
+		tx, ok := memTxPool[TxHash]
+		if ok == false {
+
+			return
+		}
 
-		sendTx(payload.AddrFrom, &tx)
+		sendTx(peer, &tx)
 	}
 }
 
-func handleBlock(request []byte, blc *Blockchain)  {
+func handleBlock(peer *Peer, request []byte, blc *Blockchain)  {
 
 	//fmt.Println("handleblock:\n")
 	//blc.Printchain()
 
-	var buff bytes.Buffer
 	var payload BlockData
 
-	dataBytes := request[COMMANDLENGTH:]
-
 	// 反序列化
-	buff.Write(dataBytes)
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodeMessage(request, &payload)
+	if err != nil {
+
+		peer.Misbehaving(scoreMalformed, fmt.Sprintf("bad %s: %s", COMMAND_BLOCK, err))
+		return
+	}
+
+	block, err := DecodeBlock(payload.BlockBytes)
 	if err != nil {
 
-		log.Panic(err)
+		peer.Misbehaving(scoreMalformed, fmt.Sprintf("bad block: %s", err))
+		return
 	}
 
-	block := DeSerializeBlock(payload.BlockBytes)
-	if block == nil {
+	// 父区块未知，说明落后了不止一个区块，先同步区块头
+	parentBytes, err := blc.GetBlock(block.PrevBlockHash)
+	if err == nil && parentBytes == nil {
 
-		fmt.Printf("Block nil")
+		sendGetHeaders(peer, blc.BlockLocator(), nil)
 	}
 
+	tipBefore := append([]byte{}, blc.Tip...)
+
 	err = blc.AddBlock(block)
 	if err != nil {
 
-		log.Panic(err)
+		// 不合法的区块直接丢弃，不写入数据库
+		// 只有校验不通过才是对方的问题，本地数据库错误等不计入不良行为
+		fmt.Printf("%s\n", err)
+		var rejectErr *BlockRejectError
+		if errors.As(err, &rejectErr) {
+
+			peer.Misbehaving(scoreInvalidBlock, fmt.Sprintf("invalid block %x: %s", block.Hash, rejectErr.Reason))
+		}
+		return
 	}
 	fmt.Printf("add block %x succ.\n", block.Hash)
+
+	// 成为新的链顶端时通告给其他全节点
+	if bytes.Compare(tipBefore, blc.Tip) != 0 && bytes.Compare(blc.Tip, block.Hash) == 0 {
+
+		relayInv(peer, BLOCK_TYPE, block.Hash)
+	}
 	//blc.Printchain()
 
-	if len(unslovedHashes) > 0 {
+	next := nextQueuedBlock()
+	if next != nil {
+
+		// 向通告区块头的节点请求，发来这个区块的节点不一定有下一个区块
+		sendGetData(next.Peer, BLOCK_TYPE, next.Hash)
+	} else {
+
+		// 这一批区块下载完，继续请求下一批区块头
+		continueHeadersSync(blc)
+	}
+}
+
+// 取出下一个要下载的区块  通告它的节点已经断开时跳过，之后重新同步区块头时会再次请求
+func nextQueuedBlock() *queuedBlock {
+
+	for len(unslovedHashes) > 0 {
 
-		sendGetData(payload.AddrFrom, BLOCK_TYPE, unslovedHashes[0])
+		next := unslovedHashes[0]
 		unslovedHashes = unslovedHashes[1:]
-	}else {
 
-		//blc.Printchain()
-		utxoSet := &UTXOSet{blc}
-		utxoSet.ResetUTXOSet()
+		if isPeerClosed(next.Peer) == false {
+
+			return &next
+		}
 	}
+
+	return nil
 }
 
+// 上一批区块头装满时，用最后一个区块头生成定位器继续请求
+// 这一批在侧链上时本地主链没有变化，用主链的定位器会收到同样的区块头
+func continueHeadersSync(blc *Blockchain) {
 
-func handleTx(request []byte, blc *Blockchain)  {
+	if headersSyncPeer == nil {
+
+		return
+	}
+
+	tip := blc.lookupBlockHeader(headersSyncTip)
+	if tip == nil {
+
+		return
+	}
+
+	sendGetHeaders(headersSyncPeer, headerLocator(tip, blc.lookupBlockHeader), nil)
+}
+
+
+func handleTx(peer *Peer, request []byte, blc *Blockchain)  {
 
-	var buff bytes.Buffer
 	var payload TxData
 
-	dataBytes := request[COMMANDLENGTH:]
-	buff.Write(dataBytes)
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	// 反序列化
+	err := decodeMessage(request, &payload)
 	if err != nil {
 
-		log.Panic(err)
+		peer.Misbehaving(scoreMalformed, fmt.Sprintf("bad %s: %s", COMMAND_TX, err))
+		return
 	}
 
-	tx := DeserializeTransaction(payload.TransactionBytes)
-	memTxPool[hex.EncodeToString(tx.TxHash)] = tx
+	decoded, err := DecodeTransaction(payload.TransactionBytes)
+	if err != nil {
 
-	// 自身为主节点，需要将交易转发给矿工节点
-	if nodeAddress == knowedNodes[0] {
+		peer.Misbehaving(scoreMalformed, fmt.Sprintf("bad tx: %s", err))
+		return
+	}
+	tx := *decoded
 
-		for _, node := range knowedNodes {
+	// 已经在交易池中，不再重复处理和转发
+	if _, ok := memTxPool[hex.EncodeToString(tx.TxHash)]; ok {
 
-			if node != nodeAddress && node != payload.AddFrom {
+		return
+	}
 
-				sendInv(node, TX_TYPE, [][]byte{tx.TxHash})
-			}
-		}
-	} else {
+	// 交易哈希必须与内容一致，否则不进入交易池
+	_, err = checkTransactionSanity(&tx)
+	if err != nil {
 
-		//fmt.Println(len(memTxPool), len(miningAddress))
-		if len(memTxPool) >= minMinerTxCount && len(miningAddress) > 0 {
+		fmt.Printf("reject tx %x: %s\n", tx.TxHash, err)
+		peer.Misbehaving(scoreInvalidTx, fmt.Sprintf("invalid tx %x", tx.TxHash))
+		return
+	}
 
-		MineTransactions:
+	// 不能与交易池中的交易花费同一个输出  先到的交易优先，冲突交易可能是正常转发的，不计入不良行为
+	if spender := mempoolConflict(&tx); spender != "" {
 
-			var txs []*Transaction
-			// 创币交易，作为挖矿奖励
-			coinbaseTx := NewCoinbaseTransaction(miningAddress)
-			txs = append(txs, coinbaseTx)
+		fmt.Printf("reject tx %x: conflicts with mempool tx %s\n", tx.TxHash, spender)
+		return
+	}
 
-			var verifyTxs []*Transaction
+	// 引用的输出必须在交易池或UTXOSet中  可能只是已经被花费，不计入不良行为
+	utxoSet := &UTXOSet{blc}
+	prevOuts, err := mempoolPrevOuts(utxoSet, &tx)
+	if err != nil {
 
-			for id := range memTxPool {
+		fmt.Printf("reject tx %x: %s\n", tx.TxHash, err)
+		return
+	}
 
-				tx := memTxPool[id]
-				if blc.VerifyTransaction(&tx, verifyTxs) {
+	// 签名必须有效，输出总额不能超过输入总额  与打包区块时的检查相同
+	fee, _, err := checkTransactionInputs(&tx, prevOuts)
+	if err != nil {
 
-					txs = append(txs, &tx)
-					verifyTxs = append(verifyTxs, &tx)
-				}else {
+		fmt.Printf("reject %s\n", err)
+		peer.Misbehaving(scoreInvalidTx, fmt.Sprintf("invalid tx %x", tx.TxHash))
+		return
+	}
 
-					log.Panic("the transaction  invalid...\n")
-				}
-			}
+	// 未成熟的创币奖励不能花费
+	err = checkMempoolMaturity(utxoSet, &tx)
+	if err != nil {
 
-			fmt.Println("All transactions verified succ!\n")
+		fmt.Printf("reject tx %x: %s\n", tx.TxHash, err)
+		return
+	}
 
-			// 建立新区块
-			var block *Block
-			// 取出上一个区块
-			err = blc.DB.View(func(tx *bolt.Tx) error {
+	// 时间锁必须在下一个区块中已经到期
+	err = utxoSet.CheckTransactionLocks(&tx)
+	if err != nil {
 
-				b := tx.Bucket([]byte(blockTableName))
-				if b != nil {
+		fmt.Printf("reject tx %x: %s\n", tx.TxHash, err)
+		return
+	}
 
-					hash := b.Get([]byte(newestBlockKey))
-					block = DeSerializeBlock(b.Get(hash))
-				}
+	fmt.Printf("tx %x fee %d, fee rate %d/kB\n", tx.TxHash, fee, fee*1000/int64(len(tx.Serialize())))
 
-				return nil
-			})
-			if err != nil {
+	addMempoolTx(&tx)
 
-				log.Panic(err)
-			}
+	// 转发给其他全节点
+	relayInv(peer, TX_TYPE, tx.TxHash)
 
-			//构造新区块
-			block = NewBlock(txs, block.Height+1, block.Hash)
+	// 指定了挖矿地址的节点打包交易
+	if mempoolReadyToMine() {
 
-			fmt.Println("New block is mined!")
+		// 挖矿时不能阻塞这个连接，否则收不到其他区块
+		go mineMempool(blc)
+	}
+}
 
-			// 添加到数据库
-			err = blc.DB.Update(func(tx *bolt.Tx) error {
+// 打包交易池中的交易挖矿，挖到后发送给所有已连接的节点
+func mineMempool(blc *Blockchain) {
 
-				b := tx.Bucket([]byte(blockTableName))
-				if b != nil {
+	// 已经在挖矿，新交易留在交易池等下一轮打包
+	if atomic.CompareAndSwapInt32(&isMining, 0, 1) == false {
 
-					b.Put(block.Hash, block.Serialize())
-					b.Put([]byte(newestBlockKey), block.Hash)
-					blc.Tip = block.Hash
+		return
+	}
+	defer atomic.StoreInt32(&isMining, 0)
 
-				}
-				return nil
-			})
-			if err != nil {
+	// 每一轮打包一个区块，挖矿期间交易池中又有了足够的交易时继续下一轮
+	for mineMempoolBlock(blc) {
 
-				log.Panic(err)
-			}
+	}
+}
 
-			utxoSet := UTXOSet{blc}
-			//utxoSet.Update()
-			utxoSet.ResetUTXOSet()
+// 打包交易池中的交易挖一个区块  返回是否继续打包下一个区块
+// 读取链顶端失败或区块被拒绝时停止，否则交易池中的交易足够打包(mempoolReadyToMine)时继续
+func mineMempoolBlock(blc *Blockchain) bool {
 
-			// 去除内存池中打包到区块的交易
-			for _, tx := range txs {
+	// 选择交易和构造区块时持有chainLock，计算工作量证明时释放，不阻塞消息处理
+	chainLock.Lock()
 
-				fmt.Println("delete...")
-				TxHash := hex.EncodeToString(tx.TxHash)
-				delete(memTxPool, TxHash)
-			}
+	// 挖矿期间收到新区块导致主链顶端变化时放弃当前区块
+	ctx, cancel := cancelOnTipChange(context.Background())
 
-			// 发送区块给其他节点
-			//sendBlock(knowedNodes[0], block.Serialize())
-			for _, node := range knowedNodes {
+	var txs []*Transaction
+	var verifyTxs []*Transaction
 
-				if node != nodeAddress {
+	// 手续费率高的交易优先打包
+	utxoSet := &UTXOSet{blc}
+	for _, tx := range selectMempoolTransactions(utxoSet) {
 
-					sendBlock(node, block.Serialize())
-				}
-			}
+		if blc.VerifyTransaction(tx, verifyTxs) {
 
-			if len(memTxPool) > 0 {
+			txs = append(txs, tx)
+			verifyTxs = append(verifyTxs, tx)
+		}else {
 
-				goto MineTransactions
-			}
+			// 无效的交易不打包，从交易池中移除
+			fmt.Printf("Remove invalid tx %x from mempool\n", tx.TxHash)
+			removeMempoolTx(hex.EncodeToString(tx.TxHash))
 		}
 	}
+
+	fmt.Print("All transactions verified succ!\n\n")
+
+	// 创币交易，作为挖矿奖励  区块奖励加上打包交易的手续费
+	var fees int64
+	for _, tx := range txs {
+
+		fees += utxoSet.TransactionFee(tx, txs)
+	}
+	coinbaseTx := NewCoinbaseTransaction(miningAddress, blc.GetBestHeight()+1, fees)
+	txs = append([]*Transaction{coinbaseTx}, txs...)
+
+	// 建立新区块
+	var block *Block
+	// 取出上一个区块
+	err := blc.DB.View(func(tx *bolt.Tx) error {
+
+		b := tx.Bucket([]byte(blockTableName))
+		if b != nil {
+
+			hash := b.Get([]byte(newestBlockKey))
+			block = DeSerializeBlock(b.Get(hash))
+		}
+
+		return nil
+	})
+	if err != nil || block == nil {
+
+		fmt.Printf("Mining aborted: cannot load chain tip: %v\n", err)
+		cancel()
+		chainLock.Unlock()
+		return false
+	}
+
+	targetBits := blc.NextTargetBits(&block.BlockHeader)
+	chainLock.Unlock()
+
+	//构造新区块  难度按调整规则计算
+	block, err = MineBlock(ctx, txs, block.Height+1, block.Hash, targetBits)
+	cancel()
+
+	chainLock.Lock()
+	if err != nil {
+
+		// 已被其他节点抢先，交易池已随新区块更新，基于新的链顶端重新挖矿
+		fmt.Printf("Mining aborted: %s\n", err)
+		retry := mempoolReadyToMine()
+		chainLock.Unlock()
+		return retry
+	}
+
+	fmt.Println("New block is mined!")
+
+	// 添加到区块链  同时更新UTXOSet
+	// 交易池中的交易没有全部校验过，被拒绝时丢弃这个区块
+	err = blc.AddBlock(block)
+	if err != nil {
+
+		// 剔除交易池中失效或冲突的交易，否则下一轮还会打包出同样被拒绝的区块
+		fmt.Printf("Mined block rejected: %s\n", err)
+		pruneMempool(utxoSet)
+		chainLock.Unlock()
+		return false
+	}
+
+	// 挖矿期间收到的区块可能已经延长了主链，新区块只进入侧链时交易没有被确认，留在交易池重新打包
+	if bytes.Compare(blc.Tip, block.Hash) != 0 {
+
+		fmt.Printf("Mined block %x is not the chain tip\n", block.Hash)
+		retry := mempoolReadyToMine()
+		chainLock.Unlock()
+		return retry
+	}
+
+	// 去除内存池中打包到区块的交易
+	for _, tx := range txs {
+
+		fmt.Println("delete...")
+		TxHash := hex.EncodeToString(tx.TxHash)
+		removeMempoolTx(TxHash)
+	}
+
+	// 通告给所有全节点
+	relayInv(nil, BLOCK_TYPE, block.Hash)
+
+	retry := mempoolReadyToMine()
+	chainLock.Unlock()
+	return retry
 }
 
+// 交易池中的交易是否足够打包一个区块  调用时持有chainLock
+func mempoolReadyToMine() bool {
 
-func handleInv(request []byte, blc *Blockchain)  {
+	return len(memTxPool) >= minMinerTxCount && len(miningAddress) > 0
+}
 
-	var buff bytes.Buffer
-	var payload Inv
+func handleInv(peer *Peer, request []byte, blc *Blockchain)  {
 
-	dataBytes := request[COMMANDLENGTH:]
+	var payload Inv
 
 	// 反序列化
-	buff.Write(dataBytes)
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodeMessage(request, &payload)
 	if err != nil {
-		log.Panic(err)
+
+		peer.Misbehaving(scoreMalformed, fmt.Sprintf("bad %s: %s", COMMAND_INV, err))
+		return
 	}
 
-	// Ivn 3000 block hashes [][]
 	if payload.Type == BLOCK_TYPE {
 
-		fmt.Println(payload.Items)
-
-		blockHash := payload.Items[0]
-		sendGetData(payload.AddrFrom, BLOCK_TYPE , blockHash)
+		// 有不知道的区块时先向对方同步区块头，再下载区块
+		for _, hash := range payload.Items {
 
-		if len(payload.Items) >= 1 {
+			blockBytes, err := blc.GetBlock(hash)
+			if err == nil && blockBytes == nil {
 
-			unslovedHashes = payload.Items[1:]
+				sendGetHeaders(peer, blc.BlockLocator(), nil)
+				break
+			}
 		}
 	}
 
 	if payload.Type == TX_TYPE {
 
-		TxHash := payload.Items[0]
+		for _, TxHash := range payload.Items {
 
-		// 添加到交易池
-		if memTxPool[hex.EncodeToString(TxHash)].TxHash == nil {
+			// 不在交易池中的交易向对方请求
+			if _, ok := memTxPool[hex.EncodeToString(TxHash)]; ok == false {
 
-			sendGetData(payload.AddrFrom, TX_TYPE, TxHash)
+				sendGetData(peer, TX_TYPE, TxHash)
+			}
 		}
 	}
-}
\ No newline at end of file
+}
+
+// 区块是否已经在下载队列中
+func isQueuedHash(hash []byte) bool {
+
+	for _, queued := range unslovedHashes {
+
+		if bytes.Compare(queued.Hash, hash) == 0 {
+
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,145 @@
+package BLC
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+/**
+握手
+
+1.主动连接的一方先发送version
+2.被连接的一方收到version后回复自己的version和verack
+3.主动连接的一方收到version后回复verack
+双方都收到verack后握手完成，之后才能收发其他消息
+协议版本低于MIN_PROTOCOL_VERSION、收到自己的Nonce(连接到了自己)或者消息顺序不对时断开连接
+*/
+
+// 本节点提供的服务  启动节点时设置
+var localServices uint64
+
+// 本节点的Nonce  每次启动时随机生成
+var localNonce = newNonce()
+
+func newNonce() uint64 {
+
+	var buf [8]byte
+	_, err := rand.Read(buf[:])
+	if err != nil {
+
+		log.Panic(err)
+	}
+
+	return binary.LittleEndian.Uint64(buf[:])
+}
+
+// 处理对方的version  被连接时回复自己的version(bestHeight为本节点的区块高度)，再回复verack
+// 返回false时连接已断开
+func acceptVersion(peer *Peer, request []byte, bestHeight int64) bool {
+
+	var payload Version
+
+	// 反序列化
+	err := decodeMessage(request, &payload)
+	if err != nil {
+
+		fmt.Printf("Disconnect %s: bad version: %s\n", peer, err)
+		peer.Misbehaving(scoreMalformed, "bad version")
+		peer.Close()
+		return false
+	}
+
+	if peer.versionReceived {
+
+		fmt.Printf("Disconnect %s: duplicate version\n", peer)
+		peer.Misbehaving(scoreProtocol, "duplicate version")
+		peer.Close()
+		return false
+	}
+
+	if payload.Nonce == localNonce {
+
+		fmt.Printf("Disconnect %s: connected to self\n", peer)
+		peer.Close()
+		return false
+	}
+
+	if payload.Version < MIN_PROTOCOL_VERSION {
+
+		fmt.Printf("Disconnect %s: protocol version %d is too old, need %d\n", peer, payload.Version, MIN_PROTOCOL_VERSION)
+		peer.Close()
+		return false
+	}
+
+	peer.versionReceived = true
+	peer.ProtocolVersion = payload.Version
+	peer.Services = payload.Services
+	peer.UserAgent = payload.UserAgent
+	peer.StartHeight = payload.BestHeight
+	peer.setListenAddr(payload.AddrFrom)
+	peer.TimeOffset = payload.Timestamp - time.Now().Unix()
+
+	// 本机节点连入时才知道它声明的监听地址，自称是被封禁节点的在这里断开
+	if banList != nil && peer.IsBanned(banList) {
+
+		fmt.Printf("Disconnect %s: banned\n", peer)
+		peer.Close()
+		return false
+	}
+
+	if peer.Inbound {
+
+		sendVersion(peer, bestHeight)
+	}
+	sendVerack(peer)
+
+	return true
+}
+
+// 处理verack  返回握手是否完成，没有收到version就收到verack时断开连接
+func acceptVerack(peer *Peer) bool {
+
+	if peer.versionReceived == false || peer.HandshakeDone() {
+
+		fmt.Printf("Disconnect %s: unexpected verack\n", peer)
+		peer.Misbehaving(scoreProtocol, "unexpected verack")
+		peer.Close()
+		return false
+	}
+
+	close(peer.handshake)
+
+	fmt.Printf("Handshake with %s: version %d, services %s, %s, height %d, time offset %ds\n",
+		peer, peer.ProtocolVersion, servicesString(peer.Services), peer.UserAgent, peer.StartHeight, peer.TimeOffset)
+
+	return true
+}
+
+// 服务标志位的可读形式
+func servicesString(services uint64) string {
+
+	var names []string
+	if services&SERVICE_FULL_NODE != 0 {
+
+		names = append(names, "full")
+	}
+	if services&SERVICE_SPV != 0 {
+
+		names = append(names, "spv")
+	}
+	if services&SERVICE_MINER != 0 {
+
+		names = append(names, "miner")
+	}
+
+	if len(names) == 0 {
+
+		return "none"
+	}
+
+	return strings.Join(names, ",")
+}
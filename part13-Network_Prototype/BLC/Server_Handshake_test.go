@@ -0,0 +1,159 @@
+package BLC
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 只处理握手消息
+func handshakeTestHandler(peer *Peer, command string, payload []byte) {
+
+	switch command {
+
+	case COMMAND_VERSION:
+		acceptVersion(peer, payload, 5)
+
+	case COMMAND_VERACK:
+		acceptVerack(peer)
+	}
+}
+
+func testVersion(version int64, nonce uint64) *Version {
+
+	return &Version{version, SERVICE_FULL_NODE | SERVICE_MINER, time.Now().Unix(), nonce, "/test:0.1/", 7, "203.0.113.10:3000"}
+}
+
+func writeTestMessage(t *testing.T, conn net.Conn, command string, payload []byte) {
+
+	err := writeMessage(conn, command, payload)
+	if err != nil {
+
+		t.Fatalf("write %s: %s", command, err)
+	}
+}
+
+// 对方一端依次读到commands
+func expectTestMessages(t *testing.T, conn net.Conn, commands ...string) {
+
+	for _, want := range commands {
+
+		command, _, err := readMessage(conn)
+		if err != nil || command != want {
+
+			t.Fatalf("read %s: %v, want %s", command, err, want)
+		}
+	}
+}
+
+func TestHandshake(t *testing.T) {
+
+	chdirTemp(t)
+	useTestBanList(t, "test")
+
+	// 被连接的一方回复version和verack，主动连接的一方只回复verack
+	for _, inbound := range []bool{true, false} {
+
+		peer, remote := newPipeTestPeer(t, "203.0.113.10:40000", inbound, handshakeTestHandler)
+
+		writeTestMessage(t, remote, COMMAND_VERSION, encodeMessage(testVersion(NODE_VERSION, localNonce+1)))
+		if inbound {
+
+			var version Version
+			command, payload, err := readMessage(remote)
+			if err != nil || command != COMMAND_VERSION || decodeMessage(payload, &version) != nil {
+
+				t.Fatalf("read %s: %v, want version", command, err)
+			}
+			if version.Nonce != localNonce || version.BestHeight != 5 {
+
+				t.Errorf("version nonce %d height %d", version.Nonce, version.BestHeight)
+			}
+		}
+		expectTestMessages(t, remote, COMMAND_VERACK)
+		if peer.HandshakeDone() {
+
+			t.Fatalf("handshake done before verack")
+		}
+
+		writeTestMessage(t, remote, COMMAND_VERACK, encodeMessage(&Verack{}))
+		select {
+
+		case <-peer.Handshake():
+
+		case <-time.After(10 * time.Second):
+			t.Fatalf("inbound %v: handshake not done", inbound)
+		}
+
+		if peer.HasService(SERVICE_FULL_NODE|SERVICE_MINER) == false || peer.HasService(SERVICE_SPV) {
+
+			t.Errorf("services %s", servicesString(peer.Services))
+		}
+		if peer.ListenAddr() != "203.0.113.10:3000" || peer.StartHeight != 7 || peer.UserAgent != "/test:0.1/" || peer.ProtocolVersion != NODE_VERSION {
+
+			t.Errorf("peer %+v", peer)
+		}
+	}
+}
+
+// 连接到自己、协议版本过低、消息顺序不对时断开  只有消息顺序不对计入不良行为
+func TestHandshakeDisconnects(t *testing.T) {
+
+	chdirTemp(t)
+	useTestBanList(t, "test")
+
+	tests := []struct {
+		name  string
+		run   func(t *testing.T, remote net.Conn)
+		score int32
+	}{
+		{"connected to self", func(t *testing.T, remote net.Conn) {
+
+			writeTestMessage(t, remote, COMMAND_VERSION, encodeMessage(testVersion(NODE_VERSION, localNonce)))
+		}, 0},
+		{"old protocol version", func(t *testing.T, remote net.Conn) {
+
+			writeTestMessage(t, remote, COMMAND_VERSION, encodeMessage(testVersion(MIN_PROTOCOL_VERSION-1, localNonce+1)))
+		}, 0},
+		{"verack before version", func(t *testing.T, remote net.Conn) {
+
+			writeTestMessage(t, remote, COMMAND_VERACK, encodeMessage(&Verack{}))
+		}, scoreProtocol},
+		{"duplicate version", func(t *testing.T, remote net.Conn) {
+
+			writeTestMessage(t, remote, COMMAND_VERSION, encodeMessage(testVersion(NODE_VERSION, localNonce+1)))
+			expectTestMessages(t, remote, COMMAND_VERSION, COMMAND_VERACK)
+			writeTestMessage(t, remote, COMMAND_VERSION, encodeMessage(testVersion(NODE_VERSION, localNonce+1)))
+		}, scoreProtocol},
+		{"duplicate verack", func(t *testing.T, remote net.Conn) {
+
+			writeTestMessage(t, remote, COMMAND_VERSION, encodeMessage(testVersion(NODE_VERSION, localNonce+1)))
+			expectTestMessages(t, remote, COMMAND_VERSION, COMMAND_VERACK)
+			writeTestMessage(t, remote, COMMAND_VERACK, encodeMessage(&Verack{}))
+			writeTestMessage(t, remote, COMMAND_VERACK, encodeMessage(&Verack{}))
+		}, scoreProtocol},
+		{"bad version payload", func(t *testing.T, remote net.Conn) {
+
+			writeTestMessage(t, remote, COMMAND_VERSION, []byte{0xff})
+		}, scoreMalformed},
+	}
+
+	for _, test := range tests {
+
+		peer, remote := newPipeTestPeer(t, "203.0.113.11:40000", true, handshakeTestHandler)
+		test.run(t, remote)
+
+		select {
+
+		case <-peer.Done():
+
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: not disconnected", test.name)
+		}
+		if score := atomic.LoadInt32(&peer.banScore); score != test.score {
+
+			t.Errorf("%s: score %d, want %d", test.name, score, test.score)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package BLC
+
+// 回复getheaders  按高度从低到高排列的区块头，最多maxHeadersPerMessage个
+type Headers struct {
+	// 节点地址
+	AddrFrom string
+	// 区块头
+	Headers []*BlockHeader
+}
+
+func (headers *Headers) encode(w *binaryWriter) {
+
+	w.writeString(headers.AddrFrom)
+	w.writeVarInt(uint64(len(headers.Headers)))
+	for _, header := range headers.Headers {
+
+		header.encode(w)
+	}
+}
+
+func (headers *Headers) decode(r *binaryReader) {
+
+	headers.AddrFrom = r.readString()
+	count := r.readCount()
+	for i := 0; i < count && r.err == nil; i++ {
+
+		header := decodeBlockHeader(r)
+		headers.Headers = append(headers.Headers, &header)
+	}
+}
@@ -9,3 +9,25 @@ type Inv struct {
 	// hash二维数组
 	Items    [][]byte
 }
+
+func (inv *Inv) encode(w *binaryWriter) {
+
+	w.writeString(inv.AddrFrom)
+	w.writeString(inv.Type)
+	w.writeVarInt(uint64(len(inv.Items)))
+	for _, item := range inv.Items {
+
+		w.writeVarBytes(item)
+	}
+}
+
+func (inv *Inv) decode(r *binaryReader) {
+
+	inv.AddrFrom = r.readString()
+	inv.Type = r.readString()
+	count := r.readCount()
+	for i := 0; i < count && r.err == nil; i++ {
+
+		inv.Items = append(inv.Items, r.readVarBytes())
+	}
+}
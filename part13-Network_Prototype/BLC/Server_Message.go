@@ -0,0 +1,100 @@
+package BLC
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/**
+网络消息的帧格式  同一个连接上可以连续收发多条消息
+
+消息头24字节：
+1.魔数(uint32小端序)  区分网络，也用于发现数据错位
+2.命令名(12字节)  不足的部分补0
+3.消息长度(uint32小端序)
+4.校验和(4字节)  消息内容sha256(sha256())的前4个字节
+之后是消息内容
+*/
+
+// 网络魔数
+const networkMagic = 0xc4a1b2d3
+
+// 消息头长度
+const messageHeaderSize = 4 + COMMANDLENGTH + 4 + 4
+
+// 单条消息内容的最大长度  足够放下最大的区块
+const maxMessagePayload = 4 * maxBlockSize
+
+// 魔数、长度或校验和不对  对方发送了错误的数据
+var errMalformedMessage = errors.New("malformed message")
+
+// 消息内容的校验和
+func messageChecksum(payload []byte) []byte {
+
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+
+	return second[:4]
+}
+
+// 写入一条消息
+func writeMessage(w io.Writer, command string, payload []byte) error {
+
+	if len(command) > COMMANDLENGTH {
+
+		return fmt.Errorf("command %s is too long", command)
+	}
+
+	header := make([]byte, messageHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:4], networkMagic)
+	copy(header[4:4+COMMANDLENGTH], commandToBytes(command))
+	binary.LittleEndian.PutUint32(header[4+COMMANDLENGTH:8+COMMANDLENGTH], uint32(len(payload)))
+	copy(header[8+COMMANDLENGTH:], messageChecksum(payload))
+
+	_, err := w.Write(append(header, payload...))
+
+	return err
+}
+
+// 读取一条消息  魔数、长度或校验和不对时返回错误，连接应当断开
+func readMessage(r io.Reader) (string, []byte, error) {
+
+	header := make([]byte, messageHeaderSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+
+		return "", nil, err
+	}
+
+	magic := binary.LittleEndian.Uint32(header[0:4])
+	if magic != networkMagic {
+
+		return "", nil, fmt.Errorf("%w: bad network magic %08x", errMalformedMessage, magic)
+	}
+
+	command := bytesToCommand(header[4 : 4+COMMANDLENGTH])
+
+	length := binary.LittleEndian.Uint32(header[4+COMMANDLENGTH : 8+COMMANDLENGTH])
+	if length > maxMessagePayload {
+
+		return "", nil, fmt.Errorf("%w: %s too large: %d bytes", errMalformedMessage, command, length)
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+
+		return "", nil, err
+	}
+
+	if bytes.Compare(messageChecksum(payload), header[8+COMMANDLENGTH:]) != 0 {
+
+		return "", nil, fmt.Errorf("%w: %s checksum mismatch", errMalformedMessage, command)
+	}
+
+	return command, payload, nil
+}
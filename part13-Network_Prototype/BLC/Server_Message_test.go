@@ -0,0 +1,196 @@
+package BLC
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 同一个连接上连续的多条消息按顺序读出
+func TestMessageFraming(t *testing.T) {
+
+	messages := []struct {
+		command string
+		payload []byte
+	}{
+		{COMMAND_VERSION, []byte("version payload")},
+		{COMMAND_VERACK, nil},
+		{COMMAND_PING, bytes.Repeat([]byte{0x5a}, 1000)},
+	}
+
+	var buf bytes.Buffer
+	for _, msg := range messages {
+
+		err := writeMessage(&buf, msg.command, msg.payload)
+		if err != nil {
+
+			t.Fatal(err)
+		}
+	}
+	if buf.Len() != 3*messageHeaderSize+len("version payload")+1000 {
+
+		t.Errorf("encoded %d bytes", buf.Len())
+	}
+
+	for _, msg := range messages {
+
+		command, payload, err := readMessage(&buf)
+		if err != nil || command != msg.command || bytes.Compare(payload, msg.payload) != 0 {
+
+			t.Fatalf("read %s %x: %v, want %s %x", command, payload, err, msg.command, msg.payload)
+		}
+	}
+	if _, _, err := readMessage(&buf); err != io.EOF {
+
+		t.Errorf("read after the last message: %v, want EOF", err)
+	}
+
+	if err := writeMessage(&buf, "commandtoolong", nil); err == nil {
+
+		t.Errorf("command longer than %d bytes written", COMMANDLENGTH)
+	}
+}
+
+func TestReadMessageMalformed(t *testing.T) {
+
+	var buf bytes.Buffer
+	err := writeMessage(&buf, COMMAND_PING, []byte("12345678"))
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+
+	tests := []struct {
+		name      string
+		change    func(data []byte) []byte
+		malformed bool
+	}{
+		{"bad magic", func(data []byte) []byte { data[0] ^= 0xff; return data }, true},
+		{"too large", func(data []byte) []byte {
+			binary.LittleEndian.PutUint32(data[4+COMMANDLENGTH:], maxMessagePayload+1)
+			return data
+		}, true},
+		{"checksum", func(data []byte) []byte { data[messageHeaderSize-1] ^= 0xff; return data }, true},
+		{"payload changed", func(data []byte) []byte { data[len(data)-1] ^= 0xff; return data }, true},
+		// 数据不完整是连接断开，不是对方的错误
+		{"short header", func(data []byte) []byte { return data[:messageHeaderSize-1] }, false},
+		{"short payload", func(data []byte) []byte { return data[:len(data)-1] }, false},
+	}
+
+	for _, test := range tests {
+
+		data := test.change(append([]byte{}, valid...))
+		_, _, err := readMessage(bytes.NewReader(data))
+		if err == nil || errors.Is(err, errMalformedMessage) != test.malformed {
+
+			t.Errorf("%s: got %v, want malformed %v", test.name, err, test.malformed)
+		}
+	}
+}
+
+// 通过内存管道连接的节点  返回节点和对方一端的连接，测试结束后断开
+func newPipeTestPeer(t *testing.T, remote string, inbound bool, handler messageHandler) (*Peer, net.Conn) {
+
+	remoteAddr, err := net.ResolveTCPAddr("tcp", remote)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	local, other := net.Pipe()
+	other.SetDeadline(time.Now().Add(10 * time.Second))
+
+	peer := newPeer(&banTestConn{local, remoteAddr}, remote, inbound)
+	peer.Start(handler)
+	t.Cleanup(func() {
+
+		peer.Close()
+		other.Close()
+	})
+
+	return peer, other
+}
+
+// 持久连接  发送队列中的消息写到对方，握手前的其他消息不处理，错误的数据断开连接并计分
+func TestPeerConnection(t *testing.T) {
+
+	chdirTemp(t)
+	useTestBanList(t, "test")
+
+	received := make(chan string, 10)
+	peer, remote := newPipeTestPeer(t, "203.0.113.9:3000", true, func(peer *Peer, command string, payload []byte) {
+
+		received <- command
+	})
+
+	peer.Send(COMMAND_VERACK, nil)
+	command, _, err := readMessage(remote)
+	if err != nil || command != COMMAND_VERACK {
+
+		t.Fatalf("remote read %s: %v", command, err)
+	}
+
+	err = writeMessage(remote, COMMAND_INV, nil)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	// 等读协程处理完这条消息再完成握手
+	for deadline := time.Now().Add(10 * time.Second); atomic.LoadInt32(&peer.banScore) == 0; time.Sleep(time.Millisecond) {
+
+		if time.Now().After(deadline) {
+
+			t.Fatalf("message before handshake not scored")
+		}
+	}
+
+	// 模拟握手完成，之后的消息交给处理函数
+	close(peer.handshake)
+	err = writeMessage(remote, COMMAND_ADDR, nil)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	select {
+
+	case command := <-received:
+		if command != COMMAND_ADDR {
+
+			t.Errorf("handler got %s, want %s", command, COMMAND_ADDR)
+		}
+
+	case <-time.After(10 * time.Second):
+		t.Fatalf("message after handshake not handled")
+	}
+	if score := atomic.LoadInt32(&peer.banScore); score != scoreProtocol {
+
+		t.Errorf("score %d after a message before handshake, want %d", score, scoreProtocol)
+	}
+
+	garbage := make([]byte, messageHeaderSize)
+	_, err = remote.Write(garbage)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	select {
+
+	case <-peer.Done():
+
+	case <-time.After(10 * time.Second):
+		t.Fatalf("peer not disconnected after bad magic")
+	}
+	if score := atomic.LoadInt32(&peer.banScore); score != scoreProtocol+scoreMalformed {
+
+		t.Errorf("score %d after bad magic, want %d", score, scoreProtocol+scoreMalformed)
+	}
+	if len(received) != 0 {
+
+		t.Errorf("handler got %d more messages", len(received))
+	}
+}
@@ -0,0 +1,499 @@
+package BLC
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/**
+长连接的对等节点
+
+每个连接有一个读协程和一个写协程：
+1.读协程依次读取消息并交给处理函数，同一个节点的消息按到达顺序处理，回复直接写回这个连接
+2.写协程从发送队列取出消息写入连接，定期发送ping保持连接
+  放入发送队列从不阻塞：持有chainLock时也会发送消息，对方不读取数据导致队列满或写超时时直接断开
+超过peerTimeout没有收到任何消息(包括pong)时断开
+握手(version/verack)完成前只接受version和verack，完成后才出现在connectedPeers中
+无法解码的消息和无效数据累计不良行为分数，达到banThreshold时断开并封禁对方(见BanKey)
+对方的监听地址由读协程在握手时写入，其他协程也会读取，通过lock访问
+*/
+
+// 发送队列长度
+const peerSendQueueSize = 2048
+
+// 多久发送一次ping
+const pingInterval = 2 * time.Minute
+
+// 多久没有收到消息就断开连接
+const peerTimeout = 5 * time.Minute
+
+// 单条消息写入的超时时间
+const peerWriteTimeout = 30 * time.Second
+
+// 连接超时
+const dialTimeout = 10 * time.Second
+
+// 最多保持的主动连接数
+const maxOutboundPeers = 8
+
+// 最多接受的连入连接数  连接一直保持，不限制会耗尽文件描述符
+const maxInboundPeers = 117
+
+// 多久检查一次主动连接数
+const outboundInterval = 10 * time.Second
+
+// 待发送的消息
+type outMessage struct {
+	command string
+	payload []byte
+	// 发送完这条消息后关闭连接
+	closeAfter bool
+}
+
+// 处理一条收到的消息
+type messageHandler func(peer *Peer, command string, payload []byte)
+
+type Peer struct {
+	// 对方地址  主动连接时为对方的监听地址，被动连接时为远端地址
+	Addr string
+	// 是否是对方连入的连接
+	Inbound bool
+
+	// 以下字段由对方的version填写
+	// 协议版本
+	ProtocolVersion int64
+	// 提供的服务
+	Services uint64
+	// 软件名称和版本
+	UserAgent string
+	// 握手时的区块高度
+	StartHeight int64
+	// 对方时钟减去本地时钟  秒
+	TimeOffset int64
+
+	// 保护listenAddr
+	lock sync.Mutex
+	// 对方声明的监听地址  握手时由version填写
+	listenAddr string
+
+	conn      net.Conn
+	sendQueue chan outMessage
+	quit      chan struct{}
+	closeOnce sync.Once
+
+	// 是否已经收到version  只在读协程中访问
+	versionReceived bool
+	// 握手完成后关闭
+	handshake chan struct{}
+	// 不良行为分数
+	banScore int32
+}
+
+// 已连接的节点
+var peers = make(map[*Peer]bool)
+var peersLock sync.Mutex
+
+func newPeer(conn net.Conn, addr string, inbound bool) *Peer {
+
+	return &Peer{
+		Addr:      addr,
+		Inbound:   inbound,
+		conn:      conn,
+		sendQueue: make(chan outMessage, peerSendQueueSize),
+		quit:      make(chan struct{}),
+		handshake: make(chan struct{}),
+	}
+}
+
+// 主动连接一个节点
+func connectPeer(addr string, handler messageHandler) (*Peer, error) {
+
+	conn, err := net.DialTimeout(PROTOCOL, addr, dialTimeout)
+	if err != nil {
+
+		return nil, err
+	}
+
+	peer := newPeer(conn, addr, false)
+	peer.Start(handler)
+
+	return peer, nil
+}
+
+// 接收对方连入的连接
+func acceptPeer(conn net.Conn, handler messageHandler) *Peer {
+
+	peer := newPeer(conn, conn.RemoteAddr().String(), true)
+	peer.Start(handler)
+
+	return peer
+}
+
+// 启动读写协程并登记到已连接节点中
+func (peer *Peer) Start(handler messageHandler) {
+
+	peersLock.Lock()
+	peers[peer] = true
+	peersLock.Unlock()
+
+	go peer.readLoop(handler)
+	go peer.writeLoop()
+}
+
+func (peer *Peer) String() string {
+
+	if peer.Inbound {
+
+		return fmt.Sprintf("%s (inbound)", peer.Addr)
+	}
+
+	return peer.Addr
+}
+
+// 读协程  依次处理收到的消息，出错时断开
+func (peer *Peer) readLoop(handler messageHandler) {
+
+	defer peer.Close()
+
+	for {
+
+		peer.conn.SetReadDeadline(time.Now().Add(peerTimeout))
+
+		command, payload, err := readMessage(peer.conn)
+		if err != nil {
+
+			// 本地主动断开时不再输出
+			if isPeerClosed(peer) == false {
+
+				fmt.Printf("Peer %s disconnected: %s\n", peer, err)
+			}
+
+			// 数据错误时计入不良行为，反复重连发送错误数据会被封禁
+			if errors.Is(err, errMalformedMessage) {
+
+				peer.Misbehaving(scoreMalformed, err.Error())
+			}
+			return
+		}
+
+		// 握手完成前只接受version和verack
+		if peer.HandshakeDone() == false && command != COMMAND_VERSION && command != COMMAND_VERACK {
+
+			peer.Misbehaving(scoreProtocol, fmt.Sprintf("%s before handshake", command))
+			continue
+		}
+
+		switch command {
+
+		case COMMAND_PING:
+			handlePing(peer, payload)
+
+		case COMMAND_PONG:
+			// 收到消息已经刷新了超时时间
+
+		default:
+			fmt.Printf("\nReceive a Message:%s from %s\n", command, peer)
+			if handler != nil {
+
+				handler(peer, command, payload)
+			}
+		}
+	}
+}
+
+// 写协程  发送队列中的消息，空闲时发送ping
+func (peer *Peer) writeLoop() {
+
+	defer peer.Close()
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+
+		select {
+
+		case msg := <-peer.sendQueue:
+			if msg.closeAfter {
+
+				return
+			}
+
+			peer.conn.SetWriteDeadline(time.Now().Add(peerWriteTimeout))
+
+			err := writeMessage(peer.conn, msg.command, msg.payload)
+			if err != nil {
+
+				fmt.Printf("Send %s to %s failed: %s\n", msg.command, peer, err)
+				return
+			}
+
+		case <-ticker.C:
+			sendPing(peer, rand.Uint64())
+
+		case <-peer.quit:
+			return
+		}
+	}
+}
+
+// 把消息放入发送队列  连接已断开时丢弃，队列满时断开连接
+func (peer *Peer) Send(command string, payload []byte) {
+
+	peer.queue(outMessage{command, payload, false})
+}
+
+// 发送完队列中的消息后关闭连接
+func (peer *Peer) CloseWhenSent() {
+
+	peer.queue(outMessage{closeAfter: true})
+}
+
+// 不阻塞  对方长时间不读取数据时不能卡住调用方(可能持有chainLock)
+func (peer *Peer) queue(msg outMessage) {
+
+	select {
+
+	case peer.sendQueue <- msg:
+
+	case <-peer.quit:
+
+	default:
+		fmt.Printf("Disconnect %s: send queue is full\n", peer)
+		peer.Close()
+	}
+}
+
+// 断开连接并从已连接节点中移除
+func (peer *Peer) Close() {
+
+	peer.closeOnce.Do(func() {
+
+		close(peer.quit)
+		peer.conn.Close()
+
+		peersLock.Lock()
+		delete(peers, peer)
+		peersLock.Unlock()
+	})
+}
+
+// 连接断开后关闭的通道
+func (peer *Peer) Done() <-chan struct{} {
+
+	return peer.quit
+}
+
+// 连接是否已经断开
+func isPeerClosed(peer *Peer) bool {
+
+	select {
+
+	case <-peer.Done():
+		return true
+
+	default:
+		return false
+	}
+}
+
+// 握手是否已经完成
+func (peer *Peer) HandshakeDone() bool {
+
+	select {
+
+	case <-peer.handshake:
+		return true
+
+	default:
+		return false
+	}
+}
+
+// 握手完成后关闭的通道
+func (peer *Peer) Handshake() <-chan struct{} {
+
+	return peer.handshake
+}
+
+// 对方是否提供某项服务
+func (peer *Peer) HasService(service uint64) bool {
+
+	return peer.Services&service == service
+}
+
+// 对方IP地址  封禁按IP记录
+func (peer *Peer) Host() string {
+
+	return remoteHost(peer.conn)
+}
+
+// 对方声明的监听地址  握手完成前为空
+func (peer *Peer) ListenAddr() string {
+
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+
+	return peer.listenAddr
+}
+
+func (peer *Peer) setListenAddr(addr string) {
+
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+
+	peer.listenAddr = addr
+}
+
+// 封禁列表中对方的键  一般为IP，主动连接的本机节点为连接时使用的地址
+// 本机节点连入时只能看到临时端口，声明的监听地址又没有经过验证，按它封禁会让对方冒充别的节点，返回空表示不封禁
+func (peer *Peer) BanKey() string {
+
+	host := peer.Host()
+	if isLoopbackHost(host) == false {
+
+		return host
+	}
+
+	if peer.Inbound {
+
+		return ""
+	}
+
+	return peer.Addr
+}
+
+// 对方是否已被封禁
+// 连入的本机节点还要检查它声明的监听地址，这个地址只用来拒绝自称是被封禁节点的连接，不用来封禁
+func (peer *Peer) IsBanned(bl *BanList) bool {
+
+	if bl.IsBanned(peer.BanKey()) {
+
+		return true
+	}
+
+	return peer.Inbound && isLoopbackHost(peer.Host()) && bl.IsBanned(peer.ListenAddr())
+}
+
+// 记录对方的不良行为  分数达到banThreshold时封禁并断开，连入的本机节点只断开
+func (peer *Peer) Misbehaving(score int, reason string) {
+
+	total := atomic.AddInt32(&peer.banScore, int32(score))
+	fmt.Printf("Misbehaving %s (+%d = %d): %s\n", peer, score, total, reason)
+
+	if total < banThreshold || isPeerClosed(peer) {
+
+		return
+	}
+
+	key := peer.BanKey()
+	if key == "" {
+
+		fmt.Printf("Disconnect %s: loopback peer is not authenticated, not banned\n", peer)
+		peer.Close()
+		return
+	}
+
+	if banList != nil {
+
+		banList.Ban(key, banDuration, reason)
+	}
+	fmt.Printf("Ban %s for %d seconds\n", key, banDuration)
+	peer.Close()
+}
+
+// 当前已连接且完成握手的所有节点
+func connectedPeers() []*Peer {
+
+	peersLock.Lock()
+	defer peersLock.Unlock()
+
+	var list []*Peer
+	for peer := range peers {
+
+		if peer.HandshakeDone() {
+
+			list = append(list, peer)
+		}
+	}
+
+	return list
+}
+
+// 当前所有连接  包括还没有完成握手的
+func allPeers() []*Peer {
+
+	peersLock.Lock()
+	defer peersLock.Unlock()
+
+	var list []*Peer
+	for peer := range peers {
+
+		list = append(list, peer)
+	}
+
+	return list
+}
+
+// 主动连接的数量  包括还没有完成握手的
+func outboundPeerCount() int {
+
+	peersLock.Lock()
+	defer peersLock.Unlock()
+
+	count := 0
+	for peer := range peers {
+
+		if peer.Inbound == false {
+
+			count++
+		}
+	}
+
+	return count
+}
+
+// 对方连入的连接数量  包括还没有完成握手的
+func inboundPeerCount() int {
+
+	peersLock.Lock()
+	defer peersLock.Unlock()
+
+	count := 0
+	for peer := range peers {
+
+		if peer.Inbound {
+
+			count++
+		}
+	}
+
+	return count
+}
+
+// 是否已经与这个地址的节点连接  对方连入时按握手时给出的监听地址比较
+func isConnectedAddr(addr string) bool {
+
+	peersLock.Lock()
+	defer peersLock.Unlock()
+
+	for peer := range peers {
+
+		if peer.Inbound == false && peer.Addr == addr {
+
+			return true
+		}
+
+		if peer.Inbound && peer.HandshakeDone() && peer.ListenAddr() == addr {
+
+			return true
+		}
+	}
+
+	return false
+}
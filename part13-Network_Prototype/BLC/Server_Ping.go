@@ -0,0 +1,17 @@
+package BLC
+
+// 保持连接  对方收到后用相同的Nonce回复pong
+type Ping struct {
+	// 随机数
+	Nonce uint64
+}
+
+func (ping *Ping) encode(w *binaryWriter) {
+
+	w.writeUint64(ping.Nonce)
+}
+
+func (ping *Ping) decode(r *binaryReader) {
+
+	ping.Nonce = r.readUint64()
+}
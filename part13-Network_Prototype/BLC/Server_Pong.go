@@ -0,0 +1,17 @@
+package BLC
+
+// 回复ping
+type Pong struct {
+	// ping中的随机数
+	Nonce uint64
+}
+
+func (pong *Pong) encode(w *binaryWriter) {
+
+	w.writeUint64(pong.Nonce)
+}
+
+func (pong *Pong) decode(r *binaryReader) {
+
+	pong.Nonce = r.readUint64()
+}
@@ -0,0 +1,171 @@
+package BLC
+
+import (
+	"fmt"
+)
+
+// 全节点为轻节点提供交易证明和区块过滤器
+
+// 查找主链上FromHeight之后与钱包有关的交易，回复交易证明
+func handleGetTxProofs(peer *Peer, request []byte, blc *Blockchain) {
+
+	var payload GetTxProofs
+
+	// 反序列化
+	err := decodeMessage(request, &payload)
+	if err != nil {
+
+		peer.Misbehaving(scoreMalformed, fmt.Sprintf("bad %s: %s", COMMAND_GETTXPROOFS, err))
+		return
+	}
+
+	watchedHashes := make(map[string]bool)
+	for _, hash := range payload.PubKeyHashes {
+
+		watchedHashes[string(hash)] = true
+	}
+	watchedOutPoints := make(map[string]bool)
+	for _, outPoint := range payload.OutPoints {
+
+		watchedOutPoints[fmt.Sprintf("%x:%d", outPoint.TxHash, outPoint.Vout)] = true
+	}
+
+	fromHeight := payload.FromHeight
+	if fromHeight < 1 {
+
+		fromHeight = 1
+	}
+
+	// 每次最多查找maxTxProofBlocks个区块，证明总字节数超过maxTxProofBytes时在区块边界停下
+	// 轻节点从LastHash之后继续请求
+	var lastHash []byte
+	var proofs [][]byte
+	size := 0
+	for _, hash := range blc.MainChainHashes(fromHeight, blc.Tip, maxTxProofBlocks) {
+
+		blockBytes, err := blc.GetBlock(hash)
+		if err != nil || blockBytes == nil {
+
+			fmt.Printf("Load block %x: %v\n", hash, err)
+			break
+		}
+		block, err := DecodeBlock(blockBytes)
+		if err != nil {
+
+			fmt.Printf("Decode block %x: %s\n", hash, err)
+			break
+		}
+
+		var blockProofs [][]byte
+		blockSize := 0
+		for _, tx := range block.Txs {
+
+			if txMatchesWallet(tx, watchedHashes, watchedOutPoints) == false {
+
+				continue
+			}
+
+			proof, err := NewTxProof(block, tx.TxHash)
+			if err != nil {
+
+				fmt.Printf("Tx proof %x: %s\n", tx.TxHash, err)
+				continue
+			}
+			data := proof.Serialize()
+			blockProofs = append(blockProofs, data)
+			blockSize += len(data)
+		}
+
+		// 至少包含一个区块，保证轻节点每次都能前进
+		if lastHash != nil && size+blockSize > maxTxProofBytes {
+
+			break
+		}
+
+		proofs = append(proofs, blockProofs...)
+		size += blockSize
+		lastHash = hash
+	}
+
+	fmt.Printf("send %d tx proofs to %s\n", len(proofs), peer)
+	sendTxProofs(peer, lastHash, proofs)
+}
+
+// 交易是否与钱包有关  支付给钱包的输出会加入watchedOutPoints，之后花费它的交易也能匹配
+func txMatchesWallet(tx *Transaction, watchedHashes map[string]bool, watchedOutPoints map[string]bool) bool {
+
+	matched := false
+
+	if tx.IsCoinbaseTransaction() == false {
+
+		for _, in := range tx.Vins {
+
+			if watchedOutPoints[fmt.Sprintf("%x:%d", in.TxHash, in.Vout)] {
+
+				matched = true
+			}
+
+			// P2PKH输入的公钥或P2SH输入的赎回脚本属于钱包
+			if publicKey := ExtractSignaturePublicKey(in.ScriptSig); publicKey != nil && watchedHashes[string(Ripemd160Hash(publicKey))] {
+
+				matched = true
+			}
+			if redeemScript := ExtractRedeemScript(in.ScriptSig); redeemScript != nil && watchedHashes[string(Ripemd160Hash(redeemScript))] {
+
+				matched = true
+			}
+		}
+	}
+
+	for index, out := range tx.Vouts {
+
+		hash := ExtractPubKeyHash(out.ScriptPubKey)
+		if hash == nil {
+
+			hash = ExtractScriptHash(out.ScriptPubKey)
+		}
+
+		if hash != nil && watchedHashes[string(hash)] {
+
+			watchedOutPoints[fmt.Sprintf("%x:%d", tx.TxHash, index)] = true
+			matched = true
+		}
+	}
+
+	return matched
+}
+
+// 逐个回复主链上StartHeight到StopHash之间区块的过滤器
+func handleGetCFilters(peer *Peer, request []byte, blc *Blockchain) {
+
+	var payload GetCFilters
+
+	// 反序列化
+	err := decodeMessage(request, &payload)
+	if err != nil {
+
+		peer.Misbehaving(scoreMalformed, fmt.Sprintf("bad %s: %s", COMMAND_GETCFILTERS, err))
+		return
+	}
+
+	if payload.FilterType != BasicFilterType {
+
+		fmt.Printf("Unknown filter type %d\n", payload.FilterType)
+		return
+	}
+
+	// StopHash不在主链上时不回复
+	hashes := blc.MainChainHashes(payload.StartHeight, payload.StopHash, maxCFiltersPerRequest)
+
+	for _, hash := range hashes {
+
+		filter, err := blc.GetBlockFilter(hash)
+		if err != nil {
+
+			fmt.Printf("%s\n", err)
+			return
+		}
+
+		sendCFilter(peer, hash, filter)
+	}
+}
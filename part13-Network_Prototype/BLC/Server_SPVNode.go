@@ -0,0 +1,459 @@
+package BLC
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"time"
+)
+
+// 轻节点每隔多久向全节点请求一次新的区块头
+const spvSyncInterval = 10 * time.Second
+
+// 是否用区块过滤器查找钱包交易  不向全节点透露钱包地址
+var spvUseFilters bool
+
+// 过滤器匹配后正在下载的区块  下载完成前忽略后面的过滤器
+var spvPendingBlock []byte
+
+// 当前这批过滤器的最后一个区块高度
+var spvFilterStopHeight int64
+
+// 收到的一条消息
+type peerMessage struct {
+	peer    *Peer
+	command string
+	payload []byte
+}
+
+// 启动轻节点  只同步区块头，再向全节点请求钱包相关交易的证明
+// useFilters为true时改为下载区块过滤器，在本地匹配后只下载有关的完整区块
+func StartSPVServer(nodeID string, useFilters bool) {
+
+	// 当前节点IP地址
+	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
+	spvUseFilters = useFilters
+	localServices = SERVICE_SPV
+
+	spv := OpenSPVChain(nodeID)
+	defer spv.DB.Close()
+
+	// 数据库中已有的区块头必须属于配置的创世区块
+	if genesis := spv.GenesisHash(); genesis != nil && bytes.Compare(genesis, genesisBlockHash) != 0 {
+
+		log.Panicf("SPV headers start from genesis %x, not %x; remove %s to resync", genesis, genesisBlockHash, fmt.Sprintf(spvDBName, nodeID))
+	}
+
+	// 从地址簿中选择全节点连接  不连接被封禁的IP
+	banList = NewBanList(nodeID)
+	addrManager = LoadAddrManager(nodeID)
+	addSeedAddresses()
+	go addrManager.saveLoop()
+
+	// 收到的消息和定时同步都在同一个循环里处理，区块头数据库不会被同时修改
+	messages := make(chan peerMessage)
+	handler := func(peer *Peer, command string, payload []byte) {
+
+		messages <- peerMessage{peer, command, payload}
+	}
+
+	// 轻节点只主动连接全节点，回复从同一个连接返回  握手完成后开始同步
+	peer := connectSPVPeer(handler, spv)
+
+	ticker := time.NewTicker(spvSyncInterval)
+	defer ticker.Stop()
+
+	for {
+
+		select {
+
+		case msg := <-messages:
+			handleSPVMessage(msg, spv, nodeID)
+
+		case <-ticker.C:
+			// 连接断开时重新连接
+			disconnectBannedPeers()
+			if peer == nil || isPeerClosed(peer) {
+
+				peer = connectSPVPeer(handler, spv)
+				spvPendingBlock = nil
+			}
+
+			if peer != nil && peer.HandshakeDone() {
+
+				sendGetHeaders(peer, spv.BlockLocator(), nil)
+			}
+		}
+	}
+}
+
+// 连接全节点并发送version  失败时返回nil，下次同步时重试
+func connectSPVPeer(handler messageHandler, spv *SPVChain) *Peer {
+
+	addr := selectOutboundAddr(SERVICE_FULL_NODE)
+	if addr == "" {
+
+		return nil
+	}
+
+	return connectOutbound(addr, spv.BestHeight(), handler)
+}
+
+// 轻节点只处理握手、区块头和交易证明
+func handleSPVMessage(msg peerMessage, spv *SPVChain, nodeID string) {
+
+	switch msg.command {
+
+	case COMMAND_VERSION:
+		acceptVersion(msg.peer, msg.payload, spv.BestHeight())
+
+	case COMMAND_VERACK:
+		handleSPVVerack(msg.peer, spv)
+
+	case COMMAND_ADDR:
+		handleSPVAddr(msg.peer, msg.payload)
+
+	case COMMAND_HEADERS:
+		handleSPVHeaders(msg.peer, msg.payload, spv, nodeID)
+
+	case COMMAND_TXPROOFS:
+		handleSPVTxProofs(msg.peer, msg.payload, spv, nodeID)
+
+	case COMMAND_CFILTER:
+		handleSPVCFilter(msg.peer, msg.payload, spv, nodeID)
+
+	case COMMAND_BLOCK:
+		handleSPVBlock(msg.peer, msg.payload, spv, nodeID)
+
+	default:
+		fmt.Println("Unknown command!")
+	}
+}
+
+// 握手完成  对方必须是全节点，之后开始同步区块头
+func handleSPVVerack(peer *Peer, spv *SPVChain) {
+
+	if acceptVerack(peer) == false {
+
+		return
+	}
+
+	if peer.HasService(SERVICE_FULL_NODE) == false {
+
+		fmt.Printf("Disconnect %s: not a full node\n", peer)
+		peer.Close()
+		return
+	}
+
+	addrManager.Good(peer.Addr, peer.Services)
+	sendGetAddr(peer)
+
+	sendGetHeaders(peer, spv.BlockLocator(), nil)
+}
+
+// 记录全节点告诉的地址  连接断开后可以换一个全节点
+func handleSPVAddr(peer *Peer, request []byte) {
+
+	var payload Addr
+
+	// 反序列化
+	err := decodeMessage(request, &payload)
+	if err != nil || len(payload.AddrList) > maxAddrPerMessage {
+
+		peer.Misbehaving(scoreMalformed, fmt.Sprintf("bad %s", COMMAND_ADDR))
+		return
+	}
+
+	for _, na := range payload.AddrList {
+
+		addrManager.AddAddress(na.Addr, na.Services, na.Timestamp)
+	}
+}
+
+// 保存区块头  一条消息装满时继续请求，否则开始请求钱包交易
+func handleSPVHeaders(peer *Peer, request []byte, spv *SPVChain, nodeID string) {
+
+	var payload Headers
+
+	// 反序列化
+	err := decodeMessage(request, &payload)
+	if err != nil {
+
+		peer.Misbehaving(scoreMalformed, fmt.Sprintf("bad %s: %s", COMMAND_HEADERS, err))
+		return
+	}
+
+	for _, header := range payload.Headers {
+
+		err := spv.AddHeader(header)
+		if err != nil {
+
+			// 父区块头未知时下次同步重新请求，不计入不良行为
+			fmt.Printf("Reject header at height %d: %s\n", header.Height, err)
+			if err != errSPVHeaderOrphan {
+
+				peer.Misbehaving(scoreInvalidBlock, fmt.Sprintf("invalid header %x", header.Hash()))
+			}
+			return
+		}
+	}
+
+	tip := spv.TipHeader()
+	if tip == nil {
+
+		return
+	}
+	fmt.Printf("Header chain height: %d\n", tip.Height)
+
+	if len(payload.Headers) >= maxHeadersPerMessage {
+
+		sendGetHeaders(peer, spv.BlockLocator(), nil)
+		return
+	}
+
+	if spvUseFilters {
+
+		requestCFilters(peer, spv)
+		return
+	}
+
+	requestTxProofs(peer, spv, nodeID)
+}
+
+// 请求上次查找到的位置之后与钱包有关的交易
+func requestTxProofs(peer *Peer, spv *SPVChain, nodeID string) {
+
+	pubKeyHashes := walletPubKeyHashes(nodeID)
+	if len(pubKeyHashes) == 0 {
+
+		return
+	}
+
+	var outPoints []*OutPoint
+	for _, utxo := range spv.UTXOs() {
+
+		outPoints = append(outPoints, &OutPoint{utxo.TxHash, utxo.Index})
+	}
+
+	sendGetTxProofs(peer, spv.ScanHeight(), pubKeyHashes, outPoints)
+}
+
+// 验证并保存交易证明，重新计算钱包余额
+func handleSPVTxProofs(peer *Peer, request []byte, spv *SPVChain, nodeID string) {
+
+	var payload TxProofs
+
+	// 反序列化
+	err := decodeMessage(request, &payload)
+	if err != nil {
+
+		peer.Misbehaving(scoreMalformed, fmt.Sprintf("bad %s: %s", COMMAND_TXPROOFS, err))
+		return
+	}
+
+	complete := true
+	for _, data := range payload.Proofs {
+
+		// 无法解码或验证不通过的证明是全节点伪造的
+		proof, err := DecodeTxProof(data)
+		if err == nil {
+
+			err = proof.Verify()
+		}
+		if err != nil {
+
+			fmt.Printf("Reject tx proof: %s\n", err)
+			peer.Misbehaving(scoreInvalidBlock, "invalid tx proof")
+			complete = false
+			continue
+		}
+
+		err = spv.AddTxProof(proof)
+		if err != nil {
+
+			// 证明所在的区块头还没同步到时，下次从原来的位置重新查找
+			fmt.Printf("Reject tx proof: %s\n", err)
+			complete = false
+		}
+	}
+
+	// LastHash不在本地主链上时不记录，也不继续请求
+	if complete == false || payload.LastHash == nil || spv.IsInMainChain(payload.LastHash) == false {
+
+		printSPVBalances(spv, nodeID)
+		return
+	}
+
+	spv.SetScanned(payload.LastHash)
+	printSPVBalances(spv, nodeID)
+
+	// 全节点一次只查找一部分区块，没有到本地主链顶端时继续请求
+	if spv.ScanHeight() <= spv.BestHeight() {
+
+		requestTxProofs(peer, spv, nodeID)
+	}
+}
+
+// 请求下一批区块过滤器  从上次查找到的位置开始，到本地主链顶端为止
+func requestCFilters(peer *Peer, spv *SPVChain) {
+
+	tip := spv.TipHeader()
+	start := spv.ScanHeight()
+	if spvPendingBlock != nil || tip == nil || start > tip.Height {
+
+		return
+	}
+
+	stop := tip
+	if tip.Height-start+1 > maxCFiltersPerRequest {
+
+		stop = ancestorHeader(tip, start+maxCFiltersPerRequest-1, spv.lookupHeader)
+	}
+	spvFilterStopHeight = stop.Height
+
+	sendGetCFilters(peer, start, stop.Hash())
+}
+
+// 按高度依次检查区块过滤器  匹配钱包时下载完整区块，否则直接记为已查找
+func handleSPVCFilter(peer *Peer, request []byte, spv *SPVChain, nodeID string) {
+
+	var payload CFilter
+
+	// 反序列化
+	err := decodeMessage(request, &payload)
+	if err != nil {
+
+		peer.Misbehaving(scoreMalformed, fmt.Sprintf("bad %s: %s", COMMAND_CFILTER, err))
+		return
+	}
+
+	// 正在下载区块，或者不是下一个要查找的区块
+	header := spv.lookupHeader(payload.BlockHash)
+	if spvPendingBlock != nil || payload.FilterType != BasicFilterType || header == nil ||
+		header.Height != spv.ScanHeight() || spv.IsInMainChain(payload.BlockHash) == false {
+
+		return
+	}
+
+	if spv.MatchBlockFilter(payload.BlockHash, payload.Filter, walletAddresses(nodeID)) {
+
+		fmt.Printf("Filter of block %d matches the wallet, downloading block %x\n", header.Height, payload.BlockHash)
+		spvPendingBlock = payload.BlockHash
+		sendGetData(peer, BLOCK_TYPE, payload.BlockHash)
+		return
+	}
+
+	spv.SetScanned(payload.BlockHash)
+
+	if header.Height == spvFilterStopHeight {
+
+		printSPVBalances(spv, nodeID)
+		requestCFilters(peer, spv)
+	}
+}
+
+// 收到过滤器匹配的区块  检查默克尔根后保存与钱包有关的交易证明，再继续请求过滤器
+func handleSPVBlock(peer *Peer, request []byte, spv *SPVChain, nodeID string) {
+
+	var payload BlockData
+
+	// 反序列化
+	err := decodeMessage(request, &payload)
+	if err != nil {
+
+		peer.Misbehaving(scoreMalformed, fmt.Sprintf("bad %s: %s", COMMAND_BLOCK, err))
+		return
+	}
+
+	block, err := DecodeBlock(payload.BlockBytes)
+	if err != nil || spvPendingBlock == nil || bytes.Compare(block.Hash, spvPendingBlock) != 0 {
+
+		return
+	}
+	spvPendingBlock = nil
+
+	// 交易必须与区块头中的默克尔根一致，全节点不能隐瞒或添加交易
+	tree, err := block.MerkleTree()
+	if err != nil || bytes.Compare(tree.RootNode.Data, block.MerkleRoot) != 0 {
+
+		fmt.Printf("Reject block %x: transactions do not match the merkle root\n", block.Hash)
+		peer.Misbehaving(scoreInvalidBlock, fmt.Sprintf("invalid block %x", block.Hash))
+		return
+	}
+
+	watchedHashes := make(map[string]bool)
+	for _, hash := range walletPubKeyHashes(nodeID) {
+
+		watchedHashes[string(hash)] = true
+	}
+	watchedOutPoints := make(map[string]bool)
+	for _, utxo := range spv.UTXOs() {
+
+		watchedOutPoints[fmt.Sprintf("%x:%d", utxo.TxHash, utxo.Index)] = true
+	}
+
+	for _, tx := range block.Txs {
+
+		if txMatchesWallet(tx, watchedHashes, watchedOutPoints) == false {
+
+			continue
+		}
+
+		proof, err := NewTxProof(block, tx.TxHash)
+		if err == nil {
+
+			err = spv.AddTxProof(proof)
+		}
+		if err != nil {
+
+			fmt.Printf("Reject tx %x: %s\n", tx.TxHash, err)
+			return
+		}
+	}
+
+	spv.SetScanned(block.Hash)
+	printSPVBalances(spv, nodeID)
+
+	requestCFilters(peer, spv)
+}
+
+// 重新计算钱包UTXO视图，输出各地址余额
+func printSPVBalances(spv *SPVChain, nodeID string) {
+
+	addresses := walletAddresses(nodeID)
+	spv.RebuildUTXOs(addresses)
+
+	for _, address := range addresses {
+
+		mature, immature := spv.GetBalance(address)
+		fmt.Printf("%s 可用：%d  未成熟：%d\n", address, mature, immature)
+	}
+}
+
+// 本地钱包的所有地址
+func walletAddresses(nodeID string) []string {
+
+	wallets, _ := NewWallets(nodeID)
+
+	var addresses []string
+	for address := range wallets.Wallets {
+
+		addresses = append(addresses, address)
+	}
+
+	return addresses
+}
+
+// 本地钱包地址的公钥哈希
+func walletPubKeyHashes(nodeID string) [][]byte {
+
+	wallets, _ := NewWallets(nodeID)
+
+	var hashes [][]byte
+	for _, wallet := range wallets.Wallets {
+
+		hashes = append(hashes, Ripemd160Hash(wallet.PublicKey))
+	}
+
+	return hashes
+}
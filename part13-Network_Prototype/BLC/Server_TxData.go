@@ -7,3 +7,15 @@ type TxData struct {
 	// 交易
 	TransactionBytes []byte
 }
+
+func (txData *TxData) encode(w *binaryWriter) {
+
+	w.writeString(txData.AddFrom)
+	w.writeVarBytes(txData.TransactionBytes)
+}
+
+func (txData *TxData) decode(r *binaryReader) {
+
+	txData.AddFrom = r.readString()
+	txData.TransactionBytes = r.readVarBytes()
+}
@@ -0,0 +1,33 @@
+package BLC
+
+// 回复gettxproofs  按区块高度和交易位置排列的交易证明
+type TxProofs struct {
+	// 节点地址
+	AddrFrom string
+	// 本次查找到的最后一个区块哈希  轻节点据此记录已经查找到的位置，没有查找任何区块时为空
+	LastHash []byte
+	// 序列化的交易证明
+	Proofs [][]byte
+}
+
+func (txProofs *TxProofs) encode(w *binaryWriter) {
+
+	w.writeString(txProofs.AddrFrom)
+	w.writeVarBytes(txProofs.LastHash)
+	w.writeVarInt(uint64(len(txProofs.Proofs)))
+	for _, proof := range txProofs.Proofs {
+
+		w.writeVarBytes(proof)
+	}
+}
+
+func (txProofs *TxProofs) decode(r *binaryReader) {
+
+	txProofs.AddrFrom = r.readString()
+	txProofs.LastHash = r.readVarBytes()
+	count := r.readCount()
+	for i := 0; i < count && r.err == nil; i++ {
+
+		txProofs.Proofs = append(txProofs.Proofs, r.readVarBytes())
+	}
+}
@@ -0,0 +1,13 @@
+package BLC
+
+// 确认收到对方的version  没有内容
+type Verack struct {
+}
+
+func (verack *Verack) encode(w *binaryWriter) {
+
+}
+
+func (verack *Verack) decode(r *binaryReader) {
+
+}
@@ -1,10 +1,41 @@
 package BLC
 
+// 握手时双方首先发送的消息
 type Version struct {
-	// 版本
+	// 协议版本
 	Version    int64
+	// 节点提供的服务  SERVICE_FULL_NODE等标志位
+	Services   uint64
+	// 发送时间  Unix时间戳
+	Timestamp  int64
+	// 每次启动时生成的随机数  收到与自己相同的Nonce说明连接到了自己
+	Nonce      uint64
+	// 节点软件名称和版本
+	UserAgent  string
 	// 当前节点区块的高度
 	BestHeight int64
 	//当前节点的地址
 	AddrFrom   string
 }
+
+func (version *Version) encode(w *binaryWriter) {
+
+	w.writeInt64(version.Version)
+	w.writeUint64(version.Services)
+	w.writeInt64(version.Timestamp)
+	w.writeUint64(version.Nonce)
+	w.writeString(version.UserAgent)
+	w.writeInt64(version.BestHeight)
+	w.writeString(version.AddrFrom)
+}
+
+func (version *Version) decode(r *binaryReader) {
+
+	version.Version = r.readInt64()
+	version.Services = r.readUint64()
+	version.Timestamp = r.readInt64()
+	version.Nonce = r.readUint64()
+	version.UserAgent = r.readString()
+	version.BestHeight = r.readInt64()
+	version.AddrFrom = r.readString()
+}
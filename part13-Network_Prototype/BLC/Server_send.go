@@ -2,97 +2,190 @@ package BLC
 
 import (
 	"fmt"
-	"io"
-	"bytes"
-	"log"
-	"net"
+	"time"
 )
 
 //COMMAND_VERSION
-func sendVersion(toAddress string, blc *Blockchain)  {
+func sendVersion(peer *Peer, bestHeight int64)  {
 
 
-	bestHeight := blc.GetBestHeight()
-	payload := gobEncode(Version{NODE_VERSION, bestHeight, nodeAddress})
+	payload := encodeMessage(&Version{NODE_VERSION, localServices, time.Now().Unix(), localNonce, USER_AGENT, bestHeight, nodeAddress})
 
-	request := append(commandToBytes(COMMAND_VERSION), payload...)
+	peer.Send(COMMAND_VERSION, payload)
+}
+
+func sendVerack(peer *Peer) {
 
-	sendData(toAddress, request)
+	peer.Send(COMMAND_VERACK, encodeMessage(&Verack{}))
 }
 
 
 
-//COMMAND_GETBLOCKS
-func sendGetBlocks(toAddress string)  {
+// 主节点将自己的所有的区块hash发送给钱包节点
+//COMMAND_BLOCK
+//
+func sendInv(peer *Peer, kind string, hashes [][]byte) {
+
+	payload := encodeMessage(&Inv{nodeAddress,kind,hashes})
+
+	peer.Send(COMMAND_INV, payload)
 
-	payload := gobEncode(GetBlocks{nodeAddress})
+}
 
-	request := append(commandToBytes(COMMAND_GETBLOCKS), payload...)
+// 向除from以外已连接的所有全节点通告新的区块或交易  from为nil时通告所有全节点
+func relayInv(from *Peer, kind string, hash []byte) {
 
-	sendData(toAddress, request)
+	for _, node := range connectedPeers() {
 
+		if node != from && node.HasService(SERVICE_FULL_NODE) {
+
+			sendInv(node, kind, [][]byte{hash})
+		}
+	}
 }
 
-// 主节点将自己的所有的区块hash发送给钱包节点
-//COMMAND_BLOCK
-//
-func sendInv(toAddress string, kind string, hashes [][]byte) {
 
-	payload := gobEncode(Inv{nodeAddress,kind,hashes})
 
-	request := append(commandToBytes(COMMAND_INV), payload...)
+func sendGetData(peer *Peer, kind string ,blockHash []byte) {
 
-	sendData(toAddress, request)
+	payload := encodeMessage(&GetData{nodeAddress,kind,blockHash})
 
+	peer.Send(COMMAND_GETDATA, payload)
 }
 
 
+func sendBlock(peer *Peer, blockBytes []byte)  {
+
 
-func sendGetData(toAddress string, kind string ,blockHash []byte) {
+	payload := encodeMessage(&BlockData{nodeAddress,blockBytes})
 
-	payload := gobEncode(GetData{nodeAddress,kind,blockHash})
+	peer.Send(COMMAND_BLOCK, payload)
+}
 
-	request := append(commandToBytes(COMMAND_GETDATA), payload...)
+func sendTx(peer *Peer, tx *Transaction)  {
 
-	sendData(toAddress, request)
+	data := TxData{nodeAddress, tx.Serialize()}
+	payload := encodeMessage(&data)
+
+	peer.Send(COMMAND_TX, payload)
 }
 
+//COMMAND_GETHEADERS
+func sendGetHeaders(peer *Peer, locator [][]byte, stopHash []byte) {
 
-func sendBlock(toAddress string, blockBytes []byte)  {
+	payload := encodeMessage(&GetHeaders{nodeAddress, locator, stopHash})
 
+	peer.Send(COMMAND_GETHEADERS, payload)
+}
 
-	payload := gobEncode(BlockData{nodeAddress,blockBytes})
+func sendHeaders(peer *Peer, headers []*BlockHeader) {
 
-	request := append(commandToBytes(COMMAND_BLOCK), payload...)
+	payload := encodeMessage(&Headers{nodeAddress, headers})
 
-	sendData(toAddress, request)
+	peer.Send(COMMAND_HEADERS, payload)
 }
 
-func sendTx(toAddress string, tx *Transaction)  {
+//COMMAND_GETTXPROOFS
+func sendGetTxProofs(peer *Peer, fromHeight int64, pubKeyHashes [][]byte, outPoints []*OutPoint) {
 
-	data := TxData{nodeAddress, tx.Serialize()}
-	payload := gobEncode(data)
-	request := append(commandToBytes(COMMAND_TX), payload...)
+	payload := encodeMessage(&GetTxProofs{nodeAddress, fromHeight, pubKeyHashes, outPoints})
 
-	sendData(toAddress, request)
+	peer.Send(COMMAND_GETTXPROOFS, payload)
 }
 
-// 客户端向服务器发送数据
-func sendData(to string, data []byte) {
+func sendTxProofs(peer *Peer, lastHash []byte, proofs [][]byte) {
 
-	fmt.Printf("Client send message to server:%s...\n", to)
+	payload := encodeMessage(&TxProofs{nodeAddress, lastHash, proofs})
 
-	conn, err := net.Dial("tcp", to)
-	if err != nil {
+	peer.Send(COMMAND_TXPROOFS, payload)
+}
+
+//COMMAND_GETCFILTERS
+func sendGetCFilters(peer *Peer, startHeight int64, stopHash []byte) {
+
+	payload := encodeMessage(&GetCFilters{nodeAddress, BasicFilterType, startHeight, stopHash})
+
+	peer.Send(COMMAND_GETCFILTERS, payload)
+}
+
+func sendCFilter(peer *Peer, blockHash []byte, filter []byte) {
+
+	payload := encodeMessage(&CFilter{nodeAddress, BasicFilterType, blockHash, filter})
+
+	peer.Send(COMMAND_CFILTER, payload)
+}
 
-		panic("error")
+//COMMAND_ADDR
+func sendAddr(peer *Peer, addrList []*NetAddress) {
+
+	peer.Send(COMMAND_ADDR, encodeMessage(&Addr{nodeAddress, addrList}))
+}
+
+func sendGetAddr(peer *Peer) {
+
+	peer.Send(COMMAND_GETADDR, encodeMessage(&GetAddr{nodeAddress}))
+}
+
+//COMMAND_PING
+func sendPing(peer *Peer, nonce uint64) {
+
+	peer.Send(COMMAND_PING, encodeMessage(&Ping{nonce}))
+}
+
+func sendPong(peer *Peer, nonce uint64) {
+
+	peer.Send(COMMAND_PONG, encodeMessage(&Pong{nonce}))
+}
+
+// 把交易发送给节点  命令行使用，依次尝试本节点和种子节点，都连接不上时返回错误
+func submitTx(tx *Transaction) error {
+
+	for _, address := range append([]string{nodeAddress}, seedNodes...) {
+
+		if submitTxTo(address, tx) {
+
+			return nil
+		}
 	}
-	defer conn.Close()
 
-	// 要发送的数据
-	_, err = io.Copy(conn, bytes.NewReader(data))
+	return fmt.Errorf("send Tx:%x failed: no node is reachable", tx.TxHash)
+}
+
+// 握手完成后发送交易，发送完后断开连接
+func submitTxTo(toAddress string, tx *Transaction) bool {
+
+	peer, err := connectPeer(toAddress, func(peer *Peer, command string, payload []byte) {
+
+		switch command {
+
+		case COMMAND_VERSION:
+			acceptVersion(peer, payload, 0)
+
+		case COMMAND_VERACK:
+			acceptVerack(peer)
+		}
+	})
 	if err != nil {
 
-		log.Panic(err)
+		return false
+	}
+
+	sendVersion(peer, 0)
+
+	select {
+
+	case <-peer.Handshake():
+
+	case <-peer.Done():
+		fmt.Printf("Send Tx:%x to %s failed: handshake failed\n", tx.TxHash, toAddress)
+		return false
 	}
+
+	fmt.Printf("Send Tx:%x to %s\n", tx.TxHash, toAddress)
+	sendTx(peer, tx)
+	peer.CloseWhenSent()
+
+	<-peer.Done()
+
+	return true
 }
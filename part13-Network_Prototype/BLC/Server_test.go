@@ -0,0 +1,120 @@
+package BLC
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+)
+
+// 已完成握手、提供services服务的节点
+func newHandshakeTestPeer(t *testing.T, remote string, services uint64) (*Peer, net.Conn) {
+
+	peer, conn := newPipeTestPeer(t, remote, false, nil)
+	peer.Services = services
+	close(peer.handshake)
+
+	return peer, conn
+}
+
+// 短时间内对方一端没有收到消息
+func expectNoTestMessage(t *testing.T, name string, conn net.Conn) {
+
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if command, _, err := readMessage(conn); err == nil {
+
+		t.Errorf("%s received %s", name, command)
+	}
+}
+
+// 通告发给除来源外的所有全节点，不发给轻节点
+func TestRelayInv(t *testing.T) {
+
+	source, sourceConn := newHandshakeTestPeer(t, "203.0.113.40:3000", SERVICE_FULL_NODE)
+	_, fullConn := newHandshakeTestPeer(t, "203.0.113.41:3000", SERVICE_FULL_NODE|SERVICE_MINER)
+	_, spvConn := newHandshakeTestPeer(t, "203.0.113.42:3000", SERVICE_SPV)
+
+	hash := repeatHash(0x66)
+	relayInv(source, BLOCK_TYPE, hash)
+
+	var inv Inv
+	command, payload, err := readMessage(fullConn)
+	if err != nil || command != COMMAND_INV || decodeMessage(payload, &inv) != nil {
+
+		t.Fatalf("read %s: %v, want inv", command, err)
+	}
+	if inv.Type != BLOCK_TYPE || len(inv.Items) != 1 || bytes.Compare(inv.Items[0], hash) != 0 {
+
+		t.Errorf("inv %s %x", inv.Type, inv.Items)
+	}
+
+	expectNoTestMessage(t, "source", sourceConn)
+	expectNoTestMessage(t, "spv peer", spvConn)
+}
+
+// 只向对方请求交易池中没有的交易
+func TestHandleInvRequestsUnknownTxs(t *testing.T) {
+
+	useTestMempool(t, "")
+	known := vectorTx()
+	addMempoolTx(known)
+	unknown := repeatHash(0x77)
+
+	peer, conn := newHandshakeTestPeer(t, "203.0.113.43:3000", SERVICE_FULL_NODE)
+	handleInv(peer, encodeMessage(&Inv{"203.0.113.43:3000", TX_TYPE, [][]byte{known.TxHash, unknown}}), nil)
+
+	var getData GetData
+	command, payload, err := readMessage(conn)
+	if err != nil || command != COMMAND_GETDATA || decodeMessage(payload, &getData) != nil {
+
+		t.Fatalf("read %s: %v, want getdata", command, err)
+	}
+	if getData.Type != TX_TYPE || hex.EncodeToString(getData.Hash) != hex.EncodeToString(unknown) {
+
+		t.Errorf("getdata %s %x, want tx %x", getData.Type, getData.Hash, unknown)
+	}
+	expectNoTestMessage(t, "peer", conn)
+}
+
+// 没有主动连接且地址簿中没有可用地址时才使用种子节点，种子节点中跳过自己和已连接的
+func TestSelectOutboundAddr(t *testing.T) {
+
+	useTestAddrManager(t, "test")
+
+	savedSeeds, savedAddress := seedNodes, nodeAddress
+	seedNodes = []string{"localhost:3000", "localhost:3001", "localhost:3002"}
+	nodeAddress = "localhost:3000"
+	defer func() {
+
+		seedNodes, nodeAddress = savedSeeds, savedAddress
+	}()
+
+	if addr := selectOutboundAddr(SERVICE_FULL_NODE); addr != "localhost:3001" {
+
+		t.Errorf("selected %q, want the first other seed", addr)
+	}
+
+	peer, _ := newPipeTestPeer(t, "localhost:3001", false, nil)
+	if addr := selectOutboundAddr(SERVICE_FULL_NODE); addr != "" {
+
+		t.Errorf("selected seed %q with an outbound peer", addr)
+	}
+
+	addrManager.AddAddress("203.0.113.44:3000", SERVICE_FULL_NODE, time.Now().Unix())
+	if addr := selectOutboundAddr(SERVICE_FULL_NODE); addr != "203.0.113.44:3000" {
+
+		t.Errorf("selected %q, want the address book entry", addr)
+	}
+
+	// 种子节点连入时也跳过
+	peer.Close()
+	addrManager.Attempt("203.0.113.44:3000")
+	inbound, _ := newPipeTestPeer(t, "127.0.0.1:53000", true, nil)
+	inbound.setListenAddr("localhost:3001")
+	close(inbound.handshake)
+	if addr := selectOutboundAddr(SERVICE_FULL_NODE); addr != "localhost:3002" {
+
+		t.Errorf("selected %q, want the seed that is not connected", addr)
+	}
+}
@@ -1,14 +1,32 @@
 package BLC
 
+import "sync"
 
-//localhost:3000 主节点的地址
-var knowedNodes = []string{"localhost:8000"}
+
+// 种子节点  启动时加入地址簿，地址簿中没有可用地址时连接，可以用环境变量SEED_NODES设置
+var seedNodes = []string{"localhost:8000"}
 var nodeAddress string //全局变量，节点地址
+// 待下载的区块  每个区块记录通告它的区块头的节点，只向这个节点请求
+type queuedBlock struct {
+	Hash []byte
+	Peer *Peer
+}
+
 // 存储拥有最新链的未处理的区块hash值
-var unslovedHashes [][]byte
+var unslovedHashes []queuedBlock
+// 上一批区块头装满时记录对方节点和最后一个区块头，区块下载完后继续向它请求区块头
+var headersSyncPeer *Peer
+var headersSyncTip []byte
 // 交易内存池
 var memTxPool = make(map[string]Transaction)
+// 交易池中已被花费的输出  键为"交易哈希:输出序号"，值为花费它的交易哈希
+var memTxSpends = make(map[string]string)
 // 矿工地址
 var miningAddress string
 // 挖矿需要满足的最小交易数
-const minMinerTxCount = 1
\ No newline at end of file
+const minMinerTxCount = 1
+// 是否正在挖矿  同一时间只有一个挖矿任务
+var isMining int32
+// 消息处理和挖矿都会修改区块链和上面的全局变量(交易池、孤块、同步状态)，必须持有这个锁
+// 每个连接的读协程并发调用处理函数，挖矿协程只在计算工作量证明时释放锁
+var chainLock sync.Mutex
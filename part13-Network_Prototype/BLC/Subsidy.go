@@ -0,0 +1,108 @@
+package BLC
+
+import "bytes"
+
+// 出块奖励相关参数
+// 创世区块的出块奖励
+const initialBlockSubsidy = 25
+
+// 每隔多少个区块奖励减半
+const subsidyHalvingInterval = 210
+
+// 创币交易的输出至少要经过多少个区块才能花费  防止链重组后花费它的交易全部失效
+const coinbaseMaturity = 100
+
+// 某个高度的区块奖励  创世区块高度为1，每subsidyHalvingInterval个区块减半，直到为0
+func BlockSubsidy(height int64) int64 {
+
+	if height < 1 {
+
+		return 0
+	}
+
+	halvings := (height - 1) / subsidyHalvingInterval
+	if halvings >= 63 {
+
+		return 0
+	}
+
+	return initialBlockSubsidy >> uint(halvings)
+}
+
+// 到某个高度为止(包含该高度)一共发行的币数  手续费只是转移，不计入发行量
+func TotalSupply(height int64) int64 {
+
+	var supply int64
+	for start := int64(1); start <= height; start += subsidyHalvingInterval {
+
+		subsidy := BlockSubsidy(start)
+		if subsidy == 0 {
+
+			break
+		}
+
+		// 本减半周期内已经产生的区块数
+		blocks := height - start + 1
+		if blocks > subsidyHalvingInterval {
+
+			blocks = subsidyHalvingInterval
+		}
+		supply += subsidy * blocks
+	}
+
+	return supply
+}
+
+// 最终的发行总量
+func MaxSupply() int64 {
+
+	var supply int64
+	for subsidy := int64(initialBlockSubsidy); subsidy > 0; subsidy >>= 1 {
+
+		supply += subsidy * subsidyHalvingInterval
+	}
+
+	return supply
+}
+
+// 交易的手续费：输入总额减去输出总额
+// 输入可以引用UTXOSet中的输出，也可以引用txs中还没打包的交易的输出
+func (utxoSet *UTXOSet) TransactionFee(tx *Transaction, txs []*Transaction) int64 {
+
+	if tx.IsCoinbaseTransaction() {
+
+		return 0
+	}
+
+	var inValue, outValue int64
+	for _, in := range tx.Vins {
+
+		inValue += utxoSet.findOutputValue(in.TxHash, in.Vout, txs)
+	}
+	for _, out := range tx.Vouts {
+
+		outValue += out.Value
+	}
+
+	return inValue - outValue
+}
+
+// 查找交易输入引用的输出金额
+func (utxoSet *UTXOSet) findOutputValue(txHash []byte, index int, txs []*Transaction) int64 {
+
+	for _, tx := range txs {
+
+		if bytes.Compare(tx.TxHash, txHash) == 0 && index < len(tx.Vouts) {
+
+			return tx.Vouts[index].Value
+		}
+	}
+
+	utxo := utxoSet.FindUTXO(txHash, index)
+	if utxo == nil {
+
+		return 0
+	}
+
+	return utxo.Output.Value
+}
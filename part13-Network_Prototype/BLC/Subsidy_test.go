@@ -0,0 +1,80 @@
+package BLC
+
+import "testing"
+
+func TestBlockSubsidy(t *testing.T) {
+
+	tests := []struct {
+		height  int64
+		subsidy int64
+	}{
+		{0, 0},
+		{1, 25},
+		{subsidyHalvingInterval, 25},
+		{subsidyHalvingInterval + 1, 12},
+		{2 * subsidyHalvingInterval, 12},
+		{2*subsidyHalvingInterval + 1, 6},
+		{4*subsidyHalvingInterval + 1, 1},
+		{5*subsidyHalvingInterval + 1, 0},
+		{64*subsidyHalvingInterval + 1, 0},
+	}
+
+	for _, test := range tests {
+
+		if subsidy := BlockSubsidy(test.height); subsidy != test.subsidy {
+
+			t.Errorf("height %d: subsidy %d, want %d", test.height, subsidy, test.subsidy)
+		}
+	}
+}
+
+// 发行量等于逐个区块奖励之和，最终停在MaxSupply
+func TestTotalSupply(t *testing.T) {
+
+	if MaxSupply() != (25+12+6+3+1)*subsidyHalvingInterval {
+
+		t.Fatalf("max supply %d", MaxSupply())
+	}
+
+	var supply int64
+	for height := int64(1); height <= 6*subsidyHalvingInterval; height++ {
+
+		supply += BlockSubsidy(height)
+		if TotalSupply(height) != supply {
+
+			t.Fatalf("height %d: total supply %d, want %d", height, TotalSupply(height), supply)
+		}
+	}
+	if supply != MaxSupply() || TotalSupply(1<<40) != MaxSupply() {
+
+		t.Errorf("supply %d, total %d, want %d", supply, TotalSupply(1<<40), MaxSupply())
+	}
+}
+
+// 创币交易可以领取区块奖励加手续费，多一点也不行
+func TestCoinbaseClaimsSubsidyAndFees(t *testing.T) {
+
+	blc := newTestBlockchain(t)
+
+	alice := NewWallet()
+	fund := fundTestWallets(t, blc, 10, alice)
+	parent := tipBlock(t, blc)
+	height := parent.Height + 1
+	spend := newTestSpend(t, blc, alice, fund, 0, NewTXOutput(7, testAddress()))
+
+	greedy := mineTestBlockWithTxs(t, blc, parent, []*Transaction{NewCoinbaseTransaction(testAddress(), height, 4), spend})
+	err := blc.AddBlock(greedy)
+	rejectErr, ok := err.(*BlockRejectError)
+	if ok == false || rejectErr.Reason != RejectBadCoinbase {
+
+		t.Fatalf("coinbase claiming more than the fees: %v", err)
+	}
+
+	block := mineTestBlockWithTxs(t, blc, parent, []*Transaction{NewCoinbaseTransaction(testAddress(), height, 3), spend})
+	mustAddBlock(t, blc, block)
+	if block.Txs[0].Vouts[0].Value != BlockSubsidy(height)+3 {
+
+		t.Errorf("coinbase pays %d, want %d", block.Txs[0].Vouts[0].Value, BlockSubsidy(height)+3)
+	}
+	assertUTXOSetMatchesReindex(t, blc)
+}
@@ -7,22 +7,56 @@ type TXInput struct {
 	TxHash []byte
 	//存储TXOutput在Vouts里的索引
 	Vout int
-	//数字签名
-	Signature []byte
-	//公钥
-	PublicKey []byte
+	//解锁脚本  P2PKH为<签名> <公钥>，P2SH为<数据...> <赎回脚本>，创币交易存放区块高度
+	ScriptSig []byte
+	//序号  用于相对锁定，MaxTxInSequenceNum表示不启用时间锁
+	Sequence uint32
 }
 
 //验证当前输入是否是当前地址的
 func (txInput *TXInput) UnlockWithAddress(address string) bool  {
 
-	//base58解码
-	version_pubKeyHash_checkSumBytes := Base58Decode([]byte(address))
-	//去除版本得到地反编码的公钥两次哈希后的值
-	ripemd160Hash := version_pubKeyHash_checkSumBytes[1:len(version_pubKeyHash_checkSumBytes)-4]
+	addressScript := PayToAddrScript(address)
+	if addressScript == nil {
 
-	//Ripemd160Hash算法得到公钥两次哈希后的值
-	ripemd160HashNew := Ripemd160Hash(txInput.PublicKey)
+		return false
+	}
 
-	return bytes.Compare(ripemd160HashNew, ripemd160Hash) == 0
-}
\ No newline at end of file
+	//P2SH地址  解锁脚本最后压入的赎回脚本的哈希与地址比较
+	if IsPayToScriptHash(addressScript) {
+
+		redeemScript := ExtractRedeemScript(txInput.ScriptSig)
+
+		return redeemScript != nil && bytes.Compare(PayToScriptHashScript(Ripemd160Hash(redeemScript)), addressScript) == 0
+	}
+
+	//从解锁脚本中取出公钥
+	publicKey := ExtractSignaturePublicKey(txInput.ScriptSig)
+	if publicKey == nil {
+
+		return false
+	}
+
+	//Ripemd160Hash算法得到公钥两次哈希后的值，生成对应的锁定脚本与地址比较
+	return bytes.Compare(PayToPubKeyHashScript(Ripemd160Hash(publicKey)), addressScript) == 0
+}
+
+// 编码：交易哈希 + 输出索引(int32) + 解锁脚本 + 序号(uint32)
+func (txInput *TXInput) encode(w *binaryWriter) {
+
+	w.writeVarBytes(txInput.TxHash)
+	w.writeInt32(int32(txInput.Vout))
+	w.writeVarBytes(txInput.ScriptSig)
+	w.writeUint32(txInput.Sequence)
+}
+
+func decodeTXInput(r *binaryReader) *TXInput {
+
+	txInput := &TXInput{}
+	txInput.TxHash = r.readVarBytes()
+	txInput.Vout = int(r.readInt32())
+	txInput.ScriptSig = r.readVarBytes()
+	txInput.Sequence = r.readUint32()
+
+	return txInput
+}
@@ -1,42 +1,46 @@
 package BLC
 
-import (
-
-	"bytes"
-)
-
 type TXOutput struct {
 	//面值
 	Value int64
-	//用户名
-	Ripemd160Hash []byte  //用户名  公钥两次哈希后的值
+	//锁定脚本  花费时需要提供能让它执行成功的解锁脚本
+	ScriptPubKey []byte
 }
 
 func NewTXOutput(value int64,address string) *TXOutput {
 
 	txOutput := &TXOutput{value,nil}
 
-	// 设置Ripemd160Hash
+	// 设置锁定脚本
 	txOutput.Lock(address)
 
 	return txOutput
 }
 
-//锁定
+//锁定  根据地址生成标准锁定脚本
 func (txOutput *TXOutput) Lock(address string) {
 
-	version_pubKeyHash_checkSumBytes := Base58Decode([]byte(address))
-	txOutput.Ripemd160Hash = version_pubKeyHash_checkSumBytes[1:len(version_pubKeyHash_checkSumBytes)-4]
+	txOutput.ScriptPubKey = PayToAddrScript(address)
 }
 
 //解锁
 func (txOutput *TXOutput) UnLockScriptPubKeyWithAddress(address string) bool {
 
-	version_pubKeyHash_checkSumBytes := Base58Decode([]byte(address))
-	ripemd160Hash := version_pubKeyHash_checkSumBytes[1:len(version_pubKeyHash_checkSumBytes) - 4]
+	return scriptPaysToAddress(txOutput.ScriptPubKey, address)
+}
+
+// 编码：面值(int64) + 锁定脚本
+func (txOutput *TXOutput) encode(w *binaryWriter) {
 
-	//fmt.Println(txOutput.Ripemd160Hash, ripemd160Hash)
-	return bytes.Compare(txOutput.Ripemd160Hash, ripemd160Hash) == 0
+	w.writeInt64(txOutput.Value)
+	w.writeVarBytes(txOutput.ScriptPubKey)
 }
 
+func decodeTXOutput(r *binaryReader) *TXOutput {
 
+	txOutput := &TXOutput{}
+	txOutput.Value = r.readInt64()
+	txOutput.ScriptPubKey = r.readVarBytes()
+
+	return txOutput
+}
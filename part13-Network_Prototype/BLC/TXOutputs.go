@@ -1,9 +1,7 @@
 package BLC
 
 import (
-	"encoding/gob"
 	"log"
-	"bytes"
 )
 
 type TXOutputs struct {
@@ -12,32 +10,44 @@ type TXOutputs struct {
 }
 
 
-// 序列化成字节数组
+// 序列化成字节数组  编码版本 + UTXO个数 + 依次编码每个UTXO
 func (txOutputs *TXOutputs) Serialize() []byte {
 
-	var result bytes.Buffer
+	w := &binaryWriter{}
+	w.writeByte(encodingVersion)
+	w.writeVarInt(uint64(len(txOutputs.UTXOS)))
+	for _, utxo := range txOutputs.UTXOS {
 
-	encoder := gob.NewEncoder(&result)
+		utxo.encode(w)
+	}
 
-	err := encoder.Encode(txOutputs)
-	if err != nil {
-		log.Panic(err)
+	return w.Bytes()
+}
+
+// 解码UTXO表记录
+func DecodeTXOutputs(txOutputsBytes []byte) (*TXOutputs, error) {
+
+	r := newBinaryReader(txOutputsBytes)
+	r.readVersion()
+
+	txOutputs := &TXOutputs{[]*UTXO{}}
+	count := r.readCount()
+	for i := 0; i < count && r.err == nil; i++ {
+
+		txOutputs.UTXOS = append(txOutputs.UTXOS, decodeUTXO(r))
 	}
 
-	return result.Bytes()
+	return txOutputs, r.finish()
 }
 
-// 反序列化
+// 反序列化  旧数据库中gob编码的记录在迁移时随UTXO表一起重建
 func DeserializeTXOutputs(txOutputsBytes []byte) *TXOutputs {
 
-	var txOutputs TXOutputs
-
-	decoder := gob.NewDecoder(bytes.NewReader(txOutputsBytes))
-	err := decoder.Decode(&txOutputs)
+	txOutputs, err := DecodeTXOutputs(txOutputsBytes)
 	if err != nil {
 
 		log.Panic(err)
 	}
 
-	return &txOutputs
-}
\ No newline at end of file
+	return txOutputs
+}
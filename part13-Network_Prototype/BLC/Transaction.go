@@ -3,17 +3,19 @@ package BLC
 import (
 	"crypto/sha256"
 	"bytes"
-	"encoding/gob"
 	"log"
 	"encoding/hex"
 	"crypto/ecdsa"
 	"crypto/rand"
-	"crypto/elliptic"
-	"math/big"
-	"time"
 	"fmt"
 )
 
+//当前交易版本  版本0是旧的交易，交易哈希混入了时间戳，无法重新计算
+const TxVersion = 1
+
+//默认手续费率  每千字节交易大小支付的手续费
+const defaultFeeRate = 1
+
 type Transaction struct {
 	//1.交易哈希值
 	TxHash []byte
@@ -21,6 +23,10 @@ type Transaction struct {
 	Vins []*TXInput
 	//3.输出
 	Vouts []*TXOutput
+	//4.版本
+	Version int64
+	//5.锁定时间  0表示不锁定，小于500000000为区块高度，否则为Unix时间戳
+	LockTime uint32
 }
 
 //1.coinbaseTransaction
@@ -105,17 +111,20 @@ type Transaction struct {
  给新的地址；如果有找零，会产生新的UTXO给原有地址。
 */
 
-//1.创币交易
-func NewCoinbaseTransaction(address string) *Transaction {
+//1.创币交易  金额为该高度的区块奖励加上区块内交易的手续费
+//交易哈希不再包含时间戳，同一地址的创币交易靠解锁脚本中写入的区块高度区分
+func NewCoinbaseTransaction(address string, height int64, fees int64) *Transaction {
 
-	//输入  由于创世区块其实没有输入，所以交易哈希传空，TXOutput索引传-1，签名随你
-	txInput := &TXInput{[]byte{}, -1, []byte{}, []byte{}}
+	//输入  由于创世区块其实没有输入，所以交易哈希传空，TXOutput索引传-1，解锁脚本压入区块高度
+	txInput := &TXInput{[]byte{}, -1, newScriptBuilder().AddInt64(height).Script(), MaxTxInSequenceNum}
 	//输出  产生一笔奖励给挖矿者
-	txOutput := NewTXOutput(int64(25), address)
+	txOutput := NewTXOutput(BlockSubsidy(height)+fees, address)
 	txCoinbase := &Transaction{
 		[]byte{},
 		[]*TXInput{txInput},
 		[]*TXOutput{txOutput},
+		TxVersion,
+		0,
 	}
 
 	txCoinbase.HashTransactions()
@@ -123,20 +132,112 @@ func NewCoinbaseTransaction(address string) *Transaction {
 	return txCoinbase
 }
 
+//创币交易中记录的区块高度
+func (tx *Transaction) CoinbaseHeight() (int64, bool) {
+
+	ops, err := parseScript(tx.Vins[0].ScriptSig)
+	if err != nil || len(ops) == 0 || ops[0].isPush() == false {
+
+		return 0, false
+	}
+
+	op := ops[0]
+	if op.opcode >= OP_1 && op.opcode <= OP_16 {
+
+		return int64(op.opcode - OP_1 + 1), true
+	}
+
+	height, err := scriptNumFromBytes(op.data, 8)
+	if err != nil {
+
+		return 0, false
+	}
+
+	return height, true
+}
+
 //创币交易判断
 func (tx *Transaction) IsCoinbaseTransaction() bool {
 
-	return len(tx.Vins[0].TxHash) == 0 && tx.Vins[0].Vout == -1
+	return len(tx.Vins) == 1 && len(tx.Vins[0].TxHash) == 0 && tx.Vins[0].Vout == -1
 }
 
 //2.普通交易
-func NewTransaction(from string, to string, amount int64, utxoSet *UTXOSet, txs []*Transaction, nodeID string) *Transaction {
+//feeRate为每千字节交易大小支付的手续费，手续费 = 输入总额 - 输出总额
+//lockTime为交易的锁定时间，sequence为每个输入的序号，不需要时间锁时分别传0和MaxTxInSequenceNum
+func NewTransaction(from string, to string, amount int64, feeRate int64, lockTime uint32, sequence uint32, utxoSet *UTXOSet, txs []*Transaction, nodeID string) *Transaction {
+
+	return newTransactionWithOutputs(from, []*TXOutput{NewTXOutput(amount, to)}, feeRate, lockTime, sequence, utxoSet, txs, nodeID)
+}
+
+//3.数据交易  一个携带数据、不可花费的输出，只支付手续费
+func NewDataTransaction(from string, data []byte, feeRate int64, utxoSet *UTXOSet, txs []*Transaction, nodeID string) (*Transaction, error) {
+
+	script, err := NullDataScript(data)
+	if err != nil {
+
+		return nil, err
+	}
+
+	return newTransactionWithOutputs(from, []*TXOutput{{0, script}}, feeRate, 0, MaxTxInSequenceNum, utxoSet, txs, nodeID), nil
+}
+
+//从from的UTXO中支付outputs和手续费
+func newTransactionWithOutputs(from string, outputs []*TXOutput, feeRate int64, lockTime uint32, sequence uint32, utxoSet *UTXOSet, txs []*Transaction, nodeID string) *Transaction {
 
 	//获取钱包集合
 	wallets, _ := NewWallets(nodeID)
 	wallet := wallets.Wallets[from]
 
-	money, spendableUTXODic := utxoSet.FindSpendableUTXOs(from, amount, txs)
+	tx, _ := buildTransactionWithFee(feeRate, func(fee int64) (*Transaction, int, error) {
+
+		tx := newSignedTransaction(wallet, from, outputs, fee, lockTime, sequence, utxoSet, txs)
+
+		return tx, len(tx.Serialize()), nil
+	})
+
+	return tx
+}
+
+//按交易大小计算手续费  不足1000字节的部分向上取整
+func TransactionFeeForSize(size int, feeRate int64) int64 {
+
+	return (int64(size)*feeRate + 999) / 1000
+}
+
+//交易大小在签名前无法确定，先按当前手续费构造，大小算出后手续费不够再重新构造
+//build按给定手续费构造交易，返回交易和计算手续费用的大小
+func buildTransactionWithFee(feeRate int64, build func(fee int64) (*Transaction, int, error)) (*Transaction, error) {
+
+	var fee int64
+	for {
+
+		tx, size, err := build(fee)
+		if err != nil {
+
+			return nil, err
+		}
+
+		required := TransactionFeeForSize(size, feeRate)
+		if fee >= required {
+
+			fmt.Printf("交易大小：%d字节，手续费：%d\n", size, fee)
+			return tx, nil
+		}
+		fee = required
+	}
+}
+
+//选取UTXO构造交易并签名  找零为输入总额减去输出总额和手续费
+func newSignedTransaction(wallet *Wallet, from string, outputs []*TXOutput, fee int64, lockTime uint32, sequence uint32, utxoSet *UTXOSet, txs []*Transaction) *Transaction {
+
+	var amount int64
+	for _, out := range outputs {
+
+		amount += out.Value
+	}
+
+	money, spendableUTXODic := utxoSet.FindSpendableUTXOs(from, amount+fee, txs)
 
 	//输入输出
 	var txInputs []*TXInput
@@ -153,7 +254,7 @@ func NewTransaction(from string, to string, amount int64, utxoSet *UTXOSet, txs
 				TxHashBytes,
 				index,
 				nil,
-				wallet.PublicKey,
+				sequence,
 			}
 
 			txInputs = append(txInputs, txInput)
@@ -161,25 +262,30 @@ func NewTransaction(from string, to string, amount int64, utxoSet *UTXOSet, txs
 	}
 
 	//转账
-	txOutput := NewTXOutput(int64(amount), to)
-	txOutputs = append(txOutputs, txOutput)
+	txOutputs = append(txOutputs, outputs...)
 
-	//找零
-	txOutput = NewTXOutput(int64(money)-int64(amount), from)
-	txOutputs = append(txOutputs, txOutput)
+	//找零  没有找零时不产生输出
+	if change := money - amount - fee; change > 0 {
+
+		txOutput := NewTXOutput(change, from)
+		txOutputs = append(txOutputs, txOutput)
+	}
 
 	//交易构造
 	tx := &Transaction{
 		[]byte{},
 		txInputs,
 		txOutputs,
+		TxVersion,
+		lockTime,
 	}
 
-	tx.HashTransactions()
-
 	//进行签名
 	utxoSet.Blockchain.SignTransaction(tx, wallet.PrivateKey, txs)
 
+	//交易哈希包含签名，签名完成后再计算
+	tx.HashTransactions()
+
 	return tx
 
 	/**
@@ -255,116 +361,110 @@ func (tx *Transaction) Sign(privateKey ecdsa.PrivateKey, prevTxs map[string]Tran
 		}
 	}
 
-	//将会被签署的是修剪后的交易副本
-	fmt.Println("Sign:")
-	txCopy := tx.TrimmedCopy()
-
 	//遍历交易的每一个输入
-	for inID, vin := range txCopy.Vins {
+	for inID, vin := range tx.Vins {
 
-		//fmt.Println("Sign:")
 		//交易输入引用的上一笔交易
 		prevTx := prevTxs[hex.EncodeToString(vin.TxHash)]
-		//Signature 被设置为 nil
-		txCopy.Vins[inID].Signature = nil
-		//PubKey 被设置为所引用输出的PubKeyHash
-		txCopy.Vins[inID].PublicKey = prevTx.Vouts[vin.Vout].Ripemd160Hash
-		//设置交易哈希
-		//txCopy.TxHash =  txCopy.Hash()
-		//设置完哈希后要重置PublicKey
-
-		//fmt.Println("Sign:")
-		//tx.PrintTx()
-		//fmt.Println("txCopy:")
-		//txCopy.PrintTx()
-
-		// 签名代码
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
-		//老师源代码
-		//r, s, err := ecdsa.Sign(rand.Reader, &privateKey, txCopy.TxHash)
-		//修改为对交易进行签名
-		r, s, err := ecdsa.Sign(rand.Reader, &privateKey, []byte(dataToSign))
+
+		// 签名代码  对修剪后的交易副本的哈希进行签名，被签名的脚本为所引用输出的锁定脚本
+		signature, err := signHash(&privateKey, tx.SignatureHash(inID, prevTx.Vouts[vin.Vout].ScriptPubKey))
 		if err != nil {
 
 			log.Panic(err)
 		}
-		//一个ECDSA签名就是一对数字，我们对这对数字连接起来就是signature
-		signature := append(r.Bytes(), s.Bytes()...)
 
-		tx.Vins[inID].Signature = signature
-		txCopy.Vins[inID].PublicKey = nil
+		//解锁脚本  <签名> <公钥>
+		tx.Vins[inID].ScriptSig = SignatureScript(signature, publicKeyBytes(&privateKey.PublicKey))
 	}
 }
 
+//用私钥对签名数据签名
+//一个ECDSA签名就是一对数字，我们对这对数字定长连接起来就是signature
+func signHash(privKey *ecdsa.PrivateKey, hash []byte) ([]byte, error) {
+
+	r, s, err := ecdsa.Sign(rand.Reader, privKey, hash)
+	if err != nil {
+
+		return nil, err
+	}
+
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return signature, nil
+}
+
 // 验签
 func (tx *Transaction) Verify(prevTxs map[string]Transaction) bool {
 
-
 	if tx.IsCoinbaseTransaction() {
 
 		return true
 	}
 
+	// 取出每个输入引用的输出
+	var prevOuts []*TXOutput
 	for _, vin := range tx.Vins {
 
-		if prevTxs[hex.EncodeToString(vin.TxHash)].TxHash == nil {
+		prevTx := prevTxs[hex.EncodeToString(vin.TxHash)]
+		if prevTx.TxHash == nil || vin.Vout < 0 || vin.Vout >= len(prevTx.Vouts) {
 
-			log.Panic("ERROR: Previous transaction is not correct")
+			return false
 		}
+		prevOuts = append(prevOuts, prevTx.Vouts[vin.Vout])
 	}
 
-	//fmt.Println("Verify:")
-	txCopy := tx.TrimmedCopy()
+	return tx.VerifyWithOutputs(prevOuts)
+}
 
-	//用于椭圆曲线算法生成秘钥对
-	curve := elliptic.P256()
+// 根据每个输入所引用的输出验签，prevOuts与Vins一一对应
+func (tx *Transaction) VerifyWithOutputs(prevOuts []*TXOutput) bool {
 
-	// 遍历输入，验证签名
-	for inID, vin := range tx.Vins {
+	return tx.VerifyScripts(prevOuts) == nil
+}
 
-		//fmt.Println("Verify:")
-		// 这个部分跟Sign方法一样,因为在验证阶段,我们需要的是与签名相同的数据。
-		prevTx := prevTxs[hex.EncodeToString(vin.TxHash)]
-		txCopy.Vins[inID].Signature = nil
-		txCopy.Vins[inID].PublicKey = prevTx.Vouts[vin.Vout].Ripemd160Hash
+// 执行每个输入的解锁脚本和所引用输出的锁定脚本，返回第一个失败的原因
+func (tx *Transaction) VerifyScripts(prevOuts []*TXOutput) error {
 
-		//txCopy.PrintTx()
-		//txCopy.TxHash =  txCopy.Hash()
+	if tx.IsCoinbaseTransaction() {
 
-		//fmt.Println("Verify:")
-		//tx.PrintTx()
-		//fmt.Println("txCopy:")
-		//txCopy.PrintTx()
+		return nil
+	}
 
-		// 私钥
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)])
-		s.SetBytes(vin.Signature[(sigLen / 2):])
+	if len(prevOuts) != len(tx.Vins) {
 
-		// 公钥
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PublicKey)
-		x.SetBytes(vin.PublicKey[:(keyLen / 2)])
-		y.SetBytes(vin.PublicKey[(keyLen / 2):])
+		return fmt.Errorf("%d inputs, %d previous outputs", len(tx.Vins), len(prevOuts))
+	}
 
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
+	for inID, vin := range tx.Vins {
 
-		// 使用从输入提取的公钥创建ecdsa.PublicKey
-		rawPubKey := ecdsa.PublicKey{curve, &x, &y}
-		if ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s) == false {
+		err := VerifyScript(vin.ScriptSig, prevOuts[inID].ScriptPubKey, tx, inID)
+		if err != nil {
 
-			return false
+			return fmt.Errorf("input %d: %s", inID, err)
 		}
-		txCopy.Vins[inID].PublicKey = nil
 	}
 
-	return true
+	return nil
 }
 
-// 拷贝一份新的Transaction用于签名,包含所有的输入输出，但TXInput.Signature 和 TXIput.PubKey 被设置为 nil                                 T
+// 计算某个输入的签名数据
+// 修剪后的交易副本清空交易哈希和所有解锁脚本，只在当前输入的位置填入被签名的脚本，序列化后取哈希
+// 被签名的脚本一般是所引用输出的锁定脚本
+func (tx *Transaction) SignatureHash(inID int, subScript []byte) []byte {
+
+	txCopy := tx.TrimmedCopy()
+	txCopy.TxHash = nil
+	txCopy.Vins[inID].ScriptSig = subScript
+
+	hash := sha256.Sum256(txCopy.Serialize())
+
+	return hash[:]
+}
+
+// 拷贝一份新的Transaction用于签名,包含所有的输入输出，但TXInput.ScriptSig 被设置为 nil
 func (tx *Transaction) TrimmedCopy() Transaction {
 
 	var inputs []*TXInput
@@ -372,15 +472,15 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 
 	for _, vin := range tx.Vins {
 
-		inputs = append(inputs, &TXInput{vin.TxHash, vin.Vout, nil, nil})
+		inputs = append(inputs, &TXInput{vin.TxHash, vin.Vout, nil, vin.Sequence})
 	}
 
 	for _, vout := range tx.Vouts {
 
-		outputs = append(outputs, &TXOutput{vout.Value, vout.Ripemd160Hash})
+		outputs = append(outputs, &TXOutput{vout.Value, vout.ScriptPubKey})
 	}
 
-	txCopy := Transaction{tx.TxHash, inputs, outputs}
+	txCopy := Transaction{tx.TxHash, inputs, outputs, tx.Version, tx.LockTime}
 
 	//fmt.Printf("\ntx:\n%x\ncopy:\n%x", tx.TxHash, txCopy.TxHash)
 
@@ -388,71 +488,137 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 }
 
 //对交易信息进行哈希
+//交易哈希只由交易内容决定：清空TxHash后序列化，做两次sha256，任何节点任何时候计算结果都相同
+//普通交易计算哈希前还要清空所有解锁脚本：转发交易的节点不需要私钥就能改写解锁脚本(换一种压栈方式、多压入数据)，
+//解锁脚本参与哈希时同一笔交易会有多个交易哈希，花费它的输出的后续交易就失效了
+//创币交易的解锁脚本记录区块高度，不是签名，保留在哈希中使每个区块的创币交易哈希不同
 func (tx *Transaction) Hash() []byte  {
 
-	var hash [32]byte
-
 	txCopy := *tx
-	txCopy.TxHash = []byte{}
+	if tx.IsCoinbaseTransaction() == false {
+
+		txCopy = tx.TrimmedCopy()
+	}
+	txCopy.TxHash = nil
 
-	fmt.Println("Hash:\n")
-	tx.PrintTx()
-	hash = sha256.Sum256(txCopy.Serialize())
+	hash := sha256.Sum256(txCopy.Serialize())
+	hash = sha256.Sum256(hash[:])
 
 	return hash[:]
 }
 
-//交易序列化
+//包含解锁脚本的交易哈希  对完整的交易编码做两次sha256
+//区块的默克尔树以它为叶子，区块哈希因此确定了每个输入的解锁脚本
+func (tx *Transaction) SignedHash() []byte {
+
+	hash := sha256.Sum256(tx.Serialize())
+	hash = sha256.Sum256(hash[:])
+
+	return hash[:]
+}
+
+//交易序列化  编码版本 + 交易内容
 func (tx *Transaction) Serialize() []byte {
 
-	var encoded bytes.Buffer
+	w := &binaryWriter{}
+	w.writeByte(encodingVersion)
+	tx.encode(w)
 
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
-	if err != nil {
+	return w.Bytes()
+}
 
-		log.Panic(err)
+//编码：版本(int64) + 交易哈希 + 输入个数 + 输入 + 输出个数 + 输出 + 锁定时间(uint32)
+func (tx *Transaction) encode(w *binaryWriter) {
+
+	w.writeInt64(tx.Version)
+	w.writeVarBytes(tx.TxHash)
+
+	w.writeVarInt(uint64(len(tx.Vins)))
+	for _, in := range tx.Vins {
+
+		in.encode(w)
 	}
 
-	//tx.PrintTx()
-	//fmt.Printf("\n%x\n", encoded.Bytes())
+	w.writeVarInt(uint64(len(tx.Vouts)))
+	for _, out := range tx.Vouts {
 
-	return encoded.Bytes()
+		out.encode(w)
+	}
+
+	w.writeUint32(tx.LockTime)
 }
 
-func DeserializeTransaction(data []byte) Transaction {
+func decodeTransaction(r *binaryReader) *Transaction {
 
-	var tx Transaction
+	tx := &Transaction{}
+	tx.Version = r.readInt64()
+	tx.TxHash = r.readVarBytes()
 
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&tx)
-	if err != nil {
+	count := r.readCount()
+	for i := 0; i < count && r.err == nil; i++ {
 
-		log.Panic(err)
+		tx.Vins = append(tx.Vins, decodeTXInput(r))
+	}
+
+	count = r.readCount()
+	for i := 0; i < count && r.err == nil; i++ {
+
+		tx.Vouts = append(tx.Vouts, decodeTXOutput(r))
 	}
 
+	tx.LockTime = r.readUint32()
+
 	return tx
 }
 
-//将交易信息转换为字节数组
-func (tx *Transaction) HashTransactions() {
+//解码交易
+func DecodeTransaction(data []byte) (*Transaction, error) {
 
-	//交易信息序列化
-	var result bytes.Buffer
+	r := newBinaryReader(data)
+	r.readVersion()
+	tx := decodeTransaction(r)
+
+	return tx, r.finish()
+}
 
-	encoder := gob.NewEncoder(&result)
+func DeserializeTransaction(data []byte) Transaction {
 
-	err := encoder.Encode(tx)
+	tx, err := DecodeTransaction(data)
 	if err != nil {
 
 		log.Panic(err)
 	}
 
-	//是创币交易的哈希不同
-	timeSpBytes := IntToHex(time.Now().Unix())
-	//设置hash
-	TxHash := sha256.Sum256(bytes.Join([][]byte{timeSpBytes, result.Bytes()}, []byte{}))
-	tx.TxHash = TxHash[:]
+	return *tx
+}
+
+//设置交易哈希
+//旧版本在哈希中混入了time.Now()，同一笔交易每次计算结果都不同，现在统一使用Hash()
+//旧数据库中的版本0交易在打开数据库时由migrateLegacyChain转换为当前版本并重新计算哈希，链上不再有版本0交易
+func (tx *Transaction) HashTransactions() {
+
+	if tx.Version < TxVersion {
+
+		tx.Version = TxVersion
+	}
+
+	tx.TxHash = tx.Hash()
+}
+
+//检查交易哈希是否与内容一致
+func (tx *Transaction) CheckTxHash() error {
+
+	if tx.Version < TxVersion {
+
+		return fmt.Errorf("legacy tx version %d", tx.Version)
+	}
+
+	if bytes.Compare(tx.TxHash, tx.Hash()) != 0 {
+
+		return fmt.Errorf("tx hash %x does not match contents", tx.TxHash)
+	}
+
+	return nil
 }
 
 func (tx *Transaction) PrintTx()  {
@@ -464,15 +630,16 @@ func (tx *Transaction) PrintTx()  {
 	for _, in := range tx.Vins {
 		fmt.Printf("TxHash:%x\n", in.TxHash)
 		fmt.Printf("Vout:%d\n", in.Vout)
-		fmt.Printf("Signature:%x\n\n", in.Signature)
-		fmt.Printf("PublicKey:%x\n\n", in.PublicKey)
+		fmt.Printf("ScriptSig:%s\n", DisasmScript(in.ScriptSig))
+		fmt.Printf("Sequence:%x\n\n", in.Sequence)
 	}
 
 	fmt.Println("Vouts:")
 	for _, out := range tx.Vouts {
 		fmt.Printf("Value:%d\n", out.Value)
-		fmt.Printf("Ripemd160Hash:%x\n\n", out.Ripemd160Hash)
+		fmt.Printf("ScriptPubKey:%s\n\n", DisasmScript(out.ScriptPubKey))
 	}
+	fmt.Printf("LockTime:%d\n", tx.LockTime)
 
-	fmt.Println("------------------------------\n\n")
+	fmt.Print("------------------------------\n\n\n")
 }
\ No newline at end of file
@@ -0,0 +1,112 @@
+package BLC
+
+import "fmt"
+
+/**
+交易证明  轻节点只保存区块头，用它证明一笔交易已经被打包
+
+证明包含：区块头 + 交易 + 默克尔证明(兄弟节点哈希和交易位置)
+验证方：
+1.重新计算交易哈希，交易内容不能被篡改
+2.检查区块头满足自身的难度目标
+3.用交易哈希和默克尔证明计算默克尔根，与区块头中的默克尔根比较
+4.区块头在本地的最长链上时，确认数 = 链高度 - 区块高度 + 1
+*/
+
+// 交易证明
+type TxProof struct {
+	//1.交易所在区块的区块头
+	Header BlockHeader
+	//2.交易
+	Tx *Transaction
+	//3.从叶子到根的兄弟节点哈希
+	Branch [][]byte
+	//4.交易在区块中的位置
+	Index uint32
+}
+
+// 生成区块中某笔交易的证明
+func NewTxProof(block *Block, txHash []byte) (*TxProof, error) {
+
+	var tx *Transaction
+	for _, blockTx := range block.Txs {
+
+		if string(blockTx.TxHash) == string(txHash) {
+
+			tx = blockTx
+			break
+		}
+	}
+	if tx == nil {
+
+		return nil, fmt.Errorf("tx %x is not in block %x", txHash, block.Hash)
+	}
+
+	branch, index, err := block.MerkleProof(txHash)
+	if err != nil {
+
+		return nil, err
+	}
+
+	return &TxProof{block.BlockHeader, tx, branch, uint32(index)}, nil
+}
+
+// 验证交易证明  只依赖区块头，不需要完整区块
+func (proof *TxProof) Verify() error {
+
+	err := proof.Tx.CheckTxHash()
+	if err != nil {
+
+		return err
+	}
+
+	if proof.Header.CheckProofOfWork() == false {
+
+		return fmt.Errorf("block header %x does not meet its target", proof.Header.Hash())
+	}
+
+	if VerifyMerkleProof(proof.Header.MerkleRoot, proof.Tx.SignedHash(), proof.Branch, int(proof.Index)) == false {
+
+		return fmt.Errorf("merkle proof does not match root %x", proof.Header.MerkleRoot)
+	}
+
+	return nil
+}
+
+// 编码：编码版本 + 定长区块头 + 交易 + 哈希个数 + 32字节哈希 + 位置(uint32)
+func (proof *TxProof) Serialize() []byte {
+
+	w := &binaryWriter{}
+	w.writeByte(encodingVersion)
+	proof.Header.encode(w)
+	proof.Tx.encode(w)
+
+	w.writeVarInt(uint64(len(proof.Branch)))
+	for _, hash := range proof.Branch {
+
+		w.writeHash(hash)
+	}
+	w.writeUint32(proof.Index)
+
+	return w.Bytes()
+}
+
+// 解码交易证明
+func DecodeTxProof(data []byte) (*TxProof, error) {
+
+	r := newBinaryReader(data)
+	r.readVersion()
+
+	proof := &TxProof{}
+	proof.Header = decodeBlockHeader(r)
+	proof.Tx = decodeTransaction(r)
+
+	count := r.readCount()
+	for i := 0; i < count && r.err == nil; i++ {
+
+		proof.Branch = append(proof.Branch, r.readHash())
+	}
+	proof.Index = r.readUint32()
+
+	return proof, r.finish()
+}
@@ -0,0 +1,136 @@
+package BLC
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// 高度为height的区块  创币交易加count笔花费不同输出的交易
+func mineTestProofBlock(t *testing.T, height int64, prevHash []byte, count int) *Block {
+
+	txs := []*Transaction{NewCoinbaseTransaction(testAddress(), height, 0)}
+	for i := 0; i < count; i++ {
+
+		tx := &Transaction{[]byte{}, []*TXInput{{repeatHash(byte(i + 1)), i, []byte{byte(i)}, MaxTxInSequenceNum}}, []*TXOutput{NewTXOutput(int64(i+1), testAddress())}, TxVersion, 0}
+		tx.HashTransactions()
+		txs = append(txs, tx)
+	}
+
+	block, err := MineBlock(context.Background(), txs, height, prevHash, genesisTargetBits)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+// 证明经过编码和解码后仍然有效，篡改任何部分都不能通过验证
+func TestTxProofVerify(t *testing.T) {
+
+	// 5个叶子，最后一个在每一层都要复制
+	block := mineTestProofBlock(t, 2, repeatHash(0x01), 4)
+
+	for index, tx := range block.Txs {
+
+		proof, err := NewTxProof(block, tx.TxHash)
+		if err != nil {
+
+			t.Fatal(err)
+		}
+		if proof.Index != uint32(index) {
+
+			t.Errorf("tx %d: proof index %d", index, proof.Index)
+		}
+
+		decoded, err := DecodeTxProof(proof.Serialize())
+		if err != nil {
+
+			t.Fatalf("tx %d: decode: %s", index, err)
+		}
+		err = decoded.Verify()
+		if err != nil {
+
+			t.Errorf("tx %d: %s", index, err)
+		}
+	}
+
+	tamper := map[string]func(proof *TxProof){
+		"index":        func(proof *TxProof) { proof.Index ^= 1 },
+		"branch":       func(proof *TxProof) { proof.Branch[0] = repeatHash(0x02) },
+		"short branch": func(proof *TxProof) { proof.Branch = proof.Branch[1:] },
+		"header nonce": func(proof *TxProof) { proof.Header.Nonce++ },
+		"merkle root":  func(proof *TxProof) { proof.Header.MerkleRoot = repeatHash(0x03) },
+		"output":       func(proof *TxProof) { proof.Tx.Vouts[0].Value++ },
+		// 交易哈希不包含解锁脚本，默克尔树的叶子包含
+		"script sig": func(proof *TxProof) { proof.Tx.Vins[0].ScriptSig = []byte{0xff} },
+	}
+	for name, change := range tamper {
+
+		proof, err := NewTxProof(block, block.Txs[2].TxHash)
+		if err != nil {
+
+			t.Fatal(err)
+		}
+		proof, err = DecodeTxProof(proof.Serialize())
+		if err != nil {
+
+			t.Fatal(err)
+		}
+		change(proof)
+		if proof.Verify() == nil {
+
+			t.Errorf("proof with tampered %s verified", name)
+		}
+	}
+
+	if _, err := NewTxProof(block, repeatHash(0x04)); err == nil {
+
+		t.Errorf("proof for a tx outside the block")
+	}
+
+	data := (&TxProof{block.BlockHeader, block.Txs[1], nil, 1}).Serialize()
+	if _, err := DecodeTxProof(data[:len(data)-1]); err == nil {
+
+		t.Errorf("truncated proof decoded")
+	}
+}
+
+// 轻节点只保存区块头在本地主链上的证明
+func TestSPVAddTxProof(t *testing.T) {
+
+	genesis := mineTestProofBlock(t, 1, make([]byte, 32), 2)
+	other := mineTestProofBlock(t, 1, make([]byte, 32), 2)
+	spv := openTestSPVChain(t, genesis.Hash)
+
+	err := spv.AddHeader(&genesis.BlockHeader)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		block *Block
+		ok    bool
+	}{
+		{genesis, true},
+		{other, false},
+	} {
+
+		proof, err := NewTxProof(test.block, test.block.Txs[1].TxHash)
+		if err != nil {
+
+			t.Fatal(err)
+		}
+		if err := spv.AddTxProof(proof); (err == nil) != test.ok {
+
+			t.Errorf("block %x: add proof %v, want ok %v", test.block.Hash, err, test.ok)
+		}
+	}
+
+	if proofs := spv.mainChainProofs(); len(proofs) != 1 || bytes.Compare(proofs[0].Tx.TxHash, genesis.Txs[1].TxHash) != 0 {
+
+		t.Errorf("stored proofs %d", len(proofs))
+	}
+}
@@ -1,5 +1,7 @@
 package BLC
 
+import "fmt"
+
 type UTXO struct {
 	//来自交易的哈希
 	TxHash []byte
@@ -7,5 +9,51 @@ type UTXO struct {
 	Index int
 	//未花费的交易输出
 	Output *TXOutput
+	//所在区块高度  未打包的交易为0
+	Height int64
+	//是否来自创币交易
+	IsCoinbase bool
 }
 
+// 编码：交易哈希 + 输出下标 + 输出 + 区块高度 + 是否来自创币交易
+func (utxo *UTXO) encode(w *binaryWriter) {
+
+	w.writeVarBytes(utxo.TxHash)
+	w.writeVarInt(uint64(utxo.Index))
+	utxo.Output.encode(w)
+	w.writeInt64(utxo.Height)
+	if utxo.IsCoinbase {
+
+		w.writeByte(1)
+	} else {
+
+		w.writeByte(0)
+	}
+}
+
+// 是否已经成熟可以花费  height为花费它的交易所在区块的高度
+func (utxo *UTXO) IsMature(height int64) bool {
+
+	return utxo.IsCoinbase == false || height-utxo.Height >= coinbaseMaturity
+}
+
+func decodeUTXO(r *binaryReader) *UTXO {
+
+	utxo := &UTXO{}
+	utxo.TxHash = r.readVarBytes()
+	utxo.Index = int(r.readVarInt())
+	utxo.Output = decodeTXOutput(r)
+	utxo.Height = r.readInt64()
+	switch r.readByte() {
+	case 0:
+	case 1:
+		utxo.IsCoinbase = true
+	default:
+		if r.err == nil {
+
+			r.err = fmt.Errorf("invalid coinbase flag")
+		}
+	}
+
+	return utxo
+}
@@ -6,12 +6,13 @@ import (
 	"encoding/hex"
 	"fmt"
 	"os"
-	"bytes"
 )
 
 
 //存储未花费交易输出的数据库表
 const UTXOTableName  = "UTXOTableName"
+//存储每个区块消耗的UTXO，用于重组时回滚
+const utxoUndoTableName = "UTXOUndoTableName"
 
 type UTXOSet struct {
 
@@ -26,31 +27,34 @@ func (utxoSet *UTXOSet) ResetUTXOSet()  {
 
 	err := utxoSet.Blockchain.DB.Update(func(tx *bolt.Tx) error {
 
-		b := tx.Bucket([]byte(UTXOTableName))
+		// 删除原有UTXO表和回滚数据
+		for _, table := range []string{UTXOTableName, utxoUndoTableName} {
 
-		// 删除原有UTXO表
-		if b != nil {
+			if tx.Bucket([]byte(table)) != nil {
 
-			err := tx.DeleteBucket([]byte(UTXOTableName))
-			if err!= nil {
+				err := tx.DeleteBucket([]byte(table))
+				if err != nil {
 
-				log.Panic(err)
+					log.Panic(err)
+				}
 			}
 		}
 
-		// 新建UTXO表
-		b ,_ = tx.CreateBucket([]byte(UTXOTableName))
-		if b != nil {
-
-			//找到链上所有UTXO并存入数据库
-			txOutputsMap := utxoSet.Blockchain.FindUTXOMap()
+		// 从主链顶端往回找到所有区块
+		blocks := tx.Bucket([]byte(blockTableName))
+		var chain []*Block
+		for block := loadBlock(blocks, blocks.Get([]byte(newestBlockKey))); block != nil; block = loadBlock(blocks, block.PrevBlockHash) {
 
-			for keyHash,outs := range txOutputsMap {
+			chain = append(chain, block)
+		}
 
-				TxHash,_ := hex.DecodeString(keyHash)
+		// 从创世区块开始依次重放，同时生成每个区块的回滚数据
+		for i := len(chain) - 1; i >= 0; i-- {
 
-				b.Put(TxHash,outs.Serialize())
+			err := utxoSet.connectBlock(tx, chain[i], false)
+			if err != nil {
 
+				return err
 			}
 		}
 
@@ -97,19 +101,26 @@ func (utxoSet *UTXOSet) FindUTXOsForAddress(address string) []*UTXO {
 	return utxos
 }
 
-// 3.查询余额
-func (utxoSet *UTXOSet) GetBalance(address string) int64 {
+// 3.查询余额  分别返回已成熟可花费的余额和未成熟的创币奖励
+func (utxoSet *UTXOSet) GetBalance(address string) (int64, int64) {
 
 	UTXOS := utxoSet.FindUTXOsForAddress(address)
+	nextHeight := utxoSet.Blockchain.GetBestHeight() + 1
 
-	var amount int64
+	var mature, immature int64
 
 	for _, utxo := range UTXOS  {
 
-		amount += utxo.Output.Value
+		if utxo.IsMature(nextHeight) {
+
+			mature += utxo.Output.Value
+		} else {
+
+			immature += utxo.Output.Value
+		}
 	}
 
-	return amount
+	return mature, immature
 }
 
 // 返回要凑多少钱，对应TXOutput的TX的Hash和index ???Set本身就是UTXO集合，里面的不全是未花费吗？？？？
@@ -136,6 +147,12 @@ func (utxoSet *UTXOSet) FindUnPackageSpendableUTXOS(address string, txs []*Trans
 
 	for _,tx := range txs {
 
+		// 未打包的创币交易还没有成熟，不能花费
+		if tx.IsCoinbaseTransaction() {
+
+			continue
+		}
+
 	Work:
 		for index,out := range tx.Vouts {
 
@@ -160,19 +177,19 @@ func (utxoSet *UTXOSet) FindUnPackageSpendableUTXOS(address string, txs []*Trans
 
 								if isUnSpent {
 
-									utxo := &UTXO{tx.TxHash, index, out}
+									utxo := &UTXO{tx.TxHash, index, out, 0, false}
 									unUTXOs = append(unUTXOs, utxo)
 								}
 							}
 						} else {
 
-							utxo := &UTXO{tx.TxHash, index, out}
+							utxo := &UTXO{tx.TxHash, index, out, 0, false}
 							unUTXOs = append(unUTXOs, utxo)
 						}
 					}
 				} else {
 
-					utxo := &UTXO{tx.TxHash, index, out}
+					utxo := &UTXO{tx.TxHash, index, out, 0, false}
 					unUTXOs = append(unUTXOs, utxo)
 				}
 			}
@@ -203,6 +220,19 @@ func (utxoSet *UTXOSet) FindSpendableUTXOs(address string,amount int64,txs []*Tr
 		}
 	}
 
+	// 新交易最早打包进下一个区块，创币交易的输出需要在该高度已经成熟
+	nextHeight := utxoSet.Blockchain.GetBestHeight() + 1
+
+	// 已经被未打包交易花费的输出
+	spentOutputs := make(map[string]bool)
+	for _, tx := range txs {
+
+		for _, in := range tx.Vins {
+
+			spentOutputs[fmt.Sprintf("%x:%d", in.TxHash, in.Vout)] = true
+		}
+	}
+
 	// 钱还不够
 	err := utxoSet.Blockchain.DB.View(func(tx *bolt.Tx) error {
 
@@ -218,6 +248,14 @@ func (utxoSet *UTXOSet) FindSpendableUTXOs(address string,amount int64,txs []*Tr
 
 				for _, utxo := range txOutputs.UTXOS {
 
+					// 只能使用属于该地址、已经成熟且还没被花费的输出
+					if utxo.Output.UnLockScriptPubKeyWithAddress(address) == false ||
+						utxo.IsMature(nextHeight) == false ||
+						spentOutputs[fmt.Sprintf("%x:%d", utxo.TxHash, utxo.Index)] {
+
+						continue
+					}
+
 					value += utxo.Output.Value
 					TxHash := hex.EncodeToString(utxo.TxHash)
 					spentableUTXO[TxHash] = append(spentableUTXO[TxHash], utxo.Index)
@@ -239,127 +277,247 @@ func (utxoSet *UTXOSet) FindSpendableUTXOs(address string,amount int64,txs []*Tr
 
 	if value < amount{
 
-		fmt.Printf("%s found.余额不足...", value)
+		fmt.Printf("%d found.余额不足...", value)
 		os.Exit(1)
 	}
 
 	return  value, spentableUTXO
 }
 
-//更新UTXO 
-func (utxoSet *UTXOSet) Update()  {
+// 查询某个交易输出是否还在UTXOSet中
+func (utxoSet *UTXOSet) FindUTXO(txHash []byte, index int) *UTXO {
 
-	// 1.找出最新区块
-	block := utxoSet.Blockchain.Iterator().Next()
+	var result *UTXO
 
-	// 未花费的UTXO  键为对应交易哈希，值为TXOutput数组
-	outsMap := make(map[string] *TXOutputs)
-	// 新区快的交易输入,这些交易输入引用的TXOutput被消耗，应该从UTXOSet删除
-	ins := []*TXInput{}
+	err := utxoSet.Blockchain.DB.View(func(tx *bolt.Tx) error {
 
-	// 2.遍历区块交易找出交易输入
-	for _, tx := range block.Txs {
+		b := tx.Bucket([]byte(UTXOTableName))
+		if b == nil {
 
-		//遍历交易输入，
-		for _, in := range tx.Vins {
+			return nil
+		}
+
+		txOutputsBytes := b.Get(txHash)
+		if txOutputsBytes == nil {
 
-			ins = append(ins, in)
+			return nil
 		}
+
+		for _, utxo := range DeserializeTXOutputs(txOutputsBytes).UTXOS {
+
+			if utxo.Index == index {
+
+				result = utxo
+				break
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+
+		log.Panic(err)
 	}
 
-	// 2.遍历交易输出
-	for _, tx := range block.Txs {
+	return result
+}
 
-		utxos := []*UTXO{}
+// 从UTXOSet中移除被花费的输出并返回它
+func spendUTXO(b *bolt.Bucket, txHash []byte, index int) (*UTXO, error) {
 
-		for index, out := range tx.Vouts {
+	txOutputsBytes := b.Get(txHash)
+	if txOutputsBytes == nil {
 
-			//未花费标志
-			isUnSpent := true
-			for _, in := range ins {
+		return nil, fmt.Errorf("output %x:%d is missing or already spent", txHash, index)
+	}
 
-				if in.Vout == index && bytes.Compare(tx.TxHash, in.TxHash) == 0 &&
-					bytes.Compare(out.Ripemd160Hash, Ripemd160Hash(in.PublicKey)) == 0 {
+	var spent *UTXO
+	utxos := []*UTXO{}
+	for _, utxo := range DeserializeTXOutputs(txOutputsBytes).UTXOS {
 
-						isUnSpent = false
-						continue
-				}
-			}
+		if utxo.Index == index {
 
-			if isUnSpent {
+			spent = utxo
+		} else {
 
-				utxo := &UTXO{tx.TxHash,index,out}
-				utxos = append(utxos,utxo)
-			}
+			utxos = append(utxos, utxo)
 		}
+	}
+	if spent == nil {
 
-		if len(utxos) > 0 {
+		return nil, fmt.Errorf("output %x:%d is missing or already spent", txHash, index)
+	}
 
-			TxHash := hex.EncodeToString(tx.TxHash)
-			outsMap[TxHash] = &TXOutputs{utxos}
-		}
+	var err error
+	if len(utxos) > 0 {
+
+		err = b.Put(txHash, (&TXOutputs{utxos}).Serialize())
+	} else {
+
+		err = b.Delete(txHash)
 	}
 
-	//3. 删除已消耗的TXOutput
-	err := utxoSet.Blockchain.DB.Update(func(tx *bolt.Tx) error {
+	return spent, err
+}
 
-		b := tx.Bucket([]byte(UTXOTableName))
-		if b != nil {
+// 连接一个区块：消耗其交易输入引用的UTXO，加入新的交易输出
+// 被消耗的UTXO作为回滚数据保存，区块被重组掉时用来恢复
+// verify为false时信任区块(重建UTXOSet时重放的是早已接收的区块，其中可能有版本0的旧交易)
+func (utxoSet *UTXOSet) connectBlock(tx *bolt.Tx, block *Block, verify bool) error {
+
+	b, err := tx.CreateBucketIfNotExists([]byte(UTXOTableName))
+	if err != nil {
 
-			for _, in := range ins {
+		return err
+	}
+	undoBucket, err := tx.CreateBucketIfNotExists([]byte(utxoUndoTableName))
+	if err != nil {
 
-				txOutputsBytes := b.Get(in.TxHash)
+		return err
+	}
 
-				//如果该交易输入无引用的交易哈希
-				if len(txOutputsBytes) == 0 {
+	undo := &TXOutputs{[]*UTXO{}}
+	// 区块内交易的手续费总和
+	var fees int64
 
-					continue
-				}
-				txOutputs := DeserializeTXOutputs(txOutputsBytes)
+	// 时间锁按区块所在分支计算，在同一个数据库事务中读取区块头
+	lookup := func(hash []byte) *BlockHeader {
 
-				// 判断是否需要
-				isNeedDelete := false
+		return loadBlockHeader(tx, hash)
+	}
+	parent := lookup(block.PrevBlockHash)
 
-				//缓存来自该交易还未花费的UTXO
-				utxos := []*UTXO{}
+	for _, transaction := range block.Txs {
 
-				for _, utxo := range txOutputs.UTXOS {
+		// 1.删除已消耗的TXOutput，同时校验签名和金额
+		if transaction.IsCoinbaseTransaction() == false {
 
-					if in.Vout == utxo.Index && bytes.Compare(utxo.Output.Ripemd160Hash, Ripemd160Hash(in.PublicKey)) == 0 {
+			var prevOuts []*TXOutput
+			var utxoHeights []int64
+			for _, in := range transaction.Vins {
 
-						isNeedDelete = true
-					}else {
+				spent, err := spendUTXO(b, in.TxHash, in.Vout)
+				if err != nil {
 
-						//txOutputs中剩余未花费的txOutput
-						utxos = append(utxos,utxo)
-					}
+					return rejectBlock(block, RejectDoubleSpend, "tx %x: %s", transaction.TxHash, err)
 				}
+				if verify && spent.IsMature(block.Height) == false {
 
-				if isNeedDelete {
+					return rejectBlock(block, RejectImmatureSpend, "tx %x spends coinbase %x from height %d before maturity",
+						transaction.TxHash, spent.TxHash, spent.Height)
+				}
+				undo.UTXOS = append(undo.UTXOS, spent)
+				prevOuts = append(prevOuts, spent.Output)
+				utxoHeights = append(utxoHeights, spent.Height)
+			}
 
-					b.Delete(in.TxHash)
+			if verify {
 
-					if len(utxos) > 0 {
+				if parent == nil {
 
-						preTXOutputs := outsMap[hex.EncodeToString(in.TxHash)]
-						preTXOutputs.UTXOS = append(preTXOutputs.UTXOS, utxos...)
-						outsMap[hex.EncodeToString(in.TxHash)] = preTXOutputs
-					}
+					return rejectBlock(block, RejectMalformed, "parent header %x is missing", block.PrevBlockHash)
 				}
+				err := checkTransactionLocks(transaction, parent, utxoHeights, lookup)
+				if err != nil {
+
+					return rejectBlock(block, RejectLockedTx, "tx %x: %s", transaction.TxHash, err)
+				}
+
+				fee, reason, err := checkTransactionInputs(transaction, prevOuts)
+				if err != nil {
+
+					return rejectBlock(block, reason, "%s", err)
+				}
+				fees += fee
 			}
+		}
+
+		// 2.新增交易输出到UTXOSet
+		utxos := []*UTXO{}
+		for index, out := range transaction.Vouts {
 
-			// 4.新增交易输出到UTXOSet
-			for keyHash, outPuts := range outsMap {
+			// 数据输出不可花费，不进入UTXOSet
+			if IsUnspendable(out.ScriptPubKey) {
 
-				keyHashBytes, _ := hex.DecodeString(keyHash)
-				b.Put(keyHashBytes, outPuts.Serialize())
+				continue
 			}
+			utxos = append(utxos, &UTXO{transaction.TxHash, index, out, block.Height, transaction.IsCoinbaseTransaction()})
 		}
+		if len(utxos) > 0 {
 
-		return nil
-	})
-	if err != nil{
+			err = b.Put(transaction.TxHash, (&TXOutputs{utxos}).Serialize())
+			if err != nil {
 
-		log.Panic(err)
+				return err
+			}
+		}
 	}
-}
\ No newline at end of file
+
+	// 创币交易金额不能超过该高度的区块奖励加手续费
+	// 累加前检查每个输出和总额不超过发行总量，防止int64溢出成负数
+	var coinbaseValue int64
+	for _, out := range block.Txs[0].Vouts {
+
+		if verify && (moneyRange(out.Value) == false || coinbaseValue > MaxSupply()-out.Value) {
+
+			return rejectBlock(block, RejectBadCoinbase, "coinbase output value %d out of range", out.Value)
+		}
+		coinbaseValue += out.Value
+	}
+	allowed := BlockSubsidy(block.Height) + fees
+	if verify && coinbaseValue > allowed {
+
+		return rejectBlock(block, RejectBadCoinbase, "coinbase pays %d, allowed %d", coinbaseValue, allowed)
+	}
+
+	return undoBucket.Put(block.Hash, undo.Serialize())
+}
+
+// 断开一个区块：删除其交易产生的输出，恢复被它消耗的UTXO
+func (utxoSet *UTXOSet) disconnectBlock(tx *bolt.Tx, block *Block) error {
+
+	b := tx.Bucket([]byte(UTXOTableName))
+	undoBucket := tx.Bucket([]byte(utxoUndoTableName))
+	if b == nil || undoBucket == nil || undoBucket.Get(block.Hash) == nil {
+
+		return fmt.Errorf("block %x: missing undo data, run resetUTXOset", block.Hash)
+	}
+
+	undo := DeserializeTXOutputs(undoBucket.Get(block.Hash))
+
+	// 1.删除区块内交易产生的输出
+	inBlock := make(map[string]bool)
+	for i := len(block.Txs) - 1; i >= 0; i-- {
+
+		inBlock[hex.EncodeToString(block.Txs[i].TxHash)] = true
+		err := b.Delete(block.Txs[i].TxHash)
+		if err != nil {
+
+			return err
+		}
+	}
+
+	// 2.恢复被消耗的UTXO  区块内部自己花掉的输出不需要恢复
+	for _, spent := range undo.UTXOS {
+
+		if inBlock[hex.EncodeToString(spent.TxHash)] {
+
+			continue
+		}
+
+		txOutputs := &TXOutputs{[]*UTXO{}}
+		if txOutputsBytes := b.Get(spent.TxHash); txOutputsBytes != nil {
+
+			txOutputs = DeserializeTXOutputs(txOutputsBytes)
+		}
+		txOutputs.UTXOS = append(txOutputs.UTXOS, spent)
+
+		err := b.Put(spent.TxHash, txOutputs.Serialize())
+		if err != nil {
+
+			return err
+		}
+	}
+
+	return undoBucket.Delete(block.Hash)
+}
@@ -0,0 +1,108 @@
+package BLC
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestUTXOIsMature(t *testing.T) {
+
+	coinbase := &UTXO{repeatHash(0x01), 0, NewTXOutput(25, testAddress()), 10, true}
+	if coinbase.IsMature(10 + coinbaseMaturity - 1) {
+
+		t.Errorf("coinbase mature after %d blocks", coinbaseMaturity-1)
+	}
+	if coinbase.IsMature(10+coinbaseMaturity) == false {
+
+		t.Errorf("coinbase not mature after %d blocks", coinbaseMaturity)
+	}
+
+	normal := &UTXO{repeatHash(0x02), 0, NewTXOutput(25, testAddress()), 10, false}
+	if normal.IsMature(11) == false {
+
+		t.Errorf("normal output immature")
+	}
+}
+
+// 创币输出在高度h+coinbaseMaturity的区块中才能花费，早一个区块也要拒绝
+func TestConnectBlockCoinbaseMaturity(t *testing.T) {
+
+	blc := newTestBlockchain(t)
+	utxoSet := &UTXOSet{blc}
+
+	alice := NewWallet()
+	parent := tipBlock(t, blc)
+	reward := NewCoinbaseTransaction(string(alice.GetAddress()), parent.Height+1, 0)
+	rewardBlock := mineTestBlockWithTxs(t, blc, parent, []*Transaction{reward})
+	appendTestBlock(t, blc, rewardBlock)
+
+	mature, immature := utxoSet.GetBalance(string(alice.GetAddress()))
+	if mature != 0 || immature != reward.Vouts[0].Value {
+
+		t.Errorf("balance %d mature, %d immature, want 0 and %d", mature, immature, reward.Vouts[0].Value)
+	}
+
+	spend := newTestSpend(t, blc, alice, reward, 0, NewTXOutput(reward.Vouts[0].Value, testAddress()))
+	// 只在事务中检查，不保存
+	rollback := errors.New("rollback")
+	connect := func(height int64) error {
+
+		block := &Block{
+			BlockHeader: BlockHeader{Height: height, PrevBlockHash: rewardBlock.Hash},
+			Txs:         []*Transaction{NewCoinbaseTransaction(testAddress(), height, 0), spend},
+			Hash:        repeatHash(0x33)}
+
+		err := blc.DB.Update(func(tx *bolt.Tx) error {
+
+			err := utxoSet.connectBlock(tx, block, true)
+			if err != nil {
+
+				return err
+			}
+
+			return rollback
+		})
+		if err == rollback {
+
+			return nil
+		}
+
+		return err
+	}
+
+	err := connect(rewardBlock.Height + coinbaseMaturity - 1)
+	rejectErr, ok := err.(*BlockRejectError)
+	if ok == false || rejectErr.Reason != RejectImmatureSpend {
+
+		t.Errorf("spend after %d blocks: %v, want %s", coinbaseMaturity-1, err, RejectImmatureSpend)
+	}
+
+	err = connect(rewardBlock.Height + coinbaseMaturity)
+	if err != nil {
+
+		t.Errorf("spend after %d blocks: %s", coinbaseMaturity, err)
+	}
+
+	// 交易池按下一个区块的高度检查  不挖矿直接把主链顶端接到需要的高度
+	for _, test := range []struct {
+		tipHeight int64
+		mature    bool
+	}{
+		{rewardBlock.Height + coinbaseMaturity - 2, false},
+		{rewardBlock.Height + coinbaseMaturity - 1, true},
+	} {
+
+		block := &Block{
+			BlockHeader: BlockHeader{Height: test.tipHeight, PrevBlockHash: blc.Tip, TargetBits: genesisTargetBits},
+			Txs:         []*Transaction{NewCoinbaseTransaction(testAddress(), test.tipHeight, 0)}}
+		block.Hash = block.BlockHeader.Hash()
+		appendTestBlock(t, blc, block)
+
+		if err := checkMempoolMaturity(utxoSet, spend); (err == nil) != test.mature {
+
+			t.Errorf("tip height %d: mempool maturity %v, want mature %v", test.tipHeight, err, test.mature)
+		}
+	}
+}
@@ -16,7 +16,6 @@ import (
 	"encoding/binary"
 	"log"
 	"encoding/json"
-	"encoding/gob"
 	"fmt"
 )
 
@@ -56,23 +55,9 @@ func ReverseBytes(data []byte) {
 }
 
 
-// 将结构体序列化成字节数组
-func gobEncode(data interface{}) []byte {
-
-	var buff bytes.Buffer
-
-	enc := gob.NewEncoder(&buff)
-	err := enc.Encode(data)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	return buff.Bytes()
-}
-
 func commandToBytes(command string) []byte {
 
-	// 消息在底层就是字节序列,前12个字节指定了命令名（比如这里的 version）
+	// 消息头中的命令名占12个字节（比如这里的 version），不足的部分补0
 	var bytes [COMMANDLENGTH]byte
 
 	for i, c := range command {
@@ -0,0 +1,410 @@
+package BLC
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// 区块时间戳最多允许超前本地时间多少秒
+const maxFutureBlockTime = 2 * 60 * 60
+
+// 区块编码后的最大字节数
+const maxBlockSize = 1000000
+
+// 计算过去时间中位数所用的区块数
+const medianTimeSpan = 11
+
+// 区块被拒绝的原因
+type RejectReason int
+
+const (
+	// 结构错误：没有交易、交易没有输入输出等
+	RejectMalformed RejectReason = iota + 1
+	// 哈希与区块内容不符或不满足难度目标
+	RejectInvalidPoW
+	// 难度不符合调整规则
+	RejectBadDifficulty
+	// 高度不是父区块高度加1
+	RejectBadHeight
+	// 时间戳过早或过于超前
+	RejectBadTimestamp
+	// 创币交易位置错误或金额超出奖励
+	RejectBadCoinbase
+	// 交易签名验证失败
+	RejectBadSignature
+	// 输入不存在或已被花费
+	RejectDoubleSpend
+	// 输出金额为负或大于输入金额
+	RejectBadTxValue
+	// 区块内交易重复
+	RejectDuplicateTx
+	// 默克尔根与交易不符
+	RejectBadMerkleRoot
+	// 花费了未成熟的创币交易输出
+	RejectImmatureSpend
+	// 交易的时间锁还没有到期
+	RejectLockedTx
+	// 父区块所在分支已被标记为无效
+	RejectInvalidAncestor
+)
+
+func (reason RejectReason) String() string {
+
+	switch reason {
+	case RejectMalformed:
+		return "malformed"
+	case RejectInvalidPoW:
+		return "invalid-pow"
+	case RejectBadDifficulty:
+		return "bad-difficulty"
+	case RejectBadHeight:
+		return "bad-height"
+	case RejectBadTimestamp:
+		return "bad-timestamp"
+	case RejectBadCoinbase:
+		return "bad-coinbase"
+	case RejectBadSignature:
+		return "bad-signature"
+	case RejectDoubleSpend:
+		return "double-spend"
+	case RejectBadTxValue:
+		return "bad-tx-value"
+	case RejectDuplicateTx:
+		return "duplicate-tx"
+	case RejectBadMerkleRoot:
+		return "bad-merkle-root"
+	case RejectImmatureSpend:
+		return "immature-spend"
+	case RejectLockedTx:
+		return "locked-tx"
+	case RejectInvalidAncestor:
+		return "invalid-ancestor"
+	}
+
+	return "unknown"
+}
+
+// 区块校验失败的错误，携带拒绝原因
+type BlockRejectError struct {
+	// 拒绝原因
+	Reason RejectReason
+	// 被拒绝的区块哈希
+	Hash []byte
+	// 详细信息
+	Message string
+}
+
+func (err *BlockRejectError) Error() string {
+
+	return fmt.Sprintf("block %x rejected (%s): %s", err.Hash, err.Reason, err.Message)
+}
+
+func rejectBlock(block *Block, reason RejectReason, format string, args ...interface{}) error {
+
+	return &BlockRejectError{reason, block.Hash, fmt.Sprintf(format, args...)}
+}
+
+// 接收区块前的校验：先做与链无关的检查，再做与父区块相关的检查(高度、难度、时间戳)
+// 侧链区块只经过这两项检查就保存，交易输入(双花、签名、金额)需要对应分支的UTXO状态，
+// 在区块连接到主链时由UTXOSet.connectBlock(verify=true)校验
+func (blc *Blockchain) ValidateBlock(block *Block, parent *Block) error {
+
+	err := CheckBlockSanity(block)
+	if err != nil {
+
+		return err
+	}
+
+	return blc.CheckBlockContext(block, parent)
+}
+
+// 与链无关的检查，收到区块后立即执行
+func CheckBlockSanity(block *Block) error {
+
+	if len(block.Txs) == 0 {
+
+		return rejectBlock(block, RejectMalformed, "no transactions")
+	}
+
+	if len(block.Serialize()) > maxBlockSize {
+
+		return rejectBlock(block, RejectMalformed, "block larger than %d bytes", maxBlockSize)
+	}
+
+	// 难度位数先检查范围，再据此计算目标值
+	if block.validTargetBits() == false {
+
+		return rejectBlock(block, RejectInvalidPoW, "target bits %d out of range", block.TargetBits)
+	}
+
+	// 工作量证明  重新计算哈希，区块内容被篡改哈希就对不上
+	if NewProofOfWork(block).IsValid() == false {
+
+		return rejectBlock(block, RejectInvalidPoW, "hash does not match contents or target")
+	}
+
+	// 默克尔根把交易和区块头绑定在一起
+	// 重复交易拼凑出的相同默克尔根也要拒绝，否则篡改过的区块会和正常区块有相同的哈希
+	mTree, err := block.MerkleTree()
+	if err != nil {
+
+		return rejectBlock(block, RejectBadMerkleRoot, "%s", err)
+	}
+	if bytes.Compare(block.MerkleRoot, mTree.RootNode.Data) != 0 {
+
+		return rejectBlock(block, RejectBadMerkleRoot, "merkle root does not match transactions")
+	}
+
+	if block.Timestamp > time.Now().Unix()+maxFutureBlockTime {
+
+		return rejectBlock(block, RejectBadTimestamp, "timestamp too far in the future")
+	}
+
+	txHashes := make(map[string]bool)
+	outpoints := make(map[string]bool)
+
+	for index, tx := range block.Txs {
+
+		reason, err := checkTransactionSanity(tx)
+		if err != nil {
+
+			return rejectBlock(block, reason, "tx %x: %s", tx.TxHash, err)
+		}
+
+		// 第一笔并且只有第一笔是创币交易
+		if tx.IsCoinbaseTransaction() != (index == 0) {
+
+			return rejectBlock(block, RejectBadCoinbase, "coinbase must be exactly the first transaction")
+		}
+
+		// 创币交易必须记录本区块高度，保证不同区块的创币交易哈希不同
+		if index == 0 {
+
+			height, ok := tx.CoinbaseHeight()
+			if ok == false || height != block.Height {
+
+				return rejectBlock(block, RejectBadCoinbase, "coinbase does not commit to height %d", block.Height)
+			}
+		}
+
+		key := hex.EncodeToString(tx.TxHash)
+		if txHashes[key] {
+
+			return rejectBlock(block, RejectDuplicateTx, "tx %x appears twice", tx.TxHash)
+		}
+		txHashes[key] = true
+
+		// 区块内同一个输出不能被花费两次
+		if index > 0 {
+
+			for _, in := range tx.Vins {
+
+				outpoint := fmt.Sprintf("%x:%d", in.TxHash, in.Vout)
+				if outpoints[outpoint] {
+
+					return rejectBlock(block, RejectDoubleSpend, "output %s spent twice in block", outpoint)
+				}
+				outpoints[outpoint] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// 金额是否在合法范围内  不能为负，也不能超过发行总量
+// 每个输出和累加的总额都要检查，否则int64溢出后的负数可以通过输出不超过输入的检查
+func moneyRange(value int64) bool {
+
+	return value >= 0 && value <= MaxSupply()
+}
+
+// 交易结构检查  返回拒绝原因
+func checkTransactionSanity(tx *Transaction) (RejectReason, error) {
+
+	if len(tx.Vins) == 0 {
+
+		return RejectMalformed, fmt.Errorf("no inputs")
+	}
+	if len(tx.Vouts) == 0 {
+
+		return RejectMalformed, fmt.Errorf("no outputs")
+	}
+
+	// 交易哈希必须由交易内容重新计算得到
+	err := tx.CheckTxHash()
+	if err != nil {
+
+		return RejectMalformed, err
+	}
+
+	var outValue int64
+	dataOutputs := 0
+	for _, out := range tx.Vouts {
+
+		if out.Value < 0 {
+
+			return RejectBadTxValue, fmt.Errorf("negative output value")
+		}
+		if moneyRange(out.Value) == false {
+
+			return RejectBadTxValue, fmt.Errorf("output value %d exceeds max supply", out.Value)
+		}
+		outValue += out.Value
+		if moneyRange(outValue) == false {
+
+			return RejectBadTxValue, fmt.Errorf("total output value out of range")
+		}
+
+		// 数据输出只能有一个，不能携带金额，数据不能超过上限
+		if IsUnspendable(out.ScriptPubKey) {
+
+			dataOutputs++
+			if dataOutputs > 1 {
+
+				return RejectMalformed, fmt.Errorf("more than one data output")
+			}
+			if out.Value != 0 {
+
+				return RejectBadTxValue, fmt.Errorf("data output carries value %d", out.Value)
+			}
+			if IsNullData(out.ScriptPubKey) == false {
+
+				return RejectMalformed, fmt.Errorf("data output is not OP_RETURN with at most %d bytes", maxDataCarrierSize)
+			}
+		}
+	}
+
+	return 0, nil
+}
+
+// 与父区块相关的检查：高度、难度、时间戳
+func (blc *Blockchain) CheckBlockContext(block *Block, parent *Block) error {
+
+	if bytes.Compare(block.PrevBlockHash, parent.Hash) != 0 {
+
+		return rejectBlock(block, RejectMalformed, "parent hash mismatch")
+	}
+
+	if block.Height != parent.Height+1 {
+
+		return rejectBlock(block, RejectBadHeight, "height %d, parent height %d", block.Height, parent.Height)
+	}
+
+	err := blc.CheckTargetBits(&block.BlockHeader, &parent.BlockHeader)
+	if err != nil {
+
+		return rejectBlock(block, RejectBadDifficulty, "%s", err)
+	}
+
+	if block.Timestamp < blc.medianTimePast(&parent.BlockHeader) {
+
+		return rejectBlock(block, RejectBadTimestamp, "timestamp before median time past")
+	}
+
+	return nil
+}
+
+// 只有区块头时的检查：工作量证明、时间戳、高度、难度
+// 下载区块体之前校验headers消息，轻节点也用它校验区块头
+func checkHeader(header *BlockHeader, parent *BlockHeader, lookup func(hash []byte) *BlockHeader) error {
+
+	hash := header.Hash()
+
+	if header.CheckProofOfWork() == false {
+
+		return fmt.Errorf("header %x: proof of work is invalid", hash)
+	}
+
+	if header.Timestamp > time.Now().Unix()+maxFutureBlockTime {
+
+		return fmt.Errorf("header %x: timestamp too far in the future", hash)
+	}
+
+	if bytes.Compare(header.PrevBlockHash, parent.Hash()) != 0 {
+
+		return fmt.Errorf("header %x: parent hash mismatch", hash)
+	}
+
+	if header.Height != parent.Height+1 {
+
+		return fmt.Errorf("header %x: height %d, parent height %d", hash, header.Height, parent.Height)
+	}
+
+	expected := nextTargetBitsFrom(parent, lookup)
+	if header.TargetBits != expected {
+
+		return fmt.Errorf("header %x: bad difficulty bits: got %d, want %d", hash, header.TargetBits, expected)
+	}
+
+	if header.Timestamp < medianTimePastFrom(parent, lookup) {
+
+		return fmt.Errorf("header %x: timestamp before median time past", hash)
+	}
+
+	return nil
+}
+
+// 最近medianTimeSpan个区块时间戳的中位数
+func (blc *Blockchain) medianTimePast(header *BlockHeader) int64 {
+
+	return medianTimePastFrom(header, blc.lookupBlockHeader)
+}
+
+// 通过lookup向前读取区块头计算过去时间中位数  数据库事务中使用loadBlockHeader避免重复打开事务
+func medianTimePastFrom(header *BlockHeader, lookup func(hash []byte) *BlockHeader) int64 {
+
+	var timestamps []int64
+	for i := 0; i < medianTimeSpan && header != nil; i++ {
+
+		timestamps = append(timestamps, header.Timestamp)
+		header = lookup(header.PrevBlockHash)
+	}
+
+	if len(timestamps) == 0 {
+
+		return 0
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	return timestamps[len(timestamps)/2]
+}
+
+// 根据交易输入引用的输出校验交易：脚本和金额，返回手续费
+func checkTransactionInputs(tx *Transaction, prevOuts []*TXOutput) (int64, RejectReason, error) {
+
+	err := tx.VerifyScripts(prevOuts)
+	if err != nil {
+
+		return 0, RejectBadSignature, fmt.Errorf("tx %x: script verification failed: %s", tx.TxHash, err)
+	}
+
+	var inValue, outValue int64
+	for _, out := range prevOuts {
+
+		inValue += out.Value
+		if moneyRange(out.Value) == false || moneyRange(inValue) == false {
+
+			return 0, RejectBadTxValue, fmt.Errorf("tx %x: input value out of range", tx.TxHash)
+		}
+	}
+	for _, out := range tx.Vouts {
+
+		outValue += out.Value
+		if moneyRange(out.Value) == false || moneyRange(outValue) == false {
+
+			return 0, RejectBadTxValue, fmt.Errorf("tx %x: output value out of range", tx.TxHash)
+		}
+	}
+
+	if outValue > inValue {
+
+		return 0, RejectBadTxValue, fmt.Errorf("tx %x: outputs %d exceed inputs %d", tx.TxHash, outValue, inValue)
+	}
+
+	return inValue - outValue, 0, nil
+}
@@ -0,0 +1,241 @@
+package BLC
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// 难度位数来自网络，超出范围的区块要被拒绝，不能在计算目标值时崩溃
+func TestCheckBlockSanityTargetBitsOutOfRange(t *testing.T) {
+
+	for _, bits := range []int64{0, 256, 300, 0xFFFFFFF0} {
+
+		block := vectorBlock()
+		block.TargetBits = bits
+		block.Hash = block.BlockHeader.Hash()
+
+		err := CheckBlockSanity(block)
+		rejectErr, ok := err.(*BlockRejectError)
+		if ok == false || rejectErr.Reason != RejectInvalidPoW {
+
+			t.Errorf("target bits %d: got %v, want %s", bits, err, RejectInvalidPoW)
+		}
+
+		if block.BlockHeader.CheckProofOfWork() {
+
+			t.Errorf("target bits %d: proof of work accepted", bits)
+		}
+
+		if work := block.BlockHeader.Work(); work.Sign() != 0 {
+
+			t.Errorf("target bits %d: work %s, want 0", bits, work)
+		}
+	}
+}
+
+func TestBlockHeaderWork(t *testing.T) {
+
+	header := &BlockHeader{TargetBits: 18}
+	if want := big.NewInt(1 << 18); header.Work().Cmp(want) != 0 {
+
+		t.Errorf("work %s, want %s", header.Work(), want)
+	}
+}
+
+// 按给定的区块头挖矿  MineBlock总是使用当前时间，这里可以指定时间戳、高度和难度
+func solveTestBlock(t *testing.T, header BlockHeader, txs []*Transaction) *Block {
+
+	block := &Block{BlockHeader: header, Txs: txs}
+	block.Version = BlockVersion
+	block.MerkleRoot = block.HashTransactions()
+
+	hash, nonce, err := NewProofOfWork(block).Mine(context.Background(), minerWorkers)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	block.Hash = hash
+	block.Nonce = nonce
+
+	return block
+}
+
+// 每种无效区块都以对应的原因被拒绝，主链不变
+func TestAddBlockRejectReasons(t *testing.T) {
+
+	blc := newTestBlockchain(t)
+
+	alice := NewWallet()
+	bob := NewWallet()
+	fund := fundTestWallets(t, blc, 10, alice)
+	parent := tipBlock(t, blc)
+	tip := blc.Tip
+	address := testAddress()
+
+	height := parent.Height + 1
+	header := BlockHeader{PrevBlockHash: parent.Hash, Timestamp: time.Now().Unix(), TargetBits: blc.NextTargetBits(&parent.BlockHeader), Height: height}
+	coinbase := func() *Transaction {
+
+		return NewCoinbaseTransaction(testAddress(), height, 0)
+	}
+	spend := func(wallet *Wallet, value int64) *Transaction {
+
+		return newTestSpend(t, blc, wallet, fund, 0, NewTXOutput(value, address))
+	}
+	solve := func(txs ...*Transaction) *Block {
+
+		return solveTestBlock(t, header, txs)
+	}
+	solveWith := func(change func(header *BlockHeader), txs ...*Transaction) *Block {
+
+		changed := header
+		change(&changed)
+
+		return solveTestBlock(t, changed, txs)
+	}
+
+	badNonce := solve(coinbase())
+	badNonce.Nonce++
+
+	badMerkleRoot := solve(coinbase())
+	badMerkleRoot.Txs[0] = coinbase()
+
+	// 重新签名得到交易哈希相同、签名不同的交易
+	tx := spend(alice, 9)
+	resigned := spend(alice, 9)
+	if bytes.Compare(tx.TxHash, resigned.TxHash) != 0 || bytes.Compare(tx.SignedHash(), resigned.SignedHash()) == 0 {
+
+		t.Fatalf("resigned tx: txid %x/%x", tx.TxHash, resigned.TxHash)
+	}
+
+	// 创世区块的创币输出已经被fund花费
+	spent := &Transaction{[]byte{}, []*TXInput{{fund.Vins[0].TxHash, 0, nil, MaxTxInSequenceNum}}, []*TXOutput{NewTXOutput(1, address)}, TxVersion, 0}
+	spent.HashTransactions()
+
+	noTxs := &Block{BlockHeader: header}
+	noTxs.Hash = noTxs.BlockHeader.Hash()
+
+	tests := []struct {
+		name   string
+		block  *Block
+		reason RejectReason
+	}{
+		{"no transactions", noTxs, RejectMalformed},
+		{"hash does not match nonce", badNonce, RejectInvalidPoW},
+		{"merkle root does not match", badMerkleRoot, RejectBadMerkleRoot},
+		// 最后两笔重复，默克尔根和去掉重复的区块相同
+		{"mutated merkle tree", solve(coinbase(), tx, spent, spent), RejectBadMerkleRoot},
+		{"future timestamp", solveWith(func(h *BlockHeader) { h.Timestamp = time.Now().Unix() + maxFutureBlockTime + 600 }, coinbase()), RejectBadTimestamp},
+		{"negative output", solve(coinbase(), spend(alice, -1)), RejectBadTxValue},
+		{"coinbase not first", solve(tx, coinbase()), RejectBadCoinbase},
+		{"two coinbases", solve(coinbase(), coinbase()), RejectBadCoinbase},
+		{"coinbase height", solve(NewCoinbaseTransaction(testAddress(), height+1, 0)), RejectBadCoinbase},
+		{"duplicate txid", solve(coinbase(), tx, resigned), RejectDuplicateTx},
+		{"double spend in block", solve(coinbase(), tx, spend(alice, 8)), RejectDoubleSpend},
+		{"wrong height", solveWith(func(h *BlockHeader) { h.Height++ }, NewCoinbaseTransaction(testAddress(), height+1, 0)), RejectBadHeight},
+		{"easier difficulty", solveWith(func(h *BlockHeader) { h.TargetBits-- }, coinbase()), RejectBadDifficulty},
+		{"timestamp before median time past", solveWith(func(h *BlockHeader) { h.Timestamp = parent.Timestamp - 100 }, coinbase()), RejectBadTimestamp},
+		// 以下在连接到主链校验交易输入时拒绝
+		{"wrong key", solve(coinbase(), spend(bob, 9)), RejectBadSignature},
+		{"outputs exceed inputs", solve(coinbase(), spend(alice, 11)), RejectBadTxValue},
+		{"spent output", solve(coinbase(), spent), RejectDoubleSpend},
+		{"coinbase pays too much", solve(NewCoinbaseTransaction(testAddress(), height, 1)), RejectBadCoinbase},
+	}
+
+	for _, test := range tests {
+
+		err := blc.AddBlock(test.block)
+		rejectErr, ok := err.(*BlockRejectError)
+		if ok == false || rejectErr.Reason != test.reason {
+
+			t.Errorf("%s: got %v, want %s", test.name, err, test.reason)
+		}
+		if bytes.Compare(blc.Tip, tip) != 0 {
+
+			t.Fatalf("%s: tip changed", test.name)
+		}
+	}
+
+	mustAddBlock(t, blc, solve(coinbase(), tx))
+}
+
+// 数据输出只能有一个，不能携带金额，只能是OP_RETURN加不超过上限的数据
+func TestCheckTransactionSanityDataOutputs(t *testing.T) {
+
+	data, err := NullDataScript(repeatHash(0x44))
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	maxData, err := NullDataScript(make([]byte, maxDataCarrierSize))
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	tooLarge := newScriptBuilder().AddOp(OP_RETURN).AddData(make([]byte, maxDataCarrierSize+1)).Script()
+	notPush := []byte{OP_RETURN, OP_DUP}
+	payment := NewTXOutput(5, testAddress())
+
+	tests := []struct {
+		name    string
+		outputs []*TXOutput
+		reason  RejectReason
+	}{
+		{"data and payment", []*TXOutput{{0, data}, payment}, 0},
+		{"data only", []*TXOutput{{0, data}}, 0},
+		{"data at limit", []*TXOutput{{0, maxData}}, 0},
+		{"two data outputs", []*TXOutput{{0, data}, {0, data}}, RejectMalformed},
+		{"data with value", []*TXOutput{{1, data}}, RejectBadTxValue},
+		{"data too large", []*TXOutput{{0, tooLarge}}, RejectMalformed},
+		{"op_return without push", []*TXOutput{{0, notPush}}, RejectMalformed},
+	}
+
+	for _, test := range tests {
+
+		tx := &Transaction{[]byte{}, []*TXInput{{repeatHash(0x01), 0, nil, MaxTxInSequenceNum}}, test.outputs, TxVersion, 0}
+		tx.HashTransactions()
+
+		reason, err := checkTransactionSanity(tx)
+		if reason != test.reason || (err == nil) != (test.reason == 0) {
+
+			t.Errorf("%s: got %s %v, want %s", test.name, reason, err, test.reason)
+		}
+	}
+}
+
+// 数据输出不可花费，不进入UTXOSet
+func TestDataOutputStaysOutOfUTXOSet(t *testing.T) {
+
+	blc := newTestBlockchain(t)
+	utxoSet := &UTXOSet{blc}
+
+	alice := NewWallet()
+	fund := fundTestWallets(t, blc, 10, alice)
+	digest := repeatHash(0x55)
+	data, err := NullDataScript(digest)
+	if err != nil {
+
+		t.Fatal(err)
+	}
+	tx := newTestSpend(t, blc, alice, fund, 0, &TXOutput{0, data}, NewTXOutput(9, string(alice.GetAddress())))
+	if transactionCarriesData(tx, digest) == false || transactionCarriesData(tx, repeatHash(0x56)) {
+
+		t.Fatalf("tx data output does not match")
+	}
+
+	parent := tipBlock(t, blc)
+	mustAddBlock(t, blc, mineTestBlockWithTxs(t, blc, parent, []*Transaction{NewCoinbaseTransaction(testAddress(), parent.Height+1, 1), tx}))
+
+	if utxoSet.FindUTXO(tx.TxHash, 0) != nil {
+
+		t.Errorf("data output in the UTXO set")
+	}
+	if utxo := utxoSet.FindUTXO(tx.TxHash, 1); utxo == nil || utxo.Output.Value != 9 {
+
+		t.Errorf("change output %+v", utxo)
+	}
+	assertUTXOSetMatchesReindex(t, blc)
+}
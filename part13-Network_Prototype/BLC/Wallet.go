@@ -12,6 +12,8 @@ import (
 
 //用于生成地址的版本
 const AddVersion  = byte(0x00)
+//P2SH(支付到脚本哈希)地址的版本
+const ScriptHashAddVersion = byte(0x05)
 //用于生成地址的校验和位数
 const AddressChecksumLen = 4
 
@@ -43,19 +45,36 @@ func newKeyPair() (ecdsa.PrivateKey, []byte) {
 	}
 
 	//2.通过私钥生成公钥
-	publicKey := append(privateKey.PublicKey.X.Bytes(), privateKey.PublicKey.Y.Bytes()...)
+	publicKey := publicKeyBytes(&privateKey.PublicKey)
 
 
 	return *privateKey, publicKey
 }
 
+//公钥编码  X、Y定长32字节，保证验签时能正确拆分
+func publicKeyBytes(publicKey *ecdsa.PublicKey) []byte {
+
+	data := make([]byte, 64)
+	publicKey.X.FillBytes(data[:32])
+	publicKey.Y.FillBytes(data[32:])
+
+	return data
+}
+
 //2.获取钱包地址 根据公钥生成地址
 func (wallet *Wallet) GetAddress() []byte {
 
 	//1.使用RIPEMD160(SHA256(PubKey)) 哈希算法，取公钥并对其哈希两次
 	ripemd160Hash := Ripemd160Hash(wallet.PublicKey)
+
+	return hashToAddress(AddVersion, ripemd160Hash)
+}
+
+//版本和哈希生成地址
+func hashToAddress(version byte, ripemd160Hash []byte) []byte {
+
 	//2.拼接版本
-	version_ripemd160Hash := append([]byte{AddVersion}, ripemd160Hash...)
+	version_ripemd160Hash := append([]byte{version}, ripemd160Hash...)
 	//3.两次sha256生成校验和
 	checkSumBytes := CheckSum(version_ripemd160Hash)
 	//4.拼接校验和
@@ -100,6 +119,18 @@ func  IsValidForAddress(address []byte) bool {
 
 	//1.base58解码地址得到版本，公钥哈希和校验位拼接的字节数组
 	version_publicKey_checksumBytes := Base58Decode(address)
+	if len(version_publicKey_checksumBytes) != 1+20+AddressChecksumLen {
+
+		return false
+	}
+
+	//只接受普通地址和P2SH地址两种版本
+	version := version_publicKey_checksumBytes[0]
+	if version != AddVersion && version != ScriptHashAddVersion {
+
+		return false
+	}
+
 	//2.获取校验位和version_publicKeHash
 	checkSumBytes := version_publicKey_checksumBytes[len(version_publicKey_checksumBytes)-AddressChecksumLen:]
 	version_ripemd160 := version_publicKey_checksumBytes[:len(version_publicKey_checksumBytes)-AddressChecksumLen]